@@ -14,6 +14,7 @@ require (
 	github.com/ipfs/go-log/v2 v2.1.3
 	github.com/nbd-wtf/go-nostr v0.52.3
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	golang.org/x/net v0.47.0
 )
 
 require (
@@ -38,7 +39,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	golang.org/x/arch v0.15.0 // indirect
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
-	golang.org/x/net v0.47.0 // indirect
 )
 
 require (
@@ -59,7 +59,7 @@ require (
 	go.uber.org/atomic v1.10.0 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 	go.uber.org/zap v1.24.0 // indirect
-	golang.org/x/crypto v0.44.0 // indirect
+	golang.org/x/crypto v0.44.0
 	golang.org/x/sys v0.38.0 // indirect
 	google.golang.org/protobuf v1.36.2 // indirect
 )
@@ -69,7 +69,7 @@ echo "Starting Relay..."
 "$BUILD_DIR/$BIN_NAME" relay "$PORT" &
 PID0=$!
 
-DERIVATION_PATH="m/44'/0'/0'/0/0"
+DERIVATION_PATH="m/44/0/0/0/0"
 
 sleep 1
 
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,11 +39,19 @@ func main() {
 
 	if mode == "validate-ks" {
 		if len(os.Args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: %s validate-ks <keyshare_file>\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "Usage: %s validate-ks <keyshare_file> [derive_path] [mainnet|testnet3]\n", os.Args[0])
 			os.Exit(1)
 		}
 
 		keyshareFile := os.Args[2]
+		derivePath := ""
+		if len(os.Args) > 3 {
+			derivePath = os.Args[3]
+		}
+		network := ""
+		if len(os.Args) > 4 {
+			network = os.Args[4]
+		}
 
 		data, err := os.ReadFile(keyshareFile)
 		if err != nil {
@@ -50,35 +59,50 @@ func main() {
 			os.Exit(1)
 		}
 
-		// Try to decode as base64 first (for .ks files), then as JSON
-		var keyshareJSON []byte
-		if decoded, err := base64.StdEncoding.DecodeString(string(data)); err == nil {
-			keyshareJSON = decoded
-		} else {
-			keyshareJSON = data
+		// Accepts base64 (.ks files) and JSON keyshares
+		report, err := tss.ValidateKeyshare(string(data), derivePath, network)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing keyshare: %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Println(report)
 
-		var ks struct {
-			PubKey       string `json:"pub_key"`
-			ChainCodeHex string `json:"chain_code_hex"`
+		var ks tss.KeyshareReport
+		if err := json.Unmarshal([]byte(report), &ks); err != nil || !ks.Valid {
+			fmt.Fprintf(os.Stderr, "Invalid keyshare\n")
+			os.Exit(1)
 		}
+		os.Exit(0)
+	}
 
-		if err := json.Unmarshal(keyshareJSON, &ks); err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing keyshare JSON: %v\n", err)
+	if mode == "migrate-path" {
+		if len(os.Args) < 4 {
+			fmt.Fprintf(os.Stderr, "Usage: %s migrate-path <pub_key> <chain_code> [paths_csv] [mainnet|testnet3] [check]\n", os.Args[0])
 			os.Exit(1)
 		}
 
-		if ks.PubKey == "" {
-			fmt.Fprintf(os.Stderr, "Invalid keyshare: missing pub_key field\n")
-			os.Exit(1)
+		pathsCSV := ""
+		if len(os.Args) > 4 {
+			pathsCSV = os.Args[4]
+		}
+		network := ""
+		if len(os.Args) > 5 {
+			network = os.Args[5]
+		}
+		checkUsage := len(os.Args) > 6 && os.Args[6] == "check"
+		if checkUsage && network != "" {
+			if _, err := tss.SetNetwork(network); err != nil {
+				fmt.Fprintf(os.Stderr, "Error setting network: %v\n", err)
+				os.Exit(1)
+			}
 		}
 
-		if ks.ChainCodeHex == "" {
-			fmt.Fprintf(os.Stderr, "Invalid keyshare: missing chain_code_hex field\n")
+		report, err := tss.DerivationMigrationReport(os.Args[2], os.Args[3], pathsCSV, network, checkUsage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-
-		fmt.Println("Valid keyshare: pub_key and chain_code_hex present")
+		fmt.Println(report)
 		os.Exit(0)
 	}
 
@@ -111,8 +135,29 @@ func main() {
 
 	if mode == "relay" {
 		port := os.Args[2]
+
+		// optional storage: relay <port> [memory|disk] [dir] [ttlSeconds] [gcSeconds]
+		if len(os.Args) > 3 {
+			dir, ttl, gc := "", 0, 0
+			if len(os.Args) > 4 {
+				dir = os.Args[4]
+			}
+			if len(os.Args) > 5 {
+				ttl, _ = strconv.Atoi(os.Args[5])
+			}
+			if len(os.Args) > 6 {
+				gc, _ = strconv.Atoi(os.Args[6])
+			}
+			if _, err := tss.UseRelayStore(os.Args[3], dir, ttl, gc); err != nil {
+				fmt.Printf("Go Error: %v\n", err)
+				return
+			}
+		}
+		if _, err := tss.RunRelay(port); err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+			return
+		}
 		defer tss.StopRelay()
-		tss.RunRelay(port)
 		select {}
 	}
 
@@ -127,11 +172,18 @@ func main() {
 		encKey := os.Args[7]
 		decKey := os.Args[8]
 		sessionKey := ""
-		ppmFile := party + ".json"
+		// no ppm file, every keygen gets fresh Paillier keys
+		ppmFile := ""
 		keyshareFile := party + ".ks"
 
-		//join keygen
-		keyshare, err := tss.JoinKeygen(ppmFile, party, parties, encKey, decKey, session, server, chainCode, sessionKey)
+		//join keygen, optionally followed by the verification signature
+		var keyshare string
+		var err error
+		if len(os.Args) > 9 && os.Args[9] == "verify" {
+			keyshare, err = tss.JoinKeygenVerified(ppmFile, party, parties, 0, encKey, decKey, session, server, chainCode, sessionKey)
+		} else {
+			keyshare, err = tss.JoinKeygen(ppmFile, party, parties, encKey, decKey, session, server, chainCode, sessionKey)
+		}
 		if err != nil {
 			fmt.Printf("Go Error: %v\n", err)
 		} else {
@@ -151,7 +203,7 @@ func main() {
 			// print out pubkeys and p2pkh address
 			fmt.Printf("%s Public Key: %s\n", party, kgR.PubKey)
 			xPub := kgR.PubKey
-			btcPath := "m/44'/0'/0'/0/0"
+			btcPath := "m/44/0/0/0/0"
 			btcPub, err := tss.GetDerivedPubKey(xPub, chainCode, btcPath, false)
 			if err != nil {
 				fmt.Printf("Failed to generate btc pubkey for %s: %v\n", party, err)
@@ -197,6 +249,122 @@ func main() {
 		}
 	}
 
+	if mode == "sign-message" {
+
+		// prepare args
+		server := os.Args[2]
+		session := os.Args[3]
+		party := os.Args[4]
+		parties := os.Args[5]
+		encKey := os.Args[6]
+		decKey := os.Args[7]
+		sessionKey := ""
+		keyshare := os.Args[8]
+		derivePath := os.Args[9]
+		network := os.Args[10]
+		address := os.Args[11]
+		message := os.Args[12]
+		format := os.Args[13] // bip137 or bip322
+
+		if _, err := tss.SetNetwork(network); err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+			return
+		}
+		signature, err := tss.JoinSignMessage(server, party, parties, session, sessionKey, encKey, decKey, keyshare, derivePath, address, message, format)
+		time.Sleep(time.Second)
+
+		if err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+		} else {
+			fmt.Printf("\n [%s] Message Signature %s\n", party, signature)
+		}
+	}
+
+	if mode == "evm-sign" {
+
+		// prepare args
+		server := os.Args[2]
+		session := os.Args[3]
+		party := os.Args[4]
+		parties := os.Args[5]
+		encKey := os.Args[6]
+		decKey := os.Args[7]
+		sessionKey := ""
+		keyshare := os.Args[8]
+		derivePath := os.Args[9]
+		kind := os.Args[10]    // personal_sign, typed_data or transaction
+		payload := os.Args[11] // message, typed data JSON or transaction JSON
+
+		result, err := tss.JoinEvmSign(server, party, parties, session, sessionKey, encKey, decKey, keyshare, derivePath, kind, payload)
+		time.Sleep(time.Second)
+
+		if err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+		} else {
+			fmt.Printf("\n [%s] EVM Signature %s\n", party, result)
+		}
+	}
+
+	if mode == "verify-message" {
+		if len(os.Args) < 6 {
+			fmt.Println("Usage: go run main.go verify-message <network> <address> <message> <signature>")
+			os.Exit(1)
+		}
+		if _, err := tss.SetNetwork(os.Args[2]); err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+			return
+		}
+		valid, err := tss.VerifyMessageSignature(os.Args[3], os.Args[4], os.Args[5])
+		if err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+		} else {
+			fmt.Println(valid)
+		}
+	}
+
+	if mode == "presign" || mode == "keysign-presigned" {
+
+		// prepare args
+		server := os.Args[2]
+		session := os.Args[3]
+		party := os.Args[4]
+		parties := os.Args[5]
+		encKey := os.Args[6]
+		decKey := os.Args[7]
+		sessionKey := ""
+		keyshare := os.Args[8]
+		storeDir := os.Args[9]
+		storeKey := os.Args[10]
+
+		if err := tss.OpenPresignStore(storeDir, storeKey); err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+			return
+		}
+
+		derivePath := os.Args[11]
+
+		var result string
+		var err error
+		if mode == "presign" {
+			result, err = tss.JoinPresign(server, party, parties, session, sessionKey, encKey, decKey, keyshare, derivePath)
+		} else {
+			presignID := os.Args[12]
+			message := os.Args[13]
+
+			// message hash, base64 encoded
+			messageHash, _ := tss.Sha256(message)
+			messageHashBase64 := base64.StdEncoding.EncodeToString([]byte(messageHash))
+			result, err = tss.JoinKeysignPresigned(server, party, parties, session, sessionKey, encKey, decKey, keyshare, derivePath, presignID, messageHashBase64)
+		}
+		time.Sleep(time.Second)
+
+		if err != nil {
+			fmt.Printf("Go Error: %v\n", err)
+		} else {
+			fmt.Printf("\n [%s] %s Result %s\n", party, mode, result)
+		}
+	}
+
 	if mode == "hex-decode" {
 		if len(os.Args) < 3 {
 			fmt.Fprintf(os.Stderr, "Usage: %s hex-decode <hex_string>\n", os.Args[0])
@@ -329,7 +497,7 @@ func main() {
 		fmt.Printf("%s Public Key: %s\n", partyName, keyshare.PubKey)
 
 		// Derive BTC public key
-		btcPath := "m/44'/0'/0'/0/0"
+		btcPath := "m/44/0/0/0/0"
 		btcPub, err := tss.GetDerivedPubKey(keyshare.PubKey, keyshare.ChainCodeHex, btcPath, false)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to generate btc pubkey for %s: %v\n", partyName, err)
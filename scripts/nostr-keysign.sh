@@ -52,7 +52,7 @@ SESSION_KEY="$(random_hex)"
 # Generate message to sign (or use provided)
 MESSAGE="${MESSAGE:-$(random_hex)}"
 if [ -z "${DERIVATION_PATH:-}" ]; then
-	DERIVATION_PATH="m/44'/0'/0'/0/0"
+	DERIVATION_PATH="m/44/0/0/0/0"
 fi
 
 echo "=== Keysign Parameters ==="
@@ -0,0 +1,154 @@
+package tss
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+// Kinds of MPC failures reported in a Blame.
+const (
+	BlameTimeout    = "timeout"    // some parties never sent their round messages
+	BlameDecryption = "decryption" // messages from some parties could not be decrypted
+	BlameProtocol   = "protocol"   // some parties sent invalid round messages
+)
+
+// Blame tells why an MPC session aborted and which parties caused it.
+// Culprits are party keys: the relay key for relay sessions, the npub for
+// Nostr sessions.
+type Blame struct {
+	Kind     string   `json:"kind"`
+	Task     string   `json:"task,omitempty"`
+	Round    int      `json:"round,omitempty"`
+	Culprits []string `json:"culprits,omitempty"`
+	Reason   string   `json:"reason"`
+}
+
+// BlameError is returned by keygen, keysign and resharing when a failure can
+// be attributed to parties. Use errors.As to get the Blame.
+type BlameError struct {
+	Blame
+	err error
+}
+
+func (e *BlameError) Error() string {
+	detail := e.Kind
+	if e.Round > 0 {
+		detail += fmt.Sprintf(", round %d", e.Round)
+	}
+	if len(e.Culprits) > 0 {
+		detail += ", culprits " + strings.Join(e.Culprits, ",")
+	}
+	return fmt.Sprintf("%s (%s)", e.Reason, detail)
+}
+
+func (e *BlameError) Unwrap() error { return e.err }
+
+func newBlame(kind string, err error, culprits ...string) *BlameError {
+	return &BlameError{
+		Blame: Blame{Kind: kind, Culprits: culprits, Reason: err.Error()},
+		err:   err,
+	}
+}
+
+// blameTssError turns a tss-lib error into a Blame. Errors without culprits
+// are blamed on the sender of the message that caused them.
+func blameTssError(kind string, err *tss.Error, from string) *BlameError {
+	culprits := make([]string, 0, len(err.Culprits()))
+	for _, culprit := range err.Culprits() {
+		if culprit != nil {
+			culprits = append(culprits, culprit.Moniker)
+		}
+	}
+	if len(culprits) == 0 && from != "" {
+		culprits = append(culprits, from)
+	}
+	blame := newBlame(kind, err, culprits...)
+	blame.Task = err.Task()
+	blame.Round = err.Round()
+	blame.Reason = err.Cause().Error()
+	return blame
+}
+
+// blameSender blames err on the sender of a message, unless it names its
+// culprits itself.
+func blameSender(kind string, err error, from string) *BlameError {
+	var blameErr *BlameError
+	if errors.As(err, &blameErr) {
+		return blameErr
+	}
+	var tssErr *tss.Error
+	if errors.As(err, &tssErr) {
+		return blameTssError(kind, tssErr, from)
+	}
+	return newBlame(kind, err, from)
+}
+
+// blameTimeout blames a timeout on the parties the local parties are still
+// waiting for in their current round.
+func blameTimeout(err error, parties ...tss.Party) *BlameError {
+	var waiting []*tss.PartyID
+	seen := make(map[string]bool)
+	for _, party := range parties {
+		for _, partyID := range party.WaitingFor() {
+			if !seen[partyID.Moniker] {
+				seen[partyID.Moniker] = true
+				waiting = append(waiting, partyID)
+			}
+		}
+	}
+	if len(parties) == 0 {
+		return newBlame(BlameTimeout, err)
+	}
+	return blameTssError(BlameTimeout, parties[0].WrapError(err, waiting...), "")
+}
+
+// failSession records the blame of a failed session in its status, so it
+// shows up in SessionState and hooks, and returns the error to hand back to
+// the caller. A timeout waiting on parties whose messages could not be
+// decrypted is reported as a decryption failure.
+func failSession(session string, err error) error {
+	var blameErr *BlameError
+	errors.As(err, &blameErr)
+	undecryptable := registry.undecryptable(session)
+	if len(undecryptable) > 0 && (blameErr == nil || blameErr.Kind == BlameTimeout) {
+		culprits := make([]string, 0, len(undecryptable))
+		for party := range undecryptable {
+			if blameErr == nil || len(blameErr.Culprits) == 0 || Contains(blameErr.Culprits, party) {
+				culprits = append(culprits, party)
+			}
+		}
+		if len(culprits) > 0 {
+			sort.Strings(culprits)
+			reasons := make([]string, 0, len(culprits))
+			for _, party := range culprits {
+				reasons = append(reasons, party+": "+undecryptable[party])
+			}
+			cause := err
+			if blameErr != nil {
+				cause = blameErr.err
+			}
+			decryptionErr := newBlame(BlameDecryption, cause, culprits...)
+			decryptionErr.Reason = "messages could not be decrypted: " + strings.Join(reasons, "; ")
+			if blameErr != nil {
+				decryptionErr.Task = blameErr.Task
+				decryptionErr.Round = blameErr.Round
+			}
+			blameErr, err = decryptionErr, decryptionErr
+		}
+	}
+	if blameErr == nil {
+		return err
+	}
+
+	Logln("BBMTLog", "session", session, "aborted:", blameErr.Kind, "culprits", blameErr.Culprits)
+	blame := blameErr.Blame
+	status := getStatus(session)
+	status.Info = "failed: " + blame.Kind
+	status.Blame = &blame
+	setStatus(session, status)
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/BoldBitcoinWallet/BBMTLib/tss"
+)
+
+func main() {
+	var (
+		addr          = flag.String("addr", "0.0.0.0:55055", "Listen address")
+		tlsCert       = flag.String("tls-cert", "", "TLS certificate file (PEM)")
+		tlsKey        = flag.String("tls-key", "", "TLS private key file (PEM)")
+		tlsSelfSigned = flag.Bool("tls-self-signed", false, "Serve TLS with a generated self-signed certificate (LAN use, pin its fingerprint)")
+		readTimeout   = flag.Duration("read-timeout", 30*time.Second, "Request read timeout")
+		writeTimeout  = flag.Duration("write-timeout", 30*time.Second, "Response write timeout (streams are exempt)")
+		maxBody       = flag.Int64("max-body", 8<<20, "Maximum request body size in bytes")
+		maxSessions   = flag.Int("max-sessions", 0, "Maximum concurrently active sessions (0: unlimited)")
+		rate          = flag.Float64("rate", 0, "Requests per second per client IP (0: unlimited)")
+		burst         = flag.Int("burst", 0, "Rate limiter burst (default: 2x rate)")
+		trustProxy    = flag.Bool("trust-proxy", false, "Take the client IP from the last X-Forwarded-For entry, set by the reverse proxy")
+		allowOpen     = flag.Bool("allow-open-sessions", false, "Serve sessions without an access control list, for older clients")
+		store         = flag.String("store", tss.RelayStoreMemory, "Relay store: memory or disk")
+		storeDir      = flag.String("store-dir", "", "Directory of the disk store")
+		ttl           = flag.Int("ttl", 0, "Seconds relay data is kept (default: 300)")
+		gc            = flag.Int("gc", 0, "Seconds between expired data cleanups (default: 600)")
+		adminToken    = flag.String("admin-token", os.Getenv("BBMT_RELAY_ADMIN_TOKEN"), "Bearer token of the admin API (default: $BBMT_RELAY_ADMIN_TOKEN, off when empty)")
+		shutdownWait  = flag.Duration("shutdown-timeout", 10*time.Second, "Time to let requests finish on shutdown")
+	)
+	flag.Parse()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	if _, err := tss.UseRelayStore(*store, *storeDir, *ttl, *gc); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	server, err := tss.NewRelayServer(tss.RelayConfig{
+		Addr:              *addr,
+		TLSCertFile:       *tlsCert,
+		TLSKeyFile:        *tlsKey,
+		SelfSignedTLS:     *tlsSelfSigned,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		MaxBodyBytes:      *maxBody,
+		MaxSessions:       *maxSessions,
+		RateLimit:         *rate,
+		RateBurst:         *burst,
+		TrustProxy:        *trustProxy,
+		AllowOpenSessions: *allowOpen,
+		AdminToken:        *adminToken,
+		Logger:            logger,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := server.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Wait for SIGINT/SIGTERM, then let in-flight requests finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownWait)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("relay shutdown incomplete", "error", err)
+	}
+	if _, err := tss.UseRelayStore(tss.RelayStoreMemory, "", 0, 0); err != nil {
+		logger.Warn("failed to close relay store", "error", err)
+	}
+}
@@ -26,6 +26,7 @@ func main() {
 		timeout         = flag.Int("timeout", 90, "Maximum timeout in seconds (default: 90)")
 		nsecEnv         = flag.String("nsec-env", "NOSTR_NSEC", "Environment variable name for nsec (used only if -npub is provided)")
 		ppmPath         = flag.String("ppm", "", "Path to pre-params file (optional)")
+		threshold       = flag.Int("threshold", 0, "Number of parties required to sign, e.g. 3 for 3-of-5 (default: derived from party count)")
 		output          = flag.String("output", "", "Output file for keyshare JSON (default: stdout)")
 	)
 	flag.Parse()
@@ -106,8 +107,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	tssThreshold, err := tss.GetThresholdForSigners(*threshold, len(peers)+1)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Run keygen
-	keyshareJSON, err := runNostrKeygen(cfg, *chaincode, *ppmPath, npub)
+	keyshareJSON, err := runNostrKeygen(cfg, *chaincode, *ppmPath, npub, tssThreshold)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -125,7 +132,7 @@ func main() {
 	}
 }
 
-func runNostrKeygen(cfg nostrtransport.Config, chaincode, ppmPath, localNpub string) (string, error) {
+func runNostrKeygen(cfg nostrtransport.Config, chaincode, ppmPath, localNpub string, threshold int) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.MaxTimeout)
 	defer cancel()
 
@@ -197,6 +204,7 @@ func runNostrKeygen(cfg nostrtransport.Config, chaincode, ppmPath, localNpub str
 		LocalPartyID: localNpub,
 		AllParties:   partiesCSV,
 		ChainCodeHex: chaincode,
+		Threshold:    threshold,
 	})
 	if err != nil {
 		pumpCancel()
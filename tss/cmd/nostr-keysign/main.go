@@ -24,7 +24,7 @@ func main() {
 		sessionID       = flag.String("session", "", "Session ID [required]")
 		sessionKey      = flag.String("session-key", "", "Session encryption key in hex [required]")
 		keyshareFile    = flag.String("keyshare", "", "Path to keyshare JSON file [required]")
-		derivePath      = flag.String("path", "m/44'/0'/0'/0/0", "HD derivation path (default: m/44'/0'/0'/0/0)")
+		derivePath      = flag.String("path", "m/44/0/0/0/0", "HD derivation path (default: m/44/0/0/0/0)")
 		message         = flag.String("message", "", "Message to sign [required]")
 		timeout         = flag.Int("timeout", 90, "Maximum timeout in seconds (default: 90)")
 	)
@@ -476,7 +476,7 @@ if [ -f "$KEYGEN_OUTPUT_DIR/party1-keyshare.json" ] && [ -f "$KEYGEN_OUTPUT_DIR/
             SESSION_ID=$(random_hex)
             SESSION_KEY=$(random_hex)
             MESSAGE=$(random_hex)
-            DERIVATION_PATH="m/44'/0'/0'/0/0"
+            DERIVATION_PATH="m/44/0/0/0/0"
             
             # All parties for keysign
             ALL_PARTIES="$NPUB1,$NPUB2"
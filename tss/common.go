@@ -12,8 +12,6 @@ import (
 	"math"
 	"math/big"
 	"runtime/debug"
-	"strconv"
-	"strings"
 
 	tcrypto "github.com/bnb-chain/tss-lib/v2/crypto"
 	"github.com/bnb-chain/tss-lib/v2/crypto/ckd"
@@ -40,6 +38,18 @@ func GetThreshold(value int) (int, error) {
 	return threshold, nil
 }
 
+// GetThresholdForSigners converts a t-of-n signer count (e.g. 3 for 3-of-5) to
+// the tss-lib threshold. A signers value of 0 falls back to GetThreshold.
+func GetThresholdForSigners(signers, parties int) (int, error) {
+	if signers == 0 {
+		return GetThreshold(parties)
+	}
+	if signers < 2 || signers > parties {
+		return 0, fmt.Errorf("invalid threshold %d-of-%d", signers, parties)
+	}
+	return signers - 1, nil
+}
+
 // GetHexEncodedPubKey returns the hexadecimal encoded string representation of an ECDSA/EDDSA public key.
 // It takes a pointer to an ECPoint as input and returns the encoded string and an error.
 // If the ECPoint is nil, it returns an empty string and an error indicating a nil ECPoint.
@@ -175,24 +185,20 @@ func derivingPubkeyFromPath(masterPub *tcrypto.ECPoint, chainCode []byte, path [
 	return ckd.DeriveChildKeyFromHierarchy(path, extendedParentPk, ec.Params().N, ec)
 }
 
+// GetDerivePathBytes parses a non-hardened derive path into child indices. It
+// returns ErrHardenedDerivation for hardened steps, which can't be derived
+// from an MPC public key.
 func GetDerivePathBytes(derivePath string) ([]uint32, error) {
+	steps, err := parseDerivePath(derivePath)
+	if err != nil {
+		return nil, err
+	}
+	if isHardenedDerivePath(steps) {
+		return nil, ErrHardenedDerivation(derivePath)
+	}
 	var pathBuf []uint32
-	for _, item := range strings.Split(derivePath, "/") {
-		if len(item) == 0 {
-			continue
-		}
-		if item == "m" {
-			continue
-		}
-		result := strings.Trim(item, "'")
-		intResult, err := strconv.Atoi(result)
-		if err != nil {
-			return nil, fmt.Errorf("invalid path: %w", err)
-		}
-		if intResult < 0 || intResult > int(math.MaxInt) {
-			return nil, fmt.Errorf("integer value %d cannot fit into a uint32", intResult)
-		}
-		pathBuf = append(pathBuf, uint32(intResult))
+	for _, step := range steps {
+		pathBuf = append(pathBuf, step.index)
 	}
 	return pathBuf, nil
 }
@@ -0,0 +1,33 @@
+package tss
+
+import "testing"
+
+func TestGetThresholdForSigners(t *testing.T) {
+	for _, tc := range []struct {
+		signers, parties, want int
+		fails                  bool
+	}{
+		{signers: 0, parties: 2, want: 1},
+		{signers: 0, parties: 3, want: 1},
+		{signers: 0, parties: 5, want: 3},
+		{signers: 2, parties: 2, want: 1},
+		{signers: 2, parties: 5, want: 1},
+		{signers: 3, parties: 5, want: 2},
+		{signers: 5, parties: 5, want: 4},
+		{signers: 1, parties: 3, fails: true},
+		{signers: 4, parties: 3, fails: true},
+		{signers: -1, parties: 3, fails: true},
+		{signers: 0, parties: 1, fails: true},
+	} {
+		threshold, err := GetThresholdForSigners(tc.signers, tc.parties)
+		if tc.fails {
+			if err == nil {
+				t.Fatalf("%d-of-%d: got threshold %d", tc.signers, tc.parties, threshold)
+			}
+			continue
+		}
+		if err != nil || threshold != tc.want {
+			t.Fatalf("%d-of-%d: threshold %d %v, want %d", tc.signers, tc.parties, threshold, err, tc.want)
+		}
+	}
+}
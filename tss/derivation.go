@@ -0,0 +1,207 @@
+package tss
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// HardenedKeyStart is the first hardened BIP-32 child index (i').
+const HardenedKeyStart uint32 = 0x80000000
+
+// ErrHardenedDerivation is returned for derive paths with hardened steps.
+type ErrHardenedDerivation string
+
+func (e ErrHardenedDerivation) Error() string {
+	return fmt.Sprintf("hardened derivation path %s can't be derived from an MPC public key, use the non-hardened path %s", string(e), legacyDerivePath(string(e)))
+}
+
+// derivePathStep is one parsed step of a derive path.
+type derivePathStep struct {
+	index    uint32
+	hardened bool
+}
+
+// parseDerivePath parses m/a/b'/c... where a step is hardened when it ends
+// with ', h or H.
+func parseDerivePath(derivePath string) ([]derivePathStep, error) {
+	var steps []derivePathStep
+	for i, item := range strings.Split(strings.TrimSpace(derivePath), "/") {
+		if len(item) == 0 {
+			continue
+		}
+		if item == "m" && i == 0 {
+			continue
+		}
+		hardened := false
+		if last := item[len(item)-1]; last == '\'' || last == 'h' || last == 'H' {
+			hardened = true
+			item = item[:len(item)-1]
+		}
+		index, err := strconv.ParseUint(item, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+		if index >= uint64(HardenedKeyStart) {
+			return nil, fmt.Errorf("path index %d is out of range, use ' for hardened steps", index)
+		}
+		steps = append(steps, derivePathStep{index: uint32(index), hardened: hardened})
+	}
+	return steps, nil
+}
+
+func isHardenedDerivePath(steps []derivePathStep) bool {
+	for _, step := range steps {
+		if step.hardened {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyDerivePath is the non-hardened path older versions derived for
+// derivePath, by dropping every hardened marker.
+func legacyDerivePath(derivePath string) string {
+	steps, err := parseDerivePath(derivePath)
+	if err != nil {
+		return derivePath
+	}
+	parts := []string{"m"}
+	for _, step := range steps {
+		parts = append(parts, strconv.FormatUint(uint64(step.index), 10))
+	}
+	return strings.Join(parts, "/")
+}
+
+// MpcDerivePath returns the MPC-native path of an address. Hardened child keys
+// need the parent private key, which no party holds, so keyshares only derive
+// along non-hardened paths. The MPC-native account scheme mirrors BIP-44
+// without hardening:
+//
+//	m/purpose/coin/account/change/index
+//
+// e.g. m/44/0/0/0/0 for the first receive address of account 0. Older
+// versions silently read m/44'/0'/0'/0/0 as m/44/0/0/0/0, so their keys live at
+// exactly that path.
+func MpcDerivePath(purpose, coin, account, change, index int) (string, error) {
+	for _, item := range []int{purpose, coin, account, change, index} {
+		if item < 0 || int64(item) >= int64(HardenedKeyStart) {
+			return "", fmt.Errorf("path index %d is out of range", item)
+		}
+	}
+	return fmt.Sprintf("m/%d/%d/%d/%d/%d", purpose, coin, account, change, index), nil
+}
+
+// LegacyDerivePath returns the non-hardened path that keys derived by older
+// versions for derivePath actually live at, e.g. m/44/0/0/0/0 for
+// m/44'/0'/0'/0/0. Use it to keep signing for wallets created with a hardened
+// path.
+func LegacyDerivePath(derivePath string) (string, error) {
+	if _, err := parseDerivePath(derivePath); err != nil {
+		return "", err
+	}
+	return legacyDerivePath(derivePath), nil
+}
+
+// DerivationMigration describes where the keys of a (possibly hardened) path
+// used by an older version really are.
+type DerivationMigration struct {
+	Path          string            `json:"path"`
+	Hardened      bool              `json:"hardened"`
+	MpcPath       string            `json:"mpc_path"`
+	DerivedPubKey string            `json:"derived_pub_key"`
+	Addresses     map[string]string `json:"addresses"`
+	Used          map[string]int    `json:"used,omitempty"` // address -> transaction count
+}
+
+// DerivationMigrationReport lists, for each path in pathsCSV (default
+// m/44'/0'/0'/0/0 and m/44'/1'/0'/0/0), the non-hardened path and the addresses
+// older versions derived for it. With checkUsage, it also looks up through the
+// current API which of those addresses have transactions, so apps can tell
+// which addresses a wallet has actually been using.
+func DerivationMigrationReport(pubKey, chainCodeHex, pathsCSV, network string, checkUsage bool) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in DerivationMigrationReport: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if pathsCSV == "" {
+		pathsCSV = "m/44'/0'/0'/0/0,m/44'/1'/0'/0/0"
+	}
+	if network == "" {
+		network = _btc_net
+	}
+	var report []DerivationMigration
+	for _, path := range strings.Split(pathsCSV, ",") {
+		path = strings.TrimSpace(path)
+		steps, err := parseDerivePath(path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		migration := DerivationMigration{
+			Path:     path,
+			Hardened: isHardenedDerivePath(steps),
+			MpcPath:  legacyDerivePath(path),
+		}
+		addresses, err := keyshareAddresses(pubKey, chainCodeHex, migration.MpcPath, network)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		migration.DerivedPubKey = addresses["derived_pub_key"]
+		delete(addresses, "derived_pub_key")
+		migration.Addresses = addresses
+
+		if checkUsage {
+			migration.Used = make(map[string]int)
+			for _, address := range addresses {
+				txCount, err := addressTxCount(address)
+				if err != nil {
+					return "", fmt.Errorf("failed to check usage of %s: %w", address, err)
+				}
+				if txCount > 0 {
+					migration.Used[address] = txCount
+				}
+			}
+		}
+		report = append(report, migration)
+	}
+
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal report: %w", err)
+	}
+	return string(reportJSON), nil
+}
+
+// addressTxCount returns the confirmed and unconfirmed transaction count of an
+// address.
+func addressTxCount(address string) (int, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/address/%s", _api_url, address))
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch address: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to fetch address: %s", resp.Status)
+	}
+	var stats struct {
+		ChainStats struct {
+			TxCount int `json:"tx_count"`
+		} `json:"chain_stats"`
+		MempoolStats struct {
+			TxCount int `json:"tx_count"`
+		} `json:"mempool_stats"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, fmt.Errorf("failed to parse address response: %w", err)
+	}
+	return stats.ChainStats.TxCount + stats.MempoolStats.TxCount, nil
+}
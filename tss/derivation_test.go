@@ -0,0 +1,139 @@
+package tss
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	tcrypto "github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// testPubKey is the secp256k1 generator, any valid public key will do.
+const testPubKey = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+
+// oldDerivedPubKey derives path the way older versions did: hardened markers
+// were trimmed and the steps derived as non-hardened.
+func oldDerivedPubKey(t *testing.T, path string) string {
+	t.Helper()
+	var pathBuf []uint32
+	for _, item := range strings.Split(path, "/") {
+		if item == "" || item == "m" {
+			continue
+		}
+		index, err := strconv.Atoi(strings.Trim(item, "'"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pathBuf = append(pathBuf, uint32(index))
+	}
+	pubKeyBuf, _ := hex.DecodeString(testPubKey)
+	pubKey, err := btcec.ParsePubKey(pubKeyBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	curve := tss.S256()
+	ecPoint, err := tcrypto.NewECPoint(curve, pubKey.X(), pubKey.Y())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainCode, _ := hex.DecodeString(testChainCode)
+	_, extendedKey, err := derivingPubkeyFromPath(ecPoint, chainCode, pathBuf, curve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(elliptic.MarshalCompressed(curve, extendedKey.X, extendedKey.Y))
+}
+
+func TestHardenedDerivePath(t *testing.T) {
+	for _, path := range []string{"m/44'/0'/0'/0/0", "m/44h/0/0/0/0", "m/44/0H/0/0/0", "m/0/1'"} {
+		var hardened ErrHardenedDerivation
+		if _, err := GetDerivePathBytes(path); !errors.As(err, &hardened) {
+			t.Fatalf("%s: want ErrHardenedDerivation, got %v", path, err)
+		}
+		if _, err := GetDerivedPubKey(testPubKey, testChainCode, path, false); !errors.As(err, &hardened) {
+			t.Fatalf("%s: want ErrHardenedDerivation, got %v", path, err)
+		}
+	}
+	path, err := GetDerivePathBytes("m/44/0/0/1/5")
+	if err != nil || len(path) != 5 || path[0] != 44 || path[3] != 1 || path[4] != 5 {
+		t.Fatalf("GetDerivePathBytes: %v %v", path, err)
+	}
+}
+
+func TestDerivePathOutOfRange(t *testing.T) {
+	for _, path := range []string{"m/2147483648", "m/2147483648'", "m/4294967296", "m/-1", "m/44/x/0"} {
+		if _, err := GetDerivePathBytes(path); err == nil {
+			t.Fatalf("%s: parsed", path)
+		}
+		if _, err := LegacyDerivePath(path); err == nil {
+			t.Fatalf("%s: legacy path parsed", path)
+		}
+	}
+	if _, err := GetDerivePathBytes("m/2147483647"); err != nil {
+		t.Fatalf("largest index: %v", err)
+	}
+	// 1<<31 overflows to a negative int on 32-bit platforms, both are out of
+	// range
+	one := uint(1)
+	for _, index := range []int{-1, int(one << 31)} {
+		if _, err := MpcDerivePath(44, 0, 0, 0, index); err == nil {
+			t.Fatalf("MpcDerivePath accepted index %d", index)
+		}
+	}
+	path, err := MpcDerivePath(84, 1, 2, 1, 7)
+	if err != nil || path != "m/84/1/2/1/7" {
+		t.Fatalf("MpcDerivePath: %s %v", path, err)
+	}
+}
+
+func TestLegacyDerivePath(t *testing.T) {
+	for _, path := range []string{"m/44'/0'/0'/0/0", "m/84h/1H/0'/1/3", "m/44/0/0/0/0"} {
+		legacy, err := LegacyDerivePath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		derived, err := GetDerivedPubKey(testPubKey, testChainCode, legacy, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := oldDerivedPubKey(t, strings.NewReplacer("h", "'", "H", "'").Replace(path)); derived != want {
+			t.Fatalf("%s: legacy path %s derives %s, older versions derived %s", path, legacy, derived, want)
+		}
+	}
+}
+
+func TestDerivationMigrationReport(t *testing.T) {
+	reportJSON, err := DerivationMigrationReport(testPubKey, testChainCode, "", "mainnet", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report []DerivationMigration
+	if err := json.Unmarshal([]byte(reportJSON), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 2 {
+		t.Fatalf("report has %d paths", len(report))
+	}
+	for _, migration := range report {
+		want := oldDerivedPubKey(t, migration.Path)
+		if !migration.Hardened || migration.MpcPath != legacyDerivePath(migration.Path) || migration.DerivedPubKey != want {
+			t.Fatalf("migration %+v, want derived pub key %s", migration, want)
+		}
+		address, err := PubToP2WPKH(want, "mainnet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migration.Addresses["p2wpkh"] != address {
+			t.Fatalf("%s: p2wpkh %s, want %s", migration.Path, migration.Addresses["p2wpkh"], address)
+		}
+	}
+	if _, err := DerivationMigrationReport(testPubKey, testChainCode, "m/44'/x", "mainnet", false); err == nil {
+		t.Fatal("invalid path reported")
+	}
+}
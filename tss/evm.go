@@ -0,0 +1,678 @@
+package tss
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	mecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+// What an EVM signature signs.
+const (
+	EvmSignPersonal    = "personal_sign" // EIP-191 version 0x45, text or 0x-prefixed hex bytes
+	EvmSignTypedData   = "typed_data"    // EIP-712 typed data JSON, as for eth_signTypedData_v4
+	EvmSignTransaction = "transaction"   // EvmTransaction JSON, legacy (EIP-155) or EIP-1559
+)
+
+const evmPersonalPrefix = "\x19Ethereum Signed Message:\n"
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// PubToEVMAddress returns the EIP-55 checksummed EVM address of a compressed
+// or uncompressed secp256k1 public key.
+func PubToEVMAddress(pubKeyHex string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in PubToEVMAddress: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	pubKeyBytes, err := hex.DecodeString(strings.TrimPrefix(pubKeyHex, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode public key: %w", err)
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return evmAddress(pubKey), nil
+}
+
+func evmAddress(pubKey *btcec.PublicKey) string {
+	return evmChecksumAddress(keccak256(pubKey.SerializeUncompressed()[1:])[12:])
+}
+
+// evmChecksumAddress encodes a 20-byte address with the EIP-55 mixed-case
+// checksum.
+func evmChecksumAddress(address []byte) string {
+	lower := hex.EncodeToString(address)
+	hash := keccak256([]byte(lower))
+	checksummed := []byte(lower)
+	for i, c := range checksummed {
+		if c >= 'a' && hash[i/2]>>(4*(1-uint(i%2)))&0x0f >= 8 {
+			checksummed[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(checksummed)
+}
+
+// EvmSigningHash returns the base64 hash an EVM signature of kind signs over
+// payload. It can be passed to JoinKeysign as is.
+func EvmSigningHash(kind, payload string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in EvmSigningHash: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	hash, _, err := evmSigningHash(kind, payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash), nil
+}
+
+func evmSigningHash(kind, payload string) ([]byte, *EvmTransaction, error) {
+	switch kind {
+	case EvmSignPersonal:
+		return evmPersonalHash(evmMessageBytes(payload)), nil, nil
+	case EvmSignTypedData:
+		hash, err := evmTypedDataHash(payload)
+		return hash, nil, err
+	case EvmSignTransaction:
+		var tx EvmTransaction
+		if err := json.Unmarshal([]byte(payload), &tx); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse transaction: %w", err)
+		}
+		fields, err := tx.fields()
+		if err != nil {
+			return nil, nil, err
+		}
+		return keccak256(tx.encode(fields.unsigned())), &tx, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown EVM signing kind %q, use %s, %s or %s", kind, EvmSignPersonal, EvmSignTypedData, EvmSignTransaction)
+	}
+}
+
+// evmMessageBytes is message as personal_sign wallets read it: 0x-prefixed
+// hex is raw bytes, anything else UTF-8 text.
+func evmMessageBytes(message string) []byte {
+	if strings.HasPrefix(message, "0x") {
+		if data, err := hex.DecodeString(message[2:]); err == nil {
+			return data
+		}
+	}
+	return []byte(message)
+}
+
+func evmPersonalHash(message []byte) []byte {
+	return keccak256([]byte(evmPersonalPrefix+strconv.Itoa(len(message))), message)
+}
+
+// RLP
+
+// rlpEncode encodes a []byte string or a []any list of those.
+func rlpEncode(item any) []byte {
+	switch v := item.(type) {
+	case []byte:
+		if len(v) == 1 && v[0] < 0x80 {
+			return v
+		}
+		return append(rlpHeader(len(v), 0x80), v...)
+	case []any:
+		var payload []byte
+		for _, e := range v {
+			payload = append(payload, rlpEncode(e)...)
+		}
+		return append(rlpHeader(len(payload), 0xc0), payload...)
+	default:
+		panic(fmt.Sprintf("rlp: unsupported item %T", item))
+	}
+}
+
+func rlpHeader(length int, offset byte) []byte {
+	if length < 56 {
+		return []byte{offset + byte(length)}
+	}
+	lengthBytes := big.NewInt(int64(length)).Bytes()
+	return append([]byte{offset + 55 + byte(len(lengthBytes))}, lengthBytes...)
+}
+
+// transactions
+
+// evmTxFields is an EvmTransaction parsed into its RLP items.
+type evmTxFields struct {
+	txType  int
+	chainID *big.Int
+	items   []any // signed fields, chain ID first for EIP-1559
+}
+
+func (f *evmTxFields) unsigned() []any {
+	if f.txType == 0 && f.chainID.Sign() > 0 {
+		// EIP-155 replay protection
+		return append(append([]any{}, f.items...), f.chainID.Bytes(), []byte{}, []byte{})
+	}
+	return f.items
+}
+
+func (f *evmTxFields) signed(v *big.Int, r, s []byte) []any {
+	return append(append([]any{}, f.items...), v.Bytes(), new(big.Int).SetBytes(r).Bytes(), new(big.Int).SetBytes(s).Bytes())
+}
+
+// v is the signature's V for the transaction type: the y parity for
+// EIP-1559, 27/28 or chainId*2+35/36 (EIP-155) for legacy transactions.
+func (f *evmTxFields) v(recoveryID byte) *big.Int {
+	switch {
+	case f.txType == 2:
+		return big.NewInt(int64(recoveryID))
+	case f.chainID.Sign() == 0:
+		return big.NewInt(27 + int64(recoveryID))
+	default:
+		v := new(big.Int).Lsh(f.chainID, 1)
+		return v.Add(v, big.NewInt(35+int64(recoveryID)))
+	}
+}
+
+func (tx *EvmTransaction) encode(items []any) []byte {
+	if tx.Type == 2 {
+		return append([]byte{0x02}, rlpEncode(items)...)
+	}
+	return rlpEncode(items)
+}
+
+func (tx *EvmTransaction) fields() (*evmTxFields, error) {
+	quantity := func(name, value string) ([]byte, error) {
+		n, err := parseEvmQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return n.Bytes(), nil
+	}
+	chainID, err := parseEvmQuantity(tx.ChainID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chainId: %w", err)
+	}
+	nonce, err := quantity("nonce", tx.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	gas, err := quantity("gas", tx.Gas)
+	if err != nil {
+		return nil, err
+	}
+	value, err := quantity("value", tx.Value)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseEvmAddress(tx.To, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to: %w", err)
+	}
+	data, err := parseEvmBytes(tx.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+
+	switch tx.Type {
+	case 0:
+		if len(tx.AccessList) > 0 {
+			return nil, errors.New("legacy transactions have no access list")
+		}
+		gasPrice, err := quantity("gasPrice", tx.GasPrice)
+		if err != nil {
+			return nil, err
+		}
+		return &evmTxFields{txType: 0, chainID: chainID, items: []any{nonce, gasPrice, gas, to, value, data}}, nil
+
+	case 2:
+		if chainID.Sign() == 0 {
+			return nil, errors.New("EIP-1559 transactions need a chainId")
+		}
+		maxPriorityFee, err := quantity("maxPriorityFeePerGas", tx.MaxPriorityFeePerGas)
+		if err != nil {
+			return nil, err
+		}
+		maxFee, err := quantity("maxFeePerGas", tx.MaxFeePerGas)
+		if err != nil {
+			return nil, err
+		}
+		accessList := []any{}
+		for _, tuple := range tx.AccessList {
+			address, err := parseEvmAddress(tuple.Address, false)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access list address: %w", err)
+			}
+			storageKeys := []any{}
+			for _, key := range tuple.StorageKeys {
+				k, err := parseEvmBytes(key)
+				if err != nil || len(k) != 32 {
+					return nil, fmt.Errorf("invalid access list storage key %q", key)
+				}
+				storageKeys = append(storageKeys, k)
+			}
+			accessList = append(accessList, []any{address, storageKeys})
+		}
+		return &evmTxFields{txType: 2, chainID: chainID, items: []any{chainID.Bytes(), nonce, maxPriorityFee, maxFee, gas, to, value, data, accessList}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d, use 0 (legacy) or 2 (EIP-1559)", tx.Type)
+	}
+}
+
+// parseEvmQuantity parses a decimal or 0x-prefixed hex unsigned integer, an
+// empty string is zero.
+func parseEvmQuantity(s string) (*big.Int, error) {
+	n, ok := new(big.Int), true
+	switch {
+	case s == "":
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		if s[2:] != "" {
+			_, ok = n.SetString(s[2:], 16)
+		}
+	default:
+		_, ok = n.SetString(s, 10)
+	}
+	if !ok || n.Sign() < 0 || n.BitLen() > 256 {
+		return nil, fmt.Errorf("%q is not an unsigned 256-bit integer", s)
+	}
+	return n, nil
+}
+
+func parseEvmBytes(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	return hex.DecodeString(s)
+}
+
+// parseEvmAddress parses a 0x-prefixed 20-byte address, an empty one is
+// allowed for contract creation when allowEmpty is set.
+func parseEvmAddress(s string, allowEmpty bool) ([]byte, error) {
+	if s == "" && allowEmpty {
+		return []byte{}, nil
+	}
+	address, err := parseEvmBytes(s)
+	if err != nil || len(address) != 20 {
+		return nil, fmt.Errorf("%q is not a 20-byte address", s)
+	}
+	return address, nil
+}
+
+// EIP-712
+
+type evmTypedField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type evmTypedData struct {
+	Types       map[string][]evmTypedField `json:"types"`
+	PrimaryType string                     `json:"primaryType"`
+	Domain      map[string]any             `json:"domain"`
+	Message     map[string]any             `json:"message"`
+}
+
+func evmTypedDataHash(typedDataJSON string) ([]byte, error) {
+	var typedData evmTypedData
+	decoder := json.NewDecoder(strings.NewReader(typedDataJSON))
+	decoder.UseNumber()
+	if err := decoder.Decode(&typedData); err != nil {
+		return nil, fmt.Errorf("failed to parse typed data: %w", err)
+	}
+	if _, ok := typedData.Types["EIP712Domain"]; !ok {
+		return nil, errors.New("typed data has no EIP712Domain type")
+	}
+	if _, ok := typedData.Types[typedData.PrimaryType]; !ok {
+		return nil, fmt.Errorf("primary type %q is not defined", typedData.PrimaryType)
+	}
+
+	domainSeparator, err := typedData.hashStruct("EIP712Domain", typedData.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash domain: %w", err)
+	}
+	if typedData.PrimaryType == "EIP712Domain" {
+		return keccak256([]byte{0x19, 0x01}, domainSeparator), nil
+	}
+	messageHash, err := typedData.hashStruct(typedData.PrimaryType, typedData.Message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash message: %w", err)
+	}
+	return keccak256([]byte{0x19, 0x01}, domainSeparator, messageHash), nil
+}
+
+// evmBaseType strips the array suffixes of an EIP-712 type.
+func evmBaseType(typ string) string {
+	if i := strings.Index(typ, "["); i >= 0 {
+		return typ[:i]
+	}
+	return typ
+}
+
+// dependencies collects the struct types typ references, typ included.
+func (td *evmTypedData) dependencies(typ string, found map[string]bool) {
+	typ = evmBaseType(typ)
+	if found[typ] {
+		return
+	}
+	if _, ok := td.Types[typ]; !ok {
+		return
+	}
+	found[typ] = true
+	for _, field := range td.Types[typ] {
+		td.dependencies(field.Type, found)
+	}
+}
+
+// encodeType is typ's signature followed by the sorted signatures of the
+// struct types it references.
+func (td *evmTypedData) encodeType(typ string) string {
+	found := map[string]bool{}
+	td.dependencies(typ, found)
+	delete(found, typ)
+	deps := make([]string, 0, len(found))
+	for dep := range found {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+
+	var sb strings.Builder
+	for _, t := range append([]string{typ}, deps...) {
+		fields := make([]string, len(td.Types[t]))
+		for i, field := range td.Types[t] {
+			fields[i] = field.Type + " " + field.Name
+		}
+		sb.WriteString(t + "(" + strings.Join(fields, ",") + ")")
+	}
+	return sb.String()
+}
+
+func (td *evmTypedData) hashStruct(typ string, data map[string]any) ([]byte, error) {
+	encoded := keccak256([]byte(td.encodeType(typ)))
+	for _, field := range td.Types[typ] {
+		value, ok := data[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing field %q", typ, field.Name)
+		}
+		enc, err := td.encodeValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typ, field.Name, err)
+		}
+		encoded = append(encoded, enc...)
+	}
+	return keccak256(encoded), nil
+}
+
+// encodeValue is the 32-byte EIP-712 encoding of value as typ.
+func (td *evmTypedData) encodeValue(typ string, value any) ([]byte, error) {
+	if strings.HasSuffix(typ, "]") {
+		items, ok := value.([]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an array for %s", typ)
+		}
+		itemType := typ[:strings.LastIndex(typ, "[")]
+		if n := typ[len(itemType)+1 : len(typ)-1]; n != "" && n != strconv.Itoa(len(items)) {
+			return nil, fmt.Errorf("expected %s items for %s, got %d", n, typ, len(items))
+		}
+		var encoded []byte
+		for _, item := range items {
+			enc, err := td.encodeValue(itemType, item)
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, enc...)
+		}
+		return keccak256(encoded), nil
+	}
+	if _, ok := td.Types[typ]; ok {
+		data, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an object for %s", typ)
+		}
+		return td.hashStruct(typ, data)
+	}
+
+	word := make([]byte, 32)
+	switch {
+	case typ == "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected a string")
+		}
+		return keccak256([]byte(s)), nil
+
+	case typ == "bytes":
+		s, _ := value.(string)
+		b, err := parseEvmBytes(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes: %w", err)
+		}
+		return keccak256(b), nil
+
+	case typ == "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("expected a bool")
+		}
+		if b {
+			word[31] = 1
+		}
+		return word, nil
+
+	case typ == "address":
+		s, _ := value.(string)
+		address, err := parseEvmAddress(s, false)
+		if err != nil {
+			return nil, err
+		}
+		copy(word[12:], address)
+		return word, nil
+
+	case strings.HasPrefix(typ, "bytes"):
+		size, err := strconv.Atoi(typ[len("bytes"):])
+		if err != nil || size < 1 || size > 32 {
+			return nil, fmt.Errorf("unknown type %s", typ)
+		}
+		s, _ := value.(string)
+		b, err := parseEvmBytes(s)
+		if err != nil || len(b) > size {
+			return nil, fmt.Errorf("invalid %s value", typ)
+		}
+		copy(word, b)
+		return word, nil
+
+	case strings.HasPrefix(typ, "uint") || strings.HasPrefix(typ, "int"):
+		signed := strings.HasPrefix(typ, "int")
+		bits := 256
+		if suffix := strings.TrimPrefix(strings.TrimPrefix(typ, "u"), "int"); suffix != "" {
+			var err error
+			if bits, err = strconv.Atoi(suffix); err != nil || bits < 8 || bits > 256 || bits%8 != 0 {
+				return nil, fmt.Errorf("unknown type %s", typ)
+			}
+		}
+		n, err := parseEvmInteger(value)
+		if err != nil {
+			return nil, err
+		}
+		min, max := big.NewInt(0), new(big.Int).Lsh(big.NewInt(1), uint(bits))
+		if signed {
+			max.Rsh(max, 1)
+			min.Neg(max)
+		}
+		if n.Cmp(min) < 0 || n.Cmp(max) >= 0 {
+			return nil, fmt.Errorf("%s out of range for %s", n, typ)
+		}
+		if n.Sign() < 0 {
+			// two's complement
+			n.Add(n, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		return n.FillBytes(word), nil
+
+	default:
+		return nil, fmt.Errorf("unknown type %s", typ)
+	}
+}
+
+// parseEvmInteger parses an EIP-712 integer given as a JSON number, a
+// decimal string or a 0x-prefixed hex string.
+func parseEvmInteger(value any) (*big.Int, error) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return nil, fmt.Errorf("expected an integer, got %T", value)
+	}
+	n, ok := new(big.Int), false
+	if hexDigits, isHex := strings.CutPrefix(s, "0x"); isHex {
+		_, ok = n.SetString(hexDigits, 16)
+	} else {
+		_, ok = n.SetString(s, 10)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%q is not an integer", s)
+	}
+	return n, nil
+}
+
+// signing
+
+// signEvm signs payload as kind with the derived public key pubKeyHex.
+// keysign runs the MPC keysign of a base64 hash and returns the
+// KeysignResponse JSON.
+func signEvm(pubKeyHex, kind, payload string, keysign func(hashBase64 string) (string, error)) (string, error) {
+	pubKey, err := btcec.ParsePubKey(hexToBytes(pubKeyHex))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	hash, tx, err := evmSigningHash(kind, payload)
+	if err != nil {
+		return "", err
+	}
+
+	sigJSON, err := keysign(base64.StdEncoding.EncodeToString(hash))
+	if err != nil {
+		return "", err
+	}
+	var sig KeysignResponse
+	if err := json.Unmarshal([]byte(sigJSON), &sig); err != nil {
+		return "", fmt.Errorf("failed to parse signature response: %w", err)
+	}
+	r, _ := hex.DecodeString(sig.R)
+	s, _ := hex.DecodeString(sig.S)
+	recoveryID, _ := hex.DecodeString(sig.RecoveryID)
+	if len(r) != 32 || len(s) != 32 || len(recoveryID) != 1 || recoveryID[0] > 1 {
+		return "", errors.New("malformed keysign signature")
+	}
+
+	// EVM verifiers recover the signer, so the recovery ID has to be right
+	compact := append(append([]byte{31 + recoveryID[0]}, r...), s...)
+	recovered, _, err := mecdsa.RecoverCompact(compact, hash)
+	if err != nil || !recovered.IsEqual(pubKey) {
+		return "", ErrInvalidSignature("EVM signature does not recover to the derived public key")
+	}
+
+	resp := EvmSignResponse{
+		Address: evmAddress(pubKey),
+		Hash:    "0x" + hex.EncodeToString(hash),
+		R:       "0x" + sig.R,
+		S:       "0x" + sig.S,
+		YParity: int(recoveryID[0]),
+	}
+	if tx == nil {
+		resp.V = strconv.Itoa(27 + int(recoveryID[0]))
+		resp.Signature = "0x" + sig.R + sig.S + hex.EncodeToString([]byte{27 + recoveryID[0]})
+	} else {
+		fields, err := tx.fields()
+		if err != nil {
+			return "", err
+		}
+		v := fields.v(recoveryID[0])
+		raw := tx.encode(fields.signed(v, r, s))
+		resp.V = v.String()
+		resp.Signature = "0x" + sig.R + sig.S + hex.EncodeToString([]byte{recoveryID[0]})
+		resp.RawTransaction = "0x" + hex.EncodeToString(raw)
+		resp.TransactionHash = "0x" + hex.EncodeToString(keccak256(raw))
+	}
+
+	result, err := json.Marshal(resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal response: %w", err)
+	}
+	return string(result), nil
+}
+
+// JoinEvmSign signs payload as kind (personal_sign, typed_data or
+// transaction) over the HTTP relay with the keyshare at derivePath and
+// returns an EvmSignResponse JSON. EVM wallets derive at m/44'/60'/0'/0/i,
+// MPC keyshares can only use the unhardened m/44/60/0/0/i.
+func JoinEvmSign(server, key, partiesCSV, session, sessionKey, encKey, decKey, keyshare, derivePath, kind, payload string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in JoinEvmSign: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	pubKey, err := keyshareSigningPubKey(keyshare, derivePath)
+	if err != nil {
+		return "", err
+	}
+	return signEvm(pubKey, kind, payload, func(hashBase64 string) (string, error) {
+		return JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, keyshare, derivePath, hashBase64)
+	})
+}
+
+// NostrEvmSign is JoinEvmSign over Nostr.
+func NostrEvmSign(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON, derivationPath, kind, payload string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in NostrEvmSign: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	cfg, keyshare, allParties, err := nostrSigningConfig(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON)
+	if err != nil {
+		return "", err
+	}
+	pubKey, err := signingPubKey(keyshare.PubKey, keyshare.ChainCodeHex, derivationPath)
+	if err != nil {
+		return "", err
+	}
+	return signEvm(pubKey, kind, payload, func(hashBase64 string) (string, error) {
+		return runNostrKeysignInternalWithSighash(cfg, keyshare, derivationPath, hashBase64, allParties)
+	})
+}
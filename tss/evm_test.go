@@ -0,0 +1,127 @@
+package tss
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func testEvmKey(t *testing.T, privKeyHex string) (*btcec.PrivateKey, string) {
+	t.Helper()
+	privKeyBytes, err := hex.DecodeString(privKeyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, _ := btcec.PrivKeyFromBytes(privKeyBytes)
+	return privKey, hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+}
+
+func testSignEvm(t *testing.T, privKey *btcec.PrivateKey, pubKey, kind, payload string) EvmSignResponse {
+	t.Helper()
+	out, err := signEvm(pubKey, kind, payload, localKeysign(t, privKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp EvmSignResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// The example transaction of EIP-155. Its signature uses RFC 6979 nonces,
+// like localKeysign.
+func TestEvmEIP155Vector(t *testing.T) {
+	privKey, pubKey := testEvmKey(t, strings.Repeat("46", 32))
+	address, err := PubToEVMAddress(pubKey)
+	if err != nil || address != "0x9d8A62f656a8d1615C1294fd71e9CFb3E4855A4F" {
+		t.Fatalf("address %s: %v", address, err)
+	}
+	tx := `{"type":0,"chainId":"1","nonce":"9","gasPrice":"20000000000","gas":"21000","to":"0x3535353535353535353535353535353535353535","value":"1000000000000000000","data":""}`
+	resp := testSignEvm(t, privKey, pubKey, EvmSignTransaction, tx)
+	if resp.Hash != "0xdaf5a779ae972f972197303d7b574746c7ef83eadac0f2791ad23db92e4c8e53" {
+		t.Fatalf("signing hash %s", resp.Hash)
+	}
+	if resp.V != "37" ||
+		resp.R != "0x28ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276" ||
+		resp.S != "0x67cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83" {
+		t.Fatalf("signature v %s r %s s %s", resp.V, resp.R, resp.S)
+	}
+	if resp.RawTransaction != "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83" {
+		t.Fatalf("raw transaction %s", resp.RawTransaction)
+	}
+}
+
+func TestEvmEIP1559Encoding(t *testing.T) {
+	privKey, pubKey := testEvmKey(t, strings.Repeat("46", 32))
+	tx := `{"type":2,"chainId":"0x1","nonce":"0","maxPriorityFeePerGas":"1000000000","maxFeePerGas":"30000000000","gas":"21000","to":"0x3535353535353535353535353535353535353535","value":"1","data":"0x","accessList":[{"address":"0x3535353535353535353535353535353535353535","storageKeys":["0x0000000000000000000000000000000000000000000000000000000000000001"]}]}`
+
+	// 0x02 || rlp([chainId, nonce, maxPriorityFeePerGas, maxFeePerGas, gas,
+	// to, value, data, accessList]), encoded by hand
+	to := "94" + strings.Repeat("35", 20)
+	accessList := "f838" + "f7" + to + "e1" + "a0" + strings.Repeat("00", 31) + "01"
+	fields := "01" + "80" + "843b9aca00" + "8506fc23ac00" + "825208" + to + "01" + "80" + accessList
+	unsigned, _ := hex.DecodeString("02" + "f861" + fields)
+
+	resp := testSignEvm(t, privKey, pubKey, EvmSignTransaction, tx)
+	if resp.Hash != "0x"+hex.EncodeToString(keccak256(unsigned)) {
+		t.Fatalf("signing hash %s", resp.Hash)
+	}
+	if resp.V != "0" && resp.V != "1" {
+		t.Fatalf("v %s is not a y parity", resp.V)
+	}
+	// the signed transaction appends y parity, r and s to the same fields
+	signed := fields + "0" + resp.V + "a0" + resp.R[2:] + "a0" + resp.S[2:]
+	if resp.V == "0" {
+		signed = fields + "80" + "a0" + resp.R[2:] + "a0" + resp.S[2:]
+	}
+	if resp.RawTransaction != "0x02"+"f8a4"+signed {
+		t.Fatalf("raw transaction %s", resp.RawTransaction)
+	}
+}
+
+// The example of EIP-712, signed by keccak256("cow").
+func TestEvmEIP712Vector(t *testing.T) {
+	typedData := `{"types":{"EIP712Domain":[{"name":"name","type":"string"},{"name":"version","type":"string"},{"name":"chainId","type":"uint256"},{"name":"verifyingContract","type":"address"}],"Person":[{"name":"name","type":"string"},{"name":"wallet","type":"address"}],"Mail":[{"name":"from","type":"Person"},{"name":"to","type":"Person"},{"name":"contents","type":"string"}]},"primaryType":"Mail","domain":{"name":"Ether Mail","version":"1","chainId":1,"verifyingContract":"0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"},"message":{"from":{"name":"Cow","wallet":"0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},"to":{"name":"Bob","wallet":"0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},"contents":"Hello, Bob!"}}`
+	hash, err := evmTypedDataHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(hash) != "be609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2" {
+		t.Fatalf("typed data hash %x", hash)
+	}
+
+	privKey, pubKey := testEvmKey(t, hex.EncodeToString(keccak256([]byte("cow"))))
+	resp := testSignEvm(t, privKey, pubKey, EvmSignTypedData, typedData)
+	if resp.Address != "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826" {
+		t.Fatalf("address %s", resp.Address)
+	}
+	if resp.V != "28" ||
+		resp.R != "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d" ||
+		resp.S != "0x07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b91562" {
+		t.Fatalf("signature v %s r %s s %s", resp.V, resp.R, resp.S)
+	}
+}
+
+func TestEvmPersonalSign(t *testing.T) {
+	hash := evmPersonalHash(evmMessageBytes("hello"))
+	if hex.EncodeToString(hash) != "50b2c43fd39106bafbba0da34fc430e1f91e3c96ea2acee2bc34119f92b37750" {
+		t.Fatalf("personal hash %x", hash)
+	}
+	// 0x-prefixed hex is signed as bytes
+	if hex.EncodeToString(evmPersonalHash(evmMessageBytes("0x68656c6c6f"))) != hex.EncodeToString(hash) {
+		t.Fatal("hex message is not signed as bytes")
+	}
+
+	privKey, pubKey := testEvmKey(t, strings.Repeat("46", 32))
+	resp := testSignEvm(t, privKey, pubKey, EvmSignPersonal, "hello")
+	if resp.V != "27" && resp.V != "28" {
+		t.Fatalf("v %s", resp.V)
+	}
+	if len(resp.Signature) != 2+130 || resp.Signature[2:66] != resp.R[2:] {
+		t.Fatalf("signature %s", resp.Signature)
+	}
+}
@@ -0,0 +1,241 @@
+package tss
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChainCode is the chain code of every test keygen.
+const testChainCode = "1000000000000000000000000000000000000000000000000000000000000000"
+
+// testNet delivers messages between services of the same process.
+type testNet struct {
+	mu       sync.Mutex
+	services map[string]*ServiceImpl
+}
+
+func newTestNet() *testNet {
+	return &testNet{services: make(map[string]*ServiceImpl)}
+}
+
+func (n *testNet) add(party string, s *ServiceImpl) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.services[party] = s
+}
+
+func (n *testNet) service(party string) *ServiceImpl {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.services[party]
+}
+
+type testMessenger struct {
+	net *testNet
+}
+
+func (m *testMessenger) Send(from, to, body string) error {
+	// parties start at slightly different times, like they do over a relay
+	for i := 0; i < 100; i++ {
+		if s := m.net.service(to); s != nil {
+			go s.ApplyData(body)
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("no party %s", to)
+}
+
+// testState keeps the keyshare of one party in memory.
+type testState struct {
+	mu    sync.Mutex
+	state string
+}
+
+func (s *testState) GetLocalState(pubKey string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, nil
+}
+
+func (s *testState) SaveLocalState(pubKey, localState string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = localState
+	return nil
+}
+
+func (s *testState) localState(t *testing.T) LocalState {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var localState LocalState
+	if err := json.Unmarshal([]byte(s.state), &localState); err != nil {
+		t.Fatal(err)
+	}
+	return localState
+}
+
+func (s *testState) setLocalState(t *testing.T, localState *LocalState) {
+	t.Helper()
+	localStateJSON, err := json.Marshal(localState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = string(localStateJSON)
+}
+
+// testPreParams is the pre-parameters file of a test party, the parties are
+// partyA to partyD.
+func testPreParams(party string) string {
+	return filepath.Join("testdata", "preparams", party+".json")
+}
+
+// runParties runs fn for every party at once and returns the errors by party.
+func runParties(parties []string, fn func(party string) error) map[string]error {
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, party := range parties {
+		wg.Add(1)
+		go func(party string) {
+			defer wg.Done()
+			err := fn(party)
+			mu.Lock()
+			errs[party] = err
+			mu.Unlock()
+		}(party)
+	}
+	wg.Wait()
+	return errs
+}
+
+func requireNoErrors(t *testing.T, errs map[string]error) {
+	t.Helper()
+	for party, err := range errs {
+		if err != nil {
+			t.Fatalf("%s: %v", party, err)
+		}
+	}
+}
+
+// testKeygen runs a keygen of parties with the given signing threshold, 0
+// for the default.
+func testKeygen(t *testing.T, parties []string, threshold int) map[string]*testState {
+	t.Helper()
+	net := newTestNet()
+	states := make(map[string]*testState)
+	for _, party := range parties {
+		states[party] = &testState{}
+		s, err := NewService(&testMessenger{net}, states[party], true, testPreParams(party))
+		if err != nil {
+			t.Fatal(err)
+		}
+		net.add(party, s)
+	}
+	errs := runParties(parties, func(party string) error {
+		_, err := net.service(party).KeygenECDSA(&KeygenRequest{
+			LocalPartyID: party,
+			AllParties:   strings.Join(parties, ","),
+			ChainCodeHex: testChainCode,
+			Threshold:    threshold,
+		})
+		return err
+	})
+	requireNoErrors(t, errs)
+	return states
+}
+
+// sharedKeyshares is a 2-of-3 keygen of partyA to partyC, run once for all
+// tests since keygen takes a while.
+var sharedKeyshares struct {
+	once   sync.Once
+	states map[string]string
+}
+
+// testKeyshares returns a copy of the shared keyshares.
+func testKeyshares(t *testing.T) map[string]*testState {
+	t.Helper()
+	sharedKeyshares.once.Do(func() {
+		states := testKeygen(t, []string{"partyA", "partyB", "partyC"}, 0)
+		sharedKeyshares.states = make(map[string]string)
+		for party, state := range states {
+			sharedKeyshares.states[party] = state.state
+		}
+	})
+	if sharedKeyshares.states == nil {
+		t.Fatal("shared keygen failed")
+	}
+	states := make(map[string]*testState)
+	for party, state := range sharedKeyshares.states {
+		states[party] = &testState{state: state}
+	}
+	return states
+}
+
+// testServices creates the services of parties over a fresh network.
+func testServices(t *testing.T, parties []string, states map[string]*testState) *testNet {
+	t.Helper()
+	net := newTestNet()
+	for _, party := range parties {
+		s, err := NewService(&testMessenger{net}, states[party], false, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		net.add(party, s)
+	}
+	return net
+}
+
+// testKeysign signs message with signers, for derivePath.
+func testKeysign(t *testing.T, signers []string, states map[string]*testState, derivePath string, message []byte) (map[string]*KeysignResponse, map[string]error) {
+	t.Helper()
+	net := testServices(t, signers, states)
+	var mu sync.Mutex
+	responses := make(map[string]*KeysignResponse)
+	errs := runParties(signers, func(party string) error {
+		resp, err := net.service(party).KeysignECDSA(&KeysignRequest{
+			PubKey:               states[party].localState(t).PubKey,
+			MessageToSign:        base64.StdEncoding.EncodeToString(message),
+			KeysignCommitteeKeys: strings.Join(signers, ","),
+			LocalPartyKey:        party,
+			DerivePath:           derivePath,
+		})
+		mu.Lock()
+		responses[party] = resp
+		mu.Unlock()
+		return err
+	})
+	return responses, errs
+}
+
+// requireSignature checks that resp is a signature of the derivePath key.
+func requireSignature(t *testing.T, states map[string]*testState, party, derivePath string, resp *KeysignResponse) {
+	t.Helper()
+	localState := states[party].localState(t)
+	derived, err := GetDerivedPubKey(localState.PubKey, localState.ChainCodeHex, derivePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := SecP256k1Recover(resp.R, resp.S, resp.RecoveryID, resp.MsgHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered != derived {
+		t.Fatalf("signature recovers %s, want %s", recovered, derived)
+	}
+}
+
+func testMessage(b byte) []byte {
+	message := make([]byte, 32)
+	message[0] = b
+	return message
+}
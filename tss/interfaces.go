@@ -7,6 +7,9 @@ import (
 type Service interface {
 	KeygenECDSA(req *KeygenRequest) (*KeygenResponse, error)
 	KeysignECDSA(req *KeysignRequest) (*KeysignResponse, error)
+	ReshareECDSA(req *ReshareRequest) (*ReshareResponse, error)
+	PresignECDSA(req *PresignRequest) (*PresignResponse, error)
+	KeysignPresignedECDSA(req *PresignedKeysignRequest) (*KeysignResponse, error)
 	ApplyData(string) error
 }
 
@@ -24,13 +27,17 @@ type ServiceImpl struct {
 	messenger        Messenger
 	stateAccessor    LocalStateAccessor
 	inboundMessageCh chan string
+	abortCh          chan error // aborts the running protocol with a blame
 }
 
 type MessageFromTss struct {
-	WireBytes   []byte `json:"wire_bytes"`
-	From        string `json:"from"`
-	To          string `json:"to"`
-	IsBroadcast bool   `json:"is_broadcast"`
+	WireBytes     []byte `json:"wire_bytes"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	IsBroadcast   bool   `json:"is_broadcast"`
+	FromCommittee string `json:"from_committee,omitempty"` // resharing only: "old" or "new"
+	ToCommittee   string `json:"to_committee,omitempty"`   // resharing only: "old" or "new"
+	ShareEpoch    int    `json:"share_epoch,omitempty"`    // sender's share epoch, keysign and resharing only
 }
 
 type LocalState struct {
@@ -40,12 +47,18 @@ type LocalState struct {
 	LocalPartyKey       string                         `json:"local_party_key"`
 	ChainCodeHex        string                         `json:"chain_code_hex"`
 	CreatedAt           int64                          `json:"created_at"`
+	ResharePrefix       string                         `json:"reshare_prefix,omitempty"` // party key prefix, set by resharing
+	Threshold           int                            `json:"threshold,omitempty"`      // tss-lib threshold t, 0 means derived from committee size
+	ShareEpoch          int                            `json:"share_epoch,omitempty"`    // bumped by every refresh or reshare, 0 after keygen
+	RefreshedAt         int64                          `json:"refreshed_at,omitempty"`   // unix millis of the last refresh or reshare
+	VerifiedAt          int64                          `json:"verified_at,omitempty"`    // unix millis of the post-keygen verification signature, 0 if not verified
 }
 
 type KeygenRequest struct {
 	LocalPartyID string
 	AllParties   string
 	ChainCodeHex string
+	Threshold    int // tss-lib threshold t, 0 means derived from the party count
 }
 
 type KeygenResponse struct {
@@ -60,6 +73,43 @@ type KeysignRequest struct {
 	DerivePath           string `json:"derive_path"`
 }
 
+type PresignRequest struct {
+	PubKey               string `json:"pub_key"`
+	PresignID            string `json:"presign_id"` // shared by the committee, names the presignature
+	PresignCommitteeKeys string `json:"presign_committee_keys"`
+	LocalPartyKey        string `json:"local_party_key"`
+	DerivePath           string `json:"derive_path"` // the only path the presignature signs for
+}
+
+type PresignResponse struct {
+	PresignID  string   `json:"presign_id"`
+	PubKey     string   `json:"pub_key"`
+	DerivePath string   `json:"derive_path"`
+	Committee  []string `json:"committee"`
+	ShareEpoch int      `json:"share_epoch"`
+}
+
+type PresignedKeysignRequest struct {
+	KeysignRequest
+	PresignID string `json:"presign_id"`
+}
+
+type ReshareRequest struct {
+	PubKey         string // local state lookup key, only used by old committee members
+	LocalPartyKey  string
+	OldParties     string // old committee members taking part, comma separated
+	NewParties     string // new committee, comma separated
+	OldThreshold   int    // tss-lib threshold t of the old committee
+	NewThreshold   int    // tss-lib threshold t of the new committee
+	ChainCodeHex   string // required for new committee members without a keyshare
+	ExpectedPubKey string // optional, hex pub key new committee members expect to receive
+	ResharePrefix  string // party key prefix of the new committee
+}
+
+type ReshareResponse struct {
+	PubKey string `json:"pub_key"`
+}
+
 type KeysignResponse struct {
 	Msg          string `json:"msg"`
 	MsgHex       string `json:"msg_hex"`
@@ -69,6 +119,39 @@ type KeysignResponse struct {
 	RecoveryID   string `json:"recovery_id"`
 }
 
+// EvmTransaction is an unsigned EVM transaction. Quantities are decimal or
+// 0x-prefixed hex strings, To is empty for contract creation.
+type EvmTransaction struct {
+	Type                 int              `json:"type"` // 0 legacy (EIP-155), 2 EIP-1559
+	ChainID              string           `json:"chainId"`
+	Nonce                string           `json:"nonce"`
+	GasPrice             string           `json:"gasPrice,omitempty"`             // legacy
+	MaxPriorityFeePerGas string           `json:"maxPriorityFeePerGas,omitempty"` // EIP-1559
+	MaxFeePerGas         string           `json:"maxFeePerGas,omitempty"`         // EIP-1559
+	Gas                  string           `json:"gas"`
+	To                   string           `json:"to"`
+	Value                string           `json:"value"`
+	Data                 string           `json:"data"`
+	AccessList           []EvmAccessTuple `json:"accessList,omitempty"` // EIP-1559
+}
+
+type EvmAccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+type EvmSignResponse struct {
+	Address         string `json:"address"`   // EIP-55 signer address
+	Hash            string `json:"hash"`      // signed hash, 0x hex
+	R               string `json:"r"`         // 0x hex, 32 bytes
+	S               string `json:"s"`         // 0x hex, 32 bytes, low-S
+	V               string `json:"v"`         // decimal: 27/28, EIP-155 chainId*2+35/36 or the y parity for EIP-1559
+	YParity         int    `json:"yParity"`   // 0 or 1
+	Signature       string `json:"signature"` // 0x r||s||v, v is 27/28 for messages and the y parity for transactions
+	RawTransaction  string `json:"rawTransaction,omitempty"`
+	TransactionHash string `json:"transactionHash,omitempty"`
+}
+
 type FeeResponse struct {
 	FastestFee  int `json:"fastestFee"`
 	HalfHourFee int `json:"halfHourFee"`
@@ -0,0 +1,86 @@
+package tss
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// keygenVerifyDerivePath is the child key used for the verification
+// signature. No wallet derives from it, so the signature can't be reused as a
+// wallet signature.
+const keygenVerifyDerivePath = "m/0"
+
+// KeygenVerifySession returns the session ID of the verification keysign that
+// follows the keygen session.
+func KeygenVerifySession(session string) string {
+	return session + "-verify"
+}
+
+// keygenVerifyMessage is the domain separated message every party signs after
+// keygen. It is derived from the keygen session ID and the new public key.
+func keygenVerifyMessage(session, pubKey string) string {
+	return "BBMT keygen verification v1\nsession: " + session + "\npub_key: " + pubKey
+}
+
+// keygenVerifyDigest is the sha256 of keygenVerifyMessage, base64 encoded the
+// way KeysignRequest.MessageToSign expects it.
+func keygenVerifyDigest(session, pubKey string) (string, error) {
+	hash, err := Sha256(keygenVerifyMessage(session, pubKey))
+	if err != nil {
+		return "", err
+	}
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hashBytes), nil
+}
+
+// verifyKeygenSignature checks the verification keysign response against the
+// public key of localState and marks the keyshare as verified.
+func verifyKeygenSignature(localState *LocalState, session, keysignResponse string) error {
+	var resp KeysignResponse
+	if err := json.Unmarshal([]byte(keysignResponse), &resp); err != nil {
+		return fmt.Errorf("failed to parse verification signature: %w", err)
+	}
+	digest, err := keygenVerifyDigest(session, localState.PubKey)
+	if err != nil {
+		return err
+	}
+	digestBytes, _ := base64.StdEncoding.DecodeString(digest)
+	if resp.MsgHex != hex.EncodeToString(digestBytes) {
+		return errors.New("verification signature is over a different message")
+	}
+
+	derivedPubKey, err := GetDerivedPubKey(localState.PubKey, localState.ChainCodeHex, keygenVerifyDerivePath, false)
+	if err != nil {
+		return fmt.Errorf("failed to derive verification key: %w", err)
+	}
+	pubKeyBytes, err := hex.DecodeString(derivedPubKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode verification key: %w", err)
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse verification key: %w", err)
+	}
+	r, okR := new(big.Int).SetString(resp.R, 16)
+	s, okS := new(big.Int).SetString(resp.S, 16)
+	if !okR || !okS {
+		return errors.New("malformed verification signature")
+	}
+	if !ecdsa.Verify(pubKey.ToECDSA(), digestBytes, r, s) {
+		return errors.New("verification signature does not match the new public key")
+	}
+
+	localState.VerifiedAt = time.Now().UnixMilli()
+	return nil
+}
@@ -0,0 +1,77 @@
+package tss
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestKeygenVerifyMessage(t *testing.T) {
+	message := keygenVerifyMessage("session", "02abcd")
+	if !strings.HasPrefix(message, "BBMT keygen verification v1\n") || !strings.Contains(message, "session: session\n") || !strings.HasSuffix(message, "pub_key: 02abcd") {
+		t.Fatalf("message %q", message)
+	}
+	digest, err := keygenVerifyDigest("session", "02abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(digest); err != nil || len(raw) != 32 {
+		t.Fatalf("digest %q is not a base64 sha256: %v", digest, err)
+	}
+	for _, other := range [][2]string{{"session-2", "02abcd"}, {"session", "02abce"}} {
+		if d, _ := keygenVerifyDigest(other[0], other[1]); d == digest {
+			t.Fatalf("same digest for %v", other)
+		}
+	}
+	if KeygenVerifySession("keygen-1") != "keygen-1-verify" {
+		t.Fatal("verification session")
+	}
+}
+
+func TestVerifyKeygenSignature(t *testing.T) {
+	states := testKeyshares(t)
+	localState := states["partyA"].localState(t)
+	digest, err := keygenVerifyDigest("verify", localState.PubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _ := base64.StdEncoding.DecodeString(digest)
+	sign := func(derivePath string, message []byte) string {
+		t.Helper()
+		responses, errs := testKeysign(t, []string{"partyA", "partyB"}, states, derivePath, message)
+		requireNoErrors(t, errs)
+		resp, err := json.Marshal(responses["partyA"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(resp)
+	}
+	sig := sign(keygenVerifyDerivePath, message)
+
+	if err := verifyKeygenSignature(&localState, "verify", sig); err != nil {
+		t.Fatal(err)
+	}
+	if localState.VerifiedAt == 0 {
+		t.Fatal("verified_at not set")
+	}
+
+	unverified := states["partyA"].localState(t)
+	if err := verifyKeygenSignature(&unverified, "another session", sig); err == nil || !strings.Contains(err.Error(), "different message") {
+		t.Fatalf("signature of another session: %v", err)
+	}
+	// a wallet key's signature of the same message is not a verification
+	if err := verifyKeygenSignature(&unverified, "verify", sign("m/44/0/0/0/0", message)); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("signature of another key: %v", err)
+	}
+	var resp KeysignResponse
+	json.Unmarshal([]byte(sig), &resp)
+	resp.S = resp.R
+	tampered, _ := json.Marshal(resp)
+	if err := verifyKeygenSignature(&unverified, "verify", string(tampered)); err == nil {
+		t.Fatal("tampered signature verified")
+	}
+	if unverified.VerifiedAt != 0 {
+		t.Fatal("verified_at set by a failed verification")
+	}
+}
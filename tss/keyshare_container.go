@@ -0,0 +1,462 @@
+package tss
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted keyshare container.
+//
+// A container is a JSON document with a cleartext header and the sealed
+// keyshare. The compacted header JSON is authenticated as AEAD associated
+// data, so it can be displayed before unlocking but not tampered with:
+//
+//	{"header": {...}, "ciphertext": "<base64>"}
+const (
+	KeyshareContainerFormat  = "bbmt-keyshare"
+	KeyshareContainerVersion = 1
+
+	KdfArgon2id = "argon2id"
+	KdfScrypt   = "scrypt"
+	KdfRaw      = "raw" // caller supplied 32-byte key, no derivation
+
+	keyshareCipher  = "xchacha20poly1305"
+	keyshareSaltLen = 16
+)
+
+// KeyshareKdf holds the key derivation parameters of a container.
+type KeyshareKdf struct {
+	Name    string `json:"name"`
+	Salt    string `json:"salt,omitempty"` // base64
+	Time    uint32 `json:"time,omitempty"` // argon2id passes
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+	N       int    `json:"n,omitempty"` // scrypt cost
+	R       int    `json:"r,omitempty"`
+	P       int    `json:"p,omitempty"`
+}
+
+// KeyshareHeader describes a sealed keyshare without revealing it.
+type KeyshareHeader struct {
+	Format            string      `json:"format"`
+	Version           int         `json:"version"`
+	Curve             string      `json:"curve"`
+	Network           string      `json:"network"`
+	PubKeyFingerprint string      `json:"pubkey_fingerprint"`
+	Committee         []string    `json:"committee"`
+	Threshold         int         `json:"threshold"` // parties required to sign
+	ShareEpoch        int         `json:"share_epoch"`
+	CreatedAt         int64       `json:"created_at"` // keygen time, unix millis
+	SealedAt          int64       `json:"sealed_at"`
+	Kdf               KeyshareKdf `json:"kdf"`
+	Cipher            string      `json:"cipher"`
+	Nonce             string      `json:"nonce"` // base64
+}
+
+type keyshareContainer struct {
+	Header     json.RawMessage `json:"header"`
+	Ciphertext string          `json:"ciphertext"`
+}
+
+// SealKeyshare encrypts a keyshare (JSON or base64 JSON, with or without nostr
+// credentials) with a password, using Argon2id, and returns the container JSON.
+// network, mainnet or testnet3, is the network the keyshare is used on; the
+// keyshare itself does not record it.
+func SealKeyshare(keyshare, password, network string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in SealKeyshare: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	return sealKeyshare(keyshare, password, network, KdfArgon2id)
+}
+
+// SealKeyshareWithKdf is SealKeyshare with a choice of password KDF,
+// KdfArgon2id or KdfScrypt.
+func SealKeyshareWithKdf(keyshare, password, network, kdf string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in SealKeyshareWithKdf: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if kdf != KdfArgon2id && kdf != KdfScrypt {
+		return "", fmt.Errorf("unsupported password kdf %q", kdf)
+	}
+	return sealKeyshare(keyshare, password, network, kdf)
+}
+
+// SealKeyshareWithKey encrypts a keyshare with a 32-byte hex key, e.g. one
+// held in the platform keystore, instead of a password.
+func SealKeyshareWithKey(keyshare, keyHex, network string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in SealKeyshareWithKey: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	return sealKeyshare(keyshare, keyHex, network, KdfRaw)
+}
+
+// OpenKeyshare decrypts a password sealed container and returns the keyshare JSON.
+func OpenKeyshare(container, password string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in OpenKeyshare: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	plaintext, _, err := openKeyshare(container, password, false)
+	return plaintext, err
+}
+
+// OpenKeyshareWithKey decrypts a key sealed container and returns the keyshare JSON.
+func OpenKeyshareWithKey(container, keyHex string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in OpenKeyshareWithKey: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	plaintext, _, err := openKeyshare(container, keyHex, true)
+	return plaintext, err
+}
+
+// RekeyKeyshare changes the password of a container. The keyshare is sealed
+// again with a fresh salt, nonce and seal time, the rest of the header is kept.
+func RekeyKeyshare(container, oldPassword, newPassword string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in RekeyKeyshare: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	plaintext, header, err := openKeyshare(container, oldPassword, false)
+	if err != nil {
+		return "", err
+	}
+	return sealKeyshareHeader([]byte(plaintext), newPassword, *header)
+}
+
+// MigrateKeyshare brings a keyshare to the current container format. Plaintext
+// keyshares (JSON or base64 JSON) are sealed with the password for network;
+// containers of an older version are opened and sealed again, keeping their
+// network; current containers are checked against the password and returned
+// unchanged.
+func MigrateKeyshare(keyshare, password, network string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in MigrateKeyshare: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if !IsKeyshareContainer(keyshare) {
+		Logln("BBMTLog", "sealing plaintext keyshare")
+		return sealKeyshare(keyshare, password, network, KdfArgon2id)
+	}
+	plaintext, header, err := openKeyshare(keyshare, password, false)
+	if err != nil {
+		return "", err
+	}
+	if header.Version == KeyshareContainerVersion {
+		return keyshare, nil
+	}
+	Logln("BBMTLog", "migrating keyshare container from version", header.Version)
+	if header.Network != "" {
+		network = header.Network
+	}
+	return sealKeyshare(plaintext, password, network, header.Kdf.Name)
+}
+
+// KeyshareContainerHeader returns the header JSON of a container without
+// decrypting it.
+func KeyshareContainerHeader(container string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in KeyshareContainerHeader: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	_, header, err := parseKeyshareContainer(container)
+	if err != nil {
+		return "", err
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal header: %w", err)
+	}
+	return string(headerJSON), nil
+}
+
+// IsKeyshareContainer tells whether data is a keyshare container rather than
+// a plaintext keyshare.
+func IsKeyshareContainer(data string) bool {
+	_, _, err := parseKeyshareContainer(data)
+	return err == nil
+}
+
+// decodeKeyshare accepts a keyshare as JSON or base64 JSON, like
+// LocalStateAccessorImp.GetLocalState, and returns the JSON bytes.
+func decodeKeyshare(keyshare string) ([]byte, error) {
+	keyshare = strings.TrimSpace(keyshare)
+	if strings.HasPrefix(keyshare, "{") {
+		return []byte(keyshare), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(keyshare)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyshare: %w", err)
+	}
+	return decoded, nil
+}
+
+func sealKeyshare(keyshare, secret, network, kdf string) (string, error) {
+	if network != "mainnet" && network != "testnet3" {
+		return "", fmt.Errorf("non supported network %s", network)
+	}
+	plaintext, err := decodeKeyshare(keyshare)
+	if err != nil {
+		return "", err
+	}
+	// LocalStateNostr is a superset of LocalState, so it reads either
+	var localState LocalStateNostr
+	if err := json.Unmarshal(plaintext, &localState); err != nil {
+		return "", fmt.Errorf("failed to unmarshal keyshare: %w", err)
+	}
+	if localState.PubKey == "" {
+		return "", errors.New("keyshare has no pub_key")
+	}
+	fingerprint, err := pubKeyFingerprint(localState.PubKey)
+	if err != nil {
+		return "", err
+	}
+	threshold, err := localState.threshold()
+	if err != nil {
+		return "", fmt.Errorf("failed to get threshold: %w", err)
+	}
+
+	return sealKeyshareHeader(plaintext, secret, KeyshareHeader{
+		Format:            KeyshareContainerFormat,
+		Version:           KeyshareContainerVersion,
+		Curve:             "secp256k1",
+		Network:           network,
+		PubKeyFingerprint: fingerprint,
+		Committee:         localState.KeygenCommitteeKeys,
+		Threshold:         threshold + 1,
+		ShareEpoch:        localState.ShareEpoch,
+		CreatedAt:         localState.CreatedAt,
+		Kdf:               newKeyshareKdf(kdf),
+		Cipher:            keyshareCipher,
+	})
+}
+
+// sealKeyshareHeader seals plaintext under header, with a fresh salt, nonce
+// and seal time.
+func sealKeyshareHeader(plaintext []byte, secret string, header KeyshareHeader) (string, error) {
+	if secret == "" {
+		return "", errors.New("empty password")
+	}
+	header.SealedAt = time.Now().UnixMilli()
+	if header.Kdf.Name != KdfRaw {
+		salt := make([]byte, keyshareSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("failed to generate salt: %w", err)
+		}
+		header.Kdf.Salt = base64.StdEncoding.EncodeToString(salt)
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header.Nonce = base64.StdEncoding.EncodeToString(nonce)
+
+	key, err := deriveKeyshareKey(secret, header.Kdf)
+	if err != nil {
+		return "", err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %w", err)
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal header: %w", err)
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, headerJSON)
+
+	containerJSON, err := json.MarshalIndent(keyshareContainer{
+		Header:     headerJSON,
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal container: %w", err)
+	}
+	return string(containerJSON), nil
+}
+
+func openKeyshare(container, secret string, rawKey bool) (string, *KeyshareHeader, error) {
+	parsed, header, err := parseKeyshareContainer(container)
+	if err != nil {
+		return "", nil, err
+	}
+	if rawKey != (header.Kdf.Name == KdfRaw) {
+		if rawKey {
+			return "", nil, errors.New("keyshare is sealed with a password, not a key")
+		}
+		return "", nil, errors.New("keyshare is sealed with a key, not a password")
+	}
+	nonce, err := base64.StdEncoding.DecodeString(header.Nonce)
+	if err != nil || len(nonce) != chacha20poly1305.NonceSizeX {
+		return "", nil, errors.New("invalid container nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parsed.Ciphertext)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid container ciphertext: %w", err)
+	}
+	key, err := deriveKeyshareKey(secret, header.Kdf)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	var headerJSON bytes.Buffer
+	if err := json.Compact(&headerJSON, parsed.Header); err != nil {
+		return "", nil, fmt.Errorf("invalid container header: %w", err)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, headerJSON.Bytes())
+	if err != nil {
+		return "", nil, errors.New("wrong password or corrupted keyshare")
+	}
+	return string(plaintext), header, nil
+}
+
+func parseKeyshareContainer(container string) (*keyshareContainer, *KeyshareHeader, error) {
+	var parsed keyshareContainer
+	if err := json.Unmarshal([]byte(strings.TrimSpace(container)), &parsed); err != nil {
+		return nil, nil, fmt.Errorf("invalid keyshare container: %w", err)
+	}
+	if len(parsed.Header) == 0 || parsed.Ciphertext == "" {
+		return nil, nil, errors.New("invalid keyshare container: missing header or ciphertext")
+	}
+	var header KeyshareHeader
+	if err := json.Unmarshal(parsed.Header, &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid keyshare container header: %w", err)
+	}
+	if header.Format != KeyshareContainerFormat {
+		return nil, nil, fmt.Errorf("unknown keyshare container format %q", header.Format)
+	}
+	if header.Version < 1 || header.Version > KeyshareContainerVersion {
+		return nil, nil, fmt.Errorf("unsupported keyshare container version %d", header.Version)
+	}
+	if header.Cipher != keyshareCipher {
+		return nil, nil, fmt.Errorf("unsupported keyshare cipher %q", header.Cipher)
+	}
+	return &parsed, &header, nil
+}
+
+func newKeyshareKdf(name string) KeyshareKdf {
+	switch name {
+	case KdfArgon2id:
+		return KeyshareKdf{Name: KdfArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+	case KdfScrypt:
+		return KeyshareKdf{Name: KdfScrypt, N: 1 << 15, R: 8, P: 1}
+	}
+	return KeyshareKdf{Name: KdfRaw}
+}
+
+// keyshareKdfMaxMemory bounds the memory a container may ask the KDF for.
+const keyshareKdfMaxMemory = 256 << 20
+
+// deriveKeyshareKey derives the AEAD key. The parameters come from the
+// container header, so they are bounded to keep a crafted container from
+// exhausting memory on a phone.
+func deriveKeyshareKey(secret string, kdf KeyshareKdf) ([]byte, error) {
+	if kdf.Name == KdfRaw {
+		key, err := hex.DecodeString(secret)
+		if err != nil || len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("key must be %d bytes of hex", chacha20poly1305.KeySize)
+		}
+		return key, nil
+	}
+	salt, err := base64.StdEncoding.DecodeString(kdf.Salt)
+	if err != nil || len(salt) < keyshareSaltLen {
+		return nil, errors.New("invalid kdf salt")
+	}
+	switch kdf.Name {
+	case KdfArgon2id:
+		// Memory is in KiB
+		if kdf.Time < 1 || kdf.Time > 16 || kdf.Memory < 8*1024 || kdf.Memory > keyshareKdfMaxMemory/1024 || kdf.Threads < 1 || kdf.Threads > 16 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+		return argon2.IDKey([]byte(secret), salt, kdf.Time, kdf.Memory, kdf.Threads, chacha20poly1305.KeySize), nil
+	case KdfScrypt:
+		// scrypt needs 128*N*r bytes
+		if kdf.N < 1<<14 || kdf.N > 1<<20 || kdf.R < 1 || kdf.R > 32 || kdf.P < 1 || kdf.P > 16 || 128*int64(kdf.N)*int64(kdf.R) > keyshareKdfMaxMemory {
+			return nil, errors.New("invalid scrypt parameters")
+		}
+		key, err := scrypt.Key([]byte(secret), salt, kdf.N, kdf.R, kdf.P, chacha20poly1305.KeySize)
+		if err != nil {
+			return nil, fmt.Errorf("scrypt: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported kdf %q", kdf.Name)
+}
+
+// pubKeyFingerprint identifies a wallet by the first 8 bytes of the SHA-256 of
+// its compressed public key, without revealing the key itself.
+func pubKeyFingerprint(pubKeyHex string) (string, error) {
+	pubKey, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return "", fmt.Errorf("invalid pub key hex: %w", err)
+	}
+	sum := sha256.Sum256(pubKey)
+	return hex.EncodeToString(sum[:8]), nil
+}
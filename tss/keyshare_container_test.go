@@ -0,0 +1,179 @@
+package tss
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testKeyshareJSON = `{"pub_key":"02fdf026b2becd8d17caa89908a698150209d4707c98ca0bee160042f562c8e8a8","keygen_committee_keys":["a","b","c"],"chain_code_hex":"00","created_at":5,"nsec":"abcd"}`
+
+// editContainer changes the container with edit, header and ciphertext
+// included.
+func editContainer(t *testing.T, container string, edit func(header *KeyshareHeader, ciphertext []byte)) string {
+	t.Helper()
+	var parsed keyshareContainer
+	if err := json.Unmarshal([]byte(container), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	var header KeyshareHeader
+	if err := json.Unmarshal(parsed.Header, &header); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parsed.Ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(&header, ciphertext)
+	if parsed.Header, err = json.Marshal(header); err != nil {
+		t.Fatal(err)
+	}
+	parsed.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	edited, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(edited)
+}
+
+func TestKeyshareContainerRoundTrip(t *testing.T) {
+	for _, kdf := range []string{KdfArgon2id, KdfScrypt} {
+		t.Run(kdf, func(t *testing.T) {
+			container, err := SealKeyshareWithKdf(testKeyshareJSON, "password", "mainnet", kdf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(container, "abcd") || !IsKeyshareContainer(container) || IsKeyshareContainer(testKeyshareJSON) {
+				t.Fatal("keyshare is not sealed")
+			}
+			headerJSON, err := KeyshareContainerHeader(container)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var header KeyshareHeader
+			if err := json.Unmarshal([]byte(headerJSON), &header); err != nil {
+				t.Fatal(err)
+			}
+			if header.Kdf.Name != kdf || header.Network != "mainnet" || header.Threshold != 2 || len(header.Committee) != 3 || header.PubKeyFingerprint == "" {
+				t.Fatalf("unexpected header %s", headerJSON)
+			}
+
+			if _, err := OpenKeyshare(container, "wrong"); err == nil {
+				t.Fatal("opened with a wrong password")
+			}
+			keyshare, err := OpenKeyshare(container, "password")
+			if err != nil || keyshare != testKeyshareJSON {
+				t.Fatalf("open: %v", err)
+			}
+
+			rekeyed, err := RekeyKeyshare(container, "password", "new password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := OpenKeyshare(rekeyed, "password"); err == nil {
+				t.Fatal("rekeyed container opens with the old password")
+			}
+			if keyshare, err := OpenKeyshare(rekeyed, "new password"); err != nil || keyshare != testKeyshareJSON {
+				t.Fatalf("open rekeyed: %v", err)
+			}
+			rekeyedJSON, err := KeyshareContainerHeader(rekeyed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rekeyedHeader KeyshareHeader
+			if err := json.Unmarshal([]byte(rekeyedJSON), &rekeyedHeader); err != nil {
+				t.Fatal(err)
+			}
+			if rekeyedHeader.Network != header.Network || rekeyedHeader.CreatedAt != header.CreatedAt || rekeyedHeader.Kdf.Salt == header.Kdf.Salt || rekeyedHeader.Nonce == header.Nonce {
+				t.Fatalf("rekeyed header %s, sealed %s", rekeyedJSON, headerJSON)
+			}
+			if migrated, err := MigrateKeyshare(rekeyed, "new password", "testnet3"); err != nil || migrated != rekeyed {
+				t.Fatalf("a current container was migrated: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyshareContainerTamper(t *testing.T) {
+	container, err := SealKeyshare(testKeyshareJSON, "password", "testnet3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, edit := range map[string]func(*KeyshareHeader, []byte){
+		"threshold":  func(h *KeyshareHeader, _ []byte) { h.Threshold = 3 },
+		"committee":  func(h *KeyshareHeader, _ []byte) { h.Committee = []string{"a", "b", "d"} },
+		"epoch":      func(h *KeyshareHeader, _ []byte) { h.ShareEpoch = 1 },
+		"ciphertext": func(_ *KeyshareHeader, c []byte) { c[0] ^= 1 },
+		"tag":        func(_ *KeyshareHeader, c []byte) { c[len(c)-1] ^= 1 },
+	} {
+		if _, err := OpenKeyshare(editContainer(t, container, edit), "password"); err == nil {
+			t.Fatalf("tampered %s accepted", name)
+		}
+	}
+	// the container itself still opens after a round trip through editContainer
+	untouched := editContainer(t, container, func(*KeyshareHeader, []byte) {})
+	if keyshare, err := OpenKeyshare(untouched, "password"); err != nil || keyshare != testKeyshareJSON {
+		t.Fatalf("open: %v", err)
+	}
+}
+
+func TestKeyshareContainerKdfBounds(t *testing.T) {
+	container, err := SealKeyshare(testKeyshareJSON, "password", "testnet3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, edit := range map[string]func(*KeyshareHeader, []byte){
+		"argon2id memory":  func(h *KeyshareHeader, _ []byte) { h.Kdf.Memory = 1024 * 1024 },
+		"argon2id threads": func(h *KeyshareHeader, _ []byte) { h.Kdf.Threads = 255 },
+		"argon2id time":    func(h *KeyshareHeader, _ []byte) { h.Kdf.Time = 100 },
+		"scrypt memory": func(h *KeyshareHeader, _ []byte) {
+			h.Kdf = KeyshareKdf{Name: KdfScrypt, Salt: h.Kdf.Salt, N: 1 << 20, R: 8, P: 1}
+		},
+		"scrypt r": func(h *KeyshareHeader, _ []byte) {
+			h.Kdf = KeyshareKdf{Name: KdfScrypt, Salt: h.Kdf.Salt, N: 1 << 14, R: 64, P: 1}
+		},
+	} {
+		_, err := OpenKeyshare(editContainer(t, container, edit), "password")
+		if err == nil || !strings.Contains(err.Error(), "parameters") {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestKeyshareContainerWithKey(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	container, err := SealKeyshareWithKey(testKeyshareJSON, key, "testnet3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyshare, err := OpenKeyshareWithKey(container, key); err != nil || keyshare != testKeyshareJSON {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := OpenKeyshareWithKey(container, strings.Repeat("cd", 32)); err == nil {
+		t.Fatal("opened with a wrong key")
+	}
+	if _, err := OpenKeyshare(container, key); err == nil {
+		t.Fatal("key sealed container opened as password sealed")
+	}
+	if _, err := SealKeyshareWithKey(testKeyshareJSON, "short", "testnet3"); err == nil {
+		t.Fatal("sealed with a short key")
+	}
+}
+
+func TestMigrateKeyshare(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(testKeyshareJSON))
+	if _, err := MigrateKeyshare(encoded, "password", ""); err == nil {
+		t.Fatal("sealed a keyshare without a network")
+	}
+	container, err := MigrateKeyshare(encoded, "password", "testnet3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyshare, err := OpenKeyshare(container, "password"); err != nil || keyshare != testKeyshareJSON {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := MigrateKeyshare(container, "wrong", "testnet3"); err == nil {
+		t.Fatal("migrated with a wrong password")
+	}
+}
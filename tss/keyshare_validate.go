@@ -0,0 +1,309 @@
+package tss
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime/debug"
+	"strings"
+
+	tcrypto "github.com/bnb-chain/tss-lib/v2/crypto"
+	ecdsaKeygen "github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+// KeyshareReport is the result of ValidateKeyshare.
+type KeyshareReport struct {
+	Valid         bool              `json:"valid"`
+	PubKey        string            `json:"pub_key"`
+	LocalPartyKey string            `json:"local_party_key"`
+	Committee     []string          `json:"committee"`
+	Threshold     int               `json:"threshold"` // parties required to sign
+	ShareEpoch    int               `json:"share_epoch"`
+	VerifiedAt    int64             `json:"verified_at,omitempty"`
+	Network       string            `json:"network"`
+	DerivePath    string            `json:"derive_path"`
+	Addresses     map[string]string `json:"addresses,omitempty"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+func (r *KeyshareReport) fail(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// ValidateKeyshare checks that a keyshare (JSON or base64 JSON) is internally
+// consistent and usable for signing, without talking to the other parties:
+//   - the public key matches the ECDSA save data and the public shares
+//   - the local secret share matches its public share
+//   - the Paillier and Pedersen parameters are well formed
+//   - the committee, local party key and threshold agree with the save data
+//
+// It returns a KeyshareReport JSON, including the wallet addresses at
+// derivePath for network ("mainnet" or "testnet3", empty means the current
+// network). An error is only returned when the keyshare can't be parsed.
+func ValidateKeyshare(keyshare, derivePath, network string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in ValidateKeyshare: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if IsKeyshareContainer(keyshare) {
+		return "", errors.New("keyshare is sealed, open it first")
+	}
+	keyshareJSON, err := decodeKeyshare(keyshare)
+	if err != nil {
+		return "", err
+	}
+	var localState LocalState
+	if err := json.Unmarshal(keyshareJSON, &localState); err != nil {
+		return "", fmt.Errorf("failed to unmarshal keyshare: %w", err)
+	}
+	if derivePath == "" {
+		derivePath = "m/44/0/0/0/0"
+	}
+	if network == "" {
+		network = _btc_net
+	}
+
+	report := validateLocalState(&localState)
+	report.Network = network
+	report.DerivePath = derivePath
+	if len(report.Errors) == 0 {
+		report.Addresses, err = keyshareAddresses(localState.PubKey, localState.ChainCodeHex, derivePath, network)
+		if err != nil {
+			report.fail("failed to derive addresses: %v", err)
+		}
+	}
+	report.Valid = len(report.Errors) == 0
+
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal report: %w", err)
+	}
+	return string(reportJSON), nil
+}
+
+func validateLocalState(localState *LocalState) *KeyshareReport {
+	report := &KeyshareReport{
+		PubKey:        localState.PubKey,
+		LocalPartyKey: localState.LocalPartyKey,
+		Committee:     localState.KeygenCommitteeKeys,
+		ShareEpoch:    localState.ShareEpoch,
+		VerifiedAt:    localState.VerifiedAt,
+	}
+	data := localState.ECDSALocalData
+	curve := tss.S256()
+	n := len(data.Ks)
+
+	if chainCode, err := hex.DecodeString(localState.ChainCodeHex); err != nil || len(chainCode) != 32 {
+		report.fail("chain code must be 32 bytes of hex")
+	}
+
+	// committee and threshold
+	threshold, err := localState.threshold()
+	if err != nil {
+		report.fail("invalid threshold: %v", err)
+	} else {
+		report.Threshold = threshold + 1
+		if threshold < 1 || threshold >= n {
+			report.fail("threshold %d-of-%d is not usable", threshold+1, n)
+		}
+	}
+	if len(localState.KeygenCommitteeKeys) != n {
+		report.fail("committee has %d parties, save data has %d", len(localState.KeygenCommitteeKeys), n)
+	}
+	for _, party := range localState.KeygenCommitteeKeys {
+		key := new(big.Int).SetBytes([]byte(localState.ResharePrefix + party))
+		if indexOfKey(data.Ks, key) < 0 {
+			report.fail("committee party %s has no share in the save data", party)
+		}
+	}
+	if !Contains(localState.KeygenCommitteeKeys, localState.LocalPartyKey) {
+		report.fail("local party %s is not in the committee", localState.LocalPartyKey)
+	}
+	if data.ShareID == nil {
+		report.fail("nil share id")
+		return report
+	}
+	if data.ShareID.Cmp(new(big.Int).SetBytes([]byte(localState.ResharePrefix+localState.LocalPartyKey))) != 0 {
+		report.fail("share id does not belong to local party %s", localState.LocalPartyKey)
+	}
+	index := indexOfKey(data.Ks, data.ShareID)
+	if index < 0 {
+		report.fail("share id is not in the save data")
+		return report
+	}
+
+	// public key and shares
+	if len(data.BigXj) != n {
+		report.fail("save data has %d public shares for %d parties", len(data.BigXj), n)
+		return report
+	}
+	for j, bigXj := range data.BigXj {
+		if bigXj == nil || !bigXj.IsOnCurve() {
+			report.fail("public share %d is not a curve point", j)
+			return report
+		}
+	}
+	if data.ECDSAPub == nil {
+		report.fail("nil ecdsa pub key")
+	} else {
+		if pubKey, err := GetHexEncodedPubKey(data.ECDSAPub); err != nil {
+			report.fail("invalid ecdsa pub key: %v", err)
+		} else if pubKey != localState.PubKey {
+			report.fail("pub_key %s does not match save data pub key %s", localState.PubKey, pubKey)
+		}
+		if threshold > 0 && threshold < n {
+			// any t+1 public shares must interpolate to the same public key
+			for _, subset := range [][]int{seq(0, threshold+1), seq(n-threshold-1, n)} {
+				pub, err := interpolatePubKey(curve, data.Ks, data.BigXj, subset)
+				if err != nil || !pub.Equals(data.ECDSAPub) {
+					report.fail("public shares %v do not interpolate to the pub key", subset)
+				}
+			}
+		}
+	}
+	if data.Xi == nil {
+		report.fail("nil secret share")
+	} else if !tcrypto.ScalarBaseMult(curve, new(big.Int).Mod(data.Xi, curve.Params().N)).Equals(data.BigXj[index]) {
+		report.fail("secret share does not match its public share")
+	}
+
+	// Paillier and Pedersen parameters
+	pre := data.LocalPreParams
+	if err := checkPreParams(&pre); errors.Is(err, errIncompletePreParams) {
+		report.fail("%v", err)
+		return report
+	} else if err != nil {
+		report.fail("%v", err)
+	}
+	if len(data.PaillierPKs) != n || len(data.NTildej) != n || len(data.H1j) != n || len(data.H2j) != n {
+		report.fail("save data has incomplete peer parameters")
+		return report
+	}
+	for j := 0; j < n; j++ {
+		if data.PaillierPKs[j] == nil || data.PaillierPKs[j].N == nil || data.NTildej[j] == nil || data.H1j[j] == nil || data.H2j[j] == nil {
+			report.fail("missing Paillier/Pedersen parameters of party %d", j)
+			continue
+		}
+		if data.PaillierPKs[j].N.BitLen() < 2047 || data.NTildej[j].BitLen() < 2047 {
+			report.fail("Paillier/Pedersen modulus of party %d is too short", j)
+		}
+		if data.H1j[j].Cmp(data.H2j[j]) == 0 {
+			report.fail("h1 equals h2 for party %d", j)
+		}
+	}
+	if data.PaillierPKs[index] != nil && data.PaillierPKs[index].N != nil && data.PaillierPKs[index].N.Cmp(pre.PaillierSK.N) != 0 {
+		report.fail("local Paillier public key does not match the secret key")
+	}
+	if data.NTildej[index] != nil && data.NTildej[index].Cmp(pre.NTildei) != 0 {
+		report.fail("local NTilde does not match the pre-parameters")
+	}
+	return report
+}
+
+var errIncompletePreParams = errors.New("incomplete Paillier/Pedersen pre-parameters")
+
+// checkPreParams checks that Paillier and Pedersen pre-parameters are
+// complete and consistent with their secret primes.
+func checkPreParams(pre *ecdsaKeygen.LocalPreParams) error {
+	if !pre.ValidateWithProof() {
+		return errIncompletePreParams
+	}
+	var problems []string
+	if new(big.Int).Mul(pre.PaillierSK.P, pre.PaillierSK.Q).Cmp(pre.PaillierSK.N) != 0 {
+		problems = append(problems, "Paillier modulus is not P*Q")
+	}
+	one, two := big.NewInt(1), big.NewInt(2)
+	safeP := new(big.Int).Add(new(big.Int).Mul(pre.P, two), one)
+	safeQ := new(big.Int).Add(new(big.Int).Mul(pre.Q, two), one)
+	if new(big.Int).Mul(safeP, safeQ).Cmp(pre.NTildei) != 0 {
+		problems = append(problems, "NTilde is not a product of the saved safe primes")
+	}
+	if new(big.Int).Exp(pre.H1i, pre.Alpha, pre.NTildei).Cmp(pre.H2i) != 0 ||
+		new(big.Int).Exp(pre.H2i, pre.Beta, pre.NTildei).Cmp(pre.H1i) != 0 {
+		problems = append(problems, "h1, h2 are not related by alpha, beta")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// interpolatePubKey evaluates the public share polynomial at zero from the
+// parties at the given indexes, using Lagrange coefficients.
+func interpolatePubKey(curve elliptic.Curve, ks []*big.Int, bigXj []*tcrypto.ECPoint, subset []int) (*tcrypto.ECPoint, error) {
+	modQ := new(big.Int).Set(curve.Params().N)
+	var pub *tcrypto.ECPoint
+	for _, j := range subset {
+		coef := big.NewInt(1)
+		for _, m := range subset {
+			if m == j {
+				continue
+			}
+			diff := new(big.Int).Sub(ks[m], ks[j])
+			diff.Mod(diff, modQ)
+			inv := new(big.Int).ModInverse(diff, modQ)
+			if inv == nil {
+				return nil, errors.New("duplicate share ids")
+			}
+			coef.Mul(coef, ks[m]).Mul(coef, inv).Mod(coef, modQ)
+		}
+		term := bigXj[j].ScalarMult(coef)
+		if pub == nil {
+			pub = term
+			continue
+		}
+		var err error
+		if pub, err = pub.Add(term); err != nil {
+			return nil, err
+		}
+	}
+	return pub, nil
+}
+
+func keyshareAddresses(pubKey, chainCodeHex, derivePath, network string) (map[string]string, error) {
+	derivedPubKey, err := GetDerivedPubKey(pubKey, chainCodeHex, derivePath, false)
+	if err != nil {
+		return nil, err
+	}
+	addresses := map[string]string{"derived_pub_key": derivedPubKey}
+	for name, toAddress := range map[string]func(string, string) (string, error){
+		"p2pkh":       PubToP2KH,
+		"p2wpkh":      PubToP2WPKH,
+		"p2sh-p2wpkh": PubToP2SHP2WKH,
+		"p2tr":        PubToP2TR,
+	} {
+		address, err := toAddress(derivedPubKey, network)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		addresses[name] = address
+	}
+	return addresses, nil
+}
+
+func indexOfKey(ks []*big.Int, key *big.Int) int {
+	for i, k := range ks {
+		if k != nil && k.Cmp(key) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func seq(from, to int) []int {
+	out := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		out = append(out, i)
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package tss
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	tcrypto "github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+// validateReport runs ValidateKeyshare on the keyshare in state, changed
+// by tamper.
+func validateReport(t *testing.T, state *testState, tamper func(localState *LocalState)) KeyshareReport {
+	t.Helper()
+	localState := state.localState(t)
+	tamper(&localState)
+	localStateJSON, err := json.Marshal(localState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportJSON, err := ValidateKeyshare(string(localStateJSON), "", "mainnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report KeyshareReport
+	if err := json.Unmarshal([]byte(reportJSON), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestValidateKeyshare(t *testing.T) {
+	states := testKeyshares(t)
+	report := validateReport(t, states["partyA"], func(*LocalState) {})
+	if !report.Valid || len(report.Errors) > 0 {
+		t.Fatalf("valid keyshare reported invalid: %v", report.Errors)
+	}
+	if report.Threshold != 2 || len(report.Committee) != 3 || report.LocalPartyKey != "partyA" {
+		t.Fatalf("report %+v", report)
+	}
+	for _, kind := range []string{"derived_pub_key", "p2pkh", "p2wpkh", "p2sh-p2wpkh", "p2tr"} {
+		if report.Addresses[kind] == "" {
+			t.Fatalf("no %s address", kind)
+		}
+	}
+	if _, err := ValidateKeyshare("not a keyshare", "", ""); err == nil {
+		t.Fatal("parsed an invalid keyshare")
+	}
+}
+
+func TestValidateKeyshareTampered(t *testing.T) {
+	states := testKeyshares(t)
+	for name, tc := range map[string]struct {
+		tamper func(localState *LocalState)
+		want   []string
+	}{
+		"xi": {
+			tamper: func(l *LocalState) { l.ECDSALocalData.Xi.Add(l.ECDSALocalData.Xi, big.NewInt(1)) },
+			want:   []string{"secret share does not match its public share"},
+		},
+		"bigXj": {
+			tamper: func(l *LocalState) {
+				other := (indexOfKey(l.ECDSALocalData.Ks, l.ECDSALocalData.ShareID) + 1) % len(l.ECDSALocalData.BigXj)
+				l.ECDSALocalData.BigXj[other] = tcrypto.ScalarBaseMult(tss.S256(), big.NewInt(7))
+			},
+			want: []string{"do not interpolate to the pub key"},
+		},
+		"paillier": {
+			tamper: func(l *LocalState) {
+				sk := l.ECDSALocalData.LocalPreParams.PaillierSK
+				sk.N = new(big.Int).Add(sk.N, big.NewInt(2))
+			},
+			want: []string{"Paillier modulus is not P*Q", "local Paillier public key does not match the secret key"},
+		},
+		"pedersen": {
+			tamper: func(l *LocalState) {
+				pre := &l.ECDSALocalData.LocalPreParams
+				pre.H2i = new(big.Int).Add(pre.H2i, big.NewInt(1))
+			},
+			want: []string{"h1, h2 are not related by alpha, beta"},
+		},
+		"committee": {
+			tamper: func(l *LocalState) { l.KeygenCommitteeKeys = []string{"partyA", "partyB", "partyD"} },
+			want:   []string{"committee party partyD has no share in the save data"},
+		},
+		"pub key": {
+			tamper: func(l *LocalState) { l.PubKey = "02" + strings.Repeat("11", 32) },
+			want:   []string{"does not match save data pub key"},
+		},
+	} {
+		report := validateReport(t, states["partyA"], tc.tamper)
+		if report.Valid {
+			t.Fatalf("%s: tampered keyshare reported valid", name)
+		}
+		got := strings.Join(report.Errors, "; ")
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Fatalf("%s: errors %q, want %q", name, got, want)
+			}
+		}
+	}
+}
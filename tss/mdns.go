@@ -0,0 +1,656 @@
+package tss
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// LAN discovery over mDNS/DNS-SD (RFC 6762, RFC 6763). Devices running a
+// relay or waiting for peers advertise a _bbmt._tcp service whose TXT
+// records carry the party ID, a fingerprint of its public key and an
+// optional session hint, so peers find each other on any subnet size and on
+// IPv6 without scanning. DiscoverPeers browses for it first and falls back
+// to scanning the /24.
+
+const (
+	mdnsService   = "_bbmt._tcp.local."
+	mdnsServices  = "_services._dns-sd._udp.local."
+	mdnsPort      = 5353
+	mdnsTTL       = 120
+	mdnsBrowseGap = time.Second // between repeated queries
+)
+
+var (
+	mdnsGroup4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+	mdnsGroup6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: mdnsPort}
+)
+
+// Discovery roles of an advertisement.
+const (
+	DiscoveryRolePeer  = "peer"
+	DiscoveryRoleRelay = "relay"
+)
+
+// DiscoveredService is a _bbmt._tcp service found on the LAN.
+type DiscoveredService struct {
+	Instance    string   `json:"instance"`
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	IPs         []string `json:"ips"`
+	Role        string   `json:"role,omitempty"`
+	ID          string   `json:"id,omitempty"`
+	Fingerprint string   `json:"fp,omitempty"`
+	SessionHint string   `json:"hint,omitempty"`
+}
+
+// discoveryHint is the session hint advertised and browsed for.
+var (
+	discoveryHintMu sync.Mutex
+	discoveryHint   string
+)
+
+// SetDiscoverySessionHint sets the session hint devices advertise and
+// DiscoverPeers looks for, e.g. a short code shown on both screens, so
+// ceremonies on the same network do not find each other. Empty matches any.
+func SetDiscoverySessionHint(hint string) (string, error) {
+	discoveryHintMu.Lock()
+	defer discoveryHintMu.Unlock()
+	discoveryHint = hint
+	return hint, nil
+}
+
+func currentDiscoveryHint() string {
+	discoveryHintMu.Lock()
+	defer discoveryHintMu.Unlock()
+	return discoveryHint
+}
+
+// ---- Responder ----
+
+// mdnsAdvertiser answers mDNS queries for one _bbmt._tcp instance.
+type mdnsAdvertiser struct {
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	txt      []string
+	conns    []net.PacketConn
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// mdnsLabel makes s a DNS label of at most 63 bytes.
+func mdnsLabel(s string) string {
+	s = strings.NewReplacer(".", "-", "\\", "-").Replace(s)
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	if s == "" {
+		s = "bbmt"
+	}
+	return s
+}
+
+// advertiseService starts answering for a _bbmt._tcp instance on port until
+// stop is called.
+func advertiseService(role, id, pubKey, port, hint string) (*mdnsAdvertiser, error) {
+	portNum, err := strconv.Atoi(port)
+	if err != nil || portNum <= 0 || portNum > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	label := id
+	if label == "" {
+		label = "bbmt-" + role
+	}
+	a := &mdnsAdvertiser{
+		instance: dnsmessage.MustNewName(mdnsLabel(label+"-"+hex.EncodeToString(suffix)) + "." + mdnsService),
+		host:     dnsmessage.MustNewName("bbmt-" + hex.EncodeToString(suffix) + ".local."),
+		port:     uint16(portNum),
+		txt:      []string{"v=1", "role=" + role},
+		done:     make(chan struct{}),
+	}
+	if id != "" {
+		a.txt = append(a.txt, "id="+id)
+	}
+	if fp, err := pubKeyFingerprint(pubKey); err == nil && pubKey != "" {
+		a.txt = append(a.txt, "fp="+fp)
+	}
+	if hint != "" {
+		a.txt = append(a.txt, "hint="+hint)
+	}
+
+	ifaces := multicastInterfaces()
+	if conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup4); err == nil {
+		p := ipv4.NewPacketConn(conn)
+		for i := range ifaces {
+			p.JoinGroup(&ifaces[i], &net.UDPAddr{IP: mdnsGroup4.IP})
+		}
+		p.SetMulticastLoopback(true)
+		a.conns = append(a.conns, conn)
+	} else {
+		Logln("BBMTLog", "mDNS IPv4 unavailable:", err)
+	}
+	if conn, err := net.ListenMulticastUDP("udp6", nil, mdnsGroup6); err == nil {
+		p := ipv6.NewPacketConn(conn)
+		for i := range ifaces {
+			p.JoinGroup(&ifaces[i], &net.UDPAddr{IP: mdnsGroup6.IP})
+		}
+		p.SetMulticastLoopback(true)
+		a.conns = append(a.conns, conn)
+	} else {
+		Logln("BBMTLog", "mDNS IPv6 unavailable:", err)
+	}
+	if len(a.conns) == 0 {
+		return nil, fmt.Errorf("no multicast network for mDNS")
+	}
+
+	for _, conn := range a.conns {
+		a.wg.Add(1)
+		go a.serve(conn)
+	}
+	go a.announce()
+	Logln("BBMTLog", "mDNS advertising", a.instance.String(), "on port", port)
+	return a, nil
+}
+
+// announce sends the records unsolicited twice, as RFC 6762 asks.
+func (a *mdnsAdvertiser) announce() {
+	for i := 0; i < 2; i++ {
+		if msg, err := a.response(0, nil, mdnsTTL); err == nil {
+			a.multicast(msg)
+		}
+		select {
+		case <-a.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (a *mdnsAdvertiser) multicast(msg []byte) {
+	for _, conn := range a.conns {
+		if conn.LocalAddr().(*net.UDPAddr).IP.To4() != nil {
+			conn.WriteTo(msg, mdnsGroup4)
+			continue
+		}
+		for _, ifi := range multicastInterfaces() {
+			conn.WriteTo(msg, &net.UDPAddr{IP: mdnsGroup6.IP, Port: mdnsPort, Zone: ifi.Name})
+		}
+	}
+}
+
+func (a *mdnsAdvertiser) serve(conn net.PacketConn) {
+	defer a.wg.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-a.done:
+			default:
+				Logln("BBMTLog", "mDNS read error:", err)
+			}
+			return
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.Response {
+			continue
+		}
+		questions, err := parser.AllQuestions()
+		if err != nil {
+			continue
+		}
+		wanted := []dnsmessage.Question{}
+		unicast := false
+		for _, q := range questions {
+			if a.answers(q) {
+				wanted = append(wanted, q)
+				// top bit of the class asks for a unicast response
+				unicast = unicast || q.Class&(1<<15) != 0
+			}
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+
+		// legacy unicast queries (RFC 6762 6.7) get their ID and questions back
+		src := from.(*net.UDPAddr)
+		if src.Port != mdnsPort {
+			msg, err := a.response(header.ID, wanted, 10)
+			if err == nil {
+				conn.WriteTo(msg, src)
+			}
+			continue
+		}
+		msg, err := a.response(0, nil, mdnsTTL)
+		if err != nil {
+			continue
+		}
+		if unicast {
+			conn.WriteTo(msg, src)
+		} else {
+			a.multicast(msg)
+		}
+	}
+}
+
+func (a *mdnsAdvertiser) answers(q dnsmessage.Question) bool {
+	name := strings.ToLower(q.Name.String())
+	switch name {
+	case mdnsService, mdnsServices:
+		return q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL
+	case strings.ToLower(a.instance.String()):
+		return q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL
+	case strings.ToLower(a.host.String()):
+		return q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeAAAA || q.Type == dnsmessage.TypeALL
+	}
+	return false
+}
+
+// response builds a message with all records of the instance.
+func (a *mdnsAdvertiser) response(id uint16, questions []dnsmessage.Question, ttl uint32) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if len(questions) > 0 {
+		b.StartQuestions()
+		for _, q := range questions {
+			q.Class &^= 1 << 15
+			b.Question(q)
+		}
+	}
+	b.StartAnswers()
+	service := dnsmessage.MustNewName(mdnsService)
+	header := func(name dnsmessage.Name, typ dnsmessage.Type, cacheFlush bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if cacheFlush {
+			class |= 1 << 15
+		}
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: class, TTL: ttl}
+	}
+	if err := b.PTRResource(header(service, dnsmessage.TypePTR, false), dnsmessage.PTRResource{PTR: a.instance}); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(header(a.instance, dnsmessage.TypeSRV, true), dnsmessage.SRVResource{Target: a.host, Port: a.port}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(header(a.instance, dnsmessage.TypeTXT, true), dnsmessage.TXTResource{TXT: a.txt}); err != nil {
+		return nil, err
+	}
+	for _, ip := range advertisedIPs() {
+		if ip4 := ip.To4(); ip4 != nil {
+			var addr [4]byte
+			copy(addr[:], ip4)
+			if err := b.AResource(header(a.host, dnsmessage.TypeA, true), dnsmessage.AResource{A: addr}); err != nil {
+				return nil, err
+			}
+		} else {
+			var addr [16]byte
+			copy(addr[:], ip.To16())
+			if err := b.AAAAResource(header(a.host, dnsmessage.TypeAAAA, true), dnsmessage.AAAAResource{AAAA: addr}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b.Finish()
+}
+
+// stop sends goodbye records and stops answering.
+func (a *mdnsAdvertiser) stop() {
+	if a == nil {
+		return
+	}
+	select {
+	case <-a.done:
+		return
+	default:
+	}
+	if msg, err := a.response(0, nil, 0); err == nil {
+		a.multicast(msg)
+	}
+	close(a.done)
+	for _, conn := range a.conns {
+		conn.Close()
+	}
+	a.wg.Wait()
+	Logln("BBMTLog", "mDNS advertising stopped", a.instance.String())
+}
+
+// multicastInterfaces are the interfaces mDNS runs on.
+func multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	up := []net.Interface{}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 {
+			up = append(up, ifi)
+		}
+	}
+	return up
+}
+
+// advertisedIPs are the addresses peers can reach this device on: IPv4 and
+// routable IPv6 addresses of the multicast interfaces, loopback when there
+// are none.
+func advertisedIPs() []net.IP {
+	ips := []net.IP{}
+	for _, ifi := range multicastInterfaces() {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		ips = append(ips, net.IPv4(127, 0, 0, 1))
+	}
+	return ips
+}
+
+// ---- Browser ----
+
+// browseServices queries the LAN for _bbmt._tcp services until ctx is done
+// or want services matching accept were found (0 waits for ctx).
+func browseServices(ctx context.Context, want int, accept func(DiscoveredService) bool) ([]DiscoveredService, error) {
+	query, err := mdnsQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	// legacy unicast queries from ephemeral ports, responders answer them
+	// directly so no port 5353 socket is needed
+	conns := []net.PacketConn{}
+	if conn, err := net.ListenUDP("udp4", &net.UDPAddr{}); err == nil {
+		conns = append(conns, conn)
+	}
+	if conn, err := net.ListenUDP("udp6", &net.UDPAddr{}); err == nil {
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("no network for mDNS")
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	send := func() {
+		for _, conn := range conns {
+			if conn.LocalAddr().(*net.UDPAddr).IP.To4() != nil {
+				conn.WriteTo(query, mdnsGroup4)
+				continue
+			}
+			for _, ifi := range multicastInterfaces() {
+				conn.WriteTo(query, &net.UDPAddr{IP: mdnsGroup6.IP, Port: mdnsPort, Zone: ifi.Name})
+			}
+		}
+	}
+
+	records := newMDNSRecords()
+	var mu sync.Mutex
+	found := make(chan struct{}, 1)
+	for _, conn := range conns {
+		go func(conn net.PacketConn) {
+			buf := make([]byte, 9000)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				records.add(buf[:n])
+				done := want > 0 && len(filterServices(records.services(), accept)) >= want
+				mu.Unlock()
+				if done {
+					select {
+					case found <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}(conn)
+	}
+
+	send()
+	ticker := time.NewTicker(mdnsBrowseGap)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-found:
+			break loop
+		case <-ticker.C:
+			send()
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return filterServices(records.services(), accept), nil
+}
+
+func mdnsQuery() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(time.Now().UnixNano())})
+	b.StartQuestions()
+	if err := b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(mdnsService),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+func filterServices(services []DiscoveredService, accept func(DiscoveredService) bool) []DiscoveredService {
+	if accept == nil {
+		return services
+	}
+	kept := []DiscoveredService{}
+	for _, s := range services {
+		if accept(s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// mdnsRecords collects the records of browse responses.
+type mdnsRecords struct {
+	instances map[string]bool
+	srv       map[string]dnsmessage.SRVResource
+	txt       map[string][]string
+	addrs     map[string][]net.IP
+}
+
+func newMDNSRecords() *mdnsRecords {
+	return &mdnsRecords{
+		instances: map[string]bool{},
+		srv:       map[string]dnsmessage.SRVResource{},
+		txt:       map[string][]string{},
+		addrs:     map[string][]net.IP{},
+	}
+}
+
+func (m *mdnsRecords) add(packet []byte) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil || !header.Response {
+		return
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return
+	}
+	for {
+		h, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return
+		}
+		if err := m.record(&parser, h, parser.SkipAnswer); err != nil {
+			Logln("BBMTLog", "mDNS record error:", err)
+			return
+		}
+	}
+	if err := parser.SkipAllAuthorities(); err != nil {
+		return
+	}
+	for {
+		h, err := parser.AdditionalHeader()
+		if err != nil {
+			return
+		}
+		if err := m.record(&parser, h, parser.SkipAdditional); err != nil {
+			Logln("BBMTLog", "mDNS record error:", err)
+			return
+		}
+	}
+}
+
+// record parses the resource of h, skip skips it in the current section.
+func (m *mdnsRecords) record(parser *dnsmessage.Parser, h dnsmessage.ResourceHeader, skip func() error) error {
+	name := strings.ToLower(h.Name.String())
+	switch h.Type {
+	case dnsmessage.TypePTR:
+		r, err := parser.PTRResource()
+		if err != nil {
+			return err
+		}
+		if name == mdnsService {
+			m.instances[strings.ToLower(r.PTR.String())] = h.TTL > 0
+		}
+	case dnsmessage.TypeSRV:
+		r, err := parser.SRVResource()
+		if err != nil {
+			return err
+		}
+		m.srv[name] = r
+	case dnsmessage.TypeTXT:
+		r, err := parser.TXTResource()
+		if err != nil {
+			return err
+		}
+		m.txt[name] = r.TXT
+	case dnsmessage.TypeA:
+		r, err := parser.AResource()
+		if err != nil {
+			return err
+		}
+		m.addIP(name, net.IP(r.A[:]))
+	case dnsmessage.TypeAAAA:
+		r, err := parser.AAAAResource()
+		if err != nil {
+			return err
+		}
+		m.addIP(name, net.IP(r.AAAA[:]))
+	default:
+		return skip()
+	}
+	return nil
+}
+
+func (m *mdnsRecords) addIP(host string, ip net.IP) {
+	for _, known := range m.addrs[host] {
+		if known.Equal(ip) {
+			return
+		}
+	}
+	m.addrs[host] = append(m.addrs[host], ip)
+}
+
+// services are the complete live instances: with SRV and addresses.
+func (m *mdnsRecords) services() []DiscoveredService {
+	services := []DiscoveredService{}
+	for instance, live := range m.instances {
+		srv, ok := m.srv[instance]
+		if !live || !ok {
+			continue
+		}
+		host := strings.ToLower(srv.Target.String())
+		ips := m.addrs[host]
+		if len(ips) == 0 {
+			continue
+		}
+		// IPv4 first, most LAN peers are reachable on it
+		sorted := append([]net.IP{}, ips...)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].To4() != nil && sorted[j].To4() == nil })
+		s := DiscoveredService{
+			Instance: strings.TrimSuffix(instance, "."+mdnsService),
+			Host:     host,
+			Port:     int(srv.Port),
+		}
+		for _, ip := range sorted {
+			s.IPs = append(s.IPs, ip.String())
+		}
+		for _, kv := range m.txt[instance] {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "role":
+				s.Role = v
+			case "id":
+				s.ID = v
+			case "fp":
+				s.Fingerprint = v
+			case "hint":
+				s.SessionHint = v
+			}
+		}
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Instance < services[j].Instance })
+	return services
+}
+
+// BrowseLANServices lists the BBMT relays and waiting peers advertised on
+// the LAN within timeoutSeconds, as a JSON array of DiscoveredService.
+func BrowseLANServices(timeoutSeconds int) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in BrowseLANServices: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = 3
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds)*time.Second)
+	defer cancel()
+	services, err := browseServices(ctx, 0, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to browse LAN services: %w", err)
+	}
+	data, err := json.Marshal(services)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal LAN services: %w", err)
+	}
+	return string(data), nil
+}
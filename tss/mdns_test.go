@@ -0,0 +1,98 @@
+package tss
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// An NSEC record in the additional section, as Apple and Avahi responders
+// send, must be skipped rather than parsed forever.
+func TestMDNSRecordsSkipUnknownAdditional(t *testing.T) {
+	service := dnsmessage.MustNewName(mdnsService)
+	instance := dnsmessage.MustNewName("alice." + mdnsService)
+	host := dnsmessage.MustNewName("alice.local.")
+	header := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: mdnsTTL}
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.StartAnswers()
+	b.PTRResource(header(service, dnsmessage.TypePTR), dnsmessage.PTRResource{PTR: instance})
+	b.SRVResource(header(instance, dnsmessage.TypeSRV), dnsmessage.SRVResource{Target: host, Port: 55061})
+	b.TXTResource(header(instance, dnsmessage.TypeTXT), dnsmessage.TXTResource{TXT: []string{"role=peer", "id=alice"}})
+	b.StartAdditionals()
+	b.UnknownResource(header(instance, dnsmessage.Type(47)), dnsmessage.UnknownResource{Type: dnsmessage.Type(47), Data: []byte{0xc0, 0x0c, 0x00, 0x05, 0x00, 0x00, 0x80, 0x00, 0x40}})
+	b.AResource(header(host, dnsmessage.TypeA), dnsmessage.AResource{A: [4]byte{192, 0, 2, 7}})
+	packet, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := newMDNSRecords()
+	done := make(chan struct{})
+	go func() {
+		records.add(packet)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("parsing a response with an NSEC record did not return")
+	}
+	// the A record after the NSEC record is still read
+	services := records.services()
+	if len(services) != 1 || services[0].ID != "alice" || services[0].IPs[0] != "192.0.2.7" {
+		t.Fatalf("unexpected services %+v", services)
+	}
+}
+
+func TestMDNSRecordsTruncated(t *testing.T) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	b.StartAnswers()
+	b.PTRResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(mdnsService), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: mdnsTTL},
+		dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("alice." + mdnsService)})
+	packet, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := newMDNSRecords()
+	for n := 12; n < len(packet); n++ {
+		records.add(packet[:n])
+	}
+	if len(records.services()) != 0 {
+		t.Fatal("truncated response produced a service")
+	}
+}
+
+// Two responders in this process answer a browse over multicast loopback.
+func TestBrowseServicesLoopback(t *testing.T) {
+	peer, err := advertiseService(DiscoveryRolePeer, "alice", "", "55061", "hint")
+	if err != nil {
+		t.Skipf("no multicast: %v", err)
+	}
+	defer peer.stop()
+	relay, err := advertiseService(DiscoveryRoleRelay, "", "", "55062", "")
+	if err != nil {
+		t.Skipf("no multicast: %v", err)
+	}
+	defer relay.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	services, err := browseServices(ctx, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := map[string]DiscoveredService{}
+	for _, s := range services {
+		roles[s.Role] = s
+	}
+	if len(services) != 2 || roles[DiscoveryRolePeer].Port != 55061 || roles[DiscoveryRoleRelay].Port != 55062 {
+		t.Fatalf("unexpected services %+v", services)
+	}
+	if roles[DiscoveryRolePeer].ID != "alice" || roles[DiscoveryRolePeer].SessionHint != "hint" {
+		t.Fatalf("unexpected peer %+v", roles[DiscoveryRolePeer])
+	}
+}
@@ -0,0 +1,444 @@
+package tss
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	mecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// Bitcoin message signature formats.
+const (
+	MessageFormatBIP137 = "bip137" // legacy "Bitcoin Signed Message", 65 bytes base64
+	MessageFormatBIP322 = "bip322" // generic signed message, simple or full format
+)
+
+const (
+	bitcoinMessagePrefix = "Bitcoin Signed Message:\n"
+	bip322Tag            = "BIP0322-signed-message"
+
+	// limits on a BIP-322 simple signature's witness stack
+	maxWitnessItems    = 500
+	maxWitnessItemSize = 11000
+)
+
+// Address kinds a keyshare can sign messages for.
+const (
+	messageP2PKH      = "p2pkh"
+	messageP2SHP2WPKH = "p2sh-p2wpkh"
+	messageP2WPKH     = "p2wpkh"
+)
+
+// bip137HeaderBase is the first BIP-137 header byte of each address kind,
+// the recovery ID is added to it.
+var bip137HeaderBase = map[string]byte{
+	messageP2PKH:      31,
+	messageP2SHP2WPKH: 35,
+	messageP2WPKH:     39,
+}
+
+// BitcoinMessageHash returns the base64 BIP-137 digest of message, the double
+// SHA-256 of the "Bitcoin Signed Message" prefix and the message. It can be
+// passed to JoinKeysign as is.
+func BitcoinMessageHash(message string) (string, error) {
+	return base64.StdEncoding.EncodeToString(bitcoinMessageDigest(message)), nil
+}
+
+func bitcoinMessageDigest(message string) []byte {
+	var buf bytes.Buffer
+	wire.WriteVarString(&buf, 0, bitcoinMessagePrefix)
+	wire.WriteVarString(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+func btcNetParams() *chaincfg.Params {
+	if _btc_net == "mainnet" {
+		return &chaincfg.MainNetParams
+	}
+	return &chaincfg.TestNet3Params
+}
+
+// messageAddressScripts checks that address belongs to pubKey and returns its
+// kind, its output script and, for nested SegWit, its redeem script.
+func messageAddressScripts(pubKey []byte, address btcutil.Address) (string, []byte, []byte, error) {
+	pubKeyHash := btcutil.Hash160(pubKey)
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to create output script: %w", err)
+	}
+	switch addr := address.(type) {
+	case *btcutil.AddressPubKeyHash:
+		if bytes.Equal(addr.Hash160()[:], pubKeyHash) {
+			return messageP2PKH, pkScript, nil, nil
+		}
+	case *btcutil.AddressWitnessPubKeyHash:
+		if bytes.Equal(addr.Hash160()[:], pubKeyHash) {
+			return messageP2WPKH, pkScript, nil, nil
+		}
+	case *btcutil.AddressScriptHash:
+		redeemScript := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, pubKeyHash...)
+		if bytes.Equal(addr.Hash160()[:], btcutil.Hash160(redeemScript)) {
+			return messageP2SHP2WPKH, pkScript, redeemScript, nil
+		}
+	case *btcutil.AddressTaproot:
+		return "", nil, nil, errors.New("taproot (P2TR) message signing is not supported, BNB-TSS has no Schnorr support")
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported address type %T", address)
+	}
+	return "", nil, nil, fmt.Errorf("address %s does not belong to public key %x", address.EncodeAddress(), pubKey)
+}
+
+// bip322ToSpend is the BIP-322 virtual transaction that commits to the
+// message and pays to the address.
+func bip322ToSpend(pkScript []byte, message string) (*wire.MsgTx, error) {
+	messageHash := chainhash.TaggedHash([]byte(bip322Tag), []byte(message))
+	scriptSig, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(messageHash[:]).Script()
+	if err != nil {
+		return nil, err
+	}
+	tx := wire.NewMsgTx(0)
+	txIn := wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0xffffffff), scriptSig, nil)
+	txIn.Sequence = 0
+	tx.AddTxIn(txIn)
+	tx.AddTxOut(wire.NewTxOut(0, pkScript))
+	return tx, nil
+}
+
+// bip322ToSign is the BIP-322 virtual transaction spending toSpend, the one
+// that gets signed.
+func bip322ToSign(toSpend *wire.MsgTx) *wire.MsgTx {
+	toSpendHash := toSpend.TxHash()
+	tx := wire.NewMsgTx(0)
+	txIn := wire.NewTxIn(wire.NewOutPoint(&toSpendHash, 0), nil, nil)
+	txIn.Sequence = 0
+	tx.AddTxIn(txIn)
+	tx.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	return tx
+}
+
+// encodeWitness serializes a witness stack the way BIP-322 simple signatures
+// carry it.
+func encodeWitness(witness wire.TxWitness) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := wire.WriteVarInt(&buf, 0, uint64(len(witness))); err != nil {
+		return nil, err
+	}
+	for _, item := range witness {
+		if err := wire.WriteVarBytes(&buf, 0, item); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeWitness parses a serialized witness stack, which must use up data.
+func decodeWitness(data []byte) (wire.TxWitness, error) {
+	reader := bytes.NewReader(data)
+	count, err := wire.ReadVarInt(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxWitnessItems {
+		return nil, errors.New("too many witness items")
+	}
+	witness := make(wire.TxWitness, count)
+	for i := range witness {
+		if witness[i], err = wire.ReadVarBytes(reader, 0, maxWitnessItemSize, "witness item"); err != nil {
+			return nil, err
+		}
+	}
+	if reader.Len() != 0 {
+		return nil, errors.New("trailing bytes after witness")
+	}
+	return witness, nil
+}
+
+// signBitcoinMessage signs message for address in format with the derived
+// public key pubKeyHex. keysign runs the MPC keysign of a base64 digest and
+// returns the KeysignResponse JSON. The signature is verified before it is
+// returned.
+func signBitcoinMessage(pubKeyHex, address, message, format string, keysign func(digestBase64 string) (string, error)) (string, error) {
+	pubKey, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key format: %w", err)
+	}
+	addr, err := btcutil.DecodeAddress(address, btcNetParams())
+	if err != nil {
+		return "", fmt.Errorf("failed to decode address: %w", err)
+	}
+	kind, pkScript, redeemScript, err := messageAddressScripts(pubKey, addr)
+	if err != nil {
+		return "", err
+	}
+	sign := func(digest []byte) (*KeysignResponse, error) {
+		sigJSON, err := keysign(base64.StdEncoding.EncodeToString(digest))
+		if err != nil {
+			return nil, err
+		}
+		var sig KeysignResponse
+		if err := json.Unmarshal([]byte(sigJSON), &sig); err != nil {
+			return nil, fmt.Errorf("failed to parse signature response: %w", err)
+		}
+		return &sig, nil
+	}
+
+	var signature string
+	switch format {
+	case MessageFormatBIP137:
+		sig, err := sign(bitcoinMessageDigest(message))
+		if err != nil {
+			return "", err
+		}
+		r, _ := hex.DecodeString(sig.R)
+		s, _ := hex.DecodeString(sig.S)
+		recoveryID, _ := hex.DecodeString(sig.RecoveryID)
+		if len(r) != 32 || len(s) != 32 || len(recoveryID) != 1 || recoveryID[0] > 3 {
+			return "", errors.New("malformed keysign signature")
+		}
+		compact := append([]byte{bip137HeaderBase[kind] + recoveryID[0]}, r...)
+		signature = base64.StdEncoding.EncodeToString(append(compact, s...))
+
+	case MessageFormatBIP322:
+		toSpend, err := bip322ToSpend(pkScript, message)
+		if err != nil {
+			return "", fmt.Errorf("failed to build to_spend: %w", err)
+		}
+		toSign := bip322ToSign(toSpend)
+		hashCache := txscript.NewTxSigHashes(toSign, txscript.NewCannedPrevOutputFetcher(pkScript, 0))
+		var sigHash []byte
+		switch kind {
+		case messageP2WPKH:
+			sigHash, err = txscript.CalcWitnessSigHash(pkScript, hashCache, txscript.SigHashAll, toSign, 0, 0)
+		case messageP2SHP2WPKH:
+			sigHash, err = txscript.CalcWitnessSigHash(redeemScript, hashCache, txscript.SigHashAll, toSign, 0, 0)
+		default:
+			sigHash, err = txscript.CalcSignatureHash(pkScript, txscript.SigHashAll, toSign, 0)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to calculate to_sign sighash: %w", err)
+		}
+		sig, err := sign(sigHash)
+		if err != nil {
+			return "", err
+		}
+		der, err := hex.DecodeString(sig.DerSignature)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode DER signature: %w", err)
+		}
+		signatureWithHashType := append(der, byte(txscript.SigHashAll))
+
+		// native SegWit uses the simple format (the witness), the others the
+		// full format (the whole to_sign transaction)
+		var encoded []byte
+		switch kind {
+		case messageP2WPKH:
+			encoded, err = encodeWitness(wire.TxWitness{signatureWithHashType, pubKey})
+		case messageP2SHP2WPKH:
+			toSign.TxIn[0].SignatureScript, err = txscript.NewScriptBuilder().AddData(redeemScript).Script()
+			toSign.TxIn[0].Witness = wire.TxWitness{signatureWithHashType, pubKey}
+		default:
+			toSign.TxIn[0].SignatureScript, err = txscript.NewScriptBuilder().AddData(signatureWithHashType).AddData(pubKey).Script()
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to build signature: %w", err)
+		}
+		if encoded == nil {
+			var buf bytes.Buffer
+			if err := toSign.Serialize(&buf); err != nil {
+				return "", fmt.Errorf("failed to serialize to_sign: %w", err)
+			}
+			encoded = buf.Bytes()
+		}
+		signature = base64.StdEncoding.EncodeToString(encoded)
+
+	default:
+		return "", fmt.Errorf("unknown message format %q, use %s or %s", format, MessageFormatBIP137, MessageFormatBIP322)
+	}
+
+	if ok, err := verifyBitcoinMessage(addr, message, signature); err != nil || !ok {
+		return "", ErrInvalidSignature(fmt.Sprintf("%s message signature does not verify for %s", format, address))
+	}
+	return signature, nil
+}
+
+// VerifyMessageSignature verifies a base64 BIP-137 or BIP-322 (simple or
+// full) signature of message by address. BIP-137 signatures with a P2PKH
+// header are also accepted for the SegWit addresses of the same key, as
+// Electrum and hardware wallets produce them.
+func VerifyMessageSignature(address, message, signature string) (result bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in VerifyMessageSignature: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = false
+		}
+	}()
+
+	addr, err := btcutil.DecodeAddress(address, btcNetParams())
+	if err != nil {
+		return false, fmt.Errorf("failed to decode address: %w", err)
+	}
+	return verifyBitcoinMessage(addr, message, signature)
+}
+
+func verifyBitcoinMessage(addr btcutil.Address, message, signature string) (bool, error) {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, fmt.Errorf("signature is not base64: %w", err)
+	}
+	if len(sig) == 65 && sig[0] >= 27 && sig[0] <= 42 {
+		return verifyBip137(addr, message, sig)
+	}
+	return verifyBip322(addr, message, sig)
+}
+
+func verifyBip137(addr btcutil.Address, message string, sig []byte) (bool, error) {
+	header := sig[0]
+	compact := append([]byte{}, sig...)
+	if header >= 35 {
+		compact[0] = 31 + (header-27)&3 // compressed key, recovery ID
+	}
+	pubKey, compressed, err := mecdsa.RecoverCompact(compact, bitcoinMessageDigest(message))
+	if err != nil {
+		return false, nil
+	}
+	serialized := pubKey.SerializeUncompressed()
+	if compressed {
+		serialized = pubKey.SerializeCompressed()
+	}
+
+	var kinds []string
+	switch {
+	case header < 31:
+		kinds = []string{messageP2PKH}
+	case header < 35:
+		kinds = []string{messageP2PKH, messageP2SHP2WPKH, messageP2WPKH}
+	case header < 39:
+		kinds = []string{messageP2SHP2WPKH}
+	default:
+		kinds = []string{messageP2WPKH}
+	}
+	kind, _, _, err := messageAddressScripts(serialized, addr)
+	if err != nil {
+		return false, nil
+	}
+	return Contains(kinds, kind), nil
+}
+
+func verifyBip322(addr btcutil.Address, message string, sig []byte) (bool, error) {
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return false, fmt.Errorf("failed to create output script: %w", err)
+	}
+	toSpend, err := bip322ToSpend(pkScript, message)
+	if err != nil {
+		return false, fmt.Errorf("failed to build to_spend: %w", err)
+	}
+	toSign := bip322ToSign(toSpend)
+	if witness, err := decodeWitness(sig); err == nil {
+		toSign.TxIn[0].Witness = witness
+	} else {
+		// full format: the signed to_sign transaction
+		var full wire.MsgTx
+		if err := full.Deserialize(bytes.NewReader(sig)); err != nil {
+			return false, errors.New("signature is neither a BIP-137, a BIP-322 simple nor a BIP-322 full signature")
+		}
+		if len(full.TxIn) == 0 || full.TxIn[0].PreviousOutPoint != toSign.TxIn[0].PreviousOutPoint ||
+			len(full.TxOut) != 1 || full.TxOut[0].Value != 0 || !bytes.Equal(full.TxOut[0].PkScript, []byte{txscript.OP_RETURN}) {
+			return false, nil
+		}
+		toSign = &full
+	}
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(pkScript, 0)
+	vm, err := txscript.NewEngine(pkScript, toSign, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(toSign, prevOutFetcher), 0, prevOutFetcher)
+	if err != nil {
+		return false, nil
+	}
+	return vm.Execute() == nil, nil
+}
+
+// signingPubKey is the public key a keyshare signs with at derivePath.
+func signingPubKey(pubKey, chainCodeHex, derivePath string) (string, error) {
+	if _, err := btcec.ParsePubKey(hexToBytes(pubKey)); err != nil {
+		return "", fmt.Errorf("invalid keyshare public key: %w", err)
+	}
+	return GetDerivedPubKey(pubKey, chainCodeHex, derivePath, false)
+}
+
+// keyshareSigningPubKey is signingPubKey for a JSON or base64 keyshare.
+func keyshareSigningPubKey(keyshare, derivePath string) (string, error) {
+	localStateStr, err := (&LocalStateAccessorImp{}).GetLocalState(keyshare)
+	if err != nil {
+		return "", err
+	}
+	var localState LocalState
+	if err := json.Unmarshal([]byte(localStateStr), &localState); err != nil {
+		return "", fmt.Errorf("failed to unmarshal keyshare: %w", err)
+	}
+	return signingPubKey(localState.PubKey, localState.ChainCodeHex, derivePath)
+}
+
+// JoinSignMessage signs message for address over the HTTP relay and returns
+// the base64 signature: the 65-byte compact signature for bip137, the
+// witness (native SegWit) or the signed to_sign transaction (P2PKH, nested
+// SegWit) for bip322. address must be an address of the keyshare at
+// derivePath.
+func JoinSignMessage(server, key, partiesCSV, session, sessionKey, encKey, decKey, keyshare, derivePath, address, message, format string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in JoinSignMessage: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	pubKey, err := keyshareSigningPubKey(keyshare, derivePath)
+	if err != nil {
+		return "", err
+	}
+	return signBitcoinMessage(pubKey, address, message, format, func(digestBase64 string) (string, error) {
+		return JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, keyshare, derivePath, digestBase64)
+	})
+}
+
+// NostrSignMessage is JoinSignMessage over Nostr.
+func NostrSignMessage(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON, derivationPath, address, message, format string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in NostrSignMessage: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	cfg, keyshare, allParties, err := nostrSigningConfig(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON)
+	if err != nil {
+		return "", err
+	}
+	pubKey, err := signingPubKey(keyshare.PubKey, keyshare.ChainCodeHex, derivationPath)
+	if err != nil {
+		return "", err
+	}
+	return signBitcoinMessage(pubKey, address, message, format, func(digestBase64 string) (string, error) {
+		return runNostrKeysignInternalWithSighash(cfg, keyshare, derivationPath, digestBase64, allParties)
+	})
+}
@@ -0,0 +1,165 @@
+package tss
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	mecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// useNetwork switches _btc_net for the test.
+func useNetwork(t *testing.T, network string) {
+	previous := _btc_net
+	_btc_net = network
+	t.Cleanup(func() { _btc_net = previous })
+}
+
+// localKeysign signs like a keysign would, with a single private key.
+func localKeysign(t *testing.T, privKey *btcec.PrivateKey) func(string) (string, error) {
+	return func(digestBase64 string) (string, error) {
+		digest, err := base64.StdEncoding.DecodeString(digestBase64)
+		if err != nil {
+			return "", err
+		}
+		compact := mecdsa.SignCompact(privKey, digest, true)
+		sig, err := normalizeSignature(privKey.PubKey().ToECDSA(), digest, compact[1:33], compact[33:])
+		if err != nil {
+			return "", err
+		}
+		resp, err := json.Marshal(KeysignResponse{
+			R:            hex.EncodeToString(sig.R),
+			S:            hex.EncodeToString(sig.S),
+			DerSignature: hex.EncodeToString(sig.DER),
+			RecoveryID:   hex.EncodeToString([]byte{sig.RecoveryID}),
+		})
+		return string(resp), err
+	}
+}
+
+// The test vectors of BIP-322.
+func TestBIP322Vectors(t *testing.T) {
+	useNetwork(t, "mainnet")
+	const address = "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+	addr, err := btcutil.DecodeAddress(address, btcNetParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vector := range []struct {
+		message, messageHash, toSpend, toSign, signature string
+	}{
+		{
+			message:     "",
+			messageHash: "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1",
+			toSpend:     "c5680aa69bb8d860bf82d4e9cd3504b55dde018de765a91bb566283c545a99a7",
+			toSign:      "1e9654e951a5ba44c8604c4de6c67fd78a27e81dcadcfe1edf638ba3aaebaed6",
+			signature:   "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+		},
+		{
+			message:     "Hello World",
+			messageHash: "f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a",
+			toSpend:     "b79d196740ad5217771c1098fc4a4b51e0535c32236c71f1ea4d61a2d603352b",
+			toSign:      "88737ae86f2077145f93cc4b153ae9a1cb8d56afa511988c149c5c8c9d93bddf",
+			signature:   "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+		},
+	} {
+		messageHash := chainhash.TaggedHash([]byte(bip322Tag), []byte(vector.message))
+		if hex.EncodeToString(messageHash[:]) != vector.messageHash {
+			t.Fatalf("%q: message hash %x", vector.message, messageHash[:])
+		}
+		toSpend, err := bip322ToSpend(pkScript, vector.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if toSpend.TxHash().String() != vector.toSpend {
+			t.Fatalf("%q: to_spend %s", vector.message, toSpend.TxHash())
+		}
+		if toSign := bip322ToSign(toSpend); toSign.TxHash().String() != vector.toSign {
+			t.Fatalf("%q: to_sign %s", vector.message, toSign.TxHash())
+		}
+		if ok, err := VerifyMessageSignature(address, vector.message, vector.signature); err != nil || !ok {
+			t.Fatalf("%q: signature rejected: %v", vector.message, err)
+		}
+	}
+	// each signature is only good for its own message
+	if ok, _ := VerifyMessageSignature(address, "Hello World", "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="); ok {
+		t.Fatal("signature of the empty message verifies for Hello World")
+	}
+}
+
+func TestMessageSigningAddressKinds(t *testing.T) {
+	useNetwork(t, "testnet3")
+	privKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+	addresses := map[string]func(string, string) (string, error){
+		messageP2PKH:      PubToP2KH,
+		messageP2WPKH:     PubToP2WPKH,
+		messageP2SHP2WPKH: PubToP2SHP2WKH,
+	}
+	for kind, toAddress := range addresses {
+		address, err := toAddress(pubKey, "testnet3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []string{MessageFormatBIP137, MessageFormatBIP322} {
+			signature, err := signBitcoinMessage(pubKey, address, "hello", format, localKeysign(t, privKey))
+			if err != nil {
+				t.Fatalf("%s %s: %v", kind, format, err)
+			}
+			if ok, err := VerifyMessageSignature(address, "hello", signature); err != nil || !ok {
+				t.Fatalf("%s %s: rejected: %v", kind, format, err)
+			}
+			if ok, _ := VerifyMessageSignature(address, "hellO", signature); ok {
+				t.Fatalf("%s %s: verifies for another message", kind, format)
+			}
+		}
+	}
+}
+
+// BIP-137 signatures are the compact signatures of Bitcoin Core's signmessage,
+// with the header of the address kind.
+func TestBIP137Headers(t *testing.T) {
+	useNetwork(t, "testnet3")
+	privKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+	compact := mecdsa.SignCompact(privKey, bitcoinMessageDigest("hello"), true)
+	recoveryID := compact[0] - 31
+
+	for kind, toAddress := range map[string]func(string, string) (string, error){
+		messageP2PKH:      PubToP2KH,
+		messageP2WPKH:     PubToP2WPKH,
+		messageP2SHP2WPKH: PubToP2SHP2WKH,
+	} {
+		address, err := toAddress(pubKey, "testnet3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := signBitcoinMessage(pubKey, address, "hello", MessageFormatBIP137, localKeysign(t, privKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]byte{bip137HeaderBase[kind] + recoveryID}, compact[1:]...)
+		if signature != base64.StdEncoding.EncodeToString(want) {
+			t.Fatalf("%s: signature %s", kind, signature)
+		}
+		// Electrum signs SegWit addresses with the P2PKH header
+		if ok, err := VerifyMessageSignature(address, "hello", base64.StdEncoding.EncodeToString(compact)); err != nil || !ok {
+			t.Fatalf("%s: P2PKH header rejected: %v", kind, err)
+		}
+	}
+}
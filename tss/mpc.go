@@ -1,7 +1,7 @@
 package tss
 
 import (
-	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
@@ -28,6 +28,7 @@ type Status struct {
 	Type  string
 	Done  bool
 	Time  int
+	Blame *Blame // set when the session aborted
 }
 
 type MessengerImp struct {
@@ -38,25 +39,31 @@ type MessengerImp struct {
 }
 
 type LocalStateAccessorImp struct {
-	key string
+	key     string
+	session string
 }
 
 var (
-	statusMap        = make(map[string]Status)
-	statusLog        = make(map[string][]Status)
-	encryptionKey    = ""
-	decryptionKey    = ""
-	localStateMemory = ""
-	keyGenTimeout    = 120
-	keySignTimeout   = 60
-	msgFetchTimeout  = 70
+	keyGenTimeout   = 120
+	keySignTimeout  = 60
+	msgFetchTimeout = 70
+
+	// poll interval while messages are pushed over the relay stream
+	streamPollInterval = 5 * time.Second
+	// message post attempts and the backoff step between them
+	sendAttempts   = 3
+	sendRetryDelay = 500 * time.Millisecond
 )
 
 func SessionState(session string) string {
-	status, exists := statusMap[session]
+	status, exists := registry.status(session)
 	if !exists {
 		return "{}" // Return an empty state if session doesn't exist
 	}
+	return formatSessionState(status)
+}
+
+func formatSessionState(status Status) string {
 	step := status.Step
 	seqNo := status.SeqNo
 	index := status.Index
@@ -64,20 +71,26 @@ func SessionState(session string) string {
 	time := status.Time
 	done := status.Done
 
+	blame := ""
+	if status.Blame != nil {
+		if blameJSON, err := json.Marshal(status.Blame); err == nil {
+			blame = `, "blame": ` + string(blameJSON)
+		}
+	}
+
 	return fmt.Sprintf(
-		`{ "time": %d, "step": %d, "type": "%s", "info": "%s", "sentNo": %d, "receivedNo": %d, "done": %t }`,
-		time, step, status.Type, info, seqNo, index, done,
+		`{ "time": %d, "step": %d, "type": "%s", "info": "%s", "sentNo": %d, "receivedNo": %d, "done": %t%s }`,
+		time, step, status.Type, info, seqNo, index, done, blame,
 	)
 }
 
 func ClearSessionLog(session string) {
-	delete(statusMap, session)
-	delete(statusLog, session)
+	registry.clear(session)
 }
 
 func SessionLog(session string) string {
 
-	statuses, exists := statusLog[session]
+	statuses, exists := registry.logs(session)
 	if !exists {
 		return "[]"
 	}
@@ -109,62 +122,52 @@ func stringJoin(parts []string, delimiter string) string {
 }
 
 func getStatus(session string) Status {
-	return statusMap[session]
+	status, _ := registry.status(session)
+	return status
 }
 
 func setSeqNo(session, info string, step, seqNo int) {
-	status := statusMap[session]
-	status.Time = int(time.Now().Unix())
-	status.Step = step
-	status.SeqNo = seqNo
-	status.Info = info
-	statusMap[session] = status
-	if _, exists := statusLog[session]; !exists {
-		statusLog[session] = []Status{}
-	}
-	statusLog[session] = append(statusLog[session], status)
+	registry.update(session, func(status *Status) {
+		status.Step = step
+		status.SeqNo = seqNo
+		status.Info = info
+	})
 }
 
 func setIndex(session, info string, step, index int) {
-	status := statusMap[session]
-	status.Time = int(time.Now().Unix())
-	status.Step = step
-	status.Index = index
-	status.Info = info
-	statusMap[session] = status
-	if _, exists := statusLog[session]; !exists {
-		statusLog[session] = []Status{}
-	}
-	statusLog[session] = append(statusLog[session], status)
+	registry.update(session, func(status *Status) {
+		status.Step = step
+		status.Index = index
+		status.Info = info
+	})
 }
 
 func setStep(session, info string, step int) {
-	status := statusMap[session]
-	status.Step = step
-	status.Info = info
-	status.Time = int(time.Now().Unix())
-	statusMap[session] = status
-	if _, exists := statusLog[session]; !exists {
-		statusLog[session] = []Status{}
-	}
-	statusLog[session] = append(statusLog[session], status)
-	Hook(SessionState(session))
+	status := registry.update(session, func(status *Status) {
+		status.Step = step
+		status.Info = info
+	})
+	Hook(formatSessionState(status))
 }
 
 func setStatus(session string, status Status) {
-	status.Time = int(time.Now().Unix())
-	statusMap[session] = status
-	if _, exists := statusLog[session]; !exists {
-		statusLog[session] = []Status{}
-	}
-	statusLog[session] = append(statusLog[session], status)
-	Hook(SessionState(session))
+	status = registry.update(session, func(current *Status) {
+		*current = status
+	})
+	Hook(formatSessionState(status))
 }
 
 func JoinKeygen(ppmPath, key, partiesCSV, encKey, decKey, session, server, chaincode, sessionKey string) (result string, err error) {
+	return JoinKeygenWithThreshold(ppmPath, key, partiesCSV, 0, encKey, decKey, session, server, chaincode, sessionKey)
+}
+
+// JoinKeygenWithThreshold runs keygen with an explicit t-of-n threshold, where
+// threshold is the number of parties required to sign (e.g. 3 for 3-of-5).
+// A threshold of 0 uses the default derived from the party count.
+func JoinKeygenWithThreshold(ppmPath, key, partiesCSV string, threshold int, encKey, decKey, session, server, chaincode, sessionKey string) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			errMsg := fmt.Sprintf("PANIC in JoinKeygen: %v", r)
+			errMsg := fmt.Sprintf("PANIC in JoinKeygenWithThreshold: %v", r)
 			Logf("BBMTLog: %s", errMsg)
 			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
 			err = fmt.Errorf("internal error (panic): %v", r)
@@ -173,6 +176,10 @@ func JoinKeygen(ppmPath, key, partiesCSV, encKey, decKey, session, server, chain
 	}()
 
 	parties := strings.Split(partiesCSV, ",")
+	tssThreshold, err := GetThresholdForSigners(threshold, len(parties))
+	if err != nil {
+		return "", err
+	}
 
 	if len(sessionKey) > 0 && (len(encKey) > 0 || len(decKey) > 0) {
 		return "", fmt.Errorf("either a session key, either enc/dec keys")
@@ -182,12 +189,10 @@ func JoinKeygen(ppmPath, key, partiesCSV, encKey, decKey, session, server, chain
 		return "", fmt.Errorf("either a session key, either both enc/dec keys")
 	}
 
-	encryptionKey = encKey
-	decryptionKey = decKey
-
 	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: "keygen", Done: false, Time: 0}
 	setStatus(session, status)
-	localStateMemory = ""
+	registry.setKeys(session, encKey, decKey)
+	registry.setLocalState(session, "")
 
 	Logln("BBMTLog", "start joinSession", session, "...")
 
@@ -195,7 +200,7 @@ func JoinKeygen(ppmPath, key, partiesCSV, encKey, decKey, session, server, chain
 	status.Info = "start joinSession"
 	setStatus(session, status)
 
-	if err := joinSession(server, session, key); err != nil {
+	if err := joinSession(server, session, key, parties, sessionKey); err != nil {
 		return "", fmt.Errorf("fail to register session: %w", err)
 	}
 
@@ -221,7 +226,8 @@ func JoinKeygen(ppmPath, key, partiesCSV, encKey, decKey, session, server, chain
 	}
 
 	localStateAccessor := &LocalStateAccessorImp{
-		key: key,
+		key:     key,
+		session: session,
 	}
 	Logln("BBMTLog", "localStateAccessor loaded...")
 	status.Step++
@@ -243,13 +249,13 @@ func JoinKeygen(ppmPath, key, partiesCSV, encKey, decKey, session, server, chain
 		LocalPartyID: key,
 		AllParties:   strings.Join(parties, ","),
 		ChainCodeHex: chaincode,
+		Threshold:    tssThreshold,
 	})
 	if err != nil {
 		close(endCh)
-		return "", fmt.Errorf("fail to generate ECDSA key: %w", err)
+		return "", failSession(session, fmt.Errorf("fail to generate ECDSA key: %w", err))
 	}
-	localState := localStateMemory
-	localStateMemory = ""
+	localState := registry.takeLocalState(session)
 	Logln("BBMTLog", "ECDSA keygen response ok")
 	status = getStatus(session)
 	status.Step++
@@ -281,6 +287,60 @@ func JoinKeygen(ppmPath, key, partiesCSV, encKey, decKey, session, server, chain
 	return localState, nil
 }
 
+// JoinKeygenVerified runs JoinKeygenWithThreshold, then has the whole committee
+// sign a domain separated message derived from the session ID in the
+// KeygenVerifySession(session) session. The keyshare is only returned, with
+// verified_at set, once that signature verifies against the new public key.
+func JoinKeygenVerified(ppmPath, key, partiesCSV string, threshold int, encKey, decKey, session, server, chaincode, sessionKey string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in JoinKeygenVerified: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	keyshare, err := JoinKeygenWithThreshold(ppmPath, key, partiesCSV, threshold, encKey, decKey, session, server, chaincode, sessionKey)
+	if err != nil {
+		return "", err
+	}
+	var localState LocalState
+	if err := json.Unmarshal([]byte(keyshare), &localState); err != nil {
+		return "", fmt.Errorf("failed to unmarshal keyshare: %w", err)
+	}
+
+	Logln("BBMTLog", "start keygen verification keysign...")
+	status := getStatus(session)
+	status.Info = "verifying keyshare"
+	status.Done = false
+	setStatus(session, status)
+
+	message, err := keygenVerifyDigest(session, localState.PubKey)
+	if err != nil {
+		return "", err
+	}
+	sig, err := JoinKeysign(server, key, strings.Join(localState.KeygenCommitteeKeys, ","), KeygenVerifySession(session), sessionKey, encKey, decKey, keyshare, keygenVerifyDerivePath, message)
+	if err != nil {
+		return "", fmt.Errorf("keygen verification keysign failed: %w", err)
+	}
+	if err := verifyKeygenSignature(&localState, session, sig); err != nil {
+		return "", err
+	}
+	verified, err := json.MarshalIndent(localState, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal local state: %w", err)
+	}
+
+	status = getStatus(session)
+	status.Step++
+	status.Info = "keyshare verified"
+	status.Done = true
+	setStatus(session, status)
+	return string(verified), nil
+}
+
 func JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, keyshare, derivePath, message string) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -301,20 +361,16 @@ func JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, k
 		return "", fmt.Errorf("either a session key, either both enc/dec keys")
 	}
 
-	encryptionKey = encKey
-	decryptionKey = decKey
-
 	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: "keysign", Done: false, Time: 0}
 	setStatus(session, status)
-
-	localStateMemory = ""
+	registry.setKeys(session, encKey, decKey)
 
 	Logln("BBMTLog", "start joinSession", session, "...")
 	status.Step++
 	status.Info = "start joinSession"
 	setStatus(session, status)
 
-	if err := joinSession(server, session, key); err != nil {
+	if err := joinSession(server, session, key, parties, sessionKey); err != nil {
 		return "", fmt.Errorf("fail to register session: %w", err)
 	}
 
@@ -340,7 +396,8 @@ func JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, k
 	}
 
 	localStateAccessor := &LocalStateAccessorImp{
-		key: key,
+		key:     key,
+		session: session,
 	}
 	Logln("BBMTLog", "localStateAccessor loaded...")
 	status.Step++
@@ -367,7 +424,7 @@ func JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, k
 	})
 	if err != nil {
 		close(endCh)
-		return "", fmt.Errorf("fail to KeysignECDSA key sign: %w", err)
+		return "", failSession(session, fmt.Errorf("fail to KeysignECDSA key sign: %w", err))
 	}
 
 	sigStr, err := json.Marshal(resp)
@@ -391,7 +448,7 @@ func JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, k
 	setStatus(session, status)
 
 	time.Sleep(time.Second)
-	err = flagPartyKeysignComplete(server, session, message, string(sigStr))
+	err = flagPartyKeysignComplete(server, session, key, message, string(sigStr))
 	if err != nil {
 		Logln("BBMTLog", "Warning: flagPartyKeysignComplete", "error", err)
 	}
@@ -406,6 +463,352 @@ func JoinKeysign(server, key, partiesCSV, session, sessionKey, encKey, decKey, k
 	return string(sigStr), nil
 }
 
+// JoinPresign runs the presigning rounds with the parties in partiesCSV over
+// the HTTP relay and stores the presignature in the presign store, named after
+// the session. JoinKeysignPresigned later turns it into a signature for
+// derivePath in a single round. Returns the PresignResponse JSON.
+func JoinPresign(server, key, partiesCSV, session, sessionKey, encKey, decKey, keyshare, derivePath string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in JoinPresign: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	return joinPresignSession("presign", server, key, partiesCSV, session, sessionKey, encKey, decKey, func(tssServerImp *ServiceImpl) (string, error) {
+		resp, err := tssServerImp.PresignECDSA(&PresignRequest{
+			PubKey:               keyshare,
+			PresignID:            session,
+			PresignCommitteeKeys: partiesCSV,
+			LocalPartyKey:        key,
+			DerivePath:           derivePath,
+		})
+		if err != nil {
+			return "", fmt.Errorf("fail to PresignECDSA: %w", err)
+		}
+		respStr, err := json.Marshal(resp)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal presign Resp to JSON, error: %w", err)
+		}
+		return string(respStr), nil
+	})
+}
+
+// JoinKeysignPresigned signs message (base64 of the hash) in a single round,
+// with the presignature presignID that the same parties computed with
+// JoinPresign. The presignature is used up, whether signing succeeds or not.
+func JoinKeysignPresigned(server, key, partiesCSV, session, sessionKey, encKey, decKey, keyshare, derivePath, presignID, message string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in JoinKeysignPresigned: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	sigStr, err := joinPresignSession("keysign", server, key, partiesCSV, session, sessionKey, encKey, decKey, func(tssServerImp *ServiceImpl) (string, error) {
+		resp, err := tssServerImp.KeysignPresignedECDSA(&PresignedKeysignRequest{
+			KeysignRequest: KeysignRequest{
+				PubKey:               keyshare,
+				MessageToSign:        message,
+				LocalPartyKey:        key,
+				KeysignCommitteeKeys: partiesCSV,
+				DerivePath:           derivePath,
+			},
+			PresignID: presignID,
+		})
+		if err != nil {
+			return "", fmt.Errorf("fail to KeysignPresignedECDSA key sign: %w", err)
+		}
+		sigStr, err := json.Marshal(resp)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal sig Resp to JSON, error: %w", err)
+		}
+		return string(sigStr), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := flagPartyKeysignComplete(server, session, key, message, sigStr); err != nil {
+		Logln("BBMTLog", "Warning: flagPartyKeysignComplete", "error", err)
+	}
+	return sigStr, nil
+}
+
+// joinPresignSession joins the relay session, runs fn with the session's
+// service and ends the session, like JoinKeysign does for a keysign.
+func joinPresignSession(statusType, server, key, partiesCSV, session, sessionKey, encKey, decKey string, fn func(tssServerImp *ServiceImpl) (string, error)) (string, error) {
+	parties := strings.Split(partiesCSV, ",")
+
+	if len(sessionKey) > 0 && (len(encKey) > 0 || len(decKey) > 0) {
+		return "", fmt.Errorf("either a session key, either enc/dec keys")
+	}
+
+	if len(sessionKey) == 0 && (len(encKey) == 0 || len(decKey) == 0) {
+		return "", fmt.Errorf("either a session key, either both enc/dec keys")
+	}
+
+	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: statusType, Done: false, Time: 0}
+	setStatus(session, status)
+	registry.setKeys(session, encKey, decKey)
+
+	Logln("BBMTLog", "start joinSession", session, "...")
+	status.Step++
+	status.Info = "start joinSession"
+	setStatus(session, status)
+
+	if err := joinSession(server, session, key, parties, sessionKey); err != nil {
+		return "", fmt.Errorf("fail to register session: %w", err)
+	}
+
+	Logln("BBMTLog", "waiting parties...")
+	status.Step++
+	status.Info = "waiting parties"
+	setStatus(session, status)
+
+	if err := awaitJoiners(parties, server, session); err != nil {
+		Logln("BBMTLog", "fail to wait all parties", "error", err)
+		return "", fmt.Errorf("fail to wait all parties: %w", err)
+	}
+
+	status.SeqNo++
+	status.Index++
+	setStatus(session, status)
+
+	messenger := &MessengerImp{
+		Server:     server,
+		SessionID:  session,
+		SessionKey: sessionKey,
+	}
+	localStateAccessor := &LocalStateAccessorImp{
+		key:     key,
+		session: session,
+	}
+	tssServerImp, err := NewService(messenger, localStateAccessor, false, "-")
+	if err != nil {
+		return "", fmt.Errorf("fail to create tss server: %w", err)
+	}
+	endCh := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go downloadMessage(server, session, sessionKey, key, *tssServerImp, endCh, wg)
+	Logln("BBMTLog", "start ECDSA", statusType, "...")
+	result, err := fn(tssServerImp)
+	if err != nil {
+		close(endCh)
+		return "", failSession(session, err)
+	}
+	Logln("BBMTLog", "ECDSA", statusType, "response ok")
+	status = getStatus(session)
+	status.Step++
+	status.Info = statusType + " ok"
+	setStatus(session, status)
+
+	time.Sleep(time.Second)
+	if err := endSession(server, session); err != nil {
+		close(endCh)
+		return "", fmt.Errorf("fail to end session: %w", err)
+	}
+	status.Step++
+	status.Info = "session ended"
+	status.Done = true
+	setStatus(session, status)
+
+	close(endCh)
+	wg.Wait()
+	Logln("========== DONE ==========")
+	return result, nil
+}
+
+// JoinReshare moves an existing key to a new committee over the HTTP relay.
+// oldThreshold and newThreshold are the number of parties required to sign
+// (e.g. 2 for a 2-of-3 wallet). Old committee members pass their keyshare;
+// new members without one pass the wallet's pubKey and chaincode instead.
+// New committee members get their new keyshare back, parties that only belong
+// to the old committee get an empty result and should discard their keyshare.
+func JoinReshare(ppmPath, key, oldPartiesCSV, newPartiesCSV string, oldThreshold, newThreshold int, encKey, decKey, session, server, sessionKey, keyshare, pubKey, chaincode string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in JoinReshare: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	return joinReshare("reshare", ppmPath, key, oldPartiesCSV, newPartiesCSV, oldThreshold, newThreshold, encKey, decKey, session, server, sessionKey, keyshare, pubKey, chaincode)
+}
+
+// JoinRefresh replaces the secret shares of a keyshare with fresh ones, among
+// the same committee and with the same threshold. The public key and chain
+// code don't change, but shares from before the refresh can no longer be
+// combined with the new ones. All parties of the keygen committee must join.
+// The returned keyshare has its share epoch bumped and replaces the old one.
+func JoinRefresh(ppmPath, key, encKey, decKey, session, server, sessionKey, keyshare string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in JoinRefresh: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	localStateStr, err := (&LocalStateAccessorImp{}).GetLocalState(keyshare)
+	if err != nil {
+		return "", err
+	}
+	var localState LocalState
+	if err := json.Unmarshal([]byte(localStateStr), &localState); err != nil {
+		return "", fmt.Errorf("failed to unmarshal keyshare: %w", err)
+	}
+	if localState.LocalPartyKey != key {
+		return "", fmt.Errorf("keyshare belongs to %s, not %s", localState.LocalPartyKey, key)
+	}
+	threshold, err := localState.threshold()
+	if err != nil {
+		return "", fmt.Errorf("failed to get threshold: %w", err)
+	}
+	committee := strings.Join(localState.KeygenCommitteeKeys, ",")
+	return joinReshare("refresh", ppmPath, key, committee, committee, threshold+1, threshold+1, encKey, decKey, session, server, sessionKey, keyshare, localState.PubKey, localState.ChainCodeHex)
+}
+
+func joinReshare(statusType, ppmPath, key, oldPartiesCSV, newPartiesCSV string, oldThreshold, newThreshold int, encKey, decKey, session, server, sessionKey, keyshare, pubKey, chaincode string) (string, error) {
+	oldParties := strings.Split(oldPartiesCSV, ",")
+	newParties := strings.Split(newPartiesCSV, ",")
+	parties := append([]string{}, oldParties...)
+	for _, party := range newParties {
+		if !Contains(parties, party) {
+			parties = append(parties, party)
+		}
+	}
+	isNew := Contains(newParties, key)
+
+	if len(sessionKey) > 0 && (len(encKey) > 0 || len(decKey) > 0) {
+		return "", fmt.Errorf("either a session key, either enc/dec keys")
+	}
+
+	if len(sessionKey) == 0 && (len(encKey) == 0 || len(decKey) == 0) {
+		return "", fmt.Errorf("either a session key, either both enc/dec keys")
+	}
+
+	if Contains(oldParties, key) && len(keyshare) == 0 {
+		return "", fmt.Errorf("old committee member needs its keyshare")
+	}
+
+	resharePrefix, err := ResharePrefix(session)
+	if err != nil {
+		return "", fmt.Errorf("fail to derive reshare prefix: %w", err)
+	}
+
+	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: statusType, Done: false, Time: 0}
+	setStatus(session, status)
+	registry.setKeys(session, encKey, decKey)
+	registry.setLocalState(session, "")
+
+	Logln("BBMTLog", "start joinSession", session, "...")
+	status.Step++
+	status.Info = "start joinSession"
+	setStatus(session, status)
+
+	if err := joinSession(server, session, key, parties, sessionKey); err != nil {
+		return "", fmt.Errorf("fail to register session: %w", err)
+	}
+
+	Logln("BBMTLog", "waiting parties...")
+	status.Step++
+	status.Info = "waiting parties"
+	setStatus(session, status)
+
+	if err := awaitJoiners(parties, server, session); err != nil {
+		Logln("BBMTLog", "fail to wait all parties", "error", err)
+		return "", fmt.Errorf("fail to wait all parties: %w", err)
+	}
+
+	status.SeqNo++
+	status.Index++
+	setStatus(session, status)
+
+	Logln("BBMTLog", "inbound messenger up...")
+	messenger := &MessengerImp{
+		Server:     server,
+		SessionID:  session,
+		SessionKey: sessionKey,
+	}
+
+	localStateAccessor := &LocalStateAccessorImp{
+		key:     key,
+		session: session,
+	}
+	Logln("BBMTLog", "localStateAccessor loaded...")
+	status.Step++
+	status.Info = "local state loaded"
+	setStatus(session, status)
+
+	Logln("BBMTLog", "preparing NewService...")
+	tssServerImp, err := NewService(messenger, localStateAccessor, isNew, ppmPath)
+	if err != nil {
+		return "", fmt.Errorf("fail to create tss server: %w", err)
+	}
+	endCh := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	Logln("BBMTLog", "downloadMessage active...")
+	go downloadMessage(server, session, sessionKey, key, *tssServerImp, endCh, wg)
+	Logln("BBMTLog", "doing ECDSA", statusType, "...")
+	_, err = tssServerImp.ReshareECDSA(&ReshareRequest{
+		PubKey:         keyshare,
+		LocalPartyKey:  key,
+		OldParties:     oldPartiesCSV,
+		NewParties:     newPartiesCSV,
+		OldThreshold:   oldThreshold - 1,
+		NewThreshold:   newThreshold - 1,
+		ChainCodeHex:   chaincode,
+		ExpectedPubKey: pubKey,
+		ResharePrefix:  resharePrefix,
+	})
+	if err != nil {
+		close(endCh)
+		return "", failSession(session, fmt.Errorf("fail to %s ECDSA key: %w", statusType, err))
+	}
+	localState := registry.takeLocalState(session)
+	Logln("BBMTLog", "ECDSA", statusType, "response ok")
+	status = getStatus(session)
+	status.Step++
+	status.Info = statusType + " ok"
+	setStatus(session, status)
+
+	time.Sleep(time.Second)
+	if err = endSession(server, session); err != nil {
+		Logln("BBMTLog", "Warning: endSession", "error", err)
+	}
+	status.Step++
+	status.Info = "session ended"
+	setStatus(session, status)
+
+	err = flagPartyComplete(server, session, key)
+	if err != nil {
+		Logln("BBMTLog", "Warning: flagPartyComplete", "error", err)
+	}
+	status.Step++
+	status.Info = "local party complete"
+	status.Done = true
+	setStatus(session, status)
+
+	close(endCh)
+	wg.Wait()
+
+	Logln("========== DONE ==========")
+	return localState, nil
+}
+
 func md5Hash(data string) (string, error) {
 	// Create a new MD5 hash
 	hasher := md5.New()
@@ -526,6 +929,7 @@ func (m *MessengerImp) Send(from, to, body string) error {
 	payload := body
 
 	// Encrypt the message if required
+	encryptionKey, _ := registry.keys(m.SessionID)
 	if len(m.SessionKey) > 0 {
 		payload, err = AesEncrypt(body, m.SessionKey)
 		if err != nil {
@@ -569,11 +973,39 @@ func (m *MessengerImp) Send(from, to, body string) error {
 	url := m.Server + "/message/" + m.SessionID
 	Logln("BBMTLog", "sending message...")
 
-	// Prepare the HTTP request
-	resp, err := http.Post(url, "application/json", bytes.NewReader(requestBody))
+	// The relay drops repeated posts of a sequence number, so retrying after
+	// a network error or relay failure never delivers the message twice.
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = sendMessage(m.SessionID, url, requestBody)
+		if !retry || attempt == sendAttempts {
+			break
+		}
+		Logln("BBMTLog", "retrying message send:", err)
+		time.Sleep(time.Duration(attempt) * sendRetryDelay)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Increment the sequence number after successful send
+	Logln("BBMTLog", "incremented Sent Message To OutSeqNo", status.SeqNo)
+	status.Info = fmt.Sprintf("Sent Message %d", status.SeqNo)
+	status.Step++
+	status.SeqNo++
+	setSeqNo(m.SessionID, status.Info, status.Step, status.SeqNo)
+
+	return nil
+}
+
+// sendMessage posts one message to the relay and reports whether a failure
+// is worth retrying: network errors and relay server errors are, refusals
+// are not.
+func sendMessage(session, url string, requestBody []byte) (bool, error) {
+	resp, err := relayDo(session, http.MethodPost, url, requestBody)
 	if err != nil {
 		Logln("BBMTLog", "fail to send message: ", err)
-		return fmt.Errorf("fail to send message: %w", err)
+		return true, fmt.Errorf("fail to send message: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -581,24 +1013,16 @@ func (m *MessengerImp) Send(from, to, body string) error {
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		Logln("BBMTLog", "fail to read response: ", err)
-		return fmt.Errorf("fail to read response: %w", err)
+		return true, fmt.Errorf("fail to read response: %w", err)
 	}
-	Logln("BBMTLog", "message sent, status:", resp.Status)
+	Logln("BBMTLog", "message sent, status:", resp.Status, strings.TrimSpace(string(respBody)))
 
 	// Check for non-200 status codes
 	if resp.StatusCode != http.StatusOK {
 		Logln("BBMTLog", "message sent, response body:", string(respBody)[:min(80, len(string(respBody)))]+"...")
-		return fmt.Errorf("fail to send message: %s", resp.Status)
+		return resp.StatusCode >= http.StatusInternalServerError, fmt.Errorf("fail to send message: %s", resp.Status)
 	}
-
-	// Increment the sequence number after successful send
-	Logln("BBMTLog", "incremented Sent Message To OutSeqNo", status.SeqNo)
-	status.Info = fmt.Sprintf("Sent Message %d", status.SeqNo)
-	status.Step++
-	status.SeqNo++
-	setSeqNo(m.SessionID, status.Info, status.Step, status.SeqNo)
-
-	return nil
+	return false, nil
 }
 
 func (l *LocalStateAccessorImp) GetLocalState(keyshare string) (string, error) {
@@ -616,11 +1040,29 @@ func (l *LocalStateAccessorImp) GetLocalState(keyshare string) (string, error) {
 }
 
 func (l *LocalStateAccessorImp) SaveLocalState(pubKey, localState string) error {
-	localStateMemory = localState
+	registry.setLocalState(l.session, localState)
 	return nil
 }
 
-func joinSession(server, session, key string) error {
+// joinSession registers key in the relay session of parties, after locking
+// the session to its members when the keys allow it (see relay_auth.go).
+func joinSession(server, session, key string, parties []string, sessionKey string) error {
+	encKey, decKey := registry.keys(session)
+	auth, err := newRelayAuth(key, parties, sessionKey, encKey, decKey)
+	if err != nil {
+		return fmt.Errorf("fail to set up relay authentication: %w", err)
+	}
+	registry.setAuth(session, auth)
+	if err := registerSessionACL(server, session); err != nil {
+		return err
+	}
+	spec := SessionSpec{Type: getStatus(session).Type, Expected: len(parties)}
+	if validSessionType(spec.Type) {
+		if err := createRelaySession(server, session, spec); err != nil {
+			return err
+		}
+	}
+
 	timeout := time.NewTimer(30 * time.Second)
 	defer timeout.Stop()
 	for {
@@ -630,8 +1072,7 @@ func joinSession(server, session, key string) error {
 		default:
 			sessionUrl := server + "/" + session
 			body := []byte("[\"" + key + "\"]")
-			bodyReader := bytes.NewReader(body)
-			resp, err := http.Post(sessionUrl, "application/json", bodyReader)
+			resp, err := relayDo(session, http.MethodPost, sessionUrl, body)
 			if err != nil {
 				Logln("BBMTLog", "fail to get session", "error", err)
 				time.Sleep(2 * time.Second)
@@ -647,27 +1088,50 @@ func joinSession(server, session, key string) error {
 
 func awaitJoiners(parties []string, server, session string) error {
 	sessionUrl := server + "/" + session
-	timeout := time.NewTimer(30 * time.Second)
-	defer timeout.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// wait for the participants pushed over the relay stream if it has one
+	if events, err := openStream(ctx, server, session, ""); err == nil {
+		for event := range events {
+			if event.Name != "session" {
+				continue
+			}
+			var keys []string
+			if err := json.Unmarshal([]byte(event.Data), &keys); err != nil {
+				return fmt.Errorf("fail to unmarshal session body: %w", err)
+			}
+			if equalUnordered(keys, parties) {
+				return nil
+			}
+		}
+		if ctx.Err() == nil {
+			Logln("BBMTLog", "session stream closed, polling...")
+		}
+	}
 
 	for {
 		select {
-		case <-timeout.C:
+		case <-ctx.Done():
 			return fmt.Errorf("timeout waiting for all parties after 30 seconds")
 		default:
-			resp, err := http.Get(sessionUrl)
+			resp, err := relayDo(session, http.MethodGet, sessionUrl, nil)
 			if err != nil {
 				Logln("BBMTLog", "fail to get session", "error", err)
+				time.Sleep(time.Second / 2)
 				continue
 			}
 
 			if resp.StatusCode != http.StatusOK {
 				Logln("BBMTLog", "waiting for session...")
+				resp.Body.Close()
+				time.Sleep(time.Second / 2)
 				continue
 			}
 
 			var keys []string
 			buff, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
 			if err != nil {
 				return fmt.Errorf("fail to read session body: %w", err)
 			}
@@ -709,12 +1173,7 @@ func equalUnordered(a, b []string) bool {
 func endSession(server, session string) error {
 	sessionUrl := server + "/" + session
 	Logln("======================================================> Session Closure: ", session)
-	client := http.Client{}
-	req, err := http.NewRequest(http.MethodDelete, sessionUrl, nil)
-	if err != nil {
-		return fmt.Errorf("fail to end session: %w", err)
-	}
-	resp, err := client.Do(req)
+	resp, err := relayDo(session, http.MethodDelete, sessionUrl, nil)
 	if err != nil {
 		return fmt.Errorf("fail to end session: %w", err)
 	}
@@ -724,19 +1183,19 @@ func endSession(server, session string) error {
 	return nil
 }
 
-func flagPartyKeysignComplete(relayHost, sessionID, message, body string) error {
+func flagPartyKeysignComplete(relayHost, sessionID, key, message, body string) error {
 	// Construct the server URL
 	serverURL := fmt.Sprintf("%s/complete/keysign/%s", relayHost, sessionID)
 
 	// Create the HTTP POST request with the raw body
-	req, err := http.NewRequest("POST", serverURL, bytes.NewBufferString(body))
+	req, err := newRelayRequest(context.Background(), sessionID, http.MethodPost, serverURL, []byte(body))
 	if err != nil {
 		return fmt.Errorf("failed to create POST request: %w", err)
 	}
 
 	// Set required headers
-	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("message_id", message)
+	req.Header.Set("party_id", key)
 	// req.Header.Set("Content-Type", "text/plain")
 
 	// Configure the HTTP client with a timeout
@@ -774,7 +1233,7 @@ func flagPartyComplete(serverURL, session, localPartyID string) error {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	resp, err := http.Post(serverURL+"/complete/keygen/"+session, "application/json", bytes.NewBuffer(payload))
+	resp, err := relayDo(session, http.MethodPost, serverURL+"/complete/keygen/"+session, payload)
 	if err != nil {
 		return fmt.Errorf("failed to send POST request: %w", err)
 	}
@@ -794,9 +1253,138 @@ func flagPartyComplete(serverURL, session, localPartyID string) error {
 
 func downloadMessage(server, session, sessionKey, key string, tssServerImp ServiceImpl, endCh chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
-	isApplyingMessages := false
-	until := time.Now().Add(time.Duration(msgFetchTimeout) * time.Second)
+	deadline := time.NewTimer(time.Duration(msgFetchTimeout) * time.Second)
+	defer deadline.Stop()
 	msgMap := make(map[string]bool)
+	_, decryptionKey := registry.keys(session)
+
+	// last relay message ID seen, relays without IDs leave it at 0
+	var cursor uint64
+
+	// Messages are pushed over the relay stream when the relay has one,
+	// polling stays as the fallback and, slower, as a safety net.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var streamCh <-chan streamEvent
+	pollInterval := time.Second / 2
+	if events, err := openStream(ctx, server, session, key); err != nil {
+		Logln("BBMTLog", "Message stream unavailable, polling:", err)
+	} else {
+		Logln("BBMTLog", "Message stream opened")
+		streamCh = events
+		pollInterval = streamPollInterval
+	}
+
+	// applyMessages applies the new messages in order. It fails with a blame
+	// of the sender on a message the session can't apply.
+	applyMessages := func(messages []Message) error {
+		var err error
+
+		// Sort messages by relay ID, or by sequence number on relays without
+		sort.SliceStable(messages, func(i, j int) bool {
+			if messages[i].ID > 0 && messages[j].ID > 0 {
+				return messages[i].ID < messages[j].ID
+			}
+			seqNoI, errI := strconv.Atoi(messages[i].SeqNo)
+			seqNoJ, errJ := strconv.Atoi(messages[j].SeqNo)
+
+			if errI != nil || errJ != nil {
+				Logln("BBMTLog", "Error converting SeqNo to int:", errI, errJ)
+				return false
+			}
+			return seqNoI < seqNoJ
+		})
+
+		// Process messages sequentially
+		acked := cursor
+		for _, message := range messages {
+			if message.ID > 0 && message.ID <= cursor {
+				continue
+			}
+			cursor = max(cursor, message.ID)
+			if message.From == key {
+				Logln("BBMTLog", "Skipping message from self...")
+				continue
+			}
+
+			// a sender's sequence number identifies a message, the hash only
+			// on relays that do not pass it
+			Logln("BBMTLog", "Checking message seqNo", message.SeqNo)
+			appliedKey := message.From + "/" + message.SeqNo
+			if message.SeqNo == "" {
+				appliedKey = message.Hash
+			}
+			if msgMap[appliedKey] {
+				Logln("BBMTLog", "Already applied message:", message.SeqNo)
+				if message.ID == 0 {
+					deleteMessage(server, session, key, message.Hash)
+				}
+				continue
+			}
+			msgMap[appliedKey] = true
+
+			status := getStatus(session)
+
+			// Only process messages that match the expected seqNo
+			Logln("BBMTLog", "Applying message:", message.SeqNo)
+
+			status.Step++
+			status.Index++
+			status.Info = fmt.Sprintf("Received Message %s", message.SeqNo)
+			setIndex(session, status.Info, status.Step, status.Index)
+
+			// Decrypt message if necessary
+			body := message.Body
+			if len(sessionKey) > 0 {
+				body, err = AesDecrypt(message.Body, sessionKey)
+				if err != nil {
+					Logln("BBMTLog", "Failed to decrypt message:", err)
+					registry.recordUndecryptable(session, message.From, err.Error())
+					continue
+				}
+			} else if len(decryptionKey) > 0 {
+				body, err = EciesDecrypt(message.Body, decryptionKey)
+				if err != nil {
+					Logln("BBMTLog", "Failed to decrypt ECIES message:", err)
+					registry.recordUndecryptable(session, message.From, err.Error())
+					continue
+				}
+			}
+
+			// the relay authenticated the sender, the message can't claim another
+			Logln("BBMTLog", "Applying message body:", body[:min(50, len(body))])
+			if from, err := messageSender(body); err == nil && from != message.From {
+				return blameSender(BlameProtocol, fmt.Errorf("message from %s claims to be from %s", message.From, from), message.From)
+			}
+			if err := tssServerImp.ApplyData(body); err != nil {
+				Logln("BBMTLog", "Failed to apply message data:", err)
+				return blameSender(BlameProtocol, fmt.Errorf("invalid message: %w", err), message.From)
+			}
+
+			// Mark message as applied
+			Logln("BBMTLog", "Message applied:", message.SeqNo)
+			status.Step++
+			status.Info = fmt.Sprintf("Applied Message %d", status.Index)
+			setStep(session, status.Info, status.Step)
+
+			// Delete applied message from the server, relays with message
+			// IDs take one acknowledgement for the batch below
+			if message.ID == 0 {
+				Logln("BBMTLog", "Deleting applied message:", message.Hash)
+				deleteMessage(server, session, key, message.Hash)
+			}
+		}
+		if cursor > acked {
+			ackMessages(server, session, key, cursor)
+		}
+		return nil
+	}
+
+	// a message that can't be applied ends the session with its sender blamed
+	stop := func(err error) {
+		Logln("BBMTLog", "Stopping session on an invalid message:", err)
+		tssServerImp.abort(err)
+	}
 
 	for {
 		select {
@@ -804,157 +1392,108 @@ func downloadMessage(server, session, sessionKey, key string, tssServerImp Servi
 			Logln("BBMTLog", "Received signal to end downloadMessage. Stopping...")
 			return
 
-		case <-time.After(time.Second / 2):
-			if time.Since(until) > 0 {
-				Logln("BBMTLog", "Received timeout to end downloadMessage. Stopping...")
-				return
-			}
+		case <-deadline.C:
+			Logln("BBMTLog", "Received timeout to end downloadMessage. Stopping...")
+			return
 
-			// Prevent multiple fetch and apply processes at once
-			if isApplyingMessages {
-				Logln("BBMTLog", "Already applying messages, skipping fetch.")
+		case event, ok := <-streamCh:
+			if !ok {
+				Logln("BBMTLog", "Message stream closed, polling...")
+				streamCh = nil
+				pollInterval = time.Second / 2
+				continue
+			}
+			if event.Name != "message" {
 				continue
 			}
-			isApplyingMessages = true
+			var messages []Message
+			if err := json.Unmarshal([]byte(event.Data), &messages); err != nil {
+				Logln("BBMTLog", "Failed to decode pushed messages:", err)
+				continue
+			}
+			Logln("BBMTLog", "Got pushed messages count:", len(messages))
+			if err := applyMessages(messages); err != nil {
+				stop(err)
+				return
+			}
+
+		case <-time.After(pollInterval):
 			Logln("BBMTLog", "Fetching messages...")
 
-			// Fetch messages from the server
-			resp, err := http.Get(server + "/message/" + session + "/" + key)
+			// Fetch messages from the server, after the ones already seen
+			fetchURL := server + "/message/" + session + "/" + key
+			if cursor > 0 {
+				fetchURL += "?after=" + strconv.FormatUint(cursor, 10)
+			}
+			resp, err := relayDo(session, http.MethodGet, fetchURL, nil)
 			if err != nil {
 				Logln("BBMTLog", "Error fetching messages:", err)
-				isApplyingMessages = false
 				continue
 			}
 
 			if resp.StatusCode == http.StatusNotFound {
 				Logln("BBMTLog", "No messages found.")
-				isApplyingMessages = false
+				resp.Body.Close()
 				continue
 			}
 
 			if resp.StatusCode != http.StatusOK {
 				Logln("BBMTLog", "Failed to get data from server:", resp.Status)
-				isApplyingMessages = false
+				resp.Body.Close()
 				continue
 			}
 
 			// Read the response body
 			bodyBytes, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
 			if err != nil {
 				Logln("BBMTLog", "Failed to read response body:", err)
-				isApplyingMessages = false
 				continue
 			}
-			resp.Body.Close()
 
 			// Decode the messages from the response
-			var messages []struct {
-				SessionID string   `json:"session_id,omitempty"`
-				From      string   `json:"from,omitempty"`
-				To        []string `json:"to,omitempty"`
-				Body      string   `json:"body,omitempty"`
-				SeqNo     string   `json:"sequence_no,omitempty"`
-				Hash      string   `json:"hash,omitempty"`
-			}
+			var messages []Message
 			if err := json.Unmarshal(bodyBytes, &messages); err != nil {
 				Logln("BBMTLog", "Failed to decode messages:", err)
-				isApplyingMessages = false
 				continue
 			}
 
 			Logln("BBMTLog", "Got messages count:", len(messages))
-
-			// Sort messages by sequence number
-			sort.SliceStable(messages, func(i, j int) bool {
-				seqNoI, errI := strconv.Atoi(messages[i].SeqNo)
-				seqNoJ, errJ := strconv.Atoi(messages[j].SeqNo)
-
-				if errI != nil || errJ != nil {
-					Logln("BBMTLog", "Error converting SeqNo to int:", errI, errJ)
-					return false
-				}
-				return seqNoI < seqNoJ
-			})
-
-			// Process messages sequentially
-			for _, message := range messages {
-				if message.From == key {
-					Logln("BBMTLog", "Skipping message from self...")
-					continue
-				}
-
-				Logln("BBMTLog", "Checking message seqNo", message.SeqNo)
-				_, exists := msgMap[message.Hash]
-				if exists {
-					Logln("BBMTLog", "Already applied message:", message.SeqNo)
-					deleteMessage(server, session, key, message.Hash)
-					continue
-				} else {
-					msgMap[message.Hash] = true
-				}
-
-				status := getStatus(session)
-
-				// Only process messages that match the expected seqNo
-				Logln("BBMTLog", "Applying message:", message.SeqNo)
-
-				status.Step++
-				status.Index++
-				status.Info = fmt.Sprintf("Received Message %s", message.SeqNo)
-				setIndex(session, status.Info, status.Step, status.Index)
-
-				// Decrypt message if necessary
-				body := message.Body
-				if len(sessionKey) > 0 {
-					body, err = AesDecrypt(message.Body, sessionKey)
-					if err != nil {
-						Logln("BBMTLog", "Failed to decrypt message:", err)
-						continue
-					}
-				} else if len(decryptionKey) > 0 {
-					body, err = EciesDecrypt(message.Body, decryptionKey)
-					if err != nil {
-						Logln("BBMTLog", "Failed to decrypt ECIES message:", err)
-						continue
-					}
-				}
-
-				Logln("BBMTLog", "Applying message body:", body[:min(50, len(body))])
-				if err := tssServerImp.ApplyData(body); err != nil {
-					Logln("BBMTLog", "Failed to apply message data:", err)
-				}
-
-				// Mark message as applied
-				Logln("BBMTLog", "Message applied:", message.SeqNo)
-				status.Step++
-				status.Info = fmt.Sprintf("Applied Message %d", status.Index)
-				setStep(session, status.Info, status.Step)
-
-				// Delete applied message from the server
-				Logln("BBMTLog", "Deleting applied message:", message.Hash)
-				deleteMessage(server, session, key, message.Hash)
-
+			if err := applyMessages(messages); err != nil {
+				stop(err)
+				return
 			}
-			isApplyingMessages = false
 		}
 	}
 }
 
+// ackMessages releases the messages of key on the relay up to message ID.
+func ackMessages(server, session, key string, id uint64) {
+	Logln("BBMTLog", "acknowledging messages up to", id)
+	ackURL := server + "/message/" + session + "/" + key + "/ack"
+	resp, err := relayDo(session, http.MethodPost, ackURL, []byte(fmt.Sprintf(`{"id":%d}`, id)))
+	if err != nil {
+		Logln("BBMTLog", "HTTP_POST Error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		Logln("BBMTLog", "acknowledging messages failed:", resp.Status)
+		return
+	}
+	Logln("BBMTLog", "acknowledged messages up to", id)
+}
+
 func deleteMessage(server, session, key, messageHash string) {
 	// Delete Applied Message - Lower Read Overhead
 	Logln("BBMTLog", "deleting applied message", messageHash)
 	delURL := server + "/message/" + session + "/" + key + "/" + messageHash
 
-	req, err := http.NewRequest("DELETE", delURL, nil)
-	if err != nil {
-		Logln("BBMTLog", "HTTP_DELETE Request Error", err)
-	}
-
-	resp, rspErr := http.DefaultClient.Do(req)
+	resp, rspErr := relayDo(session, http.MethodDelete, delURL, nil)
 	if rspErr != nil {
 		Logln("BBMTLog", "HTTP_DELETE Error", rspErr)
+		return
 	}
-	Logln("BBMTLog", "deleted message", messageHash)
-
 	defer resp.Body.Close()
+	Logln("BBMTLog", "deleted message", messageHash)
 }
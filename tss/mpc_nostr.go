@@ -120,6 +120,19 @@ func HexToNpub(hexKey string) (string, error) {
 	return npub, nil
 }
 
+// normalizePartyKey returns hex Nostr public keys as npubs, so keyshares
+// saved with either form match the npubs of a session. Other party keys are
+// returned as they are.
+func normalizePartyKey(key string) string {
+	if len(key) != 64 || strings.HasPrefix(key, "npub1") {
+		return key
+	}
+	if npub, err := HexToNpub(key); err == nil {
+		return npub
+	}
+	return key
+}
+
 // NostrJoinKeygen performs a Nostr-based keygen and returns the keyshare JSON.
 // Parameters:
 //   - relaysCSV: Comma-separated list of Nostr relay URLs (wss://...)
@@ -128,11 +141,18 @@ func HexToNpub(hexKey string) (string, error) {
 //   - sessionID: Session identifier
 //   - sessionKey: Session encryption key in hex
 //   - chaincode: Chain code in hex
-//   - ppmPath: Path to pre-params file (optional, empty string means generate new pre-params)
+//   - ppmPath: Path to pre-params file, used when the pre-parameter pool is not running or empty (optional, empty string means generate new pre-params)
 func NostrJoinKeygen(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, chaincode, ppmPath string) (result string, err error) {
+	return NostrJoinKeygenWithThreshold(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, chaincode, ppmPath, 0)
+}
+
+// NostrJoinKeygenWithThreshold performs a Nostr-based keygen with an explicit
+// t-of-n threshold, where threshold is the number of parties required to sign
+// (e.g. 3 for 3-of-5). A threshold of 0 uses the default for the party count.
+func NostrJoinKeygenWithThreshold(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, chaincode, ppmPath string, threshold int) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			errMsg := fmt.Sprintf("PANIC in NostrJoinKeygen: %v", r)
+			errMsg := fmt.Sprintf("PANIC in NostrJoinKeygenWithThreshold: %v", r)
 			Logf("BBMTLog: %s", errMsg)
 			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
 			err = fmt.Errorf("internal error (panic): %v", r)
@@ -175,6 +195,11 @@ func NostrJoinKeygen(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKe
 		}
 	}
 
+	tssThreshold, err := GetThresholdForSigners(threshold, len(peersNpub)+1)
+	if err != nil {
+		return "", err
+	}
+
 	// Create config
 	cfg := nostrtransport.Config{
 		Relays:        relays,
@@ -192,7 +217,293 @@ func NostrJoinKeygen(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKe
 	}
 
 	// Run keygen with pre-params path
-	return runNostrKeygenInternal(cfg, chaincode, ppmPath, localNpub, sessionID)
+	return runNostrKeygenInternal(cfg, chaincode, ppmPath, localNpub, sessionID, tssThreshold)
+}
+
+// NostrJoinKeygenVerified runs NostrJoinKeygenWithThreshold, then has the whole
+// committee sign a domain separated message derived from the session ID in the
+// KeygenVerifySession(sessionID) session. The keyshare is only returned, with
+// verified_at set, once that signature verifies against the new public key.
+func NostrJoinKeygenVerified(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, chaincode, ppmPath string, threshold int) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in NostrJoinKeygenVerified: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	keyshareJSON, err := NostrJoinKeygenWithThreshold(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, chaincode, ppmPath, threshold)
+	if err != nil {
+		return "", err
+	}
+	var keyshare LocalStateNostr
+	if err := json.Unmarshal([]byte(keyshareJSON), &keyshare); err != nil {
+		return "", fmt.Errorf("failed to parse keyshare JSON: %w", err)
+	}
+
+	Logln("BBMTLog", "start keygen verification keysign...")
+	status := getStatus(sessionID)
+	status.Info = "verifying keyshare"
+	status.Done = false
+	setStatus(sessionID, status)
+
+	// The whole keygen committee signs
+	allParties, err := nostrSigningCommittee(&keyshare, keyshare.NostrNpub, partiesNpubsCSV)
+	if err != nil {
+		return "", err
+	}
+	peersNpub := make([]string, 0)
+	for _, npub := range allParties {
+		if npub != keyshare.NostrNpub {
+			peersNpub = append(peersNpub, npub)
+		}
+	}
+	relays := strings.Split(relaysCSV, ",")
+	for i := range relays {
+		relays[i] = strings.TrimSpace(relays[i])
+	}
+	cfg := nostrtransport.Config{
+		Relays:        relays,
+		SessionID:     KeygenVerifySession(sessionID),
+		SessionKeyHex: sessionKey,
+		LocalNpub:     keyshare.NostrNpub,
+		LocalNsec:     partyNsec,
+		PeersNpub:     peersNpub,
+		MaxTimeout:    90 * time.Second,
+	}
+	cfg.ApplyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+
+	// runNostrKeysignInternal signs the sha256 of the message
+	sig, err := runNostrKeysignInternal(cfg, &keyshare, keygenVerifyDerivePath, keygenVerifyMessage(sessionID, keyshare.PubKey), allParties)
+	if err != nil {
+		return "", fmt.Errorf("keygen verification keysign failed: %w", err)
+	}
+	if err := verifyKeygenSignature(&keyshare.LocalState, sessionID, sig); err != nil {
+		return "", err
+	}
+	verified, err := json.MarshalIndent(keyshare, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal result: %w", err)
+	}
+
+	status = getStatus(sessionID)
+	status.Step++
+	status.Info = "keyshare verified"
+	status.Done = true
+	setStatus(sessionID, status)
+	return string(verified), nil
+}
+
+// NostrJoinReshare moves an existing key to a new committee over Nostr and
+// returns the new keyshare JSON. The public key and chain code are unchanged.
+// Parameters:
+//   - oldPartiesNpubsCSV: Comma-separated npubs of the old committee members taking part
+//   - newPartiesNpubsCSV: Comma-separated npubs of the new committee
+//   - oldThreshold/newThreshold: Number of parties required to sign (e.g. 2 for 2-of-3)
+//   - keyshareJSON: Local keyshare, required for old committee members
+//   - pubKey/chaincode: Wallet pub key and chain code, required for new members without a keyshare
+//
+// Parties that only belong to the old committee get an empty result and should
+// discard their keyshare.
+func NostrJoinReshare(relaysCSV, partyNsec, oldPartiesNpubsCSV, newPartiesNpubsCSV, sessionID, sessionKey, keyshareJSON, pubKey, chaincode, ppmPath string, oldThreshold, newThreshold int) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in NostrJoinReshare: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: "reshare", Done: false, Time: 0}
+	setStatus(sessionID, status)
+
+	// Derive npub from nsec (handles bech32 format)
+	localNpub, err := DeriveNpubFromNsec(partyNsec)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse keyshare JSON (only old committee members hold one)
+	var keyshare *LocalStateNostr
+	if strings.TrimSpace(keyshareJSON) != "" {
+		keyshare = &LocalStateNostr{}
+		if err := json.Unmarshal([]byte(keyshareJSON), keyshare); err != nil {
+			return "", fmt.Errorf("failed to parse keyshare JSON: %w", err)
+		}
+		if keyshare.NostrNpub != localNpub {
+			return "", fmt.Errorf("keyshare npub (%s) does not match derived npub (%s)", keyshare.NostrNpub, localNpub)
+		}
+	}
+
+	// Parse relays
+	relays := strings.Split(relaysCSV, ",")
+	for i := range relays {
+		relays[i] = strings.TrimSpace(relays[i])
+	}
+
+	// Parse both committees
+	oldParties := strings.Split(oldPartiesNpubsCSV, ",")
+	for i := range oldParties {
+		oldParties[i] = strings.TrimSpace(oldParties[i])
+	}
+	newParties := strings.Split(newPartiesNpubsCSV, ",")
+	for i := range newParties {
+		newParties[i] = strings.TrimSpace(newParties[i])
+	}
+	if Contains(oldParties, localNpub) && keyshare == nil {
+		return "", fmt.Errorf("old committee member needs its keyshare")
+	}
+
+	// Extract peer npubs of both committees (excluding self)
+	peersNpub := make([]string, 0)
+	for _, npub := range append(append([]string{}, oldParties...), newParties...) {
+		if npub != localNpub && !Contains(peersNpub, npub) {
+			peersNpub = append(peersNpub, npub)
+		}
+	}
+
+	Logln("BBMTLog", "start Nostr reshare", sessionID, "...")
+	status.Step++
+	status.Info = "start Nostr reshare"
+	setStatus(sessionID, status)
+
+	cfg := nostrtransport.Config{
+		Relays:        relays,
+		SessionID:     sessionID,
+		SessionKeyHex: sessionKey,
+		LocalNpub:     localNpub,
+		LocalNsec:     partyNsec,
+		PeersNpub:     peersNpub,
+		MaxTimeout:    90 * time.Second,
+	}
+	cfg.ApplyDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+
+	resharePrefix, err := ResharePrefix(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive reshare prefix: %w", err)
+	}
+
+	return runNostrReshareInternal(cfg, keyshare, &ReshareRequest{
+		PubKey:         pubKey,
+		LocalPartyKey:  localNpub,
+		OldParties:     strings.Join(oldParties, ","),
+		NewParties:     strings.Join(newParties, ","),
+		OldThreshold:   oldThreshold - 1,
+		NewThreshold:   newThreshold - 1,
+		ChainCodeHex:   chaincode,
+		ExpectedPubKey: pubKey,
+		ResharePrefix:  resharePrefix,
+	}, ppmPath)
+}
+
+// NostrJoinRefresh replaces the secret shares of a keyshare with fresh ones over
+// Nostr, among the same committee and with the same threshold. All parties of
+// the keygen committee must join. The returned keyshare JSON has its share
+// epoch bumped and replaces the old one.
+func NostrJoinRefresh(relaysCSV, partyNsec, sessionID, sessionKey, keyshareJSON, ppmPath string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in NostrJoinRefresh: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: "refresh", Done: false, Time: 0}
+	setStatus(sessionID, status)
+
+	// Derive npub from nsec (handles bech32 format)
+	localNpub, err := DeriveNpubFromNsec(partyNsec)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse keyshare JSON
+	var keyshare LocalStateNostr
+	if err := json.Unmarshal([]byte(keyshareJSON), &keyshare); err != nil {
+		return "", fmt.Errorf("failed to parse keyshare JSON: %w", err)
+	}
+	if keyshare.NostrNpub != localNpub {
+		return "", fmt.Errorf("keyshare npub (%s) does not match derived npub (%s)", keyshare.NostrNpub, localNpub)
+	}
+	threshold, err := keyshare.threshold()
+	if err != nil {
+		return "", fmt.Errorf("failed to get threshold: %w", err)
+	}
+
+	// Parse relays
+	relays := strings.Split(relaysCSV, ",")
+	for i := range relays {
+		relays[i] = strings.TrimSpace(relays[i])
+	}
+
+	// The committee is the keygen committee (excluding self), older keyshares
+	// may record it as hex keys
+	committee := append([]string{}, keyshare.KeygenCommitteeKeys...)
+	localPartyKey := ""
+	peersNpub := make([]string, 0)
+	for _, key := range committee {
+		npub := normalizePartyKey(key)
+		if npub == localNpub {
+			localPartyKey = key
+		} else {
+			peersNpub = append(peersNpub, npub)
+		}
+	}
+	if localPartyKey == "" {
+		return "", fmt.Errorf("local party %s not in keygen committee", localNpub)
+	}
+
+	Logln("BBMTLog", "start Nostr refresh", sessionID, "...")
+	status.Step++
+	status.Info = "start Nostr refresh"
+	setStatus(sessionID, status)
+
+	cfg := nostrtransport.Config{
+		Relays:        relays,
+		SessionID:     sessionID,
+		SessionKeyHex: sessionKey,
+		LocalNpub:     localNpub,
+		LocalNsec:     partyNsec,
+		PeersNpub:     peersNpub,
+		MaxTimeout:    90 * time.Second,
+	}
+	cfg.ApplyDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+
+	resharePrefix, err := ResharePrefix(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive reshare prefix: %w", err)
+	}
+
+	return runNostrReshareInternal(cfg, &keyshare, &ReshareRequest{
+		PubKey:         keyshare.PubKey,
+		LocalPartyKey:  localPartyKey,
+		OldParties:     strings.Join(committee, ","),
+		NewParties:     strings.Join(committee, ","),
+		OldThreshold:   threshold,
+		NewThreshold:   threshold,
+		ChainCodeHex:   keyshare.ChainCodeHex,
+		ExpectedPubKey: keyshare.PubKey,
+		ResharePrefix:  resharePrefix,
+	}, ppmPath)
 }
 
 // NostrJoinKeysignWithSighash performs a Nostr-based keysign with a base64-encoded sighash (already a hash).
@@ -231,10 +542,10 @@ func NostrJoinKeysignWithSighash(relaysCSV, partyNsec, partiesNpubsCSV, sessionI
 		relays[i] = strings.TrimSpace(relays[i])
 	}
 
-	// Parse peer npubs
-	allParties := strings.Split(partiesNpubsCSV, ",")
-	for i := range allParties {
-		allParties[i] = strings.TrimSpace(allParties[i])
+	// Validate the signing subset against the keyshare
+	allParties, err := nostrSigningCommittee(&keyshare, localNpub, partiesNpubsCSV)
+	if err != nil {
+		return "", err
 	}
 
 	// Extract peer npubs (excluding self)
@@ -303,10 +614,10 @@ func NostrJoinKeysign(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionK
 		relays[i] = strings.TrimSpace(relays[i])
 	}
 
-	// Parse peer npubs
-	allParties := strings.Split(partiesNpubsCSV, ",")
-	for i := range allParties {
-		allParties[i] = strings.TrimSpace(allParties[i])
+	// Validate the signing subset against the keyshare
+	allParties, err := nostrSigningCommittee(&keyshare, localNpub, partiesNpubsCSV)
+	if err != nil {
+		return "", err
 	}
 
 	// Extract peer npubs (excluding self)
@@ -337,60 +648,167 @@ func NostrJoinKeysign(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionK
 	return runNostrKeysignInternal(cfg, &keyshare, derivationPath, message, allParties)
 }
 
-// preAgreementResult holds the results of the pre-agreement phase
-type preAgreementResult struct {
-	fullNonce   string
-	averageFees int64
+// NostrJoinPresign runs the presigning rounds over Nostr and stores the
+// presignature for derivationPath in the presign store, named after
+// sessionID. Returns the PresignResponse JSON.
+func NostrJoinPresign(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON, derivationPath string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in NostrJoinPresign: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	cfg, keyshare, allParties, err := nostrSigningConfig(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON)
+	if err != nil {
+		return "", err
+	}
+	return runNostrPresignInternal(cfg, keyshare, "presign", func(tssService *ServiceImpl) (any, error) {
+		return tssService.PresignECDSA(&PresignRequest{
+			PubKey:               keyshare.PubKey,
+			PresignID:            sessionID,
+			PresignCommitteeKeys: strings.Join(allParties, ","),
+			LocalPartyKey:        cfg.LocalNpub,
+			DerivePath:           derivationPath,
+		})
+	})
 }
 
-// runNostrPreAgreementSendBTC performs a pre-agreement phase internally.
-// Both parties exchange their peerNonce and satoshiFees, then agree on:
-// - fullNonce: sorted join of both peerNonces (like in keygen)
-// - averageFees: average of both satoshiFees
-func runNostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionFlag string, localSatoshiFees int64) (result *preAgreementResult, err error) {
+// NostrJoinKeysignPresigned signs a base64-encoded sighash over Nostr in a
+// single round, with the presignature presignID that the same parties
+// computed with NostrJoinPresign. The presignature is used up, whether signing
+// succeeds or not.
+func NostrJoinKeysignPresigned(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON, derivationPath, presignID, sighashBase64 string) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			errMsg := fmt.Sprintf("PANIC in runNostrPreAgreementSendBTC: %v", r)
+			errMsg := fmt.Sprintf("PANIC in NostrJoinKeysignPresigned: %v", r)
 			Logf("BBMTLog: %s", errMsg)
 			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
 			err = fmt.Errorf("internal error (panic): %v", r)
-			result = nil
+			result = ""
 		}
 	}()
 
-	Logln("BBMTLog", "invoking runNostrPreAgreementSendBTC...")
+	cfg, keyshare, allParties, err := nostrSigningConfig(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON)
+	if err != nil {
+		return "", err
+	}
+	return runNostrPresignInternal(cfg, keyshare, "keysign", func(tssService *ServiceImpl) (any, error) {
+		return tssService.KeysignPresignedECDSA(&PresignedKeysignRequest{
+			KeysignRequest: KeysignRequest{
+				PubKey:               keyshare.PubKey,
+				MessageToSign:        sighashBase64,
+				KeysignCommitteeKeys: strings.Join(allParties, ","),
+				LocalPartyKey:        cfg.LocalNpub,
+				DerivePath:           derivationPath,
+			},
+			PresignID: presignID,
+		})
+	})
+}
 
-	// Derive npub from nsec (handles bech32 format)
+// nostrSigningConfig parses the keyshare and builds the session config of a
+// Nostr signing committee, like NostrJoinKeysign does.
+func nostrSigningConfig(relaysCSV, partyNsec, partiesNpubsCSV, sessionID, sessionKey, keyshareJSON string) (nostrtransport.Config, *LocalStateNostr, []string, error) {
+	var cfg nostrtransport.Config
 	localNpub, err := DeriveNpubFromNsec(partyNsec)
 	if err != nil {
-		return nil, err
+		return cfg, nil, nil, err
+	}
+	var keyshare LocalStateNostr
+	if err := json.Unmarshal([]byte(keyshareJSON), &keyshare); err != nil {
+		return cfg, nil, nil, fmt.Errorf("failed to parse keyshare JSON: %w", err)
+	}
+	if keyshare.NostrNpub != localNpub {
+		return cfg, nil, nil, fmt.Errorf("keyshare npub (%s) does not match derived npub (%s)", keyshare.NostrNpub, localNpub)
 	}
-
-	// Parse relays
 	relays := strings.Split(relaysCSV, ",")
 	for i := range relays {
 		relays[i] = strings.TrimSpace(relays[i])
 	}
-
-	// Parse peer npubs
-	allParties := strings.Split(partiesNpubsCSV, ",")
-	for i := range allParties {
-		allParties[i] = strings.TrimSpace(allParties[i])
+	allParties, err := nostrSigningCommittee(&keyshare, localNpub, partiesNpubsCSV)
+	if err != nil {
+		return cfg, nil, nil, err
 	}
-
-	// Extract peer npubs (excluding self)
 	peersNpub := make([]string, 0)
 	for _, npub := range allParties {
 		if npub != localNpub {
 			peersNpub = append(peersNpub, npub)
 		}
 	}
-
-	if len(peersNpub) != 1 {
-		return nil, fmt.Errorf("pre-agreement requires exactly 1 peer, got %d", len(peersNpub))
+	cfg = nostrtransport.Config{
+		Relays:        relays,
+		SessionID:     sessionID,
+		SessionKeyHex: sessionKey,
+		LocalNpub:     localNpub,
+		LocalNsec:     partyNsec,
+		PeersNpub:     peersNpub,
+		MaxTimeout:    90 * time.Second,
 	}
-	peerNpub := peersNpub[0]
-
+	cfg.ApplyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return cfg, nil, nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, &keyshare, allParties, nil
+}
+
+// preAgreementResult holds the results of the pre-agreement phase
+type preAgreementResult struct {
+	fullNonce   string
+	averageFees int64
+}
+
+// runNostrPreAgreementSendBTC performs a pre-agreement phase internally.
+// Every party sends its peerNonce and satoshiFees to each of the N-1 peers,
+// then all agree on:
+// - fullNonce: sorted join of all peerNonces (like in keygen)
+// - averageFees: average of all satoshiFees
+func runNostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionFlag string, localSatoshiFees int64) (result *preAgreementResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in runNostrPreAgreementSendBTC: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = nil
+		}
+	}()
+
+	Logln("BBMTLog", "invoking runNostrPreAgreementSendBTC...")
+
+	// Derive npub from nsec (handles bech32 format)
+	localNpub, err := DeriveNpubFromNsec(partyNsec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse relays
+	relays := strings.Split(relaysCSV, ",")
+	for i := range relays {
+		relays[i] = strings.TrimSpace(relays[i])
+	}
+
+	// Parse peer npubs
+	allParties := strings.Split(partiesNpubsCSV, ",")
+	for i := range allParties {
+		allParties[i] = strings.TrimSpace(allParties[i])
+	}
+
+	// Extract peer npubs (excluding self)
+	peersNpub := make([]string, 0)
+	for _, npub := range allParties {
+		if npub != localNpub {
+			peersNpub = append(peersNpub, npub)
+		}
+	}
+
+	if len(peersNpub) == 0 {
+		return nil, fmt.Errorf("pre-agreement requires at least 1 peer")
+	}
+
 	// Generate session key from sessionFlag (deterministic)
 	sessionKey, err := Sha256(sessionFlag)
 	if err != nil {
@@ -403,8 +821,8 @@ func runNostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionF
 		return nil, fmt.Errorf("failed to generate peerNonce: %w", err)
 	}
 
-	Logf("runNostrPreAgreementSendBTC: sessionFlag=%s, localNpub=%s, peerNpub=%s, peerNonce=%s, localFees=%d",
-		sessionFlag, localNpub, peerNpub, peerNonce, localSatoshiFees)
+	Logf("runNostrPreAgreementSendBTC: sessionFlag=%s, localNpub=%s, peersNpub=%v, peerNonce=%s, localFees=%d",
+		sessionFlag, localNpub, peersNpub, peerNonce, localSatoshiFees)
 
 	// Create config for pre-agreement (using sessionFlag as sessionID)
 	cfg := nostrtransport.Config{
@@ -443,11 +861,11 @@ func runNostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionF
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	// Channel to receive peer's message
-	peerMessageCh := make(chan string, 1)
+	// Channel to receive the peers' messages
+	peerMessageCh := make(chan string, len(peersNpub))
 	peerErrorCh := make(chan error, 1)
 
-	// Start listening for peer's message
+	// Start listening for the peers' messages
 	// Note: The MessagePump will receive messages that match the session tag,
 	// including messages that were sent before we started listening (if they're
 	// still in the relay's cache, typically last 1-2 minutes)
@@ -473,7 +891,7 @@ func runNostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionF
 			case peerMessageCh <- peerMessage:
 			default:
 			}
-			return nil // Signal we got the message
+			return nil
 		})
 		if err != nil && err != context.Canceled {
 			select {
@@ -486,59 +904,85 @@ func runNostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionF
 	// Small delay to ensure subscription is active before sending
 	time.Sleep(1 * time.Second)
 
-	// Send our message to peer
-	err = messenger.SendMessage(ctx, localNpub, peerNpub, localMessage)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send pre-agreement message: %w", err)
+	// Send our message to every peer
+	for _, peerNpub := range peersNpub {
+		err = messenger.SendMessage(ctx, localNpub, peerNpub, localMessage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send pre-agreement message to %s: %w", peerNpub, err)
+		}
 	}
-	Logf("runNostrPreAgreementSendBTC: sent message to peer")
+	Logf("runNostrPreAgreementSendBTC: sent message to %d peers", len(peersNpub))
 
-	// Wait for peer's message
-	var peerMessage string
-	select {
-	case peerMessage = <-peerMessageCh:
-		Logf("runNostrPreAgreementSendBTC: received peer message: %s", peerMessage)
-	case err := <-peerErrorCh:
-		return nil, fmt.Errorf("failed to receive peer message: %w", err)
-	case <-ctx.Done():
-		return nil, fmt.Errorf("timeout waiting for peer message: %w", ctx.Err())
+	// Wait for one message per peer
+	agreement := newPreAgreement(peerNonce, localSatoshiFees)
+	for len(agreement.nonces) < len(peersNpub)+1 {
+		var peerMessage string
+		select {
+		case peerMessage = <-peerMessageCh:
+			Logf("runNostrPreAgreementSendBTC: received peer message: %s", peerMessage)
+		case err := <-peerErrorCh:
+			return nil, fmt.Errorf("failed to receive peer message: %w", err)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timeout waiting for peer messages (%d of %d received): %w", len(agreement.nonces)-1, len(peersNpub), ctx.Err())
+		}
+		if err := agreement.add(peerMessage); err != nil {
+			return nil, err
+		}
 	}
 
-	// Parse peer's message: <peerNonce>:<satoshiFees>
+	result = agreement.result()
+	Logf("runNostrPreAgreementSendBTC: fullNonce=%s, averageFees=%d", result.fullNonce, result.averageFees)
+	return result, nil
+}
+
+// preAgreement collects the nonces and fees of the pre-agreement messages.
+type preAgreement struct {
+	nonces    []string
+	totalFees int64
+}
+
+func newPreAgreement(localNonce string, localFees int64) *preAgreement {
+	return &preAgreement{nonces: []string{localNonce}, totalFees: localFees}
+}
+
+// add records a peer message <peerNonce>:<satoshiFees>. Nonces are random,
+// so a repeated nonce is a relay duplicate and is ignored.
+func (p *preAgreement) add(peerMessage string) error {
 	parts := strings.Split(peerMessage, ":")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid peer message format: expected 'nonce:fees', got: %s", peerMessage)
+		return fmt.Errorf("invalid peer message format: expected 'nonce:fees', got: %s", peerMessage)
 	}
-	peerNonceReceived := strings.TrimSpace(parts[0])
+	peerNonce := strings.TrimSpace(parts[0])
 	peerFeesStr := strings.TrimSpace(parts[1])
 	peerFees, err := strconv.ParseInt(peerFeesStr, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid peer fees format: %s", peerFeesStr)
+		return fmt.Errorf("invalid peer fees format: %s", peerFeesStr)
 	}
+	if Contains(p.nonces, peerNonce) {
+		return nil
+	}
+	Logf("runNostrPreAgreementSendBTC: parsed peer message - nonce=%s, fees=%d", peerNonce, peerFees)
+	p.nonces = append(p.nonces, peerNonce)
+	p.totalFees += peerFees
+	return nil
+}
 
-	Logf("runNostrPreAgreementSendBTC: parsed peer message - nonce=%s, fees=%d", peerNonceReceived, peerFees)
-
-	// Calculate fullNonce: sorted join of both nonces (like in keygen)
-	allNonces := []string{peerNonce, peerNonceReceived}
-	sort.Strings(allNonces)
-	fullNonce := strings.Join(allNonces, ",")
-
-	// Calculate average fees
-	averageFees := (localSatoshiFees + peerFees) / 2
-
-	Logf("runNostrPreAgreementSendBTC: fullNonce=%s, averageFees=%d", fullNonce, averageFees)
-
+// result is the sorted join of all nonces (like in keygen) and the average
+// of all fees.
+func (p *preAgreement) result() *preAgreementResult {
+	nonces := append([]string(nil), p.nonces...)
+	sort.Strings(nonces)
 	return &preAgreementResult{
-		fullNonce:   fullNonce,
-		averageFees: averageFees,
-	}, nil
+		fullNonce:   strings.Join(nonces, ","),
+		averageFees: p.totalFees / int64(len(nonces)),
+	}
 }
 
 // NostrPreAgreementSendBTC performs a pre-agreement phase before starting the MPC send BTC.
 // This is kept for backward compatibility but is now deprecated - use NostrMpcSendBTC which includes pre-agreement.
-// Both parties exchange their peerNonce and satoshiFees, then agree on:
-// - fullNonce: sorted join of both peerNonces (like in keygen)
-// - averageFees: average of both satoshiFees
+// All parties exchange their peerNonce and satoshiFees, then agree on:
+// - fullNonce: sorted join of all peerNonces (like in keygen)
+// - averageFees: average of all satoshiFees
 // Returns JSON: {"fullNonce": "...", "averageFees": 1234}
 func NostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionFlag string, localSatoshiFees int64) (string, error) {
 	result, err := runNostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionFlag, localSatoshiFees)
@@ -563,6 +1007,8 @@ func NostrPreAgreementSendBTC(relaysCSV, partyNsec, partiesNpubsCSV, sessionFlag
 // This function is analogous to MpcSendBTC but uses Nostr transport for keysign operations.
 // It internally performs pre-agreement to establish sessionID and unified fees.
 // Parameters:
+//   - partiesNpubsCSV: Npubs of the signing devices (self included), any subset of the
+//     keygen committee that meets the keyshare's threshold, e.g. 2 of the 3 in a 2-of-3 wallet
 //   - npubsSorted: Comma-separated sorted list of all party npubs (for sessionFlag calculation)
 //   - balanceSats: Balance in satoshis (for sessionFlag calculation)
 //   - amountSatoshi: Transaction amount in satoshis (for sessionFlag calculation)
@@ -596,6 +1042,21 @@ func runNostrMpcSendBTCInternal(relaysCSV, partyNsec, partiesNpubsCSV, npubsSort
 
 	Logln("BBMTLog", "invoking NostrMpcSendBTC...")
 
+	// Validate the signing subset before talking to the peers
+	localNpub, err := DeriveNpubFromNsec(partyNsec)
+	if err != nil {
+		return "", err
+	}
+	var keyshare LocalStateNostr
+	if err := json.Unmarshal([]byte(keyshareJSON), &keyshare); err != nil {
+		return "", fmt.Errorf("failed to parse keyshare JSON: %w", err)
+	}
+	signers, err := nostrSigningCommittee(&keyshare, localNpub, partiesNpubsCSV)
+	if err != nil {
+		return "", err
+	}
+	partiesNpubsCSV = strings.Join(signers, ",")
+
 	// Step 1: Calculate sessionFlag for pre-agreement
 	// Format: sha256(npubsSorted,balanceSats,satoshiAmount)
 	sessionFlag, err := Sha256(fmt.Sprintf("%s,%s,%d", npubsSorted, balanceSats, amountSatoshi))
@@ -1056,7 +1517,7 @@ func runNostrMpcSendBTCInternal(relaysCSV, partyNsec, partiesNpubsCSV, npubsSort
 }
 
 // runNostrKeygenInternal is the internal implementation of Nostr keygen.
-func runNostrKeygenInternal(cfg nostrtransport.Config, chaincode, ppmPath, localNpub, sessionID string) (result string, err error) {
+func runNostrKeygenInternal(cfg nostrtransport.Config, chaincode, ppmPath, localNpub, sessionID string, threshold int) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			errMsg := fmt.Sprintf("PANIC in runNostrKeygenInternal: %v", r)
@@ -1146,6 +1607,9 @@ func runNostrKeygenInternal(cfg nostrtransport.Config, chaincode, ppmPath, local
 	Logln("BBMTLog", "starting message pump...")
 	// Create message pump
 	pump := nostrtransport.NewMessagePump(cfg, client)
+	pump.OnDecryptError = func(fromNpub string, err error) {
+		registry.recordUndecryptable(sessionID, fromNpub, err.Error())
+	}
 	pumpCtx, pumpCancel := context.WithTimeout(ctx, cfg.MaxTimeout)
 	defer pumpCancel()
 
@@ -1195,10 +1659,11 @@ func runNostrKeygenInternal(cfg nostrtransport.Config, chaincode, ppmPath, local
 		LocalPartyID: localNpub,
 		AllParties:   partiesCSV,
 		ChainCodeHex: chaincode,
+		Threshold:    threshold,
 	})
 	if err != nil {
 		pumpCancel()
-		return "", fmt.Errorf("keygen failed: %w", err)
+		return "", failSession(sessionID, fmt.Errorf("keygen failed: %w", err))
 	}
 
 	Logln("BBMTLog", "ECDSA keygen response ok")
@@ -1357,12 +1822,460 @@ func runNostrKeysignInternal(cfg nostrtransport.Config, keyshare *LocalStateNost
 		return "", fmt.Errorf("create TSS service: %w", err)
 	}
 
-	// Create message pump
+	// Create message pump
+	pump := nostrtransport.NewMessagePump(cfg, client)
+	pump.OnDecryptError = func(fromNpub string, err error) {
+		registry.recordUndecryptable(sessionID, fromNpub, err.Error())
+	}
+	pumpCtx, pumpCancel := context.WithTimeout(ctx, cfg.MaxTimeout)
+	defer pumpCancel()
+
+	// Run pump in background
+	pumpErrCh := make(chan error, 1)
+	var pumpWg sync.WaitGroup
+	pumpWg.Add(1)
+	go func() {
+		defer pumpWg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				errMsg := fmt.Sprintf("PANIC in keysign pump goroutine: %v", r)
+				Logf("BBMTLog: %s", errMsg)
+				Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+				select {
+				case pumpErrCh <- fmt.Errorf("internal error (panic): %v", r):
+				default:
+				}
+			}
+		}()
+
+		err := pump.Run(pumpCtx, func(payload []byte) error {
+			// Get current status to access SeqNo and Index
+			status := getStatus(sessionID)
+			status.Step++
+			status.Index++
+			status.Info = fmt.Sprintf("Received new message %d", status.Index)
+			setIndex(sessionID, status.Info, status.Step, status.Index)
+			setStep(sessionID, status.Info, status.Step)
+			return tssService.ApplyData(string(payload))
+		})
+		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+			pumpErrCh <- err
+		} else {
+			Logln("BBMTLog", "Message applied")
+			status := getStatus(sessionID)
+			status.Step++
+			status.Info = fmt.Sprintf("Applied Message %d", status.Index)
+			setStep(sessionID, status.Info, status.Step)
+		}
+	}()
+
+	// Hash and encode message
+	status.Step++
+	status.Info = "hashing message"
+	setStep(sessionID, status.Info, status.Step)
+	messageHash, err := Sha256(message)
+	if err != nil {
+		pumpCancel()
+		return "", fmt.Errorf("hash message: %w", err)
+	}
+	messageHashBytes, err := hex.DecodeString(messageHash)
+	if err != nil {
+		pumpCancel()
+		return "", fmt.Errorf("decode message hash: %w", err)
+	}
+	messageBase64 := base64.StdEncoding.EncodeToString(messageHashBytes)
+
+	// Use the actively participating parties (allParties) for keysign committee
+	// This allows duo-mode keysign even if the keyshare was generated for 3-party MPC.
+	uniqueParties := make(map[string]bool)
+	keysignCommitteeKeysList := make([]string, 0, len(allParties))
+	for _, party := range allParties {
+		party = strings.TrimSpace(party)
+		if party == "" || uniqueParties[party] {
+			continue
+		}
+		uniqueParties[party] = true
+		keysignCommitteeKeysList = append(keysignCommitteeKeysList, party)
+	}
+	if len(keysignCommitteeKeysList) == 0 {
+		return "", fmt.Errorf("no parties specified for keysign")
+	}
+	keysignCommitteeKeys := strings.Join(keysignCommitteeKeysList, ",")
+
+	// Perform keysign
+	status.Step++
+	status.Info = "running ECDSA keysign"
+	setStep(sessionID, status.Info, status.Step)
+	keysignResp, err := tssService.KeysignECDSA(&KeysignRequest{
+		PubKey:               keyshare.PubKey,
+		MessageToSign:        messageBase64,
+		KeysignCommitteeKeys: keysignCommitteeKeys,
+		LocalPartyKey:        cfg.LocalNpub,
+		DerivePath:           derivePath,
+	})
+	if err != nil {
+		pumpCancel()
+		pumpWg.Wait()
+		return "", failSession(sessionID, fmt.Errorf("keysign failed: %w", err))
+	}
+
+	// Wait a bit for pump to finish processing
+	time.Sleep(2 * time.Second)
+	pumpCancel()
+	pumpWg.Wait()
+
+	// Check for pump errors
+	select {
+	case err := <-pumpErrCh:
+		return "", fmt.Errorf("pump error: %w", err)
+	default:
+	}
+
+	// Keysign completed successfully
+	status = getStatus(sessionID)
+	status.Step++
+	status.Info = "keysign ok"
+	setStep(sessionID, status.Info, status.Step)
+
+	// Publish completion
+	if err := coordinator.PublishComplete(ctx, "keysign"); err != nil {
+		// Non-fatal
+		Logln("BBMTLog", "Warning: failed to publish completion:", err)
+	}
+
+	status.Step++
+	status.Info = "session ended"
+	setStep(sessionID, status.Info, status.Step)
+
+	status.Step++
+	status.Info = "local party complete"
+	status.Done = true
+	setStatus(sessionID, status)
+
+	// Marshal response
+	resultJSON, err := json.MarshalIndent(keysignResp, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal response: %w", err)
+	}
+
+	Logln("========== DONE ==========")
+	return string(resultJSON), nil
+}
+
+// runNostrKeysignInternalWithSighash is similar to runNostrKeysignInternal but accepts a base64-encoded sighash directly.
+func runNostrKeysignInternalWithSighash(cfg nostrtransport.Config, keyshare *LocalStateNostr, derivePath, sighashBase64 string, allParties []string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in runNostrKeysignInternalWithSighash: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+	sessionID := cfg.SessionID
+
+	// Initialize status tracking
+	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: "keysign", Done: false, Time: 0}
+	setStatus(sessionID, status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.MaxTimeout)
+	defer cancel()
+
+	// Create Nostr client
+	status.Step++
+	status.Info = "creating Nostr client"
+	setStep(sessionID, status.Info, status.Step)
+	client, err := nostrtransport.NewClient(cfg)
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	defer client.Close("keysign complete")
+
+	// Create session coordinator
+	coordinator := nostrtransport.NewSessionCoordinator(cfg, client)
+
+	// Publish readiness
+	status.Step++
+	status.Info = "publishing ready"
+	setStep(sessionID, status.Info, status.Step)
+	Logf("runNostrKeysignInternalWithSighash: About to publish ready for session %s, localNpub=%s, peers=%v", sessionID, cfg.LocalNpub, cfg.PeersNpub)
+	if err := coordinator.PublishReady(ctx); err != nil {
+		Logf("runNostrKeysignInternalWithSighash: PublishReady failed: %v", err)
+		return "", fmt.Errorf("publish ready: %w", err)
+	}
+	Logf("runNostrKeysignInternalWithSighash: PublishReady succeeded for session %s", sessionID)
+
+	// Small delay to allow events to propagate (same as keygen)
+	time.Sleep(500 * time.Millisecond)
+
+	// Wait for all peers
+	status.Step++
+	status.Info = "waiting for peers"
+	setStep(sessionID, status.Info, status.Step)
+	Logln("BBMTLog", "waiting for peers...")
+	if err := coordinator.AwaitPeers(ctx); err != nil {
+		return "", fmt.Errorf("await peers: %w", err)
+	}
+
+	// Peers are ready, increment SeqNo and Index
+	status.SeqNo++
+	status.Index++
+	status.Step++
+	status.Info = "peers ready"
+	setSeqNo(sessionID, status.Info, status.Step, status.SeqNo)
+
+	// Create messenger and adapter
+	status.Step++
+	status.Info = "creating messenger"
+	setStep(sessionID, status.Info, status.Step)
+	messenger := nostrtransport.NewMessenger(cfg, client)
+	messengerAdapter := &nostrMessengerAdapter{
+		messenger: messenger,
+		ctx:       ctx,
+	}
+
+	// Create local state accessor that returns the keyshare
+	status.Step++
+	status.Info = "loading local state"
+	setStep(sessionID, status.Info, status.Step)
+	stateAccessor := &nostrKeysignStateAccessor{
+		keyshare: keyshare,
+	}
+
+	// Create TSS service
+	status.Step++
+	status.Info = "creating TSS service"
+	setStep(sessionID, status.Info, status.Step)
+	tssService, err := NewService(messengerAdapter, stateAccessor, false, "-")
+	if err != nil {
+		return "", fmt.Errorf("create TSS service: %w", err)
+	}
+
+	// Create message pump
+	pump := nostrtransport.NewMessagePump(cfg, client)
+	pump.OnDecryptError = func(fromNpub string, err error) {
+		registry.recordUndecryptable(sessionID, fromNpub, err.Error())
+	}
+	pumpCtx, pumpCancel := context.WithTimeout(ctx, cfg.MaxTimeout)
+	defer pumpCancel()
+
+	// Run pump in background
+	pumpErrCh := make(chan error, 1)
+	var pumpWg sync.WaitGroup
+	pumpWg.Add(1)
+	go func() {
+		defer pumpWg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				errMsg := fmt.Sprintf("PANIC in keysign pump goroutine: %v", r)
+				Logf("BBMTLog: %s", errMsg)
+				Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+				select {
+				case pumpErrCh <- fmt.Errorf("internal error (panic): %v", r):
+				default:
+				}
+			}
+		}()
+
+		err := pump.Run(pumpCtx, func(payload []byte) error {
+			// Get current status to access SeqNo and Index
+			status := getStatus(sessionID)
+			status.Step++
+			status.Index++
+			status.Info = fmt.Sprintf("Received new message %d", status.Index)
+			setIndex(sessionID, status.Info, status.Step, status.Index)
+			setStep(sessionID, status.Info, status.Step)
+			return tssService.ApplyData(string(payload))
+		})
+		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+			pumpErrCh <- err
+		} else {
+			Logln("BBMTLog", "Message applied")
+			status := getStatus(sessionID)
+			status.Step++
+			status.Info = fmt.Sprintf("Applied Message %d", status.Index)
+			setStep(sessionID, status.Info, status.Step)
+		}
+	}()
+
+	// Use the base64-encoded sighash directly (no hashing)
+	messageBase64 := sighashBase64
+
+	// Use allParties from partiesNpubsCSV (which contains only the participating parties: local + selected peer)
+	// This ensures we only use 2 parties in trio mode, not all 3 from the keyshare
+	keysignCommitteeKeys := strings.Join(allParties, ",")
+	if keysignCommitteeKeys == "" {
+		// Fallback: use keyshare's keygen committee keys if allParties is empty
+		// Convert hex keys to bech32 npubs if needed (to match LocalPartyKey format)
+		keysignCommitteeKeysList := make([]string, 0, len(keyshare.KeygenCommitteeKeys))
+		for _, key := range keyshare.KeygenCommitteeKeys {
+			if key == "" {
+				continue
+			}
+			// If already bech32 npub, use as-is
+			if strings.HasPrefix(key, "npub1") {
+				keysignCommitteeKeysList = append(keysignCommitteeKeysList, key)
+			} else {
+				// Convert hex to bech32 npub
+				npub, err := HexToNpub(key)
+				if err != nil {
+					Logf("Warning: failed to convert hex key %s to npub: %v, using as-is", key[:20]+"...", err)
+					keysignCommitteeKeysList = append(keysignCommitteeKeysList, key)
+				} else {
+					keysignCommitteeKeysList = append(keysignCommitteeKeysList, npub)
+				}
+			}
+		}
+		keysignCommitteeKeys = strings.Join(keysignCommitteeKeysList, ",")
+	}
+
+	// Perform keysign
+	status.Step++
+	status.Info = "running ECDSA keysign"
+	setStep(sessionID, status.Info, status.Step)
+	keysignResp, err := tssService.KeysignECDSA(&KeysignRequest{
+		PubKey:               keyshare.PubKey,
+		MessageToSign:        messageBase64,
+		KeysignCommitteeKeys: keysignCommitteeKeys,
+		LocalPartyKey:        cfg.LocalNpub,
+		DerivePath:           derivePath,
+	})
+	if err != nil {
+		pumpCancel()
+		pumpWg.Wait()
+		return "", failSession(sessionID, fmt.Errorf("keysign failed: %w", err))
+	}
+
+	// Wait a bit for pump to finish processing
+	time.Sleep(2 * time.Second)
+	pumpCancel()
+	pumpWg.Wait()
+
+	// Check for pump errors
+	select {
+	case err := <-pumpErrCh:
+		return "", fmt.Errorf("pump error: %w", err)
+	default:
+	}
+
+	// Keysign completed successfully
+	status = getStatus(sessionID)
+	status.Step++
+	status.Info = "keysign ok"
+	setStep(sessionID, status.Info, status.Step)
+
+	// Publish completion
+	if err := coordinator.PublishComplete(ctx, "keysign"); err != nil {
+		// Non-fatal
+		Logln("BBMTLog", "Warning: failed to publish completion:", err)
+	}
+
+	status.Step++
+	status.Info = "session ended"
+	setStep(sessionID, status.Info, status.Step)
+
+	status.Step++
+	status.Info = "local party complete"
+	status.Done = true
+	setStatus(sessionID, status)
+
+	// Marshal response
+	resultJSON, err := json.MarshalIndent(keysignResp, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal response: %w", err)
+	}
+
+	Logln("========== DONE ==========")
+	return string(resultJSON), nil
+}
+
+// runNostrReshareInternal is the internal implementation of Nostr resharing.
+func runNostrReshareInternal(cfg nostrtransport.Config, keyshare *LocalStateNostr, req *ReshareRequest, ppmPath string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in runNostrReshareInternal: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+	sessionID := cfg.SessionID
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.MaxTimeout)
+	defer cancel()
+
+	status := getStatus(sessionID)
+	setStep(sessionID, "creating Nostr client", status.Step+1)
+
+	// Create Nostr client
+	client, err := nostrtransport.NewClient(cfg)
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	defer client.Close("reshare complete")
+
+	// Create session coordinator
+	coordinator := nostrtransport.NewSessionCoordinator(cfg, client)
+
+	status = getStatus(sessionID)
+	setStep(sessionID, "publishing readiness", status.Step+1)
+	if err := coordinator.PublishReady(ctx); err != nil {
+		return "", fmt.Errorf("publish ready: %w", err)
+	}
+
+	// Small delay to allow events to propagate
+	time.Sleep(500 * time.Millisecond)
+
+	Logln("BBMTLog", "waiting for peers...")
+	status = getStatus(sessionID)
+	setStep(sessionID, "waiting for peers", status.Step+1)
+	if err := coordinator.AwaitPeers(ctx); err != nil {
+		return "", fmt.Errorf("await peers: %w", err)
+	}
+
+	status = getStatus(sessionID)
+	status.SeqNo++
+	status.Index++
+	setStatus(sessionID, status)
+
+	messenger := nostrtransport.NewMessenger(cfg, client)
+	messengerAdapter := &nostrMessengerAdapter{
+		messenger: messenger,
+		ctx:       ctx,
+	}
+
+	// The old keyshare is served to tss-lib, the new one is captured
+	var localStateJSON string
+	var localStateMu sync.Mutex
+	stateAccessor := &nostrReshareStateAccessor{
+		keyshare: keyshare,
+		saveFunc: func(pubKey, state string) error {
+			localStateMu.Lock()
+			defer localStateMu.Unlock()
+			localStateJSON = state
+			return nil
+		},
+	}
+	if keyshare != nil {
+		req.PubKey = keyshare.PubKey
+	}
+
+	status = getStatus(sessionID)
+	setStep(sessionID, "preparing TSS service", status.Step+1)
+	isNew := Contains(req.GetNewParties(), cfg.LocalNpub)
+	tssService, err := NewService(messengerAdapter, stateAccessor, isNew, ppmPath)
+	if err != nil {
+		return "", fmt.Errorf("create TSS service: %w", err)
+	}
+
 	pump := nostrtransport.NewMessagePump(cfg, client)
+	pump.OnDecryptError = func(fromNpub string, err error) {
+		registry.recordUndecryptable(sessionID, fromNpub, err.Error())
+	}
 	pumpCtx, pumpCancel := context.WithTimeout(ctx, cfg.MaxTimeout)
 	defer pumpCancel()
 
-	// Run pump in background
 	pumpErrCh := make(chan error, 1)
 	var pumpWg sync.WaitGroup
 	pumpWg.Add(1)
@@ -1370,7 +2283,7 @@ func runNostrKeysignInternal(cfg nostrtransport.Config, keyshare *LocalStateNost
 		defer pumpWg.Done()
 		defer func() {
 			if r := recover(); r != nil {
-				errMsg := fmt.Sprintf("PANIC in keysign pump goroutine: %v", r)
+				errMsg := fmt.Sprintf("PANIC in reshare pump goroutine: %v", r)
 				Logf("BBMTLog: %s", errMsg)
 				Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
 				select {
@@ -1381,124 +2294,91 @@ func runNostrKeysignInternal(cfg nostrtransport.Config, keyshare *LocalStateNost
 		}()
 
 		err := pump.Run(pumpCtx, func(payload []byte) error {
-			// Get current status to access SeqNo and Index
 			status := getStatus(sessionID)
 			status.Step++
 			status.Index++
-			status.Info = fmt.Sprintf("Received new message %d", status.Index)
+			status.Info = fmt.Sprintf("Received Message %d", status.Index)
 			setIndex(sessionID, status.Info, status.Step, status.Index)
 			setStep(sessionID, status.Info, status.Step)
 			return tssService.ApplyData(string(payload))
 		})
 		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
 			pumpErrCh <- err
-		} else {
-			Logln("BBMTLog", "Message applied")
-			status := getStatus(sessionID)
-			status.Step++
-			status.Info = fmt.Sprintf("Applied Message %d", status.Index)
-			setStep(sessionID, status.Info, status.Step)
 		}
 	}()
 
-	// Hash and encode message
-	status.Step++
-	status.Info = "hashing message"
-	setStep(sessionID, status.Info, status.Step)
-	messageHash, err := Sha256(message)
-	if err != nil {
-		pumpCancel()
-		return "", fmt.Errorf("hash message: %w", err)
-	}
-	messageHashBytes, err := hex.DecodeString(messageHash)
-	if err != nil {
-		pumpCancel()
-		return "", fmt.Errorf("decode message hash: %w", err)
-	}
-	messageBase64 := base64.StdEncoding.EncodeToString(messageHashBytes)
-
-	// Use the actively participating parties (allParties) for keysign committee
-	// This allows duo-mode keysign even if the keyshare was generated for 3-party MPC.
-	uniqueParties := make(map[string]bool)
-	keysignCommitteeKeysList := make([]string, 0, len(allParties))
-	for _, party := range allParties {
-		party = strings.TrimSpace(party)
-		if party == "" || uniqueParties[party] {
-			continue
-		}
-		uniqueParties[party] = true
-		keysignCommitteeKeysList = append(keysignCommitteeKeysList, party)
-	}
-	if len(keysignCommitteeKeysList) == 0 {
-		return "", fmt.Errorf("no parties specified for keysign")
-	}
-	keysignCommitteeKeys := strings.Join(keysignCommitteeKeysList, ",")
-
-	// Perform keysign
-	status.Step++
-	status.Info = "running ECDSA keysign"
-	setStep(sessionID, status.Info, status.Step)
-	keysignResp, err := tssService.KeysignECDSA(&KeysignRequest{
-		PubKey:               keyshare.PubKey,
-		MessageToSign:        messageBase64,
-		KeysignCommitteeKeys: keysignCommitteeKeys,
-		LocalPartyKey:        cfg.LocalNpub,
-		DerivePath:           derivePath,
-	})
-	if err != nil {
+	Logln("BBMTLog", "doing ECDSA reshare...")
+	status = getStatus(sessionID)
+	setStep(sessionID, "running ECDSA reshare", status.Step+1)
+	if _, err := tssService.ReshareECDSA(req); err != nil {
 		pumpCancel()
 		pumpWg.Wait()
-		return "", fmt.Errorf("keysign failed: %w", err)
+		return "", failSession(sessionID, fmt.Errorf("reshare failed: %w", err))
 	}
 
+	Logln("BBMTLog", "ECDSA reshare response ok")
+	status = getStatus(sessionID)
+	setStep(sessionID, "reshare ok", status.Step+1)
+
 	// Wait a bit for pump to finish processing
 	time.Sleep(2 * time.Second)
 	pumpCancel()
 	pumpWg.Wait()
 
-	// Check for pump errors
 	select {
 	case err := <-pumpErrCh:
 		return "", fmt.Errorf("pump error: %w", err)
 	default:
 	}
 
-	// Keysign completed successfully
-	status = getStatus(sessionID)
-	status.Step++
-	status.Info = "keysign ok"
-	setStep(sessionID, status.Info, status.Step)
-
-	// Publish completion
-	if err := coordinator.PublishComplete(ctx, "keysign"); err != nil {
+	if err := coordinator.PublishComplete(ctx, "reshare"); err != nil {
 		// Non-fatal
 		Logln("BBMTLog", "Warning: failed to publish completion:", err)
 	}
 
-	status.Step++
-	status.Info = "session ended"
-	setStep(sessionID, status.Info, status.Step)
-
+	status = getStatus(sessionID)
 	status.Step++
 	status.Info = "local party complete"
 	status.Done = true
 	setStatus(sessionID, status)
 
-	// Marshal response
-	resultJSON, err := json.MarshalIndent(keysignResp, "", "  ")
-	if err != nil {
-		return "", fmt.Errorf("marshal response: %w", err)
+	Logln("BBMTLog", "========== DONE ==========")
+
+	localStateMu.Lock()
+	result = localStateJSON
+	localStateMu.Unlock()
+	if !isNew {
+		return "", nil
+	}
+	if result == "" {
+		return "", fmt.Errorf("no local state captured")
 	}
 
-	Logln("========== DONE ==========")
-	return string(resultJSON), nil
+	var localState LocalState
+	if err := json.Unmarshal([]byte(result), &localState); err != nil {
+		return "", fmt.Errorf("parse local state: %w", err)
+	}
+	localStateNostr := LocalStateNostr{
+		LocalState: localState,
+		NostrNpub:  cfg.LocalNpub,
+	}
+	if err := localStateNostr.SetNsec(cfg.LocalNsec); err != nil {
+		return "", fmt.Errorf("set nsec: %w", err)
+	}
+	finalJSON, err := json.MarshalIndent(localStateNostr, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal result: %w", err)
+	}
+	return string(finalJSON), nil
 }
 
-// runNostrKeysignInternalWithSighash is similar to runNostrKeysignInternal but accepts a base64-encoded sighash directly.
-func runNostrKeysignInternalWithSighash(cfg nostrtransport.Config, keyshare *LocalStateNostr, derivePath, sighashBase64 string, allParties []string) (result string, err error) {
+// runNostrPresignInternal runs fn, a presigning or a presigned keysign, with
+// the peers of cfg, the way runNostrKeysignInternal runs a keysign, and
+// returns the JSON of its response.
+func runNostrPresignInternal(cfg nostrtransport.Config, keyshare *LocalStateNostr, statusType string, fn func(tssService *ServiceImpl) (any, error)) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			errMsg := fmt.Sprintf("PANIC in runNostrKeysignInternalWithSighash: %v", r)
+			errMsg := fmt.Sprintf("PANIC in runNostrPresignInternal: %v", r)
 			Logf("BBMTLog: %s", errMsg)
 			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
 			err = fmt.Errorf("internal error (panic): %v", r)
@@ -1507,14 +2387,12 @@ func runNostrKeysignInternalWithSighash(cfg nostrtransport.Config, keyshare *Loc
 	}()
 	sessionID := cfg.SessionID
 
-	// Initialize status tracking
-	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: "keysign", Done: false, Time: 0}
+	status := Status{Step: 0, SeqNo: 0, Index: 0, Info: "initializing...", Type: statusType, Done: false, Time: 0}
 	setStatus(sessionID, status)
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.MaxTimeout)
 	defer cancel()
 
-	// Create Nostr client
 	status.Step++
 	status.Info = "creating Nostr client"
 	setStep(sessionID, status.Info, status.Step)
@@ -1522,74 +2400,41 @@ func runNostrKeysignInternalWithSighash(cfg nostrtransport.Config, keyshare *Loc
 	if err != nil {
 		return "", fmt.Errorf("create client: %w", err)
 	}
-	defer client.Close("keysign complete")
+	defer client.Close(statusType + " complete")
 
-	// Create session coordinator
 	coordinator := nostrtransport.NewSessionCoordinator(cfg, client)
-
-	// Publish readiness
 	status.Step++
 	status.Info = "publishing ready"
 	setStep(sessionID, status.Info, status.Step)
-	Logf("runNostrKeysignInternalWithSighash: About to publish ready for session %s, localNpub=%s, peers=%v", sessionID, cfg.LocalNpub, cfg.PeersNpub)
 	if err := coordinator.PublishReady(ctx); err != nil {
-		Logf("runNostrKeysignInternalWithSighash: PublishReady failed: %v", err)
 		return "", fmt.Errorf("publish ready: %w", err)
 	}
-	Logf("runNostrKeysignInternalWithSighash: PublishReady succeeded for session %s", sessionID)
-
-	// Small delay to allow events to propagate (same as keygen)
 	time.Sleep(500 * time.Millisecond)
 
-	// Wait for all peers
 	status.Step++
 	status.Info = "waiting for peers"
 	setStep(sessionID, status.Info, status.Step)
-	Logln("BBMTLog", "waiting for peers...")
 	if err := coordinator.AwaitPeers(ctx); err != nil {
 		return "", fmt.Errorf("await peers: %w", err)
 	}
-
-	// Peers are ready, increment SeqNo and Index
 	status.SeqNo++
 	status.Index++
 	status.Step++
 	status.Info = "peers ready"
 	setSeqNo(sessionID, status.Info, status.Step, status.SeqNo)
 
-	// Create messenger and adapter
-	status.Step++
-	status.Info = "creating messenger"
-	setStep(sessionID, status.Info, status.Step)
 	messenger := nostrtransport.NewMessenger(cfg, client)
-	messengerAdapter := &nostrMessengerAdapter{
-		messenger: messenger,
-		ctx:       ctx,
-	}
-
-	// Create local state accessor that returns the keyshare
-	status.Step++
-	status.Info = "loading local state"
-	setStep(sessionID, status.Info, status.Step)
-	stateAccessor := &nostrKeysignStateAccessor{
-		keyshare: keyshare,
-	}
-
-	// Create TSS service
-	status.Step++
-	status.Info = "creating TSS service"
-	setStep(sessionID, status.Info, status.Step)
-	tssService, err := NewService(messengerAdapter, stateAccessor, false, "-")
+	tssService, err := NewService(&nostrMessengerAdapter{messenger: messenger, ctx: ctx}, &nostrKeysignStateAccessor{keyshare: keyshare}, false, "-")
 	if err != nil {
 		return "", fmt.Errorf("create TSS service: %w", err)
 	}
 
-	// Create message pump
 	pump := nostrtransport.NewMessagePump(cfg, client)
+	pump.OnDecryptError = func(fromNpub string, err error) {
+		registry.recordUndecryptable(sessionID, fromNpub, err.Error())
+	}
 	pumpCtx, pumpCancel := context.WithTimeout(ctx, cfg.MaxTimeout)
 	defer pumpCancel()
-
-	// Run pump in background
 	pumpErrCh := make(chan error, 1)
 	var pumpWg sync.WaitGroup
 	pumpWg.Add(1)
@@ -1597,128 +2442,85 @@ func runNostrKeysignInternalWithSighash(cfg nostrtransport.Config, keyshare *Loc
 		defer pumpWg.Done()
 		defer func() {
 			if r := recover(); r != nil {
-				errMsg := fmt.Sprintf("PANIC in keysign pump goroutine: %v", r)
-				Logf("BBMTLog: %s", errMsg)
-				Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+				Logf("BBMTLog: PANIC in %s pump goroutine: %v", statusType, r)
 				select {
 				case pumpErrCh <- fmt.Errorf("internal error (panic): %v", r):
 				default:
 				}
 			}
 		}()
-
 		err := pump.Run(pumpCtx, func(payload []byte) error {
-			// Get current status to access SeqNo and Index
 			status := getStatus(sessionID)
 			status.Step++
 			status.Index++
 			status.Info = fmt.Sprintf("Received new message %d", status.Index)
 			setIndex(sessionID, status.Info, status.Step, status.Index)
-			setStep(sessionID, status.Info, status.Step)
 			return tssService.ApplyData(string(payload))
 		})
 		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
 			pumpErrCh <- err
-		} else {
-			Logln("BBMTLog", "Message applied")
-			status := getStatus(sessionID)
-			status.Step++
-			status.Info = fmt.Sprintf("Applied Message %d", status.Index)
-			setStep(sessionID, status.Info, status.Step)
 		}
 	}()
 
-	// Use the base64-encoded sighash directly (no hashing)
-	messageBase64 := sighashBase64
-
-	// Use allParties from partiesNpubsCSV (which contains only the participating parties: local + selected peer)
-	// This ensures we only use 2 parties in trio mode, not all 3 from the keyshare
-	keysignCommitteeKeys := strings.Join(allParties, ",")
-	if keysignCommitteeKeys == "" {
-		// Fallback: use keyshare's keygen committee keys if allParties is empty
-		// Convert hex keys to bech32 npubs if needed (to match LocalPartyKey format)
-		keysignCommitteeKeysList := make([]string, 0, len(keyshare.KeygenCommitteeKeys))
-		for _, key := range keyshare.KeygenCommitteeKeys {
-			if key == "" {
-				continue
-			}
-			// If already bech32 npub, use as-is
-			if strings.HasPrefix(key, "npub1") {
-				keysignCommitteeKeysList = append(keysignCommitteeKeysList, key)
-			} else {
-				// Convert hex to bech32 npub
-				npub, err := HexToNpub(key)
-				if err != nil {
-					Logf("Warning: failed to convert hex key %s to npub: %v, using as-is", key[:20]+"...", err)
-					keysignCommitteeKeysList = append(keysignCommitteeKeysList, key)
-				} else {
-					keysignCommitteeKeysList = append(keysignCommitteeKeysList, npub)
-				}
-			}
-		}
-		keysignCommitteeKeys = strings.Join(keysignCommitteeKeysList, ",")
-	}
-
-	// Perform keysign
 	status.Step++
-	status.Info = "running ECDSA keysign"
+	status.Info = "running ECDSA " + statusType
 	setStep(sessionID, status.Info, status.Step)
-	keysignResp, err := tssService.KeysignECDSA(&KeysignRequest{
-		PubKey:               keyshare.PubKey,
-		MessageToSign:        messageBase64,
-		KeysignCommitteeKeys: keysignCommitteeKeys,
-		LocalPartyKey:        cfg.LocalNpub,
-		DerivePath:           derivePath,
-	})
+	resp, err := fn(tssService)
 	if err != nil {
 		pumpCancel()
 		pumpWg.Wait()
-		return "", fmt.Errorf("keysign failed: %w", err)
+		return "", failSession(sessionID, fmt.Errorf("%s failed: %w", statusType, err))
 	}
 
-	// Wait a bit for pump to finish processing
+	// give the last messages time to leave before the pump stops
 	time.Sleep(2 * time.Second)
 	pumpCancel()
 	pumpWg.Wait()
-
-	// Check for pump errors
 	select {
 	case err := <-pumpErrCh:
 		return "", fmt.Errorf("pump error: %w", err)
 	default:
 	}
 
-	// Keysign completed successfully
-	status = getStatus(sessionID)
-	status.Step++
-	status.Info = "keysign ok"
-	setStep(sessionID, status.Info, status.Step)
-
-	// Publish completion
-	if err := coordinator.PublishComplete(ctx, "keysign"); err != nil {
-		// Non-fatal
+	if err := coordinator.PublishComplete(ctx, statusType); err != nil {
 		Logln("BBMTLog", "Warning: failed to publish completion:", err)
 	}
-
-	status.Step++
-	status.Info = "session ended"
-	setStep(sessionID, status.Info, status.Step)
-
+	status = getStatus(sessionID)
 	status.Step++
 	status.Info = "local party complete"
 	status.Done = true
 	setStatus(sessionID, status)
 
-	// Marshal response
-	resultJSON, err := json.MarshalIndent(keysignResp, "", "  ")
+	resultJSON, err := json.MarshalIndent(resp, "", "  ")
 	if err != nil {
 		return "", fmt.Errorf("marshal response: %w", err)
 	}
-
 	Logln("========== DONE ==========")
 	return string(resultJSON), nil
 }
 
+// nostrSigningCommittee parses the signing subset npubs and validates them
+// against the keyshare's keygen committee and threshold. Older keyshares may
+// record the committee as hex keys, those are compared in npub form.
+func nostrSigningCommittee(keyshare *LocalStateNostr, localNpub, partiesNpubsCSV string) ([]string, error) {
+	localState := LocalState{
+		KeygenCommitteeKeys: keyshare.KeygenCommitteeKeys,
+		Threshold:           keyshare.Threshold,
+	}
+	threshold, err := localState.threshold()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get threshold: %w", err)
+	}
+	committee, err := localState.signingCommittee(strings.Split(partiesNpubsCSV, ","), threshold)
+	if err != nil {
+		return nil, err
+	}
+	if !Contains(committee, localNpub) {
+		return nil, fmt.Errorf("local party %s not in keysign committee", localNpub)
+	}
+	return committee, nil
+}
+
 // nostrLocalStateAccessor implements LocalStateAccessor for Nostr keygen.
 type nostrLocalStateAccessor struct {
 	saveFunc func(pubKey, state string) error
@@ -1780,3 +2582,31 @@ func (a *nostrMessengerAdapter) Send(from, to, body string) error {
 	setStep(cfg.SessionID, status.Info, status.Step)
 	return a.messenger.SendMessage(a.ctx, from, to, body)
 }
+
+// nostrReshareStateAccessor implements LocalStateAccessor for Nostr resharing.
+// It serves the old keyshare (if any) and captures the new one.
+type nostrReshareStateAccessor struct {
+	keyshare *LocalStateNostr
+	saveFunc func(pubKey, state string) error
+}
+
+func (a *nostrReshareStateAccessor) GetLocalState(pubKey string) (string, error) {
+	if a.keyshare == nil {
+		return "", fmt.Errorf("keyshare not loaded")
+	}
+	if a.keyshare.PubKey != pubKey {
+		return "", fmt.Errorf("pub key mismatch: expected %s, got %s", a.keyshare.PubKey, pubKey)
+	}
+	keyshareJSON, err := json.Marshal(a.keyshare.LocalState)
+	if err != nil {
+		return "", fmt.Errorf("marshal keyshare: %w", err)
+	}
+	return string(keyshareJSON), nil
+}
+
+func (a *nostrReshareStateAccessor) SaveLocalState(pubKey, localState string) error {
+	if a.saveFunc != nil {
+		return a.saveFunc(pubKey, localState)
+	}
+	return nil
+}
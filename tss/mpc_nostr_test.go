@@ -0,0 +1,37 @@
+package tss
+
+import "testing"
+
+func TestPreAgreement(t *testing.T) {
+	for name, tc := range map[string]struct {
+		messages  []string
+		peers     int
+		fullNonce string
+		fees      int64
+	}{
+		"one peer":       {messages: []string{"c:300"}, peers: 1, fullNonce: "b,c", fees: 200},
+		"four peers":     {messages: []string{"e:100", "a:200", "d:300", "c:400"}, peers: 4, fullNonce: "a,b,c,d,e", fees: 220},
+		"duplicates":     {messages: []string{"c:300", "c:300", "a:600", "c:300"}, peers: 2, fullNonce: "a,b,c", fees: 333},
+		"rounded down":   {messages: []string{"a:101"}, peers: 1, fullNonce: "a,b", fees: 100},
+		"spaces trimmed": {messages: []string{" a : 300 "}, peers: 1, fullNonce: "a,b", fees: 200},
+	} {
+		agreement := newPreAgreement("b", 100)
+		for _, message := range tc.messages {
+			if err := agreement.add(message); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if len(agreement.nonces) != tc.peers+1 {
+			t.Fatalf("%s: %d nonces, want %d", name, len(agreement.nonces), tc.peers+1)
+		}
+		result := agreement.result()
+		if result.fullNonce != tc.fullNonce || result.averageFees != tc.fees {
+			t.Fatalf("%s: got %s and %d, want %s and %d", name, result.fullNonce, result.averageFees, tc.fullNonce, tc.fees)
+		}
+	}
+	for _, message := range []string{"a", "a:1:2", "a:fees", ""} {
+		if err := newPreAgreement("b", 100).add(message); err == nil {
+			t.Fatalf("accepted %q", message)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package tss
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDownloadBlame runs downloadMessage of partyA on msg and returns the
+// error it aborts the session with.
+func testDownloadBlame(t *testing.T, session string, msg Message) error {
+	t.Helper()
+	server := newTestRelay(t, RelayConfig{AllowOpenSessions: true})
+	t.Cleanup(func() { registry.clear(session) })
+	testPostMessage(t, nil, server, session, msg)
+
+	s, err := NewService(&testMessenger{newTestNet()}, &testState{}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endCh := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go downloadMessage(server, session, "", "partyA", *s, endCh, &wg)
+	defer wg.Wait()
+	select {
+	case err := <-s.abortCh:
+		return err
+	case <-time.After(10 * time.Second):
+		close(endCh)
+		t.Fatal("session was not aborted")
+		return nil
+	}
+}
+
+func TestDownloadMessageBlamesSender(t *testing.T) {
+	for name, body := range map[string]string{
+		"malformed": "not a message",
+		"spoofed":   base64.StdEncoding.EncodeToString([]byte(`{"from":"partyC","wire_bytes":""}`)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := testDownloadBlame(t, "download-"+name, Message{From: "partyB", To: []string{"partyA"}, Body: body, SeqNo: "1"})
+			var blame *BlameError
+			if !errors.As(err, &blame) || blame.Kind != BlameProtocol || len(blame.Culprits) != 1 || blame.Culprits[0] != "partyB" {
+				t.Fatalf("want partyB blamed, got %v", err)
+			}
+			if failed := failSession("download-"+name, err); failed != err || getStatus("download-"+name).Blame == nil {
+				t.Fatal("blame not recorded in the session status")
+			}
+		})
+	}
+}
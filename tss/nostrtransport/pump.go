@@ -23,6 +23,10 @@ type MessagePump struct {
 	assembler   *ChunkAssembler
 	processed   map[string]bool
 	processedMu sync.Mutex
+
+	// OnDecryptError, if set, is called with the sender npub of every message
+	// from an expected peer that could not be decrypted.
+	OnDecryptError func(fromNpub string, err error)
 }
 
 func NewMessagePump(cfg Config, client *Client) *MessagePump {
@@ -184,6 +188,9 @@ func (p *MessagePump) Run(ctx context.Context, handler func([]byte) error) error
 				rumor, err := unseal(seal, p.cfg.LocalNsec, sealSenderNpubBech32)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "BBMTLog: MessagePump failed to unseal: %v\n", err)
+					if p.OnDecryptError != nil {
+						p.OnDecryptError(sealSenderNpubBech32, err)
+					}
 					continue
 				}
 				fmt.Fprintf(os.Stderr, "BBMTLog: MessagePump unsealed, got rumor\n")
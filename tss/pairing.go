@@ -0,0 +1,553 @@
+package tss
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticated LAN pairing. Discovery exchanges party IDs and ECIES keys in
+// the clear, so anyone on the network can pose as a peer. Pairing runs an
+// ephemeral X25519 exchange between two devices and derives a short
+// authentication string (SAS), 6 digits and 7 emoji, both users compare on
+// screen:
+//
+//	POST /pair/commit  {"id","pubkey","commit"}  -> {"id","pubkey","ephemeral","nonce"}
+//	POST /pair/reveal  {"ephemeral","nonce"}     -> 200
+//
+// The initiator commits to its ephemeral key before seeing the responder's,
+// so a man in the middle gets a single guess at the SAS. Once the users
+// confirm a match, the peer's ID and ECIES key are pinned for the ceremony:
+// PublishData then only serves pinned peers, and authenticates what it
+// serves with the pairing key, which FetchData checks.
+
+const (
+	pairingVersion   = "bbmt-pairing-v1"
+	pairingMACHeader = "X-BBMT-Pairing-MAC"
+	pairingSASDigits = 6
+	pairingSASEmoji  = 7
+)
+
+// sasEmoji are the 64 emoji of the SAS, 6 bits each.
+var sasEmoji = [64]struct{ Emoji, Name string }{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+// PairingResult is what both users compare before confirming a pairing.
+type PairingResult struct {
+	PairingID  string   `json:"pairingID"` // the same on both devices
+	PeerID     string   `json:"peerID"`
+	PeerPubkey string   `json:"peerPubkey"`
+	PeerIP     string   `json:"peerIP"`
+	SAS        string   `json:"sas"`   // 6 digits
+	Emoji      string   `json:"emoji"` // 7 emoji, space separated
+	EmojiNames []string `json:"emojiNames"`
+	Confirmed  bool     `json:"confirmed"`
+}
+
+// pairing is a completed exchange, pinned once confirmed.
+type pairing struct {
+	result PairingResult
+	key    []byte // pairing key, authenticates published data
+}
+
+// pairingRegistry holds the pairings of the current ceremony.
+type pairingRegistry struct {
+	mu       sync.Mutex
+	pairings map[string]*pairing // by pairing ID
+}
+
+var pairings = &pairingRegistry{pairings: map[string]*pairing{}}
+
+func (p *pairingRegistry) add(pr *pairing) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pairings[pr.result.PairingID] = pr
+}
+
+func (p *pairingRegistry) confirm(pairingID string, match bool) (PairingResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, ok := p.pairings[pairingID]
+	if !ok {
+		return PairingResult{}, fmt.Errorf("unknown pairing %q", pairingID)
+	}
+	if !match {
+		delete(p.pairings, pairingID)
+		return pr.result, nil
+	}
+	// a peer key is pinned once, to a single identity
+	for id, other := range p.pairings {
+		if id != pairingID && other.result.Confirmed && other.result.PeerPubkey == pr.result.PeerPubkey {
+			return PairingResult{}, fmt.Errorf("peer key already pinned by pairing %s", id)
+		}
+	}
+	pr.result.Confirmed = true
+	return pr.result, nil
+}
+
+// pinned returns the confirmed pairing of a peer ECIES key.
+func (p *pairingRegistry) pinned(pubkey string) (*pairing, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pr := range p.pairings {
+		if pr.result.Confirmed && pr.result.PeerPubkey == pubkey {
+			return pr, true
+		}
+	}
+	return nil, false
+}
+
+// active reports whether a pairing was confirmed, peers are then pinned.
+func (p *pairingRegistry) active() bool {
+	return len(p.confirmed()) > 0
+}
+
+// admits reports whether a peer key may take part: any before a pairing was
+// confirmed, only pinned ones after.
+func (p *pairingRegistry) admits(pubkey string) bool {
+	if !p.active() {
+		return true
+	}
+	_, ok := p.pinned(pubkey)
+	return ok
+}
+
+func (p *pairingRegistry) confirmed() []*pairing {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	confirmed := []*pairing{}
+	for _, pr := range p.pairings {
+		if pr.result.Confirmed {
+			confirmed = append(confirmed, pr)
+		}
+	}
+	return confirmed
+}
+
+func (p *pairingRegistry) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pairings = map[string]*pairing{}
+}
+
+// ---- Protocol ----
+
+type pairCommit struct {
+	ID     string `json:"id"`
+	Pubkey string `json:"pubkey"`
+	Commit string `json:"commit"`
+}
+
+type pairOffer struct {
+	ID        string `json:"id"`
+	Pubkey    string `json:"pubkey"`
+	Ephemeral string `json:"ephemeral"`
+	Nonce     string `json:"nonce"`
+}
+
+type pairReveal struct {
+	Ephemeral string `json:"ephemeral"`
+	Nonce     string `json:"nonce"`
+}
+
+// pairingParty is one side's contribution to the exchange.
+type pairingParty struct {
+	id        string
+	pubkey    string
+	ephemeral []byte
+	nonce     []byte
+}
+
+func pairingCommitment(ephemeral, nonce []byte) []byte {
+	hash := sha256.Sum256(pairingTranscript([]byte(pairingVersion+" commit"), ephemeral, nonce))
+	return hash[:]
+}
+
+// pairingTranscript length-prefixes the fields, so they cannot be shifted
+// between each other.
+func pairingTranscript(fields ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, field := range fields {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+// completePairing derives the SAS and pairing key of an exchange between
+// initiator and responder, self holds the private ephemeral key.
+func completePairing(self *ecdh.PrivateKey, initiator, responder pairingParty, peer pairingParty, peerIP string) (*pairing, error) {
+	peerKey, err := ecdh.X25519().NewPublicKey(peer.ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ephemeral key: %w", err)
+	}
+	shared, err := self.ECDH(peerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive pairing secret: %w", err)
+	}
+	transcript := pairingTranscript(
+		[]byte(pairingVersion),
+		[]byte(initiator.id), []byte(initiator.pubkey), initiator.ephemeral, initiator.nonce,
+		[]byte(responder.id), []byte(responder.pubkey), responder.ephemeral, responder.nonce,
+	)
+	digest := sha256.Sum256(transcript)
+	key, err := hkdf.Key(sha256.New, shared, digest[:], pairingVersion+" key", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive pairing key: %w", err)
+	}
+
+	sas := sha256.Sum256(append([]byte(pairingVersion+" sas"), digest[:]...))
+	code := binary.BigEndian.Uint32(sas[:4]) % 1000000
+	bits := binary.BigEndian.Uint64(sas[4:12])
+	emoji := make([]string, pairingSASEmoji)
+	names := make([]string, pairingSASEmoji)
+	for i := range pairingSASEmoji {
+		e := sasEmoji[(bits>>(58-6*i))&63]
+		emoji[i], names[i] = e.Emoji, e.Name
+	}
+	return &pairing{
+		result: PairingResult{
+			PairingID:  hex.EncodeToString(digest[:8]),
+			PeerID:     peer.id,
+			PeerPubkey: peer.pubkey,
+			PeerIP:     peerIP,
+			SAS:        fmt.Sprintf("%0*d", pairingSASDigits, code),
+			Emoji:      strings.Join(emoji, " "),
+			EmojiNames: names,
+		},
+		key: key,
+	}, nil
+}
+
+func newPairingParty(id, pubkey string) (*ecdh.PrivateKey, pairingParty, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, pairingParty{}, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, pairingParty{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return priv, pairingParty{id: id, pubkey: pubkey, ephemeral: priv.PublicKey().Bytes(), nonce: nonce}, nil
+}
+
+// ListenForPairing waits for PairWithPeer from another device on port and
+// returns the PairingResult JSON to show the user, who confirms it with
+// ConfirmPairing once both screens match.
+func ListenForPairing(id, pubkey, port, timeout string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in ListenForPairing: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	Logln("BBMTLog", "Listening for pairing...")
+	priv, self, err := newPairingParty(id, pubkey)
+	if err != nil {
+		return "", err
+	}
+
+	var mu sync.Mutex
+	var initiator *pairingParty
+	var commit []byte
+	var peerIP string
+	revealed := false
+	paired := make(chan *pairing, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /pair/commit", func(w http.ResponseWriter, r *http.Request) {
+		var req pairCommit
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		c, err := hex.DecodeString(req.Commit)
+		if err != nil || len(c) != sha256.Size || req.Pubkey == "" {
+			http.Error(w, "invalid commitment", http.StatusBadRequest)
+			return
+		}
+		clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+		mu.Lock()
+		defer mu.Unlock()
+		// one pairing per listen, a second device gets a mismatching SAS
+		// at most, not the pairing
+		if initiator != nil {
+			http.Error(w, "pairing in progress", http.StatusConflict)
+			return
+		}
+		initiator = &pairingParty{id: req.ID, pubkey: req.Pubkey}
+		commit, peerIP = c, clientIP
+		Logln("BBMTLog", "pairing commitment from", clientIP)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pairOffer{
+			ID:        self.id,
+			Pubkey:    self.pubkey,
+			Ephemeral: hex.EncodeToString(self.ephemeral),
+			Nonce:     hex.EncodeToString(self.nonce),
+		})
+	})
+	mux.HandleFunc("POST /pair/reveal", func(w http.ResponseWriter, r *http.Request) {
+		var req pairReveal
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		ephemeral, err1 := hex.DecodeString(req.Ephemeral)
+		nonce, err2 := hex.DecodeString(req.Nonce)
+
+		mu.Lock()
+		defer mu.Unlock()
+		clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if initiator == nil || revealed || clientIP != peerIP {
+			http.Error(w, "no pairing commitment", http.StatusConflict)
+			return
+		}
+		// the commitment is spent, the initiator cannot retry other keys
+		revealed = true
+		if err1 != nil || err2 != nil || subtle.ConstantTimeCompare(pairingCommitment(ephemeral, nonce), commit) != 1 {
+			http.Error(w, "commitment mismatch", http.StatusForbidden)
+			Logln("BBMTLog", "pairing commitment mismatch from", clientIP)
+			return
+		}
+		initiator.ephemeral, initiator.nonce = ephemeral, nonce
+		pr, err := completePairing(priv, *initiator, self, *initiator, peerIP)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		select {
+		case paired <- pr:
+		default:
+		}
+	})
+
+	if isPortInUse(port) {
+		Logln("BBMTLog", "Port", port, "is already in use. Stopping previous server...")
+		StopRelay()
+		time.Sleep(1 * time.Second)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		Logln("BBMTLog", "Error binding to port:", err)
+		return "", err
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			Logln("BBMTLog", "HTTP server error:", err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	tout, err := strconv.Atoi(timeout)
+	if err != nil {
+		tout = 30
+	}
+	select {
+	case pr := <-paired:
+		pairings.add(pr)
+		Logln("BBMTLog", "paired with", pr.result.PeerID, "pairing", pr.result.PairingID)
+		data, err := json.Marshal(pr.result)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal pairing: %w", err)
+		}
+		return string(data), nil
+	case <-time.After(time.Duration(tout) * time.Second):
+		return "", fmt.Errorf("timeout waiting for pairing")
+	}
+}
+
+// PairWithPeer pairs with the device running ListenForPairing at peerIP and
+// port, retrying until it is up or timeout seconds passed, and returns the
+// PairingResult JSON to show the user, who confirms it with ConfirmPairing
+// once both screens match.
+func PairWithPeer(id, pubkey, peerIP, port, timeout string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in PairWithPeer: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	tout, err := strconv.Atoi(timeout)
+	if err != nil {
+		tout = 30
+	}
+	deadline := time.Now().Add(time.Duration(tout) * time.Second)
+	url := "http://" + net.JoinHostPort(peerIP, port) + "/pair/"
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	priv, self, err := newPairingParty(id, pubkey)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(pairCommit{
+		ID:     self.id,
+		Pubkey: self.pubkey,
+		Commit: hex.EncodeToString(pairingCommitment(self.ephemeral, self.nonce)),
+	})
+
+	var offer pairOffer
+	for {
+		resp, err := client.Post(url+"commit", "application/json", bytes.NewReader(body))
+		if err == nil {
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", fmt.Errorf("peer refused pairing: %s %s", resp.Status, strings.TrimSpace(string(data)))
+			}
+			if err := json.Unmarshal(data, &offer); err != nil {
+				return "", fmt.Errorf("invalid pairing offer: %w", err)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timeout pairing with %s: %w", peerIP, err)
+		}
+		time.Sleep(time.Second)
+	}
+
+	ephemeral, err1 := hex.DecodeString(offer.Ephemeral)
+	nonce, err2 := hex.DecodeString(offer.Nonce)
+	if err1 != nil || err2 != nil || offer.Pubkey == "" {
+		return "", fmt.Errorf("invalid pairing offer")
+	}
+	responder := pairingParty{id: offer.ID, pubkey: offer.Pubkey, ephemeral: ephemeral, nonce: nonce}
+	pr, err := completePairing(priv, self, responder, responder, peerIP)
+	if err != nil {
+		return "", err
+	}
+
+	body, _ = json.Marshal(pairReveal{Ephemeral: hex.EncodeToString(self.ephemeral), Nonce: hex.EncodeToString(self.nonce)})
+	resp, err := client.Post(url+"reveal", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to complete pairing: %w", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("peer refused pairing: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	pairings.add(pr)
+	Logln("BBMTLog", "paired with", pr.result.PeerID, "pairing", pr.result.PairingID)
+	out, err := json.Marshal(pr.result)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal pairing: %w", err)
+	}
+	return string(out), nil
+}
+
+// ConfirmPairing records whether the user saw the same SAS on both devices.
+// A match pins the peer's ID and key for the ceremony, a mismatch discards
+// the pairing. Returns the PairingResult JSON.
+func ConfirmPairing(pairingID string, match bool) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in ConfirmPairing: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	pr, err := pairings.confirm(pairingID, match)
+	if err != nil {
+		return "", err
+	}
+	if match {
+		Logln("BBMTLog", "pairing", pairingID, "confirmed, pinned peer", pr.PeerID)
+	} else {
+		Logln("BBMTLog", "pairing", pairingID, "rejected")
+	}
+	data, err := json.Marshal(pr)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal pairing: %w", err)
+	}
+	return string(data), nil
+}
+
+// PinnedPeers returns the PairingResult JSON of the confirmed pairings.
+func PinnedPeers() (string, error) {
+	results := []PairingResult{}
+	for _, pr := range pairings.confirmed() {
+		results = append(results, pr.result)
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal pinned peers: %w", err)
+	}
+	return string(data), nil
+}
+
+// ResetPairings forgets every pairing, call it when the ceremony ends.
+func ResetPairings() (string, error) {
+	pairings.reset()
+	return "ok", nil
+}
+
+// pairingMAC authenticates data published to a pinned peer.
+func pairingMAC(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPairingMAC checks data against the MAC of any pinned peer.
+func verifyPairingMAC(data, macHex string) bool {
+	mac, err := hex.DecodeString(macHex)
+	if err != nil {
+		return false
+	}
+	for _, pr := range pairings.confirmed() {
+		expected, _ := hex.DecodeString(pairingMAC(pr.key, data))
+		if hmac.Equal(mac, expected) {
+			return true
+		}
+	}
+	return false
+}
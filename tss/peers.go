@@ -2,31 +2,186 @@ package tss
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-func ListenForPeers(id, pubkey, port, timeout, mode string) (string, error) {
-	Logln("BBMTLog", "Listening for peer...")
+// LAN discovery. For N parties, every device listens for peers and
+// discovers them on the same port, each expecting the other N-1: peers
+// advertised over mDNS first, then the given remote IPs, then the IPv4 /24.
+// A peer is identified by its ID and key, so the same peer reached on
+// several interfaces or over IPv6 counts once.
+
+// LANPeer is a peer found on the LAN.
+type LANPeer struct {
+	IP      string   `json:"ip"`
+	ID      string   `json:"id,omitempty"`
+	Pubkey  string   `json:"pubkey"`
+	Port    int      `json:"port,omitempty"`
+	IPs     []string `json:"ips"`               // every address it was seen on
+	LocalIP string   `json:"localIP,omitempty"` // this device, as the peer reached it
+	Data    string   `json:"data,omitempty"`    // sent along to PublishDataToPeers
+
+	legacy string // duo and trio payload
+}
 
-	// Channel to capture the peer IP (buffered to prevent deadlocks)
-	peerFound := make(chan string, 1)
-	stopServer := make(chan struct{})
+// lanPeers collects distinct peers, skipping this device.
+type lanPeers struct {
+	mu    sync.Mutex
+	self  string // own key
+	peers []LANPeer
+}
+
+// add adds peer, or its address to a known peer, and returns the number of
+// peers.
+func (s *lanPeers) add(peer LANPeer) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.self != "" && peer.Pubkey == s.self {
+		return len(s.peers)
+	}
+	for i := range s.peers {
+		if s.peers[i].Pubkey == peer.Pubkey && s.peers[i].ID == peer.ID {
+			if !Contains(s.peers[i].IPs, peer.IP) {
+				s.peers[i].IPs = append(s.peers[i].IPs, peer.IP)
+			}
+			return len(s.peers)
+		}
+	}
+	peer.IPs = []string{peer.IP}
+	s.peers = append(s.peers, peer)
+	return len(s.peers)
+}
+
+func (s *lanPeers) list() []LANPeer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LANPeer{}, s.peers...)
+}
+
+func (s *lanPeers) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.peers)
+}
+
+// legacyPeerPayload joins the duo and trio payloads of peers with '|'.
+func legacyPeerPayload(peers []LANPeer) string {
+	payloads := make([]string, len(peers))
+	for i, peer := range peers {
+		payloads[i] = peer.legacy
+	}
+	return strings.Join(payloads, "|")
+}
+
+func marshalPeers(peers []LANPeer) (string, error) {
+	data, err := json.Marshal(peers)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal peers: %w", err)
+	}
+	return string(data), nil
+}
 
-	// Determine listen mode: default duo (expect 1 peer). If mode == "trio", expect 2 peers
-	expectedPeers := 1
+// expectedPeerCount reads the mode of the duo and trio API: duo (1 peer),
+// trio (2 peers) or a peer count.
+func expectedPeerCount(mode string) int {
 	if strings.EqualFold(mode, "trio") {
-		expectedPeers = 2
+		return 2
+	}
+	if n, err := strconv.Atoi(mode); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+func peerTimeout(timeout string) int {
+	tout, err := strconv.Atoi(timeout)
+	if err != nil {
+		return 30
+	}
+	return tout
+}
+
+// hostOf strips the port of an address, if any.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// handshakeEntry is one ip@id@pubkey of a handshake payload.
+type handshakeEntry struct {
+	ip, id, pubkey string
+}
+
+// parseHandshake parses the payload of a listening peer,
+// peer@id@pubkey,self@id@pubkey.
+func parseHandshake(payload string) (peer, self handshakeEntry, ok bool) {
+	entries := strings.Split(strings.TrimSpace(payload), ",")
+	if len(entries) != 2 {
+		return peer, self, false
+	}
+	parsed := make([]handshakeEntry, 2)
+	for i, entry := range entries {
+		fields := strings.Split(entry, "@")
+		if len(fields) != 3 || fields[2] == "" {
+			return peer, self, false
+		}
+		parsed[i] = handshakeEntry{ip: hostOf(fields[0]), id: fields[1], pubkey: fields[2]}
+	}
+	return parsed[0], parsed[1], true
+}
+
+// ListenForPeers waits for the peers of mode, duo (1), trio (2) or a count,
+// and returns their payloads ip@id@pubkey,ip@id@pubkey joined by '|'.
+// ListenForLANPeers returns them as JSON.
+func ListenForPeers(id, pubkey, port, timeout, mode string) (string, error) {
+	peers, err := listenForPeers(id, pubkey, port, peerTimeout(timeout), expectedPeerCount(mode))
+	if err != nil {
+		return "", err
+	}
+	return legacyPeerPayload(peers), nil
+}
+
+// ListenForLANPeers waits until expected peers connected and returns them
+// as a JSON array of LANPeer.
+func ListenForLANPeers(id, pubkey, port, timeout string, expected int) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in ListenForLANPeers: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if expected < 1 {
+		return "", fmt.Errorf("expected peers must be positive, got %d", expected)
 	}
-	// Track unique peer IPs and their payloads for trio mode
-	peerIPs := make(map[string]struct{})
-	ipToPayload := make(map[string]string)
-	collectedIPs := make([]string, 0, expectedPeers)
+	peers, err := listenForPeers(id, pubkey, port, peerTimeout(timeout), expected)
+	if err != nil {
+		return "", err
+	}
+	return marshalPeers(peers)
+}
+
+func listenForPeers(id, pubkey, port string, tout, expected int) ([]LANPeer, error) {
+	Logln("BBMTLog", "Listening for", expected, "peers...")
+
+	// Signalled once every expected peer connected (buffered to prevent deadlocks)
+	peersFound := make(chan struct{}, 1)
+	stopServer := make(chan struct{})
+	peers := &lanPeers{self: pubkey}
+	portNum, _ := strconv.Atoi(port)
 
 	// Ensure no existing server is running on this port
 	if isPortInUse(port) {
@@ -53,45 +208,47 @@ func ListenForPeers(id, pubkey, port, timeout, mode string) (string, error) {
 
 		Logf("BBMTLog Got a peer connection from %s\n", clientIP)
 
-		srcIP := r.URL.Query().Get("src")
+		// src, the peer's own idea of its address, may be unknown to it
 		dstIP := r.URL.Query().Get("dst")
 		srcId := r.URL.Query().Get("id")
 		srcPubkey := r.URL.Query().Get("pubkey")
 
-		if srcIP != "" && dstIP != "" && srcPubkey != "" {
+		// Once peers are paired, only they are answered
+		if srcPubkey != "" && !pairings.admits(srcPubkey) {
+			Logln("BBMTLog", "refused unpaired peer", clientIP)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if dstIP != "" && srcPubkey != "" {
 			go func(remoteAddr string) {
 				client := http.Client{Timeout: 2 * time.Second}
 				srcIPParsed, _, _ := net.SplitHostPort(remoteAddr)
-				url := "http://" + srcIPParsed + ":" + port + "/?src=" + dstIP + "&dst=" + srcIPParsed + "&id=" + id + "&pubkey=" + pubkey
-				Logln("BBMTLog", "Sending callback to:", url)
-				_, err := client.Get(url)
+				query := url.Values{"src": {dstIP}, "dst": {srcIPParsed}, "id": {id}, "pubkey": {pubkey}}
+				callback := "http://" + net.JoinHostPort(srcIPParsed, port) + "/?" + query.Encode()
+				Logln("BBMTLog", "Sending callback to:", callback)
+				resp, err := client.Get(callback)
 				if err != nil {
 					Logln("BBMTLog", "Error in callback:", err)
+					return
 				}
+				resp.Body.Close()
 			}(r.RemoteAddr)
 
-			if expectedPeers == 1 {
-				// Duo mode: keep existing payload format, try non-blocking send
+			// The same peer may connect from several interfaces, it counts once
+			count := peers.add(LANPeer{
+				IP:      clientIP,
+				ID:      srcId,
+				Pubkey:  srcPubkey,
+				Port:    portNum,
+				LocalIP: hostOf(dstIP),
+				legacy:  clientIP + "@" + srcId + "@" + srcPubkey + "," + dstIP + "@" + id + "@" + pubkey,
+			})
+			if count >= expected {
 				select {
-				case peerFound <- clientIP + "@" + srcId + "@" + srcPubkey + "," + dstIP + "@" + id + "@" + pubkey:
+				case peersFound <- struct{}{}:
 				default:
 				}
-			} else {
-				// Trio mode: collect unique client IPs and emit two payloads joined by '|'
-				if _, exists := peerIPs[clientIP]; !exists {
-					peerIPs[clientIP] = struct{}{}
-					payload := clientIP + "@" + srcId + "@" + srcPubkey + "," + dstIP + "@" + id + "@" + pubkey
-					ipToPayload[clientIP] = payload
-					collectedIPs = append(collectedIPs, clientIP)
-					if len(collectedIPs) >= expectedPeers {
-						// Build pipe-separated payloads in order of collection
-						combined := ipToPayload[collectedIPs[0]] + "|" + ipToPayload[collectedIPs[1]]
-						select {
-						case peerFound <- combined:
-						default:
-						}
-					}
-				}
 			}
 		}
 
@@ -100,44 +257,44 @@ func ListenForPeers(id, pubkey, port, timeout, mode string) (string, error) {
 	})
 
 	// Create and start server
-	server := &http.Server{Addr: "0.0.0.0:" + port, Handler: mux}
+	server := &http.Server{Addr: ":" + port, Handler: mux}
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		Logln("BBMTLog", "Error binding to port:", err)
-		return "", err
+		return nil, err
 	}
 
 	// Start HTTP server
 	go func() {
-		Logln("BBMTLog", "Waiting for peer connection on port:", port, ", timeout:", timeout)
+		Logln("BBMTLog", "Waiting for peer connection on port:", port, ", timeout:", tout)
 		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			Logln("BBMTLog", "HTTP server error:", err)
 		}
 	}()
 
-	// Convert timeout to int
-	tout, err := strconv.Atoi(timeout)
+	// Advertise over mDNS while waiting, peers not finding it scan the subnet
+	advertiser, err := advertiseService(DiscoveryRolePeer, id, pubkey, port, currentDiscoveryHint())
 	if err != nil {
-		tout = 30
+		Logln("BBMTLog", "not advertised over mDNS:", err)
 	}
+	defer advertiser.stop()
 
 	select {
-	case peerIPs := <-peerFound:
-		Logln("BBMTLog", "Peer detected, shutting down server...")
-		Logln("BBMTLog", "Forcefully stopping server...")
+	case <-peersFound:
+		Logln("BBMTLog", "Peers detected, shutting down server...")
 		// signal handler to stop accepting new work
 		close(stopServer)
 		time.Sleep(2 * time.Second)
 		listener.Close()
 		server.Close()
-		return peerIPs, nil
+		return peers.list(), nil
 	case <-time.After(time.Duration(tout) * time.Second):
 		Logln("BBMTLog", "Timeout reached, shutting down server...")
 		// signal handler to stop accepting new work
 		close(stopServer)
 		listener.Close()
 		server.Close()
-		return "", fmt.Errorf("timeout waiting for peer connection")
+		return nil, fmt.Errorf("timeout waiting for peer connection, %d of %d peers connected", peers.count(), expected)
 	}
 }
 
@@ -151,22 +308,41 @@ func isPortInUse(port string) bool {
 	return true
 }
 
+// DiscoverPeers finds the peers of mode, duo (1), trio (2) or a count, and
+// returns their payloads ip@id@pubkey,ip@id@pubkey joined by '|'.
+// DiscoverLANPeers returns them as JSON.
 func DiscoverPeers(id, pubkey, localIP, remoteIPsCSV, port, timeout, mode string) (string, error) {
-	if localIP == "" {
-		return "", fmt.Errorf("no local IP detected, skipping peer discovery")
+	peers, err := discoverPeers(id, pubkey, localIP, remoteIPsCSV, port, peerTimeout(timeout), expectedPeerCount(mode))
+	if err != nil {
+		return "", err
 	}
+	return legacyPeerPayload(peers), nil
+}
 
-	baseIP := localIP[:strings.LastIndex(localIP, ".")+1]
-	peerFound := make(chan string)
-	// Determine expected peers based on mode (duo=1, trio=2)
-	expectedPeers := 1
-	if strings.EqualFold(mode, "trio") {
-		expectedPeers = 2
+// DiscoverLANPeers finds expected listening peers and returns them as a JSON
+// array of LANPeer. remoteIPsCSV are addresses to try besides mDNS and the
+// subnet of localIP, which may be IPv6.
+func DiscoverLANPeers(id, pubkey, localIP, remoteIPsCSV, port, timeout string, expected int) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in DiscoverLANPeers: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if expected < 1 {
+		return "", fmt.Errorf("expected peers must be positive, got %d", expected)
 	}
-	tout, err := strconv.Atoi(timeout)
+	peers, err := discoverPeers(id, pubkey, localIP, remoteIPsCSV, port, peerTimeout(timeout), expected)
 	if err != nil {
-		tout = 30
+		return "", err
 	}
+	return marshalPeers(peers)
+}
+
+func discoverPeers(id, pubkey, localIP, remoteIPsCSV, port string, tout, expected int) ([]LANPeer, error) {
 	if tout < 5 {
 		tout = 5
 	}
@@ -175,79 +351,149 @@ func DiscoverPeers(id, pubkey, localIP, remoteIPsCSV, port, timeout, mode string
 	defer cancel()
 
 	client := &http.Client{Timeout: 2000 * time.Millisecond}
-
-	// Trio mode aggregation state
-	foundIPs := make(map[string]struct{})
-	foundPayloads := make([]string, 0, expectedPeers)
-
-	// Function to check a given IP
-	checkPeer := func(ip string) {
+	peers := &lanPeers{self: pubkey}
+	// Signalled once every expected peer answered, buffered as the same peer
+	// may answer on several addresses
+	peersFound := make(chan struct{}, 1)
+
+	// Function to check a given address, and the peer's key against its
+	// advertised fingerprint if set. Reports whether a peer answered.
+	checkPeer := func(addr, fingerprint string) bool {
 		select {
 		case <-ctx.Done():
-			return
+			return false
 		default:
-			url := "http://" + ip + "/?src=" + localIP + "&dst=" + ip + "&id=" + id + "&pubkey=" + pubkey
-			resp, err := client.Get(url)
-			if err == nil && resp.StatusCode == http.StatusOK {
-				Logf("Peer discovered at: %s\n", ip)
-				bodyBytes, err := io.ReadAll(resp.Body)
-				if err == nil {
-					payload := string(bodyBytes)
-					if expectedPeers == 1 {
-						// Duo: return immediately
-						peerFound <- payload
-						cancel()
-					} else {
-						// Trio: aggregate two distinct IPs
-						host := ip
-						if idx := strings.LastIndex(ip, ":"); idx != -1 {
-							host = ip[:idx]
-						}
-						if _, ok := foundIPs[host]; !ok {
-							foundIPs[host] = struct{}{}
-							foundPayloads = append(foundPayloads, payload)
-							if len(foundPayloads) >= expectedPeers {
-								combined := strings.Join(foundPayloads, "|")
-								peerFound <- combined
-								cancel()
-							}
-						}
+		}
+		query := url.Values{"src": {localIP}, "dst": {addr}, "id": {id}, "pubkey": {pubkey}}
+		resp, err := client.Get("http://" + addr + "/?" + query.Encode())
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return false
+		}
+		payload := string(bodyBytes)
+		peer, self, ok := parseHandshake(payload)
+		if !ok || peer.pubkey == pubkey {
+			return false
+		}
+		if !pairings.admits(peer.pubkey) {
+			Logln("BBMTLog", "peer at", addr, "is not paired, ignored")
+			return false
+		}
+		if fingerprint != "" {
+			if fp, err := pubKeyFingerprint(peer.pubkey); err != nil || fp != fingerprint {
+				Logln("BBMTLog", "peer at", addr, "does not match its advertised key, ignored")
+				return false
+			}
+		}
+		Logf("Peer discovered at: %s\n", addr)
+		host, peerPort, _ := net.SplitHostPort(addr)
+		portNum, _ := strconv.Atoi(peerPort)
+		count := peers.add(LANPeer{
+			IP:      host,
+			ID:      peer.id,
+			Pubkey:  peer.pubkey,
+			Port:    portNum,
+			LocalIP: self.ip,
+			legacy:  payload,
+		})
+		if count >= expected {
+			select {
+			case peersFound <- struct{}{}:
+			default:
+			}
+			cancel()
+		}
+		return true
+	}
+
+	// First, check the peers advertised over mDNS, on the first of their
+	// addresses that answers
+	if services := browsePeers(ctx, id, pubkey, expected); len(services) > 0 {
+		for _, service := range services {
+			go func(service DiscoveredService) {
+				for _, ip := range service.IPs {
+					if checkPeer(net.JoinHostPort(ip, strconv.Itoa(service.Port)), service.Fingerprint) {
+						return
 					}
 				}
-			}
+			}(service)
+		}
+		select {
+		case <-peersFound:
+			return peers.list(), nil
+		case <-time.After(client.Timeout + time.Second):
+			Logln("BBMTLog", "mDNS found", peers.count(), "of", expected, "peers, scanning subnet")
 		}
 	}
 
-	// First, check any provided remote IPs (comma-separated), skipping self
+	// Then check any provided remote IPs (comma-separated), skipping self
 	if strings.TrimSpace(remoteIPsCSV) != "" {
 		for _, rip := range strings.Split(remoteIPsCSV, ",") {
 			rip = strings.TrimSpace(rip)
 			if rip != "" && rip != localIP {
-				checkPeer(fmt.Sprintf("%s:%s", rip, port))
+				checkPeer(net.JoinHostPort(rip, port), "")
 			}
 		}
 	}
 
-	// Scan the local subnet
-	for i := 1; i <= 254; i++ {
-		targetIP := fmt.Sprintf("%s%d", baseIP, i)
-		if targetIP == localIP {
-			Logln("BBMTLog", "skip self peer")
-			continue
+	// Scan the local subnet, IPv4 only
+	if ip := net.ParseIP(localIP); ip != nil && ip.To4() != nil {
+		baseIP := localIP[:strings.LastIndex(localIP, ".")+1]
+		for i := 1; i <= 254; i++ {
+			targetIP := fmt.Sprintf("%s%d", baseIP, i)
+			if ctx.Err() != nil {
+				break
+			}
+			if targetIP == localIP {
+				Logln("BBMTLog", "skip self peer")
+				continue
+			}
+			go checkPeer(net.JoinHostPort(targetIP, port), "")
+			time.Sleep(10 * time.Millisecond)
 		}
-		go checkPeer(fmt.Sprintf("%s:%s", targetIP, port))
-		time.Sleep(10 * time.Millisecond)
+	} else {
+		Logln("BBMTLog", "no local IPv4 address, skipping subnet scan")
 	}
 
 	select {
-	case peerData := <-peerFound:
-		return peerData, nil
+	case <-peersFound:
+		return peers.list(), nil
 	case <-ctx.Done():
+		select {
+		case <-peersFound:
+			return peers.list(), nil
+		default:
+		}
 		if ctx.Err() == context.DeadlineExceeded {
-			return "", fmt.Errorf("peer discovery timed out after %d seconds", tout)
+			return nil, fmt.Errorf("peer discovery timed out after %d seconds, found %d of %d peers", tout, peers.count(), expected)
+		}
+		return nil, fmt.Errorf("peer discovery stopped")
+	}
+}
+
+// browsePeers looks for up to want peers advertised over mDNS for a few
+// seconds, skipping this device and peers of other sessions.
+func browsePeers(ctx context.Context, id, pubkey string, want int) []DiscoveredService {
+	ownFingerprint, _ := pubKeyFingerprint(pubkey)
+	hint := currentDiscoveryHint()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	services, err := browseServices(ctx, want, func(s DiscoveredService) bool {
+		if s.Role != DiscoveryRolePeer || (s.ID == id && s.Fingerprint == ownFingerprint) {
+			return false
 		}
-		return "", fmt.Errorf("peer discovery stopped")
+		return hint == "" || s.SessionHint == hint
+	})
+	if err != nil {
+		Logln("BBMTLog", "mDNS browse failed:", err)
+		return nil
 	}
+	Logln("BBMTLog", "mDNS found", len(services), "peers")
+	return services
 }
 
 func FetchData(url, decKey, data string) (string, error) {
@@ -272,6 +518,10 @@ func FetchData(url, decKey, data string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to read response body: %w", err)
 	}
+	// Once peers are paired, only accept data of a pinned peer
+	if pairings.active() && !verifyPairingMAC(strings.TrimSpace(string(bodyBytes)), resp.Header.Get(pairingMACHeader)) {
+		return "", fmt.Errorf("data not published by a paired peer")
+	}
 	decryptedData, err := EciesDecrypt(string(bodyBytes), decKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to decrypt chaincode: %w", err)
@@ -280,105 +530,153 @@ func FetchData(url, decKey, data string) (string, error) {
 	return decryptedData, nil
 }
 
+// PublishData serves data, encrypted to enckey, to the peers of mode, duo
+// (1), trio (2) or a count, and returns their requests joined by '|'. With
+// several peers enckey lists their keys, comma-separated, and each gets the
+// data under its own. PublishDataToPeers returns the peers as JSON.
 func PublishData(port, timeout, enckey, data, mode string) (string, error) {
-	Logln("BBMTLog", "publishing data...")
-	published := make(chan string)
-	expected := 1
-	if strings.EqualFold(mode, "trio") {
-		expected = 2
+	expected := expectedPeerCount(mode)
+	peers, err := publishData(port, peerTimeout(timeout), enckey, data, expected, expected > 1)
+	if err != nil {
+		return "", err
 	}
-	// Track distinct client IPs and their payloads
-	clientIPs := make(map[string]struct{})
-	payloads := make([]string, 0, expected)
+	return legacyPeerPayload(peers), nil
+}
+
+// PublishDataToPeers serves data to expected peers, each encrypted to its
+// own key out of enckeysCSV, and returns the peers served as a JSON array of
+// LANPeer, with what they sent along as their data.
+func PublishDataToPeers(port, timeout, enckeysCSV, data string, expected int) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in PublishDataToPeers: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	if expected < 1 {
+		return "", fmt.Errorf("expected peers must be positive, got %d", expected)
+	}
+	peers, err := publishData(port, peerTimeout(timeout), enckeysCSV, data, expected, true)
+	if err != nil {
+		return "", err
+	}
+	return marshalPeers(peers)
+}
+
+// publishData serves data to expected peers, under enckey or, perPeerKeys,
+// under the listed key each peer presents.
+func publishData(port string, tout int, enckey, data string, expected int, perPeerKeys bool) ([]LANPeer, error) {
+	Logln("BBMTLog", "publishing data to", expected, "peers...")
+	allowed := map[string]bool{}
+	for _, k := range strings.Split(enckey, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			allowed[k] = true
+		}
+	}
+	served := &lanPeers{}
+	servedAll := make(chan struct{}, 1)
+	failed := make(chan error, 1)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Determine encryption key per request
+		// Determine encryption key per request: a single peer gets the data
+		// under enckey, several peers each under their own listed key
+		requestPub := r.URL.Query().Get("pubkey")
 		selectedPub := enckey
-		if expected == 2 { // trio mode
-			// enckey CSV provided in function parameter
-			allowed := map[string]struct{}{}
-			for _, k := range strings.Split(enckey, ",") {
-				k = strings.TrimSpace(k)
-				if k != "" {
-					allowed[k] = struct{}{}
-				}
-			}
-			// read client-provided pubkey from query
-			qPub := r.URL.Query().Get("pubkey")
-			if _, ok := allowed[qPub]; !ok {
+		if perPeerKeys {
+			if !allowed[requestPub] {
 				// Not an expected key; ignore this request
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			selectedPub = qPub
+			selectedPub = requestPub
+		}
+
+		// Once peers are paired, only serve them, under their pinned key
+		var paired *pairing
+		if pairings.active() {
+			pr, ok := pairings.pinned(requestPub)
+			if !ok || pr.result.PeerPubkey != selectedPub {
+				Logln("BBMTLog", "refused unpaired peer", r.RemoteAddr)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			paired = pr
 		}
 
 		encryptedData, err := EciesEncrypt(data, selectedPub)
 		if err != nil {
 			http.Error(w, "error", http.StatusInternalServerError)
 			Logln("BBMTLog", "error publishing:", err)
-			published <- "error"
+			select {
+			case failed <- err:
+			default:
+			}
 			return
 		}
+		if paired != nil {
+			w.Header().Set(pairingMACHeader, pairingMAC(paired.key, encryptedData))
+		}
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, encryptedData)
 
-		if expected == 1 {
-			// duo: return first query observed
-			published <- r.URL.RawQuery
-			return
-		}
-		// trio: collect distinct client IPs and emit when 2 unique are served
+		// The same peer may connect from several interfaces, it counts once
 		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
 			clientIP = r.RemoteAddr
 		}
-		if _, ok := clientIPs[clientIP]; !ok {
-			clientIPs[clientIP] = struct{}{}
-			payloads = append(payloads, r.URL.RawQuery)
-			if len(payloads) >= expected {
-				combined := strings.Join(payloads, "|")
-				published <- combined
+		count := served.add(LANPeer{
+			IP:     clientIP,
+			Pubkey: requestPub,
+			Data:   r.URL.Query().Get("data"),
+			legacy: r.URL.RawQuery,
+		})
+		if count >= expected {
+			select {
+			case servedAll <- struct{}{}:
+			default:
 			}
 		}
 	})
 
-	if server != nil {
+	if relayServer != nil {
 		StopRelay()
 	}
 
 	// Create and start server
-	server := &http.Server{Addr: "0.0.0.0:" + port, Handler: mux}
+	server := &http.Server{Addr: ":" + port, Handler: mux}
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		Logln("BBMTLog", "Error binding to port:", err)
-		return "", err
+		return nil, err
 	}
 
 	// Start HTTP server
 	go func() {
-		Logln("BBMTLog", "Waiting for peer connection on port:", port, ", timeout:", timeout)
+		Logln("BBMTLog", "Waiting for peer connection on port:", port, ", timeout:", tout)
 		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			Logln("BBMTLog", "HTTP server error:", err)
 		}
 	}()
-
-	tout, err := strconv.Atoi(timeout)
-	if err != nil {
-		tout = 30
-	}
+	// let the last response go out before closing
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
 
 	select {
-	case data := <-published:
-		Logln("BBMTLog", "published. received:", data)
-		time.Sleep(1000)
-		listener.Close()
-		server.Close()
-		return data, nil
+	case <-servedAll:
+		peers := served.list()
+		Logln("BBMTLog", "published. received:", legacyPeerPayload(peers))
+		return peers, nil
+	case err := <-failed:
+		return nil, fmt.Errorf("failed to publish data: %w", err)
 	case <-time.After(time.Duration(tout) * time.Second):
 		Logln("BBMTLog", "Timeout reached, shutting down server...")
-		listener.Close()
-		server.Close()
-		return "", fmt.Errorf("timeout waiting for peer connection")
+		return nil, fmt.Errorf("timeout waiting for peer connection, %d of %d peers served", served.count(), expected)
 	}
 }
@@ -0,0 +1,325 @@
+package tss
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// clientFrom is an HTTP client connecting from ip, like a device with
+// several interfaces.
+func clientFrom(ip string) *http.Client {
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}, Timeout: time.Second}
+	return &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}, Timeout: 2 * time.Second}
+}
+
+// getFrom gets url from ip until the server answers.
+func getFrom(t *testing.T, ip, url string) (int, string) {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		resp, err := clientFrom(ip).Get(url)
+		if err == nil {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("%s not reachable", url)
+	return 0, ""
+}
+
+// testListener answers peer discovery at ip:port as the peer of pubkey.
+func testListener(t *testing.T, ip, port, pubkey string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, r.URL.Query().Get("dst")+"@peer-"+pubkey+"@"+pubkey+","+hostOf(r.RemoteAddr)+"@"+r.URL.Query().Get("id")+"@"+r.URL.Query().Get("pubkey"))
+	}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+}
+
+func TestHostOf(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.168.1.5:55055":     "192.168.1.5",
+		"192.168.1.5":           "192.168.1.5",
+		"[fe80::1]:55055":       "fe80::1",
+		"[fe80::1%wlan0]:55055": "fe80::1%wlan0",
+		"[2001:db8::5]":         "2001:db8::5",
+		"2001:db8::5":           "2001:db8::5",
+		"[::1]:80":              "::1",
+	} {
+		if got := hostOf(addr); got != want {
+			t.Fatalf("hostOf(%s) = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestParseHandshake(t *testing.T) {
+	peer, self, ok := parseHandshake("[fe80::1%wlan0]:55055@peer@pk1,2001:db8::5@self@pk2\n")
+	if !ok || peer != (handshakeEntry{ip: "fe80::1%wlan0", id: "peer", pubkey: "pk1"}) || self != (handshakeEntry{ip: "2001:db8::5", id: "self", pubkey: "pk2"}) {
+		t.Fatalf("IPv6 handshake: %+v %+v %v", peer, self, ok)
+	}
+	peer, self, ok = parseHandshake("192.168.1.5@@pk1,192.168.1.6@self@pk2")
+	if !ok || peer.ip != "192.168.1.5" || peer.id != "" || self.pubkey != "pk2" {
+		t.Fatalf("IPv4 handshake: %+v %+v %v", peer, self, ok)
+	}
+	for _, payload := range []string{"", "192.168.1.5@peer@pk1", "a@b@c,d@e@f,g@h@i", "a@b@,d@e@f", "a@b,d@e@f", "a@b@c@d,e@f@g"} {
+		if _, _, ok := parseHandshake(payload); ok {
+			t.Fatalf("parsed %q", payload)
+		}
+	}
+}
+
+func TestLANPeersAdd(t *testing.T) {
+	peers := &lanPeers{self: "own"}
+	for _, peer := range []LANPeer{
+		{IP: "192.168.1.5", ID: "a", Pubkey: "pk1"},
+		{IP: "fe80::5", ID: "a", Pubkey: "pk1"},
+		{IP: "192.168.1.5", ID: "a", Pubkey: "pk1"},
+		{IP: "192.168.1.6", ID: "b", Pubkey: "pk2"},
+		{IP: "192.168.1.7", ID: "c", Pubkey: "own"},
+		{IP: "192.168.1.8", ID: "d", Pubkey: "pk1"},
+	} {
+		peers.add(peer)
+	}
+	list := peers.list()
+	if len(list) != 3 || peers.count() != 3 {
+		t.Fatalf("peers %+v", list)
+	}
+	if ips := list[0].IPs; len(ips) != 2 || ips[0] != "192.168.1.5" || ips[1] != "fe80::5" {
+		t.Fatalf("addresses of a peer on two interfaces: %v", ips)
+	}
+	// the same key under another ID is another peer
+	if list[2].ID != "d" || len(list[2].IPs) != 1 {
+		t.Fatalf("peers %+v", list)
+	}
+}
+
+func TestListenForLANPeers(t *testing.T) {
+	port := freePort(t)
+	type result struct {
+		peers string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		peers, err := ListenForLANPeers("listener", "listener-key", port, "10", 2)
+		done <- result{peers, err}
+	}()
+
+	knock := func(from, pubkey string) {
+		query := url.Values{"dst": {"127.0.0.1"}, "id": {"peer-" + pubkey}, "pubkey": {pubkey}}
+		status, body := getFrom(t, from, "http://127.0.0.1:"+port+"/?"+query.Encode())
+		if status != http.StatusOK || !strings.HasSuffix(strings.TrimSpace(body), "@peer-"+pubkey+"@"+pubkey) {
+			t.Fatalf("knock of %s: %d %s", pubkey, status, body)
+		}
+	}
+	// the first peer knocks from two interfaces and counts once
+	knock("127.0.0.2", "pk1")
+	knock("127.0.0.3", "pk1")
+	select {
+	case res := <-done:
+		t.Fatalf("listener done with one peer: %+v", res)
+	case <-time.After(200 * time.Millisecond):
+	}
+	knock("127.0.0.4", "pk2")
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	var peers []LANPeer
+	if err := json.Unmarshal([]byte(res.peers), &peers); err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || peers[0].Pubkey != "pk1" || peers[1].Pubkey != "pk2" || peers[0].LocalIP != "127.0.0.1" {
+		t.Fatalf("peers %+v", peers)
+	}
+	if ips := peers[0].IPs; len(ips) != 2 || ips[0] != "127.0.0.2" || ips[1] != "127.0.0.3" {
+		t.Fatalf("addresses of pk1: %v", ips)
+	}
+}
+
+func TestDiscoverLANPeers(t *testing.T) {
+	port := freePort(t)
+	// pk1 answers on two addresses, one of them IPv6
+	testListener(t, "127.0.0.2", port, "pk1")
+	testListener(t, "::1", port, "pk1")
+	testListener(t, "127.0.0.3", port, "pk2")
+	testListener(t, "127.0.0.4", port, "pk3")
+	testListener(t, "127.0.0.5", port, "discoverer-key")
+
+	peersJSON, err := DiscoverLANPeers("discoverer", "discoverer-key", "", "127.0.0.5,127.0.0.2,::1,127.0.0.3,127.0.0.4", port, "5", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peers []LANPeer
+	if err := json.Unmarshal([]byte(peersJSON), &peers); err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 3 || peers[0].Pubkey != "pk1" || peers[1].Pubkey != "pk2" || peers[2].Pubkey != "pk3" {
+		t.Fatalf("peers %+v", peers)
+	}
+	if ips := peers[0].IPs; len(ips) != 2 || ips[0] != "127.0.0.2" || ips[1] != "::1" {
+		t.Fatalf("addresses of pk1: %v", ips)
+	}
+	if peers[2].Port != mustAtoi(t, port) || peers[2].LocalIP == "" {
+		t.Fatalf("peer %+v", peers[2])
+	}
+
+	if _, err := DiscoverLANPeers("discoverer", "discoverer-key", "", "127.0.0.2", port, "5", 2); err == nil || !strings.Contains(err.Error(), "found 1 of 2") {
+		t.Fatalf("discovery of a missing peer: %v", err)
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPublishDataToPeers(t *testing.T) {
+	port := freePort(t)
+	keys := make([]map[string]string, 2)
+	for i := range keys {
+		keyPair, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal([]byte(keyPair), &keys[i])
+	}
+	type result struct {
+		peers string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		peers, err := PublishDataToPeers(port, "10", keys[0]["publicKey"]+","+keys[1]["publicKey"], "chaincode", 2)
+		done <- result{peers, err}
+	}()
+
+	fetch := func(from string, key map[string]string, data string) {
+		query := url.Values{"data": {data}, "pubkey": {key["publicKey"]}}
+		status, body := getFrom(t, from, "http://127.0.0.1:"+port+"/?"+query.Encode())
+		if status != http.StatusOK {
+			t.Fatalf("fetch: %d %s", status, body)
+		}
+		if decrypted, err := EciesDecrypt(strings.TrimSpace(body), key["privateKey"]); err != nil || decrypted != "chaincode" {
+			t.Fatalf("published data %q: %v", decrypted, err)
+		}
+	}
+	// keys that were not listed get nothing
+	query := url.Values{"pubkey": {"02" + strings.Repeat("11", 32)}}
+	if status, _ := getFrom(t, "127.0.0.1", "http://127.0.0.1:"+port+"/?"+query.Encode()); status != http.StatusUnauthorized {
+		t.Fatalf("unlisted key: %d", status)
+	}
+	fetch("127.0.0.2", keys[0], "from-1")
+	fetch("127.0.0.3", keys[0], "from-1")
+	fetch("127.0.0.4", keys[1], "from-2")
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	var peers []LANPeer
+	if err := json.Unmarshal([]byte(res.peers), &peers); err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || peers[0].Data != "from-1" || peers[1].Data != "from-2" || len(peers[0].IPs) != 2 {
+		t.Fatalf("peers %+v", peers)
+	}
+}
+
+// pinTestPeer confirms a pairing with the peer of pubkey.
+func pinTestPeer(t *testing.T, pubkey string) {
+	t.Helper()
+	pairings.add(&pairing{result: PairingResult{PairingID: "pairing-" + pubkey, PeerPubkey: pubkey, Confirmed: true}})
+	t.Cleanup(pairings.reset)
+}
+
+func TestListenForPeersRefusesUnpaired(t *testing.T) {
+	pinTestPeer(t, "pinned")
+	port := freePort(t)
+	type result struct {
+		peers []LANPeer
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		peers, err := listenForPeers("listener", "listener-key", port, 10, 1)
+		done <- result{peers, err}
+	}()
+
+	knock := func(pubkey string) int {
+		query := url.Values{"dst": {"127.0.0.1"}, "id": {"peer"}, "pubkey": {pubkey}}
+		for i := 0; i < 50; i++ {
+			resp, err := http.Get("http://127.0.0.1:" + port + "/?" + query.Encode())
+			if err == nil {
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatal("listener not reachable")
+		return 0
+	}
+	if status := knock("unpinned"); status != http.StatusUnauthorized {
+		t.Fatalf("unpaired peer: %d", status)
+	}
+	if status := knock("pinned"); status != http.StatusOK {
+		t.Fatalf("paired peer: %d", status)
+	}
+	res := <-done
+	if res.err != nil || len(res.peers) != 1 || res.peers[0].Pubkey != "pinned" {
+		t.Fatalf("peers %+v: %v", res.peers, res.err)
+	}
+}
+
+func TestDiscoverPeersIgnoresUnpaired(t *testing.T) {
+	// a listener answering with its key
+	listener := func(pubkey string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "127.0.0.1@peer@"+pubkey+",127.0.0.1@discoverer@discoverer-key")
+		}))
+		t.Cleanup(server.Close)
+		return server.URL[strings.LastIndex(server.URL, ":")+1:]
+	}
+	pinTestPeer(t, "pinned")
+
+	_, err := discoverPeers("discoverer", "discoverer-key", "", "127.0.0.1", listener("unpinned"), 5, 1)
+	if err == nil || !strings.Contains(err.Error(), "found 0 of 1") {
+		t.Fatalf("unpaired peer: %v", err)
+	}
+	peers, err := discoverPeers("discoverer", "discoverer-key", "", "127.0.0.1", listener("pinned"), 5, 1)
+	if err != nil || len(peers) != 1 || peers[0].Pubkey != "pinned" {
+		t.Fatalf("paired peer %+v: %v", peers, err)
+	}
+}
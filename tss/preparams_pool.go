@@ -0,0 +1,317 @@
+package tss
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ecdsaKeygen "github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	preParamsPoolExt     = ".ppm"
+	preParamsPoolAD      = "bbmt-ppm-pool/v1"
+	preParamsPoolTimeout = 10 * time.Minute
+)
+
+// PreParamsPoolStatus is the state of the pre-parameter pool, also sent to
+// the hook listener while the pool fills up.
+type PreParamsPoolStatus struct {
+	Type       string `json:"type"` // always "ppm_pool"
+	Info       string `json:"info"`
+	Running    bool   `json:"running"`
+	Generating bool   `json:"generating"`
+	Ready      int    `json:"ready"`
+	Size       int    `json:"size"`
+	Dir        string `json:"dir,omitempty"`
+}
+
+// preParamsPool keeps a few encrypted Paillier/Pedersen pre-parameters on
+// disk, so keygen doesn't have to wait minutes for safe primes. Every
+// pre-parameter is handed out once and deleted.
+type preParamsPool struct {
+	mu         sync.Mutex
+	dir        string
+	key        []byte
+	size       int
+	generating bool
+	cancel     context.CancelFunc
+	wake       chan struct{}
+	done       chan struct{}
+}
+
+var (
+	ppmPoolMu sync.Mutex
+	ppmPool   *preParamsPool
+)
+
+// StartPreParamsPool starts generating pre-parameters in the background until
+// size of them are stored in dir, encrypted with keyHex (32 bytes of hex).
+// While the pool runs, keygen and resharing calls take a pre-parameter from
+// the pool, and only fall back to their ppmPath when it is empty. Progress is reported
+// to the hook listener as PreParamsPoolStatus JSON.
+func StartPreParamsPool(dir, keyHex string, size int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in StartPreParamsPool: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+		}
+	}()
+
+	if size < 1 {
+		return fmt.Errorf("invalid pool size %d", size)
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return fmt.Errorf("pool key must be %d bytes of hex", chacha20poly1305.KeySize)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create pool dir: %w", err)
+	}
+	// drop files left half written by an interrupted run
+	if leftovers, err := filepath.Glob(filepath.Join(dir, "*.tmp")); err == nil {
+		for _, file := range leftovers {
+			os.Remove(file)
+		}
+	}
+
+	ppmPoolMu.Lock()
+	defer ppmPoolMu.Unlock()
+	if ppmPool != nil {
+		return errors.New("pre-parameter pool already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &preParamsPool{
+		dir:    dir,
+		key:    key,
+		size:   size,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	ppmPool = pool
+	go pool.fill(ctx)
+	Logln("BBMTLog", "ppm pool started in", dir, "size", size)
+	return nil
+}
+
+// StopPreParamsPool stops the background generation. Stored pre-parameters
+// stay on disk for the next StartPreParamsPool.
+func StopPreParamsPool() {
+	ppmPoolMu.Lock()
+	pool := ppmPool
+	ppmPool = nil
+	ppmPoolMu.Unlock()
+	if pool == nil {
+		return
+	}
+	pool.cancel()
+	<-pool.done
+	Logln("BBMTLog", "ppm pool stopped")
+}
+
+// PreParamsPoolState returns the PreParamsPoolStatus JSON of the pool.
+func PreParamsPoolState() string {
+	ppmPoolMu.Lock()
+	pool := ppmPool
+	ppmPoolMu.Unlock()
+	status := PreParamsPoolStatus{Type: "ppm_pool", Info: "stopped"}
+	if pool != nil {
+		status = pool.status("running")
+	}
+	data, _ := json.Marshal(status)
+	return string(data)
+}
+
+// takePoolPreParams hands out one validated pre-parameter from the running
+// pool and deletes it from disk. It returns nil when the pool is not running
+// or empty.
+func takePoolPreParams() *ecdsaKeygen.LocalPreParams {
+	ppmPoolMu.Lock()
+	pool := ppmPool
+	ppmPoolMu.Unlock()
+	if pool == nil {
+		return nil
+	}
+	defer pool.refill()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, file := range pool.files() {
+		preParams, err := pool.load(file)
+		if removeErr := os.Remove(file); removeErr != nil {
+			Logln("BBMTLog", "ppm pool failed to delete", file, removeErr)
+			continue
+		}
+		if err != nil {
+			Logln("BBMTLog", "ppm pool dropped unusable pre-parameters:", err)
+			continue
+		}
+		Logln("BBMTLog", "ppm pool handed out", filepath.Base(file))
+		return preParams
+	}
+	Logln("BBMTLog", "ppm pool is empty")
+	return nil
+}
+
+func (p *preParamsPool) fill(ctx context.Context) {
+	defer close(p.done)
+	for {
+		p.mu.Lock()
+		ready := len(p.files())
+		p.generating = ready < p.size
+		p.mu.Unlock()
+
+		if ready >= p.size {
+			p.hook("ready")
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.wake:
+				continue
+			}
+		}
+
+		p.hook("generating")
+		started := time.Now()
+		genCtx, cancel := context.WithTimeout(ctx, preParamsPoolTimeout)
+		preParams, err := ecdsaKeygen.GeneratePreParamsWithContext(genCtx)
+		cancel()
+		if ctx.Err() != nil {
+			p.mu.Lock()
+			p.generating = false
+			p.mu.Unlock()
+			return
+		}
+		if err == nil {
+			err = p.store(preParams)
+		}
+		if err != nil {
+			Logln("BBMTLog", "ppm pool generation failed:", err)
+			p.hook("failed: " + err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+			}
+			continue
+		}
+		Logln("BBMTLog", "ppm pool generated pre-parameters in", time.Since(started))
+	}
+}
+
+func (p *preParamsPool) refill() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *preParamsPool) status(info string) PreParamsPoolStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PreParamsPoolStatus{
+		Type:       "ppm_pool",
+		Info:       info,
+		Running:    true,
+		Generating: p.generating,
+		Ready:      len(p.files()),
+		Size:       p.size,
+		Dir:        p.dir,
+	}
+}
+
+func (p *preParamsPool) hook(info string) {
+	data, err := json.Marshal(p.status(info))
+	if err == nil {
+		Hook(string(data))
+	}
+}
+
+// files lists the stored pre-parameters, oldest first. Caller must hold mu.
+func (p *preParamsPool) files() []string {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), preParamsPoolExt) {
+			files = append(files, filepath.Join(p.dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (p *preParamsPool) store(preParams *ecdsaKeygen.LocalPreParams) error {
+	if err := checkPreParams(preParams); err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(preParams)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pre-parameters: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(p.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	data := aead.Seal(nonce, nonce, plaintext, []byte(preParamsPoolAD))
+
+	// write to a temp file first, so a half written file is never handed out
+	name := fmt.Sprintf("%020d-%s", time.Now().UnixNano(), hex.EncodeToString(nonce[:4]))
+	tmp := filepath.Join(p.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write pre-parameters: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := os.Rename(tmp, filepath.Join(p.dir, name+preParamsPoolExt)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store pre-parameters: %w", err)
+	}
+	return nil
+}
+
+func (p *preParamsPool) load(file string) (*ecdsaKeygen.LocalPreParams, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pre-parameters: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(p.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("truncated pre-parameters")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(preParamsPoolAD))
+	if err != nil {
+		return nil, errors.New("failed to decrypt pre-parameters, wrong pool key?")
+	}
+	var preParams ecdsaKeygen.LocalPreParams
+	if err := json.Unmarshal(plaintext, &preParams); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pre-parameters: %w", err)
+	}
+	if err := checkPreParams(&preParams); err != nil {
+		return nil, err
+	}
+	return &preParams, nil
+}
@@ -0,0 +1,64 @@
+package tss
+
+import (
+	"strings"
+	"testing"
+)
+
+// usePreParamsPool runs a pool holding the pre-parameters of parties, without
+// generating more.
+func usePreParamsPool(t *testing.T, parties ...string) {
+	t.Helper()
+	pool := &preParamsPool{
+		dir:  t.TempDir(),
+		key:  []byte(strings.Repeat("k", 32)),
+		size: len(parties),
+		wake: make(chan struct{}, 1),
+	}
+	for _, party := range parties {
+		preParams, err := loadPreParamsFromFile(testPreParams(party))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pool.store(preParams); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ppmPoolMu.Lock()
+	ppmPool = pool
+	ppmPoolMu.Unlock()
+	t.Cleanup(func() {
+		ppmPoolMu.Lock()
+		ppmPool = nil
+		ppmPoolMu.Unlock()
+	})
+}
+
+func TestPreParamsFromPool(t *testing.T) {
+	fromFile, err := PreParams(testPreParams("partyB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	usePreParamsPool(t, "partyA")
+
+	// the pool goes first, even with a ppm file
+	preParams, err := PreParams(testPreParams("partyB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preParams.NTildei.Cmp(fromFile.NTildei) == 0 {
+		t.Fatal("pre-parameters loaded from the ppm file while the pool runs")
+	}
+	if status := PreParamsPoolState(); !strings.Contains(status, `"ready":0`) {
+		t.Fatalf("pre-parameters not taken from the pool: %s", status)
+	}
+
+	// the ppm file once the pool is empty
+	preParams, err = PreParams(testPreParams("partyB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preParams.NTildei.Cmp(fromFile.NTildei) != 0 {
+		t.Fatal("empty pool did not fall back to the ppm file")
+	}
+}
@@ -0,0 +1,718 @@
+package tss
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/crypto/commitments"
+	"github.com/bnb-chain/tss-lib/v2/crypto/mta"
+	"github.com/bnb-chain/tss-lib/v2/crypto/schnorr"
+	ecdsaKeygen "github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/signing"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+// Presigning runs rounds 1 to 4 of GG18 signing ahead of time. None of them
+// depend on the message, so each party ends up with k_i, sigma_i and the
+// nonce point R = g^(1/k), where sum(k_i) = k and sum(sigma_i) = k*x. Once the
+// message m is known, every party sends s_i = m*k_i + r*sigma_i in a single
+// round and s = sum(s_i).
+//
+// tss-lib doesn't expose its signing rounds, so they are rebuilt here from its
+// MtA, commitment and Schnorr proof primitives.
+//
+// A presignature is computed for the key x' = x + d of one derive path with
+// delta d, sigma_i then shares k*x'. Tweaking the key only at signing time
+// would let whoever knows R pick a related key after the fact and forge
+// signatures without any share, so any other path is refused.
+//
+// Round 5 publishes K_i = R^k_i and S_i = R^sigma_i of every party, like
+// GG20 does. Their products must be g and the derived public key, and the
+// online round checks R^s_i = K_i^m * S_i^r for every s_i before summing, so
+// a party sending a bad s_i is identified. Honest parties always pass.
+//
+// Releasing two s_i for the same k_i reveals the keyshare. A presignature is
+// therefore deleted from the store before its s_i is sent, and never comes
+// back, not even when the signature fails.
+const (
+	presignRoundPoints = 5 // K_i and S_i
+	presignRoundSign   = 6 // the online round
+
+	presignTask = "presigning"
+	signTask    = "signing"
+)
+
+// presignMessage is the wire message of all presigning rounds and of the
+// online round. Big integers and proofs are big-endian bytes.
+type presignMessage struct {
+	PresignID  string   `json:"presign_id"`
+	From       string   `json:"from"`
+	Round      int      `json:"round"`
+	ShareEpoch int      `json:"share_epoch,omitempty"`
+	DerivePath string   `json:"derive_path,omitempty"` // round 1
+	Commitment []byte   `json:"commitment,omitempty"`  // round 1: commitment to Gamma_i
+	CA         []byte   `json:"c_a,omitempty"`         // round 1: Enc(k_i)
+	RangeProof [][]byte `json:"range_proof,omitempty"` // round 1
+	C1         []byte   `json:"c1,omitempty"`          // round 2: MtA with gamma_i
+	ProofBob   [][]byte `json:"proof_bob,omitempty"`   // round 2
+	C2         []byte   `json:"c2,omitempty"`          // round 2: MtA with w_i
+	ProofBobWC [][]byte `json:"proof_bob_wc,omitempty"`
+	Delta      []byte   `json:"delta,omitempty"`       // round 3: delta_i
+	DeCommit   [][]byte `json:"de_commit,omitempty"`   // round 4: opens the commitment to Gamma_i
+	GammaProof [][]byte `json:"gamma_proof,omitempty"` // round 4: Schnorr proof of gamma_i
+	KPoint     [][]byte `json:"k_point,omitempty"`     // round 5: K_i = R^k_i
+	SPoint     [][]byte `json:"s_point,omitempty"`     // round 5: S_i = R^sigma_i
+	MsgHash    []byte   `json:"msg_hash,omitempty"`    // online round
+	S          []byte   `json:"s,omitempty"`           // online round: s_i
+}
+
+// presignSession drives the rounds of one presigning or presigned keysign
+// over the service's messenger.
+type presignSession struct {
+	s          *ServiceImpl
+	id         string
+	task       string
+	local      string
+	parties    tss.SortedPartyIDs
+	shareEpoch int
+	pending    map[int]map[string]*presignMessage
+	until      time.Time
+}
+
+func (s *ServiceImpl) newPresignSession(id, task string, localPartyID *tss.PartyID, parties tss.SortedPartyIDs, shareEpoch int) *presignSession {
+	return &presignSession{
+		s:          s,
+		id:         id,
+		task:       task,
+		local:      localPartyID.Moniker,
+		parties:    parties,
+		shareEpoch: shareEpoch,
+		pending:    make(map[int]map[string]*presignMessage),
+		until:      time.Now().Add(time.Duration(keySignTimeout) * time.Second),
+	}
+}
+
+func (p *presignSession) send(to string, msg presignMessage) error {
+	msg.PresignID = p.id
+	msg.From = p.local
+	msg.ShareEpoch = p.shareEpoch
+	jsonBytes, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message to json, error: %w", err)
+	}
+	Logln("BBMTLog", "send", p.task, "round", msg.Round, "message from", p.local, "to", to)
+	if err := p.s.messenger.Send(p.local, to, base64.StdEncoding.EncodeToString(jsonBytes)); err != nil {
+		return fmt.Errorf("failed to send message to peer, error: %w", err)
+	}
+	return nil
+}
+
+func (p *presignSession) broadcast(msg presignMessage) error {
+	for _, party := range p.parties {
+		if party.Moniker == p.local {
+			continue
+		}
+		if err := p.send(party.Moniker, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collect waits for the round messages of every peer. Messages of later
+// rounds are kept for later.
+func (p *presignSession) collect(round int) (map[string]*presignMessage, error) {
+	for {
+		if received := p.pending[round]; len(received) == len(p.parties)-1 {
+			return received, nil
+		}
+		select {
+		case msg := <-p.s.inboundMessageCh:
+			if err := p.receive(msg); err != nil {
+				return nil, err
+			}
+		case err := <-p.s.abortCh:
+			return nil, err
+		case <-time.After(time.Until(p.until)):
+			var missing []string
+			for _, party := range p.parties {
+				if _, ok := p.pending[round][party.Moniker]; !ok && party.Moniker != p.local {
+					missing = append(missing, party.Moniker)
+				}
+			}
+			sort.Strings(missing)
+			blame := newBlame(BlameTimeout, fmt.Errorf("%s timeout, didn't finish in %d seconds", p.task, keySignTimeout), missing...)
+			blame.Task = p.task
+			blame.Round = round
+			return nil, blame
+		}
+	}
+}
+
+func (p *presignSession) receive(raw string) error {
+	originalBytes, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode message from base64, error: %w", err)
+	}
+	var msg presignMessage
+	if err := json.Unmarshal(originalBytes, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal message from json, error: %w", err)
+	}
+	known := false
+	for _, party := range p.parties {
+		known = known || (party.Moniker == msg.From && msg.From != p.local)
+	}
+	if !known {
+		return p.blame(msg.Round, fmt.Errorf("failed to find from party,from:%s", msg.From), msg.From)
+	}
+	if msg.PresignID != p.id {
+		return p.blame(msg.Round, fmt.Errorf("party %s uses presignature %s, local presignature is %s", msg.From, msg.PresignID, p.id), msg.From)
+	}
+	if msg.ShareEpoch != p.shareEpoch {
+		return p.blame(msg.Round, fmt.Errorf("mixed share epochs, party %s holds epoch %d, local epoch is %d", msg.From, msg.ShareEpoch, p.shareEpoch), msg.From)
+	}
+	if msg.Round < 1 || msg.Round > presignRoundSign {
+		return p.blame(msg.Round, fmt.Errorf("invalid round %d", msg.Round), msg.From)
+	}
+	if p.pending[msg.Round] == nil {
+		p.pending[msg.Round] = make(map[string]*presignMessage)
+	}
+	if _, ok := p.pending[msg.Round][msg.From]; ok {
+		return p.blame(msg.Round, fmt.Errorf("party %s sent round %d twice", msg.From, msg.Round), msg.From)
+	}
+	p.pending[msg.Round][msg.From] = &msg
+	return nil
+}
+
+func (p *presignSession) blame(round int, err error, culprits ...string) *BlameError {
+	blame := newBlame(BlameProtocol, err, culprits...)
+	blame.Task = p.task
+	blame.Round = round
+	return blame
+}
+
+// presignContext is the proof session context of party index, bound to ssid.
+func presignContext(ssid []byte, index int) []byte {
+	return common.AppendBigIntToBytesSlice(ssid, big.NewInt(int64(index)))
+}
+
+// presignSSID binds the proofs to the curve, the committee, its keys, the
+// presignature ID and its derive path, like tss-lib binds them to a signing
+// session.
+func presignSSID(id, derivePath string, parties tss.SortedPartyIDs, key *ecdsaKeygen.LocalPartySaveData) ([]byte, error) {
+	ec := tss.S256().Params()
+	ssidList := []*big.Int{ec.P, ec.N, ec.B, ec.Gx, ec.Gy}
+	ssidList = append(ssidList, parties.Keys()...)
+	bigXjList, err := crypto.FlattenECPoints(key.BigXj)
+	if err != nil {
+		return nil, fmt.Errorf("read BigXj failed: %w", err)
+	}
+	ssidList = append(ssidList, bigXjList...)
+	ssidList = append(ssidList, key.NTildej...)
+	ssidList = append(ssidList, key.H1j...)
+	ssidList = append(ssidList, key.H2j...)
+	idHash := sha256.Sum256([]byte(id + "\n" + derivePath))
+	ssidList = append(ssidList, new(big.Int).SetBytes(idHash[:]))
+	return common.SHA512_256i(ssidList...).Bytes(), nil
+}
+
+func bigIntsToBytes(ints []*big.Int) [][]byte {
+	result := make([][]byte, len(ints))
+	for i, item := range ints {
+		result[i] = item.Bytes()
+	}
+	return result
+}
+
+func bytesToBigInts(parts [][]byte) []*big.Int {
+	result := make([]*big.Int, len(parts))
+	for i, part := range parts {
+		result[i] = new(big.Int).SetBytes(part)
+	}
+	return result
+}
+
+// signingDerivation returns the canonical form of derivePath, its delta and
+// the derived public key of localState.
+func signingDerivation(localState *LocalState, derivePath string) (string, *big.Int, *ecdsa.PublicKey, error) {
+	if localState.ChainCodeHex == "" {
+		return "", nil, nil, errors.New("nil chain code")
+	}
+	chainCodeBuf, err := hex.DecodeString(localState.ChainCodeHex)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to decode chain code hex, error: %w", err)
+	}
+	pathBuf, err := GetDerivePathBytes(derivePath)
+	if err != nil || len(pathBuf) == 0 {
+		return "", nil, nil, fmt.Errorf("failed to get derive path bytes, error: %w", err)
+	}
+	delta, derivedKey, err := derivingPubkeyFromPath(localState.ECDSALocalData.ECDSAPub, chainCodeBuf, pathBuf, tss.S256())
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to derive key from path, error: %w", err)
+	}
+	return legacyDerivePath(derivePath), delta, &derivedKey.PublicKey, nil
+}
+
+// pointBytes and pointFromBytes carry an EC point in a message.
+func pointBytes(point *crypto.ECPoint) [][]byte {
+	return [][]byte{point.X().Bytes(), point.Y().Bytes()}
+}
+
+func pointFromBytes(parts [][]byte) (*crypto.ECPoint, error) {
+	if len(parts) != 2 {
+		return nil, errors.New("invalid point")
+	}
+	return crypto.NewECPoint(tss.S256(), new(big.Int).SetBytes(parts[0]), new(big.Int).SetBytes(parts[1]))
+}
+
+// presignShareValid checks the s_i of a party against its points:
+// R^s_i = K_i^m * S_i^r.
+func presignShareValid(R *crypto.ECPoint, points presignPoints, m, r, si *big.Int) bool {
+	ec := tss.S256()
+	pointK, err := crypto.NewECPoint(ec, points.KX, points.KY)
+	if err != nil {
+		return false
+	}
+	pointS, err := crypto.NewECPoint(ec, points.SX, points.SY)
+	if err != nil {
+		return false
+	}
+	expected, err := pointK.ScalarMult(m).Add(pointS.ScalarMult(r))
+	if err != nil {
+		return false
+	}
+	return R.ScalarMult(si).Equals(expected)
+}
+
+// loadSigningState loads the keyshare of req.PubKey and validates the
+// committee, the way KeysignECDSA does.
+func (s *ServiceImpl) loadSigningState(pubKey, committeeKeys string) (*LocalState, []string, error) {
+	Logln("BBMTLog", "restoring local state...")
+	localStateStr, err := s.stateAccessor.GetLocalState(pubKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get local state, error: %w", err)
+	}
+	var localState LocalState
+	if err := json.Unmarshal([]byte(localStateStr), &localState); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal local state, error: %w", err)
+	}
+	if localState.ECDSALocalData.ECDSAPub == nil {
+		return nil, nil, errors.New("nil ecdsa pub key")
+	}
+	threshold, err := localState.threshold()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get threshold: %w", err)
+	}
+	committee, err := localState.signingCommittee(strings.Split(committeeKeys, ","), threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !Contains(committee, localState.LocalPartyKey) {
+		return nil, nil, errors.New("local party not in keysign committee")
+	}
+	return &localState, committee, nil
+}
+
+// PresignECDSA runs the presigning rounds with the committee and stores the
+// presignature under req.PresignID. Only that committee can use it, for
+// req.DerivePath and any message, once.
+func (s *ServiceImpl) PresignECDSA(req *PresignRequest) (*PresignResponse, error) {
+	if err := s.validatePresignRequest(req); err != nil {
+		return nil, err
+	}
+	store, err := openedPresignStore()
+	if err != nil {
+		return nil, err
+	}
+	localState, committee, err := s.loadSigningState(req.PubKey, req.PresignCommitteeKeys)
+	if err != nil {
+		return nil, err
+	}
+	derivePath, keyDerivationDelta, derivedPub, err := signingDerivation(localState, req.DerivePath)
+	if err != nil {
+		return nil, err
+	}
+	if file, err := store.file(localState.PubKey, localState.LocalPartyKey, req.PresignID); err != nil {
+		return nil, err
+	} else if _, err := os.Stat(file); err == nil {
+		return nil, fmt.Errorf("presignature %s already exists", req.PresignID)
+	}
+
+	parties, localPartyID := s.getParties(committee, localState.LocalPartyKey, localState.ResharePrefix)
+	key := ecdsaKeygen.BuildLocalSaveDataSubset(localState.ECDSALocalData, parties)
+	ec := tss.S256()
+	modN := common.ModInt(ec.Params().N)
+	i := localPartyID.Index
+	wi, bigWs := signing.PrepareForSigning(ec, i, len(key.Ks), key.Xi, key.Ks, key.BigXj)
+	ssid, err := presignSSID(req.PresignID, derivePath, parties, &key)
+	if err != nil {
+		return nil, err
+	}
+	session := s.newPresignSession(req.PresignID, presignTask, localPartyID, parties, localState.ShareEpoch)
+
+	// round 1: commit to Gamma_i = g^gamma_i, start the MtAs with Enc(k_i)
+	k := common.GetRandomPositiveInt(rand.Reader, ec.Params().N)
+	gamma := common.GetRandomPositiveInt(rand.Reader, ec.Params().N)
+	pointGamma := crypto.ScalarBaseMult(ec, gamma)
+	cmt := commitments.NewHashCommitment(rand.Reader, pointGamma.X(), pointGamma.Y())
+	cis := make(map[string]*big.Int)
+	for _, Pj := range parties {
+		if Pj.Index == i {
+			continue
+		}
+		j := Pj.Index
+		cA, pi, err := mta.AliceInit(ec, key.PaillierPKs[i], k, key.NTildej[j], key.H1j[j], key.H2j[j], rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init mta: %w", err)
+		}
+		cis[Pj.Moniker] = cA
+		proof := pi.Bytes()
+		if err := session.send(Pj.Moniker, presignMessage{Round: 1, DerivePath: derivePath, Commitment: cmt.C.Bytes(), CA: cA.Bytes(), RangeProof: proof[:]}); err != nil {
+			return nil, err
+		}
+	}
+	r1msgs, err := session.collect(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// round 2: Bob's side of the MtAs, with gamma_i and with w_i
+	betas := make(map[string]*big.Int)
+	vs := make(map[string]*big.Int)
+	contextI := presignContext(ssid, i)
+	for _, Pj := range parties {
+		if Pj.Index == i {
+			continue
+		}
+		j := Pj.Index
+		r1msg := r1msgs[Pj.Moniker]
+		if r1msg.DerivePath != derivePath {
+			return nil, session.blame(1, fmt.Errorf("party %s presigns for %s, local path is %s", Pj.Moniker, r1msg.DerivePath, derivePath), Pj.Moniker)
+		}
+		rangeProof, err := mta.RangeProofAliceFromBytes(r1msg.RangeProof)
+		if err != nil {
+			return nil, session.blame(1, fmt.Errorf("UnmarshalRangeProofAlice failed: %w", err), Pj.Moniker)
+		}
+		cA := new(big.Int).SetBytes(r1msg.CA)
+		beta, c1, _, pi1, err := mta.BobMid(contextI, ec, key.PaillierPKs[j], rangeProof, gamma, cA,
+			key.NTildej[j], key.H1j[j], key.H2j[j], key.NTildej[i], key.H1j[i], key.H2j[i], rand.Reader)
+		if err != nil {
+			return nil, session.blame(2, fmt.Errorf("failed to calculate Bob_mid: %w", err), Pj.Moniker)
+		}
+		v, c2, _, pi2, err := mta.BobMidWC(contextI, ec, key.PaillierPKs[j], rangeProof, wi, cA,
+			key.NTildej[j], key.H1j[j], key.H2j[j], key.NTildej[i], key.H1j[i], key.H2j[i], bigWs[i], rand.Reader)
+		if err != nil {
+			return nil, session.blame(2, fmt.Errorf("failed to calculate Bob_mid_wc: %w", err), Pj.Moniker)
+		}
+		betas[Pj.Moniker], vs[Pj.Moniker] = beta, v
+		proofBob, proofBobWC := pi1.Bytes(), pi2.Bytes()
+		if err := session.send(Pj.Moniker, presignMessage{Round: 2, C1: c1.Bytes(), ProofBob: proofBob[:], C2: c2.Bytes(), ProofBobWC: proofBobWC[:]}); err != nil {
+			return nil, err
+		}
+	}
+	r2msgs, err := session.collect(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// round 3: Alice's side, delta_i = k_i*gamma_i + ..., sigma_i = k_i*w_i + ...
+	delta := modN.Mul(k, gamma)
+	sigma := modN.Mul(k, wi)
+	for _, Pj := range parties {
+		if Pj.Index == i {
+			continue
+		}
+		j := Pj.Index
+		r2msg := r2msgs[Pj.Moniker]
+		contextJ := presignContext(ssid, j)
+		proofBob, err := mta.ProofBobFromBytes(r2msg.ProofBob)
+		if err != nil {
+			return nil, session.blame(2, fmt.Errorf("UnmarshalProofBob failed: %w", err), Pj.Moniker)
+		}
+		alpha, err := mta.AliceEnd(contextJ, ec, key.PaillierPKs[i], proofBob, key.H1j[i], key.H2j[i],
+			cis[Pj.Moniker], new(big.Int).SetBytes(r2msg.C1), key.NTildej[i], key.PaillierSK)
+		if err != nil {
+			return nil, session.blame(3, fmt.Errorf("failed to calculate Alice_end: %w", err), Pj.Moniker)
+		}
+		proofBobWC, err := mta.ProofBobWCFromBytes(ec, r2msg.ProofBobWC)
+		if err != nil {
+			return nil, session.blame(2, fmt.Errorf("UnmarshalProofBobWC failed: %w", err), Pj.Moniker)
+		}
+		u, err := mta.AliceEndWC(contextJ, ec, key.PaillierPKs[i], proofBobWC, bigWs[j],
+			cis[Pj.Moniker], new(big.Int).SetBytes(r2msg.C2), key.NTildej[i], key.H1j[i], key.H2j[i], key.PaillierSK)
+		if err != nil {
+			return nil, session.blame(3, fmt.Errorf("failed to calculate Alice_end_wc: %w", err), Pj.Moniker)
+		}
+		delta = modN.Add(delta, modN.Add(alpha, betas[Pj.Moniker]))
+		sigma = modN.Add(sigma, modN.Add(u, vs[Pj.Moniker]))
+	}
+	if err := session.broadcast(presignMessage{Round: 3, Delta: delta.Bytes()}); err != nil {
+		return nil, err
+	}
+	r3msgs, err := session.collect(3)
+	if err != nil {
+		return nil, err
+	}
+
+	// round 4: open Gamma_i and prove gamma_i
+	for _, r3msg := range r3msgs {
+		delta = modN.Add(delta, new(big.Int).SetBytes(r3msg.Delta))
+	}
+	if delta.Sign() == 0 {
+		return nil, session.blame(3, errors.New("delta is zero"))
+	}
+	deltaInverse := modN.ModInverse(delta)
+	piGamma, err := schnorr.NewZKProof(contextI, gamma, pointGamma, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("NewZKProof(gamma, bigGamma): %w", err)
+	}
+	gammaProof := [][]byte{piGamma.Alpha.X().Bytes(), piGamma.Alpha.Y().Bytes(), piGamma.T.Bytes()}
+	if err := session.broadcast(presignMessage{Round: 4, DeCommit: bigIntsToBytes(cmt.D), GammaProof: gammaProof}); err != nil {
+		return nil, err
+	}
+	r4msgs, err := session.collect(4)
+	if err != nil {
+		return nil, err
+	}
+
+	// R = (prod Gamma_j)^(1/delta) = g^(1/k)
+	R := pointGamma
+	for _, Pj := range parties {
+		if Pj.Index == i {
+			continue
+		}
+		r4msg := r4msgs[Pj.Moniker]
+		cmtDeCmt := commitments.HashCommitDecommit{
+			C: new(big.Int).SetBytes(r1msgs[Pj.Moniker].Commitment),
+			D: bytesToBigInts(r4msg.DeCommit),
+		}
+		ok, bigGammaJ := cmtDeCmt.DeCommit()
+		if !ok || len(bigGammaJ) != 2 {
+			return nil, session.blame(4, errors.New("commitment verify failed"), Pj.Moniker)
+		}
+		bigGammaJPoint, err := crypto.NewECPoint(ec, bigGammaJ[0], bigGammaJ[1])
+		if err != nil {
+			return nil, session.blame(4, fmt.Errorf("NewECPoint(bigGammaJ): %w", err), Pj.Moniker)
+		}
+		if len(r4msg.GammaProof) != 3 {
+			return nil, session.blame(4, errors.New("failed to unmarshal bigGamma proof"), Pj.Moniker)
+		}
+		alpha, err := crypto.NewECPoint(ec, new(big.Int).SetBytes(r4msg.GammaProof[0]), new(big.Int).SetBytes(r4msg.GammaProof[1]))
+		if err != nil {
+			return nil, session.blame(4, errors.New("failed to unmarshal bigGamma proof"), Pj.Moniker)
+		}
+		proof := &schnorr.ZKProof{Alpha: alpha, T: new(big.Int).SetBytes(r4msg.GammaProof[2])}
+		if !proof.Verify(presignContext(ssid, Pj.Index), bigGammaJPoint) {
+			return nil, session.blame(4, errors.New("failed to prove bigGamma"), Pj.Moniker)
+		}
+		if R, err = R.Add(bigGammaJPoint); err != nil {
+			return nil, session.blame(4, fmt.Errorf("R.Add(bigGammaJ): %w", err), Pj.Moniker)
+		}
+	}
+	R = R.ScalarMult(deltaInverse)
+
+	// round 5: publish K_i = R^k_i and S_i = R^sigma_i, for sigma_i of the
+	// derived key x + d
+	sigma = modN.Add(sigma, modN.Mul(keyDerivationDelta, k))
+	pointK, pointS := R.ScalarMult(k), R.ScalarMult(sigma)
+	if err := session.broadcast(presignMessage{Round: presignRoundPoints, KPoint: pointBytes(pointK), SPoint: pointBytes(pointS)}); err != nil {
+		return nil, err
+	}
+	r5msgs, err := session.collect(presignRoundPoints)
+	if err != nil {
+		return nil, err
+	}
+	points := map[string]presignPoints{
+		localPartyID.Moniker: {KX: pointK.X(), KY: pointK.Y(), SX: pointS.X(), SY: pointS.Y()},
+	}
+	sumK, sumS := pointK, pointS
+	for _, Pj := range parties {
+		if Pj.Index == i {
+			continue
+		}
+		r5msg := r5msgs[Pj.Moniker]
+		pointKJ, errK := pointFromBytes(r5msg.KPoint)
+		pointSJ, errS := pointFromBytes(r5msg.SPoint)
+		if errK != nil || errS != nil {
+			return nil, session.blame(presignRoundPoints, errors.New("invalid K_i or S_i"), Pj.Moniker)
+		}
+		points[Pj.Moniker] = presignPoints{KX: pointKJ.X(), KY: pointKJ.Y(), SX: pointSJ.X(), SY: pointSJ.Y()}
+		if sumK, err = sumK.Add(pointKJ); err != nil {
+			return nil, session.blame(presignRoundPoints, fmt.Errorf("sumK.Add(K_j): %w", err), Pj.Moniker)
+		}
+		if sumS, err = sumS.Add(pointSJ); err != nil {
+			return nil, session.blame(presignRoundPoints, fmt.Errorf("sumS.Add(S_j): %w", err), Pj.Moniker)
+		}
+	}
+	// the products can't be attributed to a party without further proofs,
+	// but nothing was signed yet
+	if !sumK.Equals(crypto.ScalarBaseMult(ec, big.NewInt(1))) {
+		return nil, session.blame(presignRoundPoints, errors.New("product of K_i is not g"))
+	}
+	if sumS.X().Cmp(derivedPub.X) != 0 || sumS.Y().Cmp(derivedPub.Y) != 0 {
+		return nil, session.blame(presignRoundPoints, errors.New("product of S_i is not the derived public key"))
+	}
+
+	pre := &presignature{
+		ID:               req.PresignID,
+		PubKey:           localState.PubKey,
+		LocalPartyKey:    localState.LocalPartyKey,
+		Committee:        committee,
+		ResharePrefix:    localState.ResharePrefix,
+		ShareEpoch:       localState.ShareEpoch,
+		ShareFingerprint: shareFingerprint(localState),
+		DerivePath:       derivePath,
+		K:                k,
+		Sigma:            sigma,
+		RX:               R.X(),
+		RY:               R.Y(),
+		Points:           points,
+		CreatedAt:        time.Now().UnixMilli(),
+	}
+	if err := store.save(pre); err != nil {
+		return nil, err
+	}
+	Logln("BBMTLog", "presignature stored:", req.PresignID, "for", derivePath)
+	return &PresignResponse{
+		PresignID:  req.PresignID,
+		PubKey:     localState.PubKey,
+		DerivePath: derivePath,
+		Committee:  committee,
+		ShareEpoch: localState.ShareEpoch,
+	}, nil
+}
+
+// KeysignPresignedECDSA signs req.MessageToSign in one round with the stored
+// presignature req.PresignID, which every party of the committee must hold,
+// for the derive path it was computed for. The presignature is used up even
+// if the signing fails.
+func (s *ServiceImpl) KeysignPresignedECDSA(req *PresignedKeysignRequest) (*KeysignResponse, error) {
+	if err := s.validateKeysignRequest(&req.KeysignRequest); err != nil {
+		return nil, err
+	}
+	if req.PresignID == "" {
+		return nil, errors.New("nil presign id")
+	}
+	bytesToSign, err := base64.StdEncoding.DecodeString(req.MessageToSign)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message to sign, error: %w", err)
+	}
+	store, err := openedPresignStore()
+	if err != nil {
+		return nil, err
+	}
+	localState, committee, err := s.loadSigningState(req.PubKey, req.KeysignCommitteeKeys)
+	if err != nil {
+		return nil, err
+	}
+	derivePath, _, derivedPub, err := signingDerivation(localState, req.DerivePath)
+	if err != nil {
+		return nil, err
+	}
+	ec := tss.S256()
+
+	// refuse another path or committee without using the presignature up
+	if pre, err := store.peek(localState.PubKey, localState.LocalPartyKey, req.PresignID); err != nil {
+		return nil, err
+	} else if err := pre.check(localState, committee, derivePath); err != nil {
+		return nil, err
+	}
+	// claim the presignature before anything derived from it leaves this party
+	pre, err := store.take(localState.PubKey, localState.LocalPartyKey, req.PresignID)
+	if err != nil {
+		return nil, err
+	}
+	if err := pre.check(localState, committee, derivePath); err != nil {
+		return nil, err
+	}
+	Logln("BBMTLog", "presignature claimed:", req.PresignID)
+
+	parties, localPartyID := s.getParties(committee, localState.LocalPartyKey, localState.ResharePrefix)
+	session := s.newPresignSession(req.PresignID, signTask, localPartyID, parties, localState.ShareEpoch)
+
+	// s_i = m*k_i + r*sigma_i, sigma_i already shares k*(x + d)
+	modN := common.ModInt(ec.Params().N)
+	m := HashToInt(bytesToSign, ec)
+	si := modN.Add(modN.Mul(m, pre.K), modN.Mul(pre.RX, pre.Sigma))
+	pre.K, pre.Sigma = nil, nil
+	if err := session.broadcast(presignMessage{Round: presignRoundSign, MsgHash: bytesToSign, S: si.Bytes()}); err != nil {
+		return nil, err
+	}
+	msgs, err := session.collect(presignRoundSign)
+	if err != nil {
+		return nil, err
+	}
+	R, err := crypto.NewECPoint(ec, pre.RX, pre.RY)
+	if err != nil {
+		return nil, fmt.Errorf("invalid presignature R: %w", err)
+	}
+	var culprits []string
+	for from, msg := range msgs {
+		if !bytes.Equal(msg.MsgHash, bytesToSign) {
+			return nil, session.blame(presignRoundSign, fmt.Errorf("party %s signs a different message", from), from)
+		}
+		if !presignShareValid(R, pre.Points[from], m, pre.RX, new(big.Int).SetBytes(msg.S)) {
+			culprits = append(culprits, from)
+		}
+	}
+	if len(culprits) > 0 {
+		sort.Strings(culprits)
+		return nil, session.blame(presignRoundSign, fmt.Errorf("presignature %s is used up, invalid s_i from %s", req.PresignID, strings.Join(culprits, ",")), culprits...)
+	}
+	sum := si
+	for _, msg := range msgs {
+		sum = modN.Add(sum, new(big.Int).SetBytes(msg.S))
+	}
+
+	normalized, err := normalizeSignature(derivedPub, bytesToSign, pre.RX.Bytes(), sum.Bytes())
+	if err != nil {
+		Logln("BBMTLog", "presigned keysign produced an unusable signature", "error", err)
+		return nil, session.blame(presignRoundSign, fmt.Errorf("presignature %s is used up: %w", req.PresignID, err))
+	}
+	Logln("BBMTLog", "signature is valid")
+
+	return &KeysignResponse{
+		Msg:          req.MessageToSign,
+		MsgHex:       hex.EncodeToString(bytesToSign),
+		R:            hex.EncodeToString(normalized.R),
+		S:            hex.EncodeToString(normalized.S),
+		DerSignature: hex.EncodeToString(normalized.DER),
+		RecoveryID:   hex.EncodeToString([]byte{normalized.RecoveryID}),
+	}, nil
+}
+
+func (*ServiceImpl) validatePresignRequest(req *PresignRequest) error {
+	if req == nil {
+		return errors.New("nil request")
+	}
+	if req.PresignCommitteeKeys == "" {
+		return errors.New("nil presign committee keys")
+	}
+	if req.LocalPartyKey == "" {
+		return errors.New("nil local party key")
+	}
+	if req.PubKey == "" {
+		return errors.New("nil pub key")
+	}
+	if req.PresignID == "" {
+		return errors.New("nil presign id")
+	}
+	if req.DerivePath == "" {
+		return errors.New("nil derive path")
+	}
+	return nil
+}
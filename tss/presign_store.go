@@ -0,0 +1,386 @@
+package tss
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	presignatureExt = ".presig"
+	presignatureAD  = "bbmt-presign/v1"
+)
+
+// ErrPresignatureInvalidated is returned when a presignature no longer matches
+// its keyshare, e.g. after a refresh. The presignature is deleted.
+type ErrPresignatureInvalidated string
+
+func (e ErrPresignatureInvalidated) Error() string {
+	return "presignature invalidated: " + string(e)
+}
+
+// presignature is the single-use output of the presigning rounds: the share
+// k_i of the nonce k, the share sigma_i of k*x' for the key x' of the derive
+// path, the nonce point R and the public points of every party. It is bound
+// to the keyshare, the committee and the derive path it was computed with.
+type presignature struct {
+	ID               string                   `json:"id"`
+	PubKey           string                   `json:"pub_key"`
+	LocalPartyKey    string                   `json:"local_party_key"`
+	Committee        []string                 `json:"committee"`
+	ResharePrefix    string                   `json:"reshare_prefix,omitempty"`
+	ShareEpoch       int                      `json:"share_epoch,omitempty"`
+	ShareFingerprint string                   `json:"share_fingerprint"`
+	DerivePath       string                   `json:"derive_path"`
+	K                *big.Int                 `json:"k"`
+	Sigma            *big.Int                 `json:"sigma"`
+	RX               *big.Int                 `json:"r_x"`
+	RY               *big.Int                 `json:"r_y"`
+	Points           map[string]presignPoints `json:"points"` // by party
+	CreatedAt        int64                    `json:"created_at"`
+}
+
+// presignPoints are the public points R^k_i and R^sigma_i of a party, which
+// its s_i of the online round is checked against.
+type presignPoints struct {
+	KX *big.Int `json:"k_x"`
+	KY *big.Int `json:"k_y"`
+	SX *big.Int `json:"s_x"`
+	SY *big.Int `json:"s_y"`
+}
+
+// PresignatureInfo describes a stored presignature without its secrets.
+type PresignatureInfo struct {
+	ID            string   `json:"id"`
+	PubKey        string   `json:"pub_key"`
+	LocalPartyKey string   `json:"local_party_key"`
+	Committee     []string `json:"committee"`
+	ShareEpoch    int      `json:"share_epoch"`
+	DerivePath    string   `json:"derive_path"`
+	CreatedAt     int64    `json:"created_at"`
+}
+
+// check makes sure the presignature still belongs to localState and is used
+// with the committee and the derive path it was computed with.
+func (p *presignature) check(localState *LocalState, committee []string, derivePath string) error {
+	if p.DerivePath != derivePath {
+		return fmt.Errorf("presignature %s signs for %s only, not for %s", p.ID, p.DerivePath, derivePath)
+	}
+	if p.PubKey != localState.PubKey || p.LocalPartyKey != localState.LocalPartyKey {
+		return ErrPresignatureInvalidated("computed for another keyshare")
+	}
+	if p.ShareEpoch != localState.ShareEpoch || p.ResharePrefix != localState.ResharePrefix ||
+		p.ShareFingerprint != shareFingerprint(localState) {
+		return ErrPresignatureInvalidated("the keyshare was refreshed since")
+	}
+	if !equalUnordered(p.Committee, committee) {
+		return fmt.Errorf("presignature %s was computed by %s, not by %s", p.ID, strings.Join(p.Committee, ","), strings.Join(committee, ","))
+	}
+	return nil
+}
+
+// shareFingerprint identifies the current shares of a keyshare by the public
+// share points of the committee, which change with every refresh.
+func shareFingerprint(localState *LocalState) string {
+	hash := sha256.New()
+	for _, point := range localState.ECDSALocalData.BigXj {
+		if point != nil {
+			hash.Write(point.X().Bytes())
+			hash.Write(point.Y().Bytes())
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// presignStore keeps presignatures on disk, encrypted with the store key.
+// Taking a presignature deletes its file first, so it can't be used twice,
+// even by concurrent calls or after a crash.
+type presignStore struct {
+	dir string
+	key []byte
+}
+
+var (
+	presignStoreMu sync.Mutex
+	presigns       *presignStore
+)
+
+// OpenPresignStore keeps presignatures in dir, encrypted with keyHex (32
+// bytes of hex). Presigning and presigned keysign need an open store. Put it
+// next to the keyshares, it holds secrets of the same sensitivity.
+func OpenPresignStore(dir, keyHex string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in OpenPresignStore: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+		}
+	}()
+
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return fmt.Errorf("presign store key must be %d bytes of hex", chacha20poly1305.KeySize)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create presign store dir: %w", err)
+	}
+	// drop files left half written by an interrupted run
+	if leftovers, err := filepath.Glob(filepath.Join(dir, "*.tmp")); err == nil {
+		for _, file := range leftovers {
+			os.Remove(file)
+		}
+	}
+
+	presignStoreMu.Lock()
+	defer presignStoreMu.Unlock()
+	presigns = &presignStore{dir: dir, key: key}
+	Logln("BBMTLog", "presign store opened in", dir)
+	return nil
+}
+
+// ClosePresignStore closes the presign store. Stored presignatures stay on
+// disk for the next OpenPresignStore.
+func ClosePresignStore() {
+	presignStoreMu.Lock()
+	defer presignStoreMu.Unlock()
+	presigns = nil
+}
+
+// PresignatureList returns the PresignatureInfo JSON list of the unused
+// presignatures of the wallet pubKey.
+func PresignatureList(pubKey string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in PresignatureList: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	store, err := openedPresignStore()
+	if err != nil {
+		return "", err
+	}
+	files, err := store.files(pubKey)
+	if err != nil {
+		return "", err
+	}
+	infos := make([]PresignatureInfo, 0, len(files))
+	for _, file := range files {
+		pre, err := store.load(file)
+		if err != nil {
+			Logln("BBMTLog", "skipping unreadable presignature", filepath.Base(file), err)
+			continue
+		}
+		infos = append(infos, PresignatureInfo{
+			ID:            pre.ID,
+			PubKey:        pre.PubKey,
+			LocalPartyKey: pre.LocalPartyKey,
+			Committee:     pre.Committee,
+			ShareEpoch:    pre.ShareEpoch,
+			DerivePath:    pre.DerivePath,
+			CreatedAt:     pre.CreatedAt,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt < infos[j].CreatedAt })
+	infosJSON, err := json.Marshal(infos)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal presignatures: %w", err)
+	}
+	return string(infosJSON), nil
+}
+
+// InvalidatePresignatures deletes all presignatures of the wallet pubKey and
+// returns how many were deleted. Refresh and resharing do this on their own
+// while the store is open.
+func InvalidatePresignatures(pubKey string) (result int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in InvalidatePresignatures: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			Logf("BBMTLog: Stack trace: %s", string(debug.Stack()))
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = 0
+		}
+	}()
+
+	store, err := openedPresignStore()
+	if err != nil {
+		return 0, err
+	}
+	return store.drop(pubKey)
+}
+
+func openedPresignStore() (*presignStore, error) {
+	presignStoreMu.Lock()
+	defer presignStoreMu.Unlock()
+	if presigns == nil {
+		return nil, errors.New("presign store is not open")
+	}
+	return presigns, nil
+}
+
+// dropPresignatures deletes the presignatures of pubKey if the store is open.
+func dropPresignatures(pubKey string) {
+	presignStoreMu.Lock()
+	store := presigns
+	presignStoreMu.Unlock()
+	if store == nil || pubKey == "" {
+		return
+	}
+	if dropped, err := store.drop(pubKey); err != nil {
+		Logln("BBMTLog", "failed to invalidate presignatures:", err)
+	} else if dropped > 0 {
+		Logln("BBMTLog", "invalidated", dropped, "presignatures")
+	}
+}
+
+// file is where the presignature id of the local party of pubKey is stored.
+// The name doesn't reveal the wallet or the session.
+func (p *presignStore) file(pubKey, localPartyKey, id string) (string, error) {
+	prefix, err := pubKeyFingerprint(pubKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(presignatureAD + "\n" + pubKey + "\n" + localPartyKey + "\n" + id))
+	return filepath.Join(p.dir, prefix+"-"+hex.EncodeToString(sum[:16])+presignatureExt), nil
+}
+
+func (p *presignStore) files(pubKey string) ([]string, error) {
+	prefix, err := pubKeyFingerprint(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Glob(filepath.Join(p.dir, prefix+"-*"+presignatureExt))
+}
+
+func (p *presignStore) save(pre *presignature) error {
+	file, err := p.file(pre.PubKey, pre.LocalPartyKey, pre.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("presignature %s already exists", pre.ID)
+	}
+	plaintext, err := json.Marshal(pre)
+	if err != nil {
+		return fmt.Errorf("failed to marshal presignature: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(p.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	data := aead.Seal(nonce, nonce, plaintext, []byte(presignatureAD))
+
+	// write to a temp file first, so a half written file is never used
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write presignature: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store presignature: %w", err)
+	}
+	return nil
+}
+
+func (p *presignStore) load(file string) (*presignature, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presignature: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(p.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("truncated presignature")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(presignatureAD))
+	if err != nil {
+		return nil, errors.New("failed to decrypt presignature, wrong store key?")
+	}
+	var pre presignature
+	if err := json.Unmarshal(plaintext, &pre); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal presignature: %w", err)
+	}
+	if pre.K == nil || pre.Sigma == nil || pre.RX == nil || pre.RY == nil {
+		return nil, errors.New("incomplete presignature")
+	}
+	if pre.DerivePath == "" || len(pre.Points) != len(pre.Committee) {
+		return nil, errors.New("presignature of an older version without derive path binding, presign again")
+	}
+	return &pre, nil
+}
+
+// peek loads the presignature id of the local party of pubKey without
+// claiming it.
+func (p *presignStore) peek(pubKey, localPartyKey, id string) (*presignature, error) {
+	file, err := p.file(pubKey, localPartyKey, id)
+	if err != nil {
+		return nil, err
+	}
+	pre, err := p.load(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("presignature %s is not available, it was used or never stored", id)
+	}
+	return pre, err
+}
+
+// take claims the presignature id of the local party of pubKey by deleting
+// its file, and returns it. Whoever deletes the file owns the presignature;
+// everyone else fails.
+func (p *presignStore) take(pubKey, localPartyKey, id string) (*presignature, error) {
+	file, err := p.file(pubKey, localPartyKey, id)
+	if err != nil {
+		return nil, err
+	}
+	pre, err := p.load(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("presignature %s is not available, it was used or never stored", id)
+	}
+	if removeErr := os.Remove(file); removeErr != nil {
+		return nil, fmt.Errorf("presignature %s is not available: %w", id, removeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pre.ID != id || pre.PubKey != pubKey || pre.LocalPartyKey != localPartyKey {
+		return nil, fmt.Errorf("presignature %s is stored under the wrong name", id)
+	}
+	return pre, nil
+}
+
+func (p *presignStore) drop(pubKey string) (int, error) {
+	files, err := p.files(pubKey)
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return dropped, fmt.Errorf("failed to delete presignature: %w", err)
+		}
+		dropped++
+	}
+	return dropped, nil
+}
@@ -0,0 +1,175 @@
+package tss
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+func openTestPresignStore(t *testing.T) {
+	t.Helper()
+	if err := OpenPresignStore(t.TempDir(), strings.Repeat("00", 32)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ClosePresignStore)
+}
+
+func testPresign(t *testing.T, signers []string, states map[string]*testState, id, derivePath string) map[string]error {
+	t.Helper()
+	net := testServices(t, signers, states)
+	return runParties(signers, func(party string) error {
+		_, err := net.service(party).PresignECDSA(&PresignRequest{
+			PubKey:               states[party].localState(t).PubKey,
+			PresignID:            id,
+			PresignCommitteeKeys: strings.Join(signers, ","),
+			LocalPartyKey:        party,
+			DerivePath:           derivePath,
+		})
+		return err
+	})
+}
+
+func testPresignedKeysign(t *testing.T, signers []string, states map[string]*testState, id, derivePath string, message []byte) (map[string]*KeysignResponse, map[string]error) {
+	t.Helper()
+	net := testServices(t, signers, states)
+	var mu sync.Mutex
+	responses := make(map[string]*KeysignResponse)
+	errs := runParties(signers, func(party string) error {
+		resp, err := net.service(party).KeysignPresignedECDSA(&PresignedKeysignRequest{
+			KeysignRequest: KeysignRequest{
+				PubKey:               states[party].localState(t).PubKey,
+				MessageToSign:        base64.StdEncoding.EncodeToString(message),
+				KeysignCommitteeKeys: strings.Join(signers, ","),
+				LocalPartyKey:        party,
+				DerivePath:           derivePath,
+			},
+			PresignID: id,
+		})
+		mu.Lock()
+		responses[party] = resp
+		mu.Unlock()
+		return err
+	})
+	return responses, errs
+}
+
+func TestPresignSingleUse(t *testing.T) {
+	states := testKeyshares(t)
+	openTestPresignStore(t)
+	signers := []string{"partyC", "partyA"}
+	derivePath := "m/44/0/0/0/0"
+	requireNoErrors(t, testPresign(t, signers, states, "presign-1", derivePath))
+
+	// another path is refused and leaves the presignature in place
+	_, errs := testPresignedKeysign(t, signers, states, "presign-1", "m/44/0/0/0/1", testMessage(5))
+	for party, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "signs for "+derivePath+" only") {
+			t.Fatalf("%s: other path: %v", party, err)
+		}
+	}
+
+	responses, errs := testPresignedKeysign(t, signers, states, "presign-1", derivePath, testMessage(5))
+	requireNoErrors(t, errs)
+	requireSignature(t, states, "partyA", derivePath, responses["partyA"])
+
+	// the presignature is gone, even for the same message
+	_, errs = testPresignedKeysign(t, signers, states, "presign-1", derivePath, testMessage(5))
+	for party, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "not available") {
+			t.Fatalf("%s: reuse: %v", party, err)
+		}
+	}
+	if list, err := PresignatureList(states["partyA"].localState(t).PubKey); err != nil || list != "[]" {
+		t.Fatalf("presignatures left: %s %v", list, err)
+	}
+}
+
+func TestPresignInvalidatedByRefresh(t *testing.T) {
+	states := testKeyshares(t)
+	openTestPresignStore(t)
+	signers := []string{"partyA", "partyB"}
+	derivePath := "m/44/0/0/0/0"
+	requireNoErrors(t, testPresign(t, signers, states, "presign-2", derivePath))
+
+	// a refresh bumps the share epoch
+	for _, party := range signers {
+		localState := states[party].localState(t)
+		localState.ShareEpoch++
+		states[party].setLocalState(t, &localState)
+	}
+	_, errs := testPresignedKeysign(t, signers, states, "presign-2", derivePath, testMessage(6))
+	for party, err := range errs {
+		var invalidated ErrPresignatureInvalidated
+		if !errors.As(err, &invalidated) {
+			t.Fatalf("%s: want ErrPresignatureInvalidated, got %v", party, err)
+		}
+	}
+	if dropped, err := InvalidatePresignatures(states["partyA"].localState(t).PubKey); err != nil || dropped != 2 {
+		t.Fatalf("dropped %d: %v", dropped, err)
+	}
+}
+
+func TestPresignBlamesInvalidShare(t *testing.T) {
+	states := testKeyshares(t)
+	openTestPresignStore(t)
+	signers := []string{"partyA", "partyB"}
+	derivePath := "m/44/0/0/0/0"
+	requireNoErrors(t, testPresign(t, signers, states, "presign-3", derivePath))
+
+	// partyB's sigma_i goes bad, so does its s_i
+	store, err := openedPresignStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := states["partyB"].localState(t).PubKey
+	pre, err := store.take(pubKey, "partyB", "presign-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pre.Sigma = new(big.Int).Add(pre.Sigma, big.NewInt(1))
+	if err := store.save(pre); err != nil {
+		t.Fatal(err)
+	}
+
+	_, errs := testPresignedKeysign(t, signers, states, "presign-3", derivePath, testMessage(7))
+	var blame *BlameError
+	if !errors.As(errs["partyA"], &blame) || blame.Round != presignRoundSign || len(blame.Culprits) != 1 || blame.Culprits[0] != "partyB" {
+		t.Fatalf("partyA: want partyB blamed, got %v", errs["partyA"])
+	}
+	if errs["partyB"] == nil {
+		t.Fatal("partyB returned a signature")
+	}
+}
+
+func TestPresignShareValid(t *testing.T) {
+	ec := tss.S256()
+	modN := common.ModInt(ec.Params().N)
+	R := crypto.ScalarBaseMult(ec, common.GetRandomPositiveInt(rand.Reader, ec.Params().N))
+	k := common.GetRandomPositiveInt(rand.Reader, ec.Params().N)
+	sigma := common.GetRandomPositiveInt(rand.Reader, ec.Params().N)
+	pointK, pointS := R.ScalarMult(k), R.ScalarMult(sigma)
+	points := presignPoints{KX: pointK.X(), KY: pointK.Y(), SX: pointS.X(), SY: pointS.Y()}
+	m, r := big.NewInt(1234), R.X()
+
+	si := modN.Add(modN.Mul(m, k), modN.Mul(r, sigma))
+	if !presignShareValid(R, points, m, r, si) {
+		t.Fatal("valid s_i rejected")
+	}
+	if presignShareValid(R, points, m, r, modN.Add(si, big.NewInt(1))) {
+		t.Fatal("invalid s_i accepted")
+	}
+	if presignShareValid(R, points, big.NewInt(1235), r, si) {
+		t.Fatal("s_i of another message accepted")
+	}
+	if presignShareValid(R, presignPoints{KX: big.NewInt(1), KY: big.NewInt(1), SX: pointS.X(), SY: pointS.Y()}, m, r, si) {
+		t.Fatal("point off the curve accepted")
+	}
+}
@@ -1,20 +1,20 @@
 package tss
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/patrickmn/go-cache"
 )
 
-// Global Cache
-var dataCache = cache.New(5*time.Minute, 10*time.Minute)
-
 // Mutex for safe concurrent operations
 var mutex sync.Mutex
 
@@ -24,8 +24,10 @@ type Session struct {
 	Participants []string `json:"participants"`
 }
 
-// Message structure
+// Message structure. ID is assigned by the relay, increasing per session, so
+// parties fetch "after" the last ID they saw and acknowledge up to it.
 type Message struct {
+	ID        uint64   `json:"id,omitempty"`
 	SessionID string   `json:"session_id,omitempty"`
 	From      string   `json:"from,omitempty"`
 	To        []string `json:"to,omitempty"`
@@ -61,24 +63,57 @@ func postSession(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "sessionID is required", http.StatusBadRequest)
 		return
 	}
+	party, ok := authorize(w, r, sessionID)
+	if !ok {
+		return
+	}
 
 	var participants []string
 	if err := json.NewDecoder(r.Body).Decode(&participants); err != nil {
 		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
 		return
 	}
+	if party != "" && !(len(participants) == 1 && participants[0] == party) {
+		http.Error(w, "members can only register themselves", http.StatusForbidden)
+		return
+	}
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	if !checkSessionOpen(w, sessionID) {
+		return
+	}
+	lc, _ := loadLifecycle(sessionID)
+
+	// joins are idempotent, retries do not duplicate participants
 	key := "session-" + sessionID
-	if session, found := getData(key); found {
-		existingSession := session.(Session)
-		existingSession.Participants = append(existingSession.Participants, participants...)
-		setData(key, existingSession)
-	} else {
-		setData(key, Session{SessionID: sessionID, Participants: participants})
+	session := Session{SessionID: sessionID}
+	getData(key, &session)
+	for _, p := range participants {
+		if lc.full(p) {
+			http.Error(w, "session is full", http.StatusConflict)
+			return
+		}
+		if !Contains(session.Participants, p) {
+			session.Participants = append(session.Participants, p)
+		}
+		if !Contains(lc.Participants, p) {
+			lc.Participants = append(lc.Participants, p)
+		}
 	}
+	if party != "" {
+		refreshACL(sessionID)
+	}
+	if err := setData(key, session); err != nil {
+		http.Error(w, "failed to store session", http.StatusInternalServerError)
+		return
+	}
+	if err := saveLifecycle(sessionID, lc); err != nil {
+		http.Error(w, "failed to store session", http.StatusInternalServerError)
+		return
+	}
+	notifySession(sessionID)
 
 	w.WriteHeader(http.StatusCreated)
 	pf("Session %s registered with participants: %v", sessionID, participants)
@@ -87,10 +122,14 @@ func postSession(w http.ResponseWriter, r *http.Request) {
 func getSession(w http.ResponseWriter, r *http.Request) {
 	sessionID := getSessionID(r)
 	key := "session-" + sessionID
+	if _, ok := authorize(w, r, sessionID); !ok {
+		return
+	}
 
-	if session, found := getData(key); found {
+	var session Session
+	if getData(key, &session) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(session.(Session).Participants)
+		json.NewEncoder(w).Encode(session.Participants)
 		return
 	}
 
@@ -100,18 +139,37 @@ func getSession(w http.ResponseWriter, r *http.Request) {
 func deleteSession(w http.ResponseWriter, r *http.Request) {
 	sessionID := getSessionID(r)
 	key := "session-" + sessionID
+	if _, ok := authorize(w, r, sessionID); !ok {
+		return
+	}
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	dataCache.Delete(key)
-	dataCache.Delete(key + "-start")
+	deleteData(key)
+	deleteData(key + "-start")
+	// the lifecycle stays for the completion flags
+	if lc, found := loadLifecycle(sessionID); found && !lc.Ended {
+		lc.Ended = true
+		saveLifecycle(sessionID, lc)
+	}
+	notifySession(sessionID)
 	w.WriteHeader(http.StatusOK)
 	pf("Session %s deleted", sessionID)
 }
 
 func completedKeysign(w http.ResponseWriter, r *http.Request) {
 	sessionID := getSessionID(r)
+	party, ok := authorize(w, r, sessionID)
+	if !ok {
+		return
+	}
+	if partyID := r.Header.Get("party_id"); party == "" {
+		party = partyID
+	} else if partyID != "" && partyID != party {
+		http.Error(w, "members can only flag their own completion", http.StatusForbidden)
+		return
+	}
 
 	// Read 'message' header and 'body' from the request body
 	messageID := r.Header.Get("message_id")
@@ -128,13 +186,18 @@ func completedKeysign(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// Example operation to mark local party keysign complete (you can change it to suit your logic)
-	keysignCompleteKey := "keysign-complete-" + sessionID
-	setData(keysignCompleteKey, map[string]interface{}{
-		"sessionID": sessionID,
-		"messageID": messageID,
-		"body":      string(bodyBytes),
-	})
+	// Mark the party's keysign complete, with its signature
+	if party == "" {
+		party = "unknown"
+	}
+	if err := flagCompletion(sessionID, party, SessionCompletion{
+		Kind:      SessionTypeKeysign,
+		MessageID: messageID,
+		Result:    string(bodyBytes),
+	}); err != nil {
+		http.Error(w, "failed to store keysign completion", http.StatusInternalServerError)
+		return
+	}
 
 	// Respond to client
 	w.WriteHeader(http.StatusOK)
@@ -143,6 +206,10 @@ func completedKeysign(w http.ResponseWriter, r *http.Request) {
 
 func completedKeygen(w http.ResponseWriter, r *http.Request) {
 	sessionID := getSessionID(r)
+	party, ok := authorize(w, r, sessionID)
+	if !ok {
+		return
+	}
 
 	// Read request body (local party ID)
 	var localPartyID []string
@@ -150,10 +217,16 @@ func completedKeygen(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid or missing localPartyID", http.StatusBadRequest)
 		return
 	}
+	if party != "" && localPartyID[0] != party {
+		http.Error(w, "members can only flag their own completion", http.StatusForbidden)
+		return
+	}
 
-	// Save the completed local party ID to session data (this can be an operation to mark a local party's completion)
-	partyCompletionKey := "local-party-complete-" + sessionID
-	setData(partyCompletionKey, localPartyID)
+	// Mark the local party's keygen complete, its keyshare is saved
+	if err := flagCompletion(sessionID, localPartyID[0], SessionCompletion{Kind: SessionTypeKeygen}); err != nil {
+		http.Error(w, "failed to store keygen completion", http.StatusInternalServerError)
+		return
+	}
 
 	// Respond to client
 	w.WriteHeader(http.StatusCreated)
@@ -164,42 +237,173 @@ func completedKeygen(w http.ResponseWriter, r *http.Request) {
 func postMessage(w http.ResponseWriter, r *http.Request) {
 	sessionID := getSessionID(r)
 	key := "message-" + sessionID
+	party, ok := authorize(w, r, sessionID)
+	if !ok {
+		return
+	}
 
 	var msg Message
 	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
 		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
 		return
 	}
+	if party != "" {
+		var acl SessionACL
+		getData("acl-"+sessionID, &acl)
+		if msg.From != party {
+			http.Error(w, "members can only send as themselves", http.StatusForbidden)
+			return
+		}
+		for _, to := range msg.To {
+			if _, member := acl.Members[to]; !member {
+				http.Error(w, fmt.Sprintf("%q is not a session member", to), http.StatusForbidden)
+				return
+			}
+		}
+	}
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	if !checkSessionOpen(w, sessionID) {
+		return
+	}
 	var messages []Message
-	if data, found := getData(key); found {
-		messages = data.([]Message)
+	getData(key, &messages)
+	index := messageIndex{Seen: map[string]uint64{}}
+	getData("msgindex-"+sessionID, &index)
+	if index.Seen == nil {
+		index.Seen = map[string]uint64{}
+	}
+
+	// a retried post gets the ID of the first one
+	if id, dup := index.lookup(messages, msg); dup {
+		writeMessageID(w, id)
+		pf("Duplicate message in session %s from %s seq %s, id %d", sessionID, msg.From, msg.SeqNo, id)
+		return
+	}
+	for _, m := range messages {
+		index.LastID = max(index.LastID, m.ID)
 	}
+	index.LastID++
+	msg.ID = index.LastID
+
+	// messages first, lookup finds them if the index write fails
 	messages = append(messages, msg)
-	setData(key, messages)
+	if err := setData(key, messages); err != nil {
+		http.Error(w, "failed to store message", http.StatusInternalServerError)
+		return
+	}
+	if msg.SeqNo != "" {
+		index.Seen[msg.From+"/"+msg.SeqNo] = msg.ID
+	}
+	if err := setData("msgindex-"+sessionID, index); err != nil {
+		http.Error(w, "failed to store message index", http.StatusInternalServerError)
+		return
+	}
+	if party != "" {
+		refreshACL(sessionID)
+	}
+	refreshLifecycle(sessionID)
+	notifySession(sessionID)
 
-	w.WriteHeader(http.StatusOK)
-	pf("Message added to session %s: %+v", sessionID, msg)
+	writeMessageID(w, msg.ID)
+	pf("Message %d added to session %s: %+v", msg.ID, sessionID, msg)
+}
+
+// messageIndex numbers the messages of a session and remembers the ID of
+// every sender sequence number, so retried posts are not delivered twice
+// even after the first one was acknowledged.
+type messageIndex struct {
+	LastID uint64            `json:"lastID"`
+	Seen   map[string]uint64 `json:"seen"` // from/seq to message ID
+}
+
+// lookup finds an earlier post of msg by its sender and sequence number.
+func (index messageIndex) lookup(messages []Message, msg Message) (uint64, bool) {
+	if msg.SeqNo == "" {
+		return 0, false
+	}
+	if id, ok := index.Seen[msg.From+"/"+msg.SeqNo]; ok {
+		return id, true
+	}
+	for _, m := range messages {
+		if m.From == msg.From && m.SeqNo == msg.SeqNo {
+			return m.ID, true
+		}
+	}
+	return 0, false
+}
+
+func writeMessageID(w http.ResponseWriter, id uint64) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]uint64{"id": id})
+}
+
+// releaseMessages removes participantKey from the recipients of the
+// messages matching release and drops messages left without recipients.
+func releaseMessages(messages []Message, participantKey string, release func(Message) bool) ([]Message, int) {
+	kept := []Message{}
+	released := 0
+	for _, msg := range messages {
+		if !release(msg) || !Contains(msg.To, participantKey) {
+			kept = append(kept, msg)
+			continue
+		}
+		released++
+		to := []string{}
+		for _, t := range msg.To {
+			if t != participantKey {
+				to = append(to, t)
+			}
+		}
+		if len(to) > 0 {
+			msg.To = to
+			kept = append(kept, msg)
+		}
+	}
+	return kept, released
+}
+
+// messageCursor is the ID after which a participant wants messages, from
+// the "after" query parameter or the stream's Last-Event-ID.
+func messageCursor(r *http.Request) (uint64, error) {
+	after := r.URL.Query().Get("after")
+	if after == "" {
+		after = r.Header.Get("Last-Event-ID")
+	}
+	if after == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(after, 10, 64)
 }
 
 func getMessage(w http.ResponseWriter, r *http.Request) {
 	sessionID := getSessionID(r)
 	participantKey := getKeyParam(r)
 	key := "message-" + sessionID
+	if party, ok := authorize(w, r, sessionID); !ok {
+		return
+	} else if party != "" && party != participantKey {
+		http.Error(w, "members can only read their own messages", http.StatusForbidden)
+		return
+	}
 
-	if data, found := getData(key); found {
-		messages := data.([]Message)
+	after, err := messageCursor(r)
+	if err != nil {
+		http.Error(w, "invalid message cursor", http.StatusBadRequest)
+		return
+	}
+
+	var messages []Message
+	if getData(key, &messages) {
 		filtered := []Message{}
 		for _, msg := range messages {
-			for _, to := range msg.To {
-				if to == participantKey {
-					filtered = append(filtered, msg)
-				}
+			if (after == 0 || msg.ID > after) && Contains(msg.To, participantKey) {
+				filtered = append(filtered, msg)
 			}
 		}
+		sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].ID < filtered[j].ID })
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(filtered)
 		return
@@ -213,19 +417,30 @@ func deleteTssMessage(w http.ResponseWriter, r *http.Request) {
 	participantKey := getKeyParam(r)
 	hash := getHashParam(r)
 	key := "message-" + sessionID
+	if party, ok := authorize(w, r, sessionID); !ok {
+		return
+	} else if party != "" && party != participantKey {
+		http.Error(w, "members can only delete their own messages", http.StatusForbidden)
+		return
+	}
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	if data, found := getData(key); found {
-		messages := data.([]Message)
+	var messages []Message
+	if getData(key, &messages) {
+		// senders withdraw their message, recipients release their copy
 		filtered := []Message{}
 		for _, msg := range messages {
 			if !(msg.Hash == hash && msg.From == participantKey) {
 				filtered = append(filtered, msg)
 			}
 		}
-		setData(key, filtered)
+		filtered, _ = releaseMessages(filtered, participantKey, func(msg Message) bool { return msg.Hash == hash })
+		if err := setData(key, filtered); err != nil {
+			http.Error(w, "failed to store messages", http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		pf("Message deleted from session %s by %s with hash %s", sessionID, participantKey, hash)
 		return
@@ -234,67 +449,140 @@ func deleteTssMessage(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "message not found", http.StatusNotFound)
 }
 
-// ---- Utility Functions ----
-func setData(key string, value interface{}) {
-	dataCache.Set(key, value, cache.DefaultExpiration)
-}
+// ackTssMessages releases every message of participantKey up to the
+// acknowledged ID.
+func ackTssMessages(w http.ResponseWriter, r *http.Request) {
+	sessionID := getSessionID(r)
+	participantKey := getKeyParam(r)
+	key := "message-" + sessionID
+	if party, ok := authorize(w, r, sessionID); !ok {
+		return
+	} else if party != "" && party != participantKey {
+		http.Error(w, "members can only acknowledge their own messages", http.StatusForbidden)
+		return
+	}
 
-func getData(key string) (interface{}, bool) {
-	return dataCache.Get(key)
-}
+	var ack struct {
+		ID uint64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&ack); err != nil || ack.ID == 0 {
+		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+		return
+	}
 
-// ==============================
-// 🚀 Server Initialization
-// ==============================
-func listen(port string) *http.Server {
-	r := mux.NewRouter()
+	mutex.Lock()
+	defer mutex.Unlock()
 
-	// Session Routes
-	r.HandleFunc("/{sessionID}", postSession).Methods("POST")
-	r.HandleFunc("/{sessionID}", getSession).Methods("GET")
-	r.HandleFunc("/{sessionID}", deleteSession).Methods("DELETE")
+	var messages []Message
+	getData(key, &messages)
+	messages, released := releaseMessages(messages, participantKey, func(msg Message) bool { return msg.ID <= ack.ID })
+	if released > 0 {
+		if err := setData(key, messages); err != nil {
+			http.Error(w, "failed to store messages", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	// Handlers for session completions
-	r.HandleFunc("/complete/keysign/{sessionID}", completedKeysign).Methods("POST")
-	r.HandleFunc("/complete/keygen/{sessionID}", completedKeygen).Methods("POST")
+	w.WriteHeader(http.StatusOK)
+	pf("Messages of %s in session %s acknowledged up to %d, %d released", participantKey, sessionID, ack.ID, released)
+}
 
-	// Message Routes
-	r.HandleFunc("/message/{sessionID}", postMessage).Methods("POST")
-	r.HandleFunc("/message/{sessionID}/{participantKey}", getMessage).Methods("GET")
-	r.HandleFunc("/message/{sessionID}/{participantKey}/{hash}", deleteTssMessage).Methods("DELETE")
+// ---- Utility Functions ----
+func setData(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", key, err)
+	}
+	if err := currentRelayStore().Set(key, data); err != nil {
+		pf("Failed to store %s: %v", key, err)
+		return err
+	}
+	return nil
+}
 
-	server := &http.Server{
-		Addr:    "0.0.0.0:" + port,
-		Handler: r,
+// setDataUntil stores value until expires, or for the store's TTL when the
+// store can't keep it longer.
+func setDataUntil(key string, value interface{}, expires time.Time) error {
+	expirer, ok := currentRelayStore().(RelayStoreExpirer)
+	if !ok {
+		return setData(key, value)
 	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", key, err)
+	}
+	if err := expirer.SetUntil(key, data, expires); err != nil {
+		pf("Failed to store %s: %v", key, err)
+		return err
+	}
+	return nil
+}
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
-		}
-	}()
+// getData loads key into value and reports whether it was found.
+func getData(key string, value interface{}) bool {
+	data, err := currentRelayStore().Get(key)
+	if err != nil {
+		pf("Failed to load %s: %v", key, err)
+		return false
+	}
+	if data == nil {
+		return false
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		pf("Failed to decode %s: %v", key, err)
+		return false
+	}
+	return true
+}
 
-	return server
+func deleteData(key string) {
+	if err := currentRelayStore().Delete(key); err != nil {
+		pf("Failed to delete %s: %v", key, err)
+	}
 }
 
-var server *http.Server = nil
+// ==============================
+// 🚀 Server Initialization
+// ==============================
+
+// relayServer is the relay run by RunRelay.
+var relayServer *RelayServer = nil
 
+// relayAdvertiser advertises relayServer on the LAN over mDNS.
+var relayAdvertiser *mdnsAdvertiser
+
+// RunRelay runs the relay on port with the default limits, replacing a
+// running one.
 func RunRelay(port string) (string, error) {
-	if server != nil {
+	if relayServer != nil {
 		StopRelay()
 	}
-	time.Sleep(time.Second)
-	go func() {
-		server = listen(port)
-	}()
+	s, err := NewRelayServer(RelayConfig{Addr: "0.0.0.0:" + port})
+	if err != nil {
+		return "", err
+	}
+	if err := s.Start(); err != nil {
+		return "", err
+	}
+	relayServer = s
+	// LAN peers find the relay over mDNS, without it they scan the subnet
+	if adv, err := advertiseService(DiscoveryRoleRelay, "", "", port, currentDiscoveryHint()); err != nil {
+		Logln("BBMTLog", "relay not advertised over mDNS:", err)
+	} else {
+		relayAdvertiser = adv
+	}
 	return "ok", nil
 }
 
 func StopRelay() (string, error) {
-	if server == nil {
+	if relayServer == nil {
 		return "already_closed", nil
 	}
-	server.Close()
-	server = nil
+	relayAdvertiser.stop()
+	relayAdvertiser = nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	relayServer.Shutdown(ctx)
+	relayServer = nil
 	return "ok", nil
 }
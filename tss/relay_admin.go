@@ -0,0 +1,390 @@
+package tss
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Operational endpoints of the relay:
+//
+//	GET    /healthz                  liveness and store check, no auth
+//	GET    /metrics                  Prometheus text format, no auth
+//	GET    /admin/sessions           list sessions
+//	GET    /admin/sessions/{id}      one session
+//	DELETE /admin/sessions/{id}      purge a session
+//	DELETE /admin/sessions           purge all sessions, ?completed only
+//	                                 the ones with a completion flag
+//
+// The admin API needs "Authorization: Bearer <RelayConfig.AdminToken>" and
+// is not served without a token.
+
+// relayKeyPrefixes are the store keys of a session, by prefix.
+var relayKeyPrefixes = []string{"session-", "message-", "msgindex-", "acl-", "lifecycle-"}
+
+// latencyBuckets are the request duration histogram bounds in seconds; the
+// long ones are for streams.
+var latencyBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 30, 120}
+
+// relayMetrics collects the request metrics of a RelayServer.
+type relayMetrics struct {
+	mu       sync.Mutex
+	started  time.Time
+	inFlight int
+	requests map[requestLabels]uint64
+	bytesIn  map[string]uint64
+	bytesOut map[string]uint64
+	latency  map[string]*latencyHistogram
+}
+
+type requestLabels struct {
+	route, method string
+	code          int
+}
+
+type latencyHistogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newRelayMetrics() *relayMetrics {
+	return &relayMetrics{
+		started:  time.Now(),
+		requests: make(map[requestLabels]uint64),
+		bytesIn:  make(map[string]uint64),
+		bytesOut: make(map[string]uint64),
+		latency:  make(map[string]*latencyHistogram),
+	}
+}
+
+func (m *relayMetrics) begin() {
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+}
+
+func (m *relayMetrics) observe(route, method string, code int, bytesIn, bytesOut int64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	m.requests[requestLabels{route, method, code}]++
+	if bytesIn > 0 {
+		m.bytesIn[route] += uint64(bytesIn)
+	}
+	m.bytesOut[route] += uint64(bytesOut)
+	h, ok := m.latency[route]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[route] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// routeTemplate is the route of a request for metric labels, so session IDs
+// do not blow up the label space.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
+
+// promLabel escapes a Prometheus label value.
+func promLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// ---- Session Inventory ----
+
+// relaySessionInfo is what the admin API reports of a session.
+type relaySessionInfo struct {
+	SessionID       string   `json:"sessionID"`
+	Type            string   `json:"type,omitempty"`
+	State           string   `json:"state,omitempty"`
+	Participants    []string `json:"participants"`
+	Messages        int      `json:"messages"`
+	MessageBytes    int      `json:"messageBytes"`
+	ACL             bool     `json:"acl"`
+	KeygenComplete  bool     `json:"keygenComplete"`
+	KeysignComplete bool     `json:"keysignComplete"`
+}
+
+func (i relaySessionInfo) completed() bool {
+	return i.KeygenComplete || i.KeysignComplete
+}
+
+// relaySessionIDs lists the sessions with any data in the store.
+func relaySessionIDs() ([]string, error) {
+	lister, ok := currentRelayStore().(RelayStoreLister)
+	if !ok {
+		return nil, fmt.Errorf("relay store cannot list its keys")
+	}
+	seen := make(map[string]bool)
+	for _, prefix := range relayKeyPrefixes {
+		keys, err := lister.Keys(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			id := strings.TrimPrefix(key, prefix)
+			if prefix == "session-" && strings.HasSuffix(id, "-start") {
+				continue
+			}
+			seen[id] = true
+		}
+	}
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func relaySession(sessionID string) relaySessionInfo {
+	info := relaySessionInfo{SessionID: sessionID, Participants: []string{}}
+	var session Session
+	if getData("session-"+sessionID, &session) && session.Participants != nil {
+		info.Participants = session.Participants
+	}
+	var messages []Message
+	getData("message-"+sessionID, &messages)
+	info.Messages = len(messages)
+	for _, msg := range messages {
+		info.MessageBytes += len(msg.Body)
+	}
+	info.ACL = keyExists("acl-" + sessionID)
+	if lc, found := loadLifecycle(sessionID); found {
+		status := lc.status(sessionID, time.Now())
+		info.Type = status.Type
+		info.State = status.State
+		for _, completion := range status.Completed {
+			info.KeygenComplete = info.KeygenComplete || completion.Kind == SessionTypeKeygen
+			info.KeysignComplete = info.KeysignComplete || completion.Kind == SessionTypeKeysign
+		}
+		if len(info.Participants) == 0 {
+			info.Participants = status.Participants
+		}
+	}
+	return info
+}
+
+func keyExists(key string) bool {
+	data, err := currentRelayStore().Get(key)
+	return err == nil && data != nil
+}
+
+// purgeRelaySession removes every key of sessionID and closes its streams'
+// view of it.
+func (s *RelayServer) purgeRelaySession(sessionID string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, prefix := range relayKeyPrefixes {
+		deleteData(prefix + sessionID)
+	}
+	deleteData("session-" + sessionID + "-start")
+	s.sessions.remove(sessionID)
+	notifySession(sessionID)
+}
+
+// ---- Handlers ----
+
+func (s *RelayServer) healthz(w http.ResponseWriter, r *http.Request) {
+	status, code := "ok", http.StatusOK
+	if _, err := currentRelayStore().Get("healthz"); err != nil {
+		s.log.Warn("relay store unhealthy", "error", err)
+		status, code = "store unavailable", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         status,
+		"uptime_seconds": int64(time.Since(s.metrics.started).Seconds()),
+	})
+}
+
+func (s *RelayServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	// store inventory, when the store can list it
+	if ids, err := relaySessionIDs(); err == nil {
+		var messages, messageBytes, keygens, keysigns int
+		for _, id := range ids {
+			info := relaySession(id)
+			messages += info.Messages
+			messageBytes += info.MessageBytes
+			if info.KeygenComplete {
+				keygens++
+			}
+			if info.KeysignComplete {
+				keysigns++
+			}
+		}
+		gauge("bbmt_relay_sessions", "Sessions with data in the relay store.", float64(len(ids)))
+		gauge("bbmt_relay_messages", "Messages waiting in the relay store.", float64(messages))
+		gauge("bbmt_relay_message_bytes", "Body bytes of the messages waiting in the relay store.", float64(messageBytes))
+		fmt.Fprintf(&b, "# HELP bbmt_relay_completions Completion flags held in the relay store.\n# TYPE bbmt_relay_completions gauge\n")
+		fmt.Fprintf(&b, "bbmt_relay_completions{kind=\"keygen\"} %d\nbbmt_relay_completions{kind=\"keysign\"} %d\n", keygens, keysigns)
+	}
+	gauge("bbmt_relay_active_sessions", "Sessions with requests within the relay TTL.", float64(s.sessions.active()))
+
+	m := s.metrics
+	m.mu.Lock()
+	gauge("bbmt_relay_uptime_seconds", "Seconds since the relay started.", time.Since(m.started).Seconds())
+	gauge("bbmt_relay_requests_in_flight", "Requests being served, including open streams.", float64(m.inFlight))
+
+	requests := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		requests = append(requests, l)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, c := requests[i], requests[j]
+		if a.route != c.route {
+			return a.route < c.route
+		}
+		if a.method != c.method {
+			return a.method < c.method
+		}
+		return a.code < c.code
+	})
+	fmt.Fprintf(&b, "# HELP bbmt_relay_requests_total Requests by route, method and status code.\n# TYPE bbmt_relay_requests_total counter\n")
+	for _, l := range requests {
+		fmt.Fprintf(&b, "bbmt_relay_requests_total{route=\"%s\",method=\"%s\",code=\"%d\"} %d\n", promLabel(l.route), l.method, l.code, m.requests[l])
+	}
+	failures := make(map[[2]string]uint64)
+	for l, n := range m.requests {
+		if l.code >= 400 {
+			failures[[2]string{l.route, strconv.Itoa(l.code)}] += n
+		}
+	}
+	errorKeys := make([][2]string, 0, len(failures))
+	for k := range failures {
+		errorKeys = append(errorKeys, k)
+	}
+	sort.Slice(errorKeys, func(i, j int) bool {
+		return errorKeys[i][0]+" "+errorKeys[i][1] < errorKeys[j][0]+" "+errorKeys[j][1]
+	})
+	fmt.Fprintf(&b, "# HELP bbmt_relay_errors_total Failed requests (status 400 and up) by route and status code.\n# TYPE bbmt_relay_errors_total counter\n")
+	for _, k := range errorKeys {
+		fmt.Fprintf(&b, "bbmt_relay_errors_total{route=\"%s\",code=\"%s\"} %d\n", promLabel(k[0]), k[1], failures[k])
+	}
+
+	routes := make([]string, 0, len(m.latency))
+	for route := range m.latency {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	fmt.Fprintf(&b, "# HELP bbmt_relay_request_bytes_total Request body bytes by route.\n# TYPE bbmt_relay_request_bytes_total counter\n")
+	for _, route := range routes {
+		fmt.Fprintf(&b, "bbmt_relay_request_bytes_total{route=\"%s\"} %d\n", promLabel(route), m.bytesIn[route])
+	}
+	fmt.Fprintf(&b, "# HELP bbmt_relay_response_bytes_total Response body bytes by route.\n# TYPE bbmt_relay_response_bytes_total counter\n")
+	for _, route := range routes {
+		fmt.Fprintf(&b, "bbmt_relay_response_bytes_total{route=\"%s\"} %d\n", promLabel(route), m.bytesOut[route])
+	}
+	fmt.Fprintf(&b, "# HELP bbmt_relay_request_duration_seconds Request latencies by route.\n# TYPE bbmt_relay_request_duration_seconds histogram\n")
+	for _, route := range routes {
+		h := m.latency[route]
+		label := promLabel(route)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "bbmt_relay_request_duration_seconds_bucket{route=\"%s\",le=\"%s\"} %d\n", label, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "bbmt_relay_request_duration_seconds_bucket{route=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "bbmt_relay_request_duration_seconds_sum{route=\"%s\"} %s\n", label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "bbmt_relay_request_duration_seconds_count{route=\"%s\"} %d\n", label, h.count)
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// requireAdmin guards the admin API with the admin bearer token.
+func (s *RelayServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bbmt-relay"`)
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *RelayServer) listSessions(w http.ResponseWriter, r *http.Request) {
+	ids, err := relaySessionIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	sessions := make([]relaySessionInfo, 0, len(ids))
+	for _, id := range ids {
+		sessions = append(sessions, relaySession(id))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (s *RelayServer) getSessionInfo(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+	info := relaySession(sessionID)
+	if !keyExists("session-"+sessionID) && info.Messages == 0 && !info.ACL && info.State == "" {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *RelayServer) purgeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+	s.purgeRelaySession(sessionID)
+	s.log.Info("session purged", "session", sessionID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"purged": 1})
+}
+
+func (s *RelayServer) purgeSessions(w http.ResponseWriter, r *http.Request) {
+	ids, err := relaySessionIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	_, completedOnly := r.URL.Query()["completed"]
+	purged := 0
+	for _, id := range ids {
+		if completedOnly && !relaySession(id).completed() {
+			continue
+		}
+		s.purgeRelaySession(id)
+		purged++
+	}
+	s.log.Info("sessions purged", "count", purged, "completed_only", completedOnly)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
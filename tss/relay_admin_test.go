@@ -0,0 +1,134 @@
+package tss
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testAdminCall sends an admin API request with token, none if empty.
+func testAdminCall(t *testing.T, token, method, url string) (int, string) {
+	t.Helper()
+	req := newTestRelayRequest(t, nil, method, url, "")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return testRelayDo(t, req)
+}
+
+func TestRelayMetrics(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{AllowOpenSessions: true})
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/metrics-a", `["partyA"]`); status != http.StatusCreated {
+		t.Fatalf("join: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/metrics-b", ""); status != http.StatusNotFound {
+		t.Fatalf("unknown session: %d", status)
+	}
+
+	resp, err := http.Get(server + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("content type %s", resp.Header.Get("Content-Type"))
+	}
+	status, metrics := testRelayCall(t, nil, http.MethodGet, server+"/metrics", "")
+	if status != http.StatusOK {
+		t.Fatalf("metrics: %d", status)
+	}
+	for _, want := range []string{
+		"# TYPE bbmt_relay_requests_total counter\n",
+		`bbmt_relay_requests_total{route="/{sessionID}",method="POST",code="201"} 1` + "\n",
+		`bbmt_relay_requests_total{route="/{sessionID}",method="GET",code="404"} 1` + "\n",
+		`bbmt_relay_errors_total{route="/{sessionID}",code="404"} 1` + "\n",
+		`bbmt_relay_request_duration_seconds_count{route="/{sessionID}"} 2` + "\n",
+		`bbmt_relay_request_duration_seconds_bucket{route="/{sessionID}",le="+Inf"} 2` + "\n",
+		"bbmt_relay_sessions 1\n",
+		"bbmt_relay_requests_in_flight 1\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Fatalf("metrics miss %q:\n%s", want, metrics)
+		}
+	}
+
+	// session IDs never become labels
+	if strings.Contains(metrics, "metrics-a") {
+		t.Fatalf("session ID in the metrics:\n%s", metrics)
+	}
+}
+
+func TestRelayAdmin(t *testing.T) {
+	if status, _ := testAdminCall(t, "", http.MethodGet, newTestRelay(t, RelayConfig{AllowOpenSessions: true})+"/admin/sessions"); status != http.StatusNotFound {
+		t.Fatalf("admin API without a token: %d", status)
+	}
+
+	server := newTestRelay(t, RelayConfig{AdminToken: "secret", AllowOpenSessions: true})
+	for _, token := range []string{"", "wrong", "secret2"} {
+		if status, _ := testAdminCall(t, token, http.MethodGet, server+"/admin/sessions"); status != http.StatusUnauthorized {
+			t.Fatalf("token %q: %d", token, status)
+		}
+	}
+	req := newTestRelayRequest(t, nil, http.MethodDelete, server+"/admin/sessions", "")
+	req.Header.Set("Authorization", "secret")
+	if status, _ := testRelayDo(t, req); status != http.StatusUnauthorized {
+		t.Fatalf("token without the bearer scheme: %d", status)
+	}
+
+	for _, session := range []string{"admin-a", "admin-b"} {
+		if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/"+session, `["partyA"]`); status != http.StatusCreated {
+			t.Fatalf("join: %d", status)
+		}
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/complete/keygen/admin-a", `["partyA"]`); status != http.StatusCreated {
+		t.Fatalf("completion: %d", status)
+	}
+	msg, _ := json.Marshal(Message{From: "partyA", To: []string{"partyB"}, Body: "body", SeqNo: "1", Hash: "h1"})
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/message/admin-b", string(msg)); status != http.StatusOK {
+		t.Fatalf("post: %d", status)
+	}
+
+	list := func() map[string]relaySessionInfo {
+		t.Helper()
+		status, body := testAdminCall(t, "secret", http.MethodGet, server+"/admin/sessions")
+		if status != http.StatusOK {
+			t.Fatalf("list: %d %s", status, body)
+		}
+		var sessions []relaySessionInfo
+		if err := json.Unmarshal([]byte(body), &sessions); err != nil {
+			t.Fatal(err)
+		}
+		byID := make(map[string]relaySessionInfo)
+		for _, info := range sessions {
+			byID[info.SessionID] = info
+		}
+		return byID
+	}
+	sessions := list()
+	if len(sessions) != 2 || !sessions["admin-a"].KeygenComplete || sessions["admin-b"].Messages != 1 || sessions["admin-b"].MessageBytes != 4 {
+		t.Fatalf("sessions: %+v", sessions)
+	}
+	if status, body := testAdminCall(t, "secret", http.MethodGet, server+"/admin/sessions/admin-b"); status != http.StatusOK || !strings.Contains(body, `"participants":["partyA"]`) {
+		t.Fatalf("session: %d %s", status, body)
+	}
+	if status, _ := testAdminCall(t, "secret", http.MethodGet, server+"/admin/sessions/admin-c"); status != http.StatusNotFound {
+		t.Fatalf("unknown session: %d", status)
+	}
+
+	if status, body := testAdminCall(t, "secret", http.MethodDelete, server+"/admin/sessions?completed"); status != http.StatusOK || !strings.Contains(body, `"purged":1`) {
+		t.Fatalf("purge of completed sessions: %d %s", status, body)
+	}
+	if sessions := list(); len(sessions) != 1 || sessions["admin-b"].SessionID == "" {
+		t.Fatalf("sessions after purging completed ones: %+v", sessions)
+	}
+	if status, _ := testAdminCall(t, "secret", http.MethodDelete, server+"/admin/sessions/admin-b"); status != http.StatusOK {
+		t.Fatalf("purge: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/admin-b", ""); status != http.StatusNotFound {
+		t.Fatalf("purged session: %d", status)
+	}
+	if sessions := list(); len(sessions) != 0 {
+		t.Fatalf("sessions after the purge: %+v", sessions)
+	}
+}
@@ -0,0 +1,544 @@
+package tss
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	mecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// Relay access control. A session's members are fixed by an ACL mapping each
+// party key to a secp256k1 public key, and every request on a session with an
+// ACL must carry a signature of its method, path, query, time, nonce and
+// body by the party's key:
+//
+//	Authorization: BBMT party=<party key>,ts=<unix seconds>,nonce=<hex>,sig=<hex DER>
+//
+// The relay remembers the nonces it accepted for as long as their time is
+// valid, so a captured request can't be replayed.
+//
+// With ECIES keys of two parties each party signs with its own decryption
+// key, whose public key the other party already uses as encryption key. Both
+// sides can therefore compute the same ACL, and any of them may register it
+// first.
+//
+// Otherwise the members share a group key, derived from the session key or
+// the shared ECIES decryption key, so the relay never learns the session
+// secret. The group key only claims a member's slot in the ACL: each party
+// signs its claim with the group key and with a key of its own, generated for
+// the session, and every other request with its own key. The first claim of a
+// slot wins, so members can't sign for each other once they joined.
+
+const (
+	relayAuthScheme  = "BBMT"
+	relayAuthDomain  = "bbmt-relay-auth/v1"
+	relayAuthMaxSkew = 5 * time.Minute
+	relayNonceLen    = 16
+)
+
+// SessionACL is the member set of a relay session, party key to compressed
+// secp256k1 public key hex. With a group key, members not claimed yet have
+// no key.
+type SessionACL struct {
+	Members map[string]string `json:"members"`
+	Group   string            `json:"group,omitempty"` // compressed public key hex
+}
+
+// relayRequireAuth makes the relay refuse sessions without an ACL.
+var relayRequireAuth = true
+
+// RelayRequireAuth sets whether the relay refuses sessions that have no ACL,
+// the default. Open sessions are only for older clients on a trusted network.
+func RelayRequireAuth(required bool) (string, error) {
+	relayRequireAuth = required
+	return strconv.FormatBool(required), nil
+}
+
+// relayAuthDigest is what a request signature signs.
+func relayAuthDigest(method, path, query string, ts int64, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	digest := sha256.Sum256([]byte(strings.Join([]string{
+		relayAuthDomain, method, path, query, strconv.FormatInt(ts, 10), nonce, hex.EncodeToString(bodyHash[:]),
+	}, "\n")))
+	return digest[:]
+}
+
+// ---- Client ----
+
+// relayAuth signs a party's requests of one session.
+type relayAuth struct {
+	party    string
+	privKey  *btcec.PrivateKey
+	groupKey *btcec.PrivateKey // shared by the members, nil for a fixed ACL
+	acl      SessionACL
+}
+
+// newRelayAuth derives the request signing key and ACL of party in a session
+// of parties.
+func newRelayAuth(party string, parties []string, sessionKey, encKey, decKey string) (*relayAuth, error) {
+	acl := SessionACL{Members: make(map[string]string)}
+	var groupKey *btcec.PrivateKey
+	switch {
+	case len(sessionKey) > 0:
+		seed := sha256.Sum256([]byte(relayAuthDomain + "\nsession\n" + sessionKey))
+		groupKey, _ = btcec.PrivKeyFromBytes(seed[:])
+	case len(parties) != 2 || !Contains(parties, party):
+		// the parties share one ECIES key pair
+		privBytes, err := hex.DecodeString(decKey)
+		if err != nil || len(privBytes) != 32 {
+			return nil, fmt.Errorf("invalid decryption key")
+		}
+		groupKey, _ = btcec.PrivKeyFromBytes(privBytes)
+	default:
+		privBytes, err := hex.DecodeString(decKey)
+		if err != nil || len(privBytes) != 32 {
+			return nil, fmt.Errorf("invalid decryption key")
+		}
+		peerKey, err := hex.DecodeString(encKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		peerPub, err := btcec.ParsePubKey(peerKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		privKey, _ := btcec.PrivKeyFromBytes(privBytes)
+		for _, p := range parties {
+			if p == party {
+				acl.Members[p] = hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+			} else {
+				acl.Members[p] = hex.EncodeToString(peerPub.SerializeCompressed())
+			}
+		}
+		return &relayAuth{party: party, privKey: privKey, acl: acl}, nil
+	}
+
+	privKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate request signing key: %w", err)
+	}
+	for _, p := range parties {
+		acl.Members[p] = ""
+	}
+	acl.Members[party] = hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+	acl.Group = hex.EncodeToString(groupKey.PubKey().SerializeCompressed())
+	return &relayAuth{party: party, privKey: privKey, groupKey: groupKey, acl: acl}, nil
+}
+
+func (a *relayAuth) sign(req *http.Request, body []byte) error {
+	return a.signRequest(req, body, false)
+}
+
+// signClaim signs the request registering the ACL, also with the group key
+// to prove the party belongs to the session.
+func (a *relayAuth) signClaim(req *http.Request, body []byte) error {
+	return a.signRequest(req, body, a.groupKey != nil)
+}
+
+func (a *relayAuth) signRequest(req *http.Request, body []byte, claim bool) error {
+	nonceBytes := make([]byte, relayNonceLen)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return fmt.Errorf("failed to generate request nonce: %w", err)
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	ts := time.Now().Unix()
+	digest := relayAuthDigest(req.Method, req.URL.Path, req.URL.RawQuery, ts, nonce, body)
+	header := fmt.Sprintf("%s party=%s,ts=%d,nonce=%s,sig=%x", relayAuthScheme, url.QueryEscape(a.party), ts, nonce, mecdsa.Sign(a.privKey, digest).Serialize())
+	if claim {
+		header += fmt.Sprintf(",claim=%x", mecdsa.Sign(a.groupKey, digest).Serialize())
+	}
+	req.Header.Set("Authorization", header)
+	return nil
+}
+
+// newRelayRequest builds a relay request of session, signed when the session
+// has relay authentication.
+func newRelayRequest(ctx context.Context, session, method, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth := registry.auth(session); auth != nil {
+		if err := auth.sign(req, body); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// relayDo sends a relay request of session.
+func relayDo(session, method, url string, body []byte) (*http.Response, error) {
+	req, err := newRelayRequest(context.Background(), session, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	return relayHTTPClient.Do(req)
+}
+
+// newACLRequest builds the request registering auth's ACL of session, or
+// claiming its slot in the ACL registered by another member.
+func newACLRequest(server, session string, auth *relayAuth) (*http.Request, error) {
+	body, err := json.Marshal(auth.acl)
+	if err != nil {
+		return nil, fmt.Errorf("fail to marshal session ACL: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, server+"/acl/"+session, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := auth.signClaim(req, body); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// registerSessionACL registers the session's member set with the relay.
+// Relays without access control are tolerated.
+func registerSessionACL(server, session string) error {
+	auth := registry.auth(session)
+	if auth == nil {
+		return fmt.Errorf("no relay authentication for session %s", session)
+	}
+	req, err := newACLRequest(server, session, auth)
+	if err != nil {
+		return err
+	}
+	resp, err := relayHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fail to register session ACL: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		Logln("BBMTLog", "relay has no access control, session is open")
+		return nil
+	default:
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("relay refused session ACL: %s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// ---- Relay ----
+
+// relayNonceCache holds the nonces of accepted requests until their
+// timestamps expire.
+type relayNonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // nonce -> expiry
+	lastPrune time.Time
+}
+
+var relayNonces = &relayNonceCache{seen: make(map[string]time.Time)}
+
+// use records nonce until expires and tells whether it was new.
+func (c *relayNonceCache) use(nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastPrune) > relayAuthMaxSkew/5 {
+		for seen, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, seen)
+			}
+		}
+		c.lastPrune = now
+	}
+	if expiry, seen := c.seen[nonce]; seen && !now.After(expiry) {
+		return false
+	}
+	c.seen[nonce] = expires
+	return true
+}
+
+// relayAuthHeader is a parsed Authorization header.
+type relayAuthHeader struct {
+	party, nonce string
+	ts           int64
+	sig, claim   string // hex DER
+}
+
+func parseRelayAuth(r *http.Request) (*relayAuthHeader, error) {
+	params, ok := strings.CutPrefix(r.Header.Get("Authorization"), relayAuthScheme+" ")
+	if !ok {
+		return nil, fmt.Errorf("missing %s authorization", relayAuthScheme)
+	}
+	var h relayAuthHeader
+	for _, kv := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch k {
+		case "party":
+			h.party, _ = url.QueryUnescape(v)
+		case "ts":
+			h.ts, _ = strconv.ParseInt(v, 10, 64)
+		case "nonce":
+			h.nonce = v
+		case "sig":
+			h.sig = v
+		case "claim":
+			h.claim = v
+		}
+	}
+	return &h, nil
+}
+
+// verifyRelaySig checks a hex DER signature of digest by pubHex.
+func verifyRelaySig(pubHex, sigHex string, digest []byte) error {
+	pubKey, err := btcec.ParsePubKey(hexToBytes(pubHex))
+	if err != nil {
+		return fmt.Errorf("invalid member key: %w", err)
+	}
+	sigBytes, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	sig, err := mecdsa.ParseDERSignature(sigBytes)
+	if err != nil || !sig.Verify(digest, pubKey) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// verifyRelayAuth checks the request signature against acl and returns the
+// authenticated party.
+func verifyRelayAuth(r *http.Request, body []byte, acl SessionACL) (string, error) {
+	h, err := parseRelayAuth(r)
+	if err != nil {
+		return "", err
+	}
+	pubHex := acl.Members[h.party]
+	if pubHex == "" {
+		return "", fmt.Errorf("%q is not a session member", h.party)
+	}
+	if skew := time.Since(time.Unix(h.ts, 0)); skew > relayAuthMaxSkew || skew < -relayAuthMaxSkew {
+		return "", fmt.Errorf("authorization expired")
+	}
+	if nonceBytes, err := hex.DecodeString(h.nonce); err != nil || len(nonceBytes) != relayNonceLen {
+		return "", fmt.Errorf("invalid request nonce")
+	}
+	if err := verifyRelaySig(pubHex, h.sig, relayAuthDigest(r.Method, r.URL.Path, r.URL.RawQuery, h.ts, h.nonce, body)); err != nil {
+		return "", err
+	}
+	// only verified requests use up nonces
+	if !relayNonces.use(h.nonce, time.Unix(h.ts, 0).Add(relayAuthMaxSkew)) {
+		return "", fmt.Errorf("replayed request")
+	}
+	return h.party, nil
+}
+
+// verifyRelayClaim checks the group key signature of an ACL registration.
+func verifyRelayClaim(r *http.Request, body []byte, groupHex string) error {
+	h, err := parseRelayAuth(r)
+	if err != nil {
+		return err
+	}
+	if h.claim == "" {
+		return fmt.Errorf("missing group signature")
+	}
+	if err := verifyRelaySig(groupHex, h.claim, relayAuthDigest(r.Method, r.URL.Path, r.URL.RawQuery, h.ts, h.nonce, body)); err != nil {
+		return fmt.Errorf("invalid group signature")
+	}
+	return nil
+}
+
+// readBody reads the request body and puts it back for the handler.
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// bodyError answers a request whose body could not be read.
+func bodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "failed to read request body", http.StatusBadRequest)
+}
+
+// authorize authenticates a request on sessionID. It returns the party, ""
+// for open sessions, and false after answering the request when it is
+// refused.
+func authorize(w http.ResponseWriter, r *http.Request, sessionID string) (string, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		bodyError(w, err)
+		return "", false
+	}
+	var acl SessionACL
+	if !getData("acl-"+sessionID, &acl) {
+		if relayRequireAuth {
+			http.Error(w, "session has no access control", http.StatusUnauthorized)
+			return "", false
+		}
+		return "", true
+	}
+	party, err := verifyRelayAuth(r, body, acl)
+	if err != nil {
+		pf("Refused %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	return party, true
+}
+
+// refreshACL keeps the ACL alive as long as the session's messages.
+func refreshACL(sessionID string) {
+	var acl SessionACL
+	if getData("acl-"+sessionID, &acl) {
+		setData("acl-"+sessionID, acl)
+	}
+}
+
+// ---- ACL Handler ----
+func postSessionACL(w http.ResponseWriter, r *http.Request) {
+	sessionID := getSessionID(r)
+	body, err := readBody(r)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+	var acl SessionACL
+	if err := json.Unmarshal(body, &acl); err != nil || len(acl.Members) == 0 {
+		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if acl.Group != "" {
+		if _, err := btcec.ParsePubKey(hexToBytes(acl.Group)); err != nil {
+			http.Error(w, "invalid group key", http.StatusBadRequest)
+			return
+		}
+	}
+	for party, pubHex := range acl.Members {
+		if pubHex == "" && acl.Group != "" {
+			continue // not claimed yet
+		}
+		if _, err := btcec.ParsePubKey(hexToBytes(pubHex)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid key of %q", party), http.StatusBadRequest)
+			return
+		}
+	}
+	// the creator proves it is one of the members it lists, and with a group
+	// key that it belongs to the session
+	if acl.Group != "" {
+		if err := verifyRelayClaim(r, body, acl.Group); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	party, err := verifyRelayAuth(r, body, acl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	key := "acl-" + sessionID
+	var existing SessionACL
+	if getData(key, &existing) {
+		if !sameMembers(existing, acl) || (acl.Group == "" && !equalACL(existing, acl)) {
+			http.Error(w, "session has a different member set", http.StatusConflict)
+			return
+		}
+		claimed := existing.Members[party]
+		switch {
+		case acl.Group == "" || strings.EqualFold(claimed, acl.Members[party]):
+		case claimed != "":
+			http.Error(w, fmt.Sprintf("%q is claimed by another key", party), http.StatusConflict)
+			return
+		default:
+			existing.Members[party] = acl.Members[party]
+			if err := setData(key, existing); err != nil {
+				http.Error(w, "failed to store session ACL", http.StatusInternalServerError)
+				return
+			}
+			pf("Session %s member %s claimed", sessionID, party)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if acl.Group != "" {
+		// a member only claims its own slot
+		for p := range acl.Members {
+			if p != party {
+				acl.Members[p] = ""
+			}
+		}
+	}
+	if err := setData(key, acl); err != nil {
+		http.Error(w, "failed to store session ACL", http.StatusInternalServerError)
+		return
+	}
+	// a session created first keeps its ACL until it expires
+	refreshLifecycle(sessionID)
+
+	// participants registered before the ACL must be members
+	var session Session
+	if getData("session-"+sessionID, &session) {
+		members := []string{}
+		for _, p := range session.Participants {
+			if _, ok := acl.Members[p]; ok {
+				members = append(members, p)
+			}
+		}
+		session.Participants = members
+		setData("session-"+sessionID, session)
+	}
+	notifySession(sessionID)
+
+	w.WriteHeader(http.StatusCreated)
+	pf("Session %s access control set by %s for %d members", sessionID, party, len(acl.Members))
+}
+
+// sameMembers tells whether a and b list the same parties under the same
+// group key.
+func sameMembers(a, b SessionACL) bool {
+	if len(a.Members) != len(b.Members) || !strings.EqualFold(a.Group, b.Group) {
+		return false
+	}
+	for party := range a.Members {
+		if _, ok := b.Members[party]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func equalACL(a, b SessionACL) bool {
+	if !sameMembers(a, b) {
+		return false
+	}
+	for party, pubKey := range a.Members {
+		if !strings.EqualFold(b.Members[party], pubKey) {
+			return false
+		}
+	}
+	return true
+}
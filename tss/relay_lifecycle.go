@@ -0,0 +1,379 @@
+package tss
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Relay session lifecycle. A session may be created up front with its type
+// and expected number of participants:
+//
+//	PUT /{sessionID}             {"type":"keygen","expected":3,"ttl":600}
+//	GET /status/{sessionID}      SessionStatus
+//
+// Creation is idempotent like the ACL: every party creates the same session,
+// a different spec is refused. Joins are idempotent too, refused once the
+// session is full or expired. Completion flags of every participant are kept
+// past the session's end, so the initiator can tell that every party saved
+// its keyshare, and session streams push "status" events on every change
+// and an "expired" event when the session expires unfinished. The status of
+// a created session is kept until it expires, idle or not.
+
+// Session types of SessionSpec.
+const (
+	SessionTypeKeygen  = "keygen"
+	SessionTypeKeysign = "keysign"
+	SessionTypeReshare = "reshare"
+	SessionTypeRefresh = "refresh"
+	SessionTypePresign = "presign"
+)
+
+// Session states of SessionStatus.
+const (
+	SessionStateOpen     = "open"     // waiting for participants
+	SessionStateReady    = "ready"    // every expected participant joined
+	SessionStateEnded    = "ended"    // a participant ended the session
+	SessionStateComplete = "complete" // every participant flagged completion
+	SessionStateExpired  = "expired"  // expired before completion
+)
+
+const (
+	defaultSessionLifetime = 10 * time.Minute
+	maxSessionLifetime     = 24 * time.Hour
+	// lifecycles stay this long past their expiry, for the final state
+	sessionLifecycleGrace = 5 * time.Minute
+)
+
+// SessionSpec is what a session is created with.
+type SessionSpec struct {
+	Type     string `json:"type"`
+	Expected int    `json:"expected"`
+	TTL      int    `json:"ttl,omitempty"` // seconds, default 600
+}
+
+// SessionStatus is the lifecycle of a relay session.
+type SessionStatus struct {
+	SessionID    string                       `json:"sessionID"`
+	Type         string                       `json:"type,omitempty"`
+	Expected     int                          `json:"expected,omitempty"`
+	Participants []string                     `json:"participants"`
+	Completed    map[string]SessionCompletion `json:"completed"`
+	State        string                       `json:"state"`
+	CreatedAt    int64                        `json:"createdAt,omitempty"`
+	ExpiresAt    int64                        `json:"expiresAt,omitempty"`
+}
+
+// SessionCompletion is a participant's completion flag.
+type SessionCompletion struct {
+	Kind      string `json:"kind"` // keygen or keysign
+	At        int64  `json:"at"`
+	MessageID string `json:"messageID,omitempty"`
+	Result    string `json:"result,omitempty"` // keysign signature
+}
+
+// sessionLifecycle is the stored lifecycle of a session, created explicitly
+// or on the first join or completion flag.
+type sessionLifecycle struct {
+	SessionSpec
+	Participants []string                     `json:"participants"`
+	Completed    map[string]SessionCompletion `json:"completed"`
+	CreatedAt    int64                        `json:"createdAt"`
+	ExpiresAt    int64                        `json:"expiresAt,omitempty"` // 0 never
+	Ended        bool                         `json:"ended,omitempty"`
+}
+
+func validSessionType(t string) bool {
+	switch t {
+	case SessionTypeKeygen, SessionTypeKeysign, SessionTypeReshare, SessionTypeRefresh, SessionTypePresign:
+		return true
+	}
+	return false
+}
+
+// loadLifecycle loads the lifecycle of sessionID, a new one if it has none.
+func loadLifecycle(sessionID string) (sessionLifecycle, bool) {
+	var lc sessionLifecycle
+	found := getData("lifecycle-"+sessionID, &lc)
+	if !found {
+		lc.CreatedAt = time.Now().Unix()
+	}
+	if lc.Completed == nil {
+		lc.Completed = map[string]SessionCompletion{}
+	}
+	if lc.Participants == nil {
+		lc.Participants = []string{}
+	}
+	return lc, found
+}
+
+func (lc sessionLifecycle) expired(now time.Time) bool {
+	return lc.ExpiresAt > 0 && now.Unix() >= lc.ExpiresAt
+}
+
+func (lc sessionLifecycle) full(participant string) bool {
+	return lc.Expected > 0 && len(lc.Participants) >= lc.Expected && !Contains(lc.Participants, participant)
+}
+
+func (lc sessionLifecycle) complete() bool {
+	members := lc.Participants
+	if lc.Expected > 0 && len(members) < lc.Expected {
+		return false
+	}
+	if len(members) == 0 {
+		return false
+	}
+	for _, p := range members {
+		if _, ok := lc.Completed[p]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (lc sessionLifecycle) status(sessionID string, now time.Time) SessionStatus {
+	state := SessionStateOpen
+	switch {
+	case lc.complete():
+		state = SessionStateComplete
+	case lc.expired(now):
+		state = SessionStateExpired
+	case lc.Ended:
+		state = SessionStateEnded
+	case lc.Expected > 0 && len(lc.Participants) >= lc.Expected:
+		state = SessionStateReady
+	}
+	return SessionStatus{
+		SessionID:    sessionID,
+		Type:         lc.Type,
+		Expected:     lc.Expected,
+		Participants: lc.Participants,
+		Completed:    lc.Completed,
+		State:        state,
+		CreatedAt:    lc.CreatedAt,
+		ExpiresAt:    lc.ExpiresAt,
+	}
+}
+
+// checkSessionOpen refuses requests on an expired session. Call it with the
+// mutex held.
+func checkSessionOpen(w http.ResponseWriter, sessionID string) bool {
+	if lc, found := loadLifecycle(sessionID); found && lc.expired(time.Now()) && !lc.complete() {
+		http.Error(w, "session expired", http.StatusGone)
+		return false
+	}
+	return true
+}
+
+// flagCompletion records the completion of party in the session lifecycle.
+func flagCompletion(sessionID, party string, completion SessionCompletion) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	lc, _ := loadLifecycle(sessionID)
+	completion.At = time.Now().Unix()
+	lc.Completed[party] = completion
+	if err := saveLifecycle(sessionID, lc); err != nil {
+		return err
+	}
+	notifySession(sessionID)
+	return nil
+}
+
+// saveLifecycle stores the lifecycle of sessionID. A lifecycle with an
+// expiry is kept until it, plus a grace period, however long the session is
+// idle, and so is the ACL guarding its status.
+func saveLifecycle(sessionID string, lc sessionLifecycle) error {
+	expires := time.Unix(lc.ExpiresAt, 0).Add(sessionLifecycleGrace)
+	if lc.ExpiresAt == 0 || time.Until(expires) <= defaultRelayTTL {
+		return setData("lifecycle-"+sessionID, lc)
+	}
+	if err := setDataUntil("lifecycle-"+sessionID, lc, expires); err != nil {
+		return err
+	}
+	var acl SessionACL
+	if getData("acl-"+sessionID, &acl) {
+		return setDataUntil("acl-"+sessionID, acl, expires)
+	}
+	return nil
+}
+
+// refreshLifecycle keeps the lifecycle alive at least as long as the
+// session's messages.
+func refreshLifecycle(sessionID string) {
+	var lc sessionLifecycle
+	if getData("lifecycle-"+sessionID, &lc) {
+		saveLifecycle(sessionID, lc)
+	}
+}
+
+// ---- Lifecycle Handlers ----
+func createSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := getSessionID(r)
+	if _, ok := authorize(w, r, sessionID); !ok {
+		return
+	}
+
+	var spec SessionSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if !validSessionType(spec.Type) {
+		http.Error(w, fmt.Sprintf("invalid session type %q", spec.Type), http.StatusBadRequest)
+		return
+	}
+	if spec.Expected < 1 {
+		http.Error(w, "expected participants must be positive", http.StatusBadRequest)
+		return
+	}
+	lifetime := defaultSessionLifetime
+	if spec.TTL > 0 {
+		lifetime = min(time.Duration(spec.TTL)*time.Second, maxSessionLifetime)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	lc, found := loadLifecycle(sessionID)
+	if found && lc.Type != "" {
+		if lc.Type != spec.Type || lc.Expected != spec.Expected {
+			http.Error(w, "session exists with a different type or size", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(lc.Participants) > spec.Expected {
+		http.Error(w, "session has more participants than expected", http.StatusConflict)
+		return
+	}
+	lc.SessionSpec = spec
+	lc.ExpiresAt = time.Unix(lc.CreatedAt, 0).Add(lifetime).Unix()
+	if err := saveLifecycle(sessionID, lc); err != nil {
+		http.Error(w, "failed to store session", http.StatusInternalServerError)
+		return
+	}
+	notifySession(sessionID)
+
+	w.WriteHeader(http.StatusCreated)
+	pf("Session %s created: %s of %d participants, expires %s", sessionID, spec.Type, spec.Expected, time.Unix(lc.ExpiresAt, 0).Format(time.RFC3339))
+}
+
+func getSessionStatus(w http.ResponseWriter, r *http.Request) {
+	sessionID := getSessionID(r)
+	if _, ok := authorize(w, r, sessionID); !ok {
+		return
+	}
+	lc, found := loadLifecycle(sessionID)
+	if !found {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lc.status(sessionID, time.Now()))
+}
+
+// ---- Client ----
+
+// createRelaySession creates the session on the relay, relays without
+// lifecycle support are tolerated.
+func createRelaySession(server, session string, spec SessionSpec) error {
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("fail to marshal session spec: %w", err)
+	}
+	resp, err := relayDo(session, http.MethodPut, server+"/"+session, body)
+	if err != nil {
+		return fmt.Errorf("fail to create session: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		Logln("BBMTLog", "relay has no session lifecycle support")
+		return nil
+	default:
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("relay refused session: %s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// RelaySessionStatus returns the relay's SessionStatus of session as JSON:
+// its participants, who flagged completion, and its state (open, ready,
+// ended, complete or expired). Call it from a party of the session, whose
+// relay authentication it uses.
+func RelaySessionStatus(server, session string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in RelaySessionStatus: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	status, err := fetchSessionStatus(server, session)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return "", fmt.Errorf("fail to marshal session status: %w", err)
+	}
+	return string(data), nil
+}
+
+// AwaitRelaySessionComplete waits until every participant of session flagged
+// completion, e.g. saved its keyshare, and returns the final SessionStatus
+// as JSON. It fails when the session expires or after timeoutSeconds.
+func AwaitRelaySessionComplete(server, session string, timeoutSeconds int) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("PANIC in AwaitRelaySessionComplete: %v", r)
+			Logf("BBMTLog: %s", errMsg)
+			err = fmt.Errorf("internal error (panic): %v", r)
+			result = ""
+		}
+	}()
+
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	for {
+		status, err := fetchSessionStatus(server, session)
+		if err != nil {
+			Logln("BBMTLog", "fail to get session status", "error", err)
+		} else if status.State == SessionStateComplete {
+			data, _ := json.Marshal(status)
+			return string(data), nil
+		} else if status.State == SessionStateExpired {
+			return "", fmt.Errorf("session expired with %d of %d participants complete", len(status.Completed), max(status.Expected, len(status.Participants)))
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timeout waiting for session completion after %d seconds", timeoutSeconds)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func fetchSessionStatus(server, session string) (*SessionStatus, error) {
+	resp, err := relayDo(session, http.MethodGet, server+"/status/"+session, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get session status: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read session status: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to get session status: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var status SessionStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("fail to decode session status: %w", err)
+	}
+	return &status, nil
+}
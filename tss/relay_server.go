@@ -0,0 +1,567 @@
+package tss
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RelayConfig configures a RelayServer. Zero values take the defaults below.
+type RelayConfig struct {
+	Addr string // listen address, e.g. "0.0.0.0:55055"
+
+	// TLS, from files or, for LAN use, a self-signed certificate generated
+	// at start whose fingerprint clients pin with PinRelayCertificate
+	TLSCertFile   string
+	TLSKeyFile    string
+	SelfSignedTLS bool
+
+	ReadTimeout  time.Duration // whole request, default 30s
+	WriteTimeout time.Duration // whole response, default 30s; streams are exempt
+	IdleTimeout  time.Duration // keep-alive, default 120s
+
+	MaxBodyBytes int64   // request body limit, default 8 MiB
+	MaxSessions  int     // concurrently active sessions, 0 is unlimited
+	RateLimit    float64 // requests per second per client IP, 0 is unlimited
+	RateBurst    int     // rate limiter burst, default 2x RateLimit
+
+	TrustProxy        bool       // take the client IP from X-Forwarded-For, as set by one proxy
+	AllowOpenSessions bool       // serve sessions without an ACL, see RelayRequireAuth
+	AdminToken        string     // bearer token of the admin API, which is off without one
+	Store             RelayStore // optional, the current relay store otherwise
+	Logger            *slog.Logger
+}
+
+const (
+	defaultRelayReadTimeout  = 30 * time.Second
+	defaultRelayWriteTimeout = 30 * time.Second
+	defaultRelayIdleTimeout  = 120 * time.Second
+	defaultRelayMaxBody      = 8 << 20
+)
+
+// RelayServer is the HTTP relay as an embeddable server. The relay's
+// sessions, store and watchers are process wide, so run one per process.
+type RelayServer struct {
+	cfg      RelayConfig
+	log      *slog.Logger
+	server   *http.Server
+	limiter  *ipRateLimiter
+	sessions *sessionTracker
+	metrics  *relayMetrics
+
+	// CertFingerprint is the SHA-256 hex fingerprint of the self-signed
+	// certificate, set by Start.
+	CertFingerprint string
+
+	mu       sync.Mutex
+	listener net.Listener
+}
+
+// NewRelayServer checks cfg and creates a relay server, Start runs it.
+func NewRelayServer(cfg RelayConfig) (*RelayServer, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("relay listen address is required")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("both TLS certificate and key files are required")
+	}
+	if cfg.SelfSignedTLS && cfg.TLSCertFile != "" {
+		return nil, errors.New("use either TLS files or a self-signed certificate")
+	}
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = defaultRelayReadTimeout
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = defaultRelayWriteTimeout
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = defaultRelayIdleTimeout
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = defaultRelayMaxBody
+	}
+	if cfg.RateLimit > 0 && cfg.RateBurst == 0 {
+		cfg.RateBurst = int(math.Ceil(2 * cfg.RateLimit))
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
+
+	s := &RelayServer{
+		cfg:      cfg,
+		log:      logger,
+		sessions: newSessionTracker(cfg.MaxSessions),
+		metrics:  newRelayMetrics(),
+	}
+	if cfg.RateLimit > 0 {
+		s.limiter = newIPRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	s.server = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	return s, nil
+}
+
+// Handler is the relay's routes behind the limits and the access log.
+func (s *RelayServer) Handler() http.Handler {
+	r := mux.NewRouter()
+
+	// Operational Routes, ahead of the session routes they would match
+	r.HandleFunc("/healthz", s.healthz).Methods("GET")
+	r.HandleFunc("/metrics", s.serveMetrics).Methods("GET")
+	if s.cfg.AdminToken != "" {
+		r.HandleFunc("/admin/sessions", s.requireAdmin(s.listSessions)).Methods("GET")
+		r.HandleFunc("/admin/sessions", s.requireAdmin(s.purgeSessions)).Methods("DELETE")
+		r.HandleFunc("/admin/sessions/{id}", s.requireAdmin(s.getSessionInfo)).Methods("GET")
+		r.HandleFunc("/admin/sessions/{id}", s.requireAdmin(s.purgeSession)).Methods("DELETE")
+	}
+
+	relayRoutes(r)
+	r.Use(s.accessLog, s.limit)
+	return r
+}
+
+func relayRoutes(r *mux.Router) {
+	// Access Control Routes
+	r.HandleFunc("/acl/{sessionID}", postSessionACL).Methods("POST")
+
+	// Session Routes
+	r.HandleFunc("/{sessionID}", postSession).Methods("POST")
+	r.HandleFunc("/{sessionID}", getSession).Methods("GET")
+	r.HandleFunc("/{sessionID}", deleteSession).Methods("DELETE")
+	r.HandleFunc("/{sessionID}", createSession).Methods("PUT")
+	r.HandleFunc("/status/{sessionID}", getSessionStatus).Methods("GET")
+
+	// Handlers for session completions
+	r.HandleFunc("/complete/keysign/{sessionID}", completedKeysign).Methods("POST")
+	r.HandleFunc("/complete/keygen/{sessionID}", completedKeygen).Methods("POST")
+
+	// Message Routes
+	r.HandleFunc("/message/{sessionID}", postMessage).Methods("POST")
+	r.HandleFunc("/message/{sessionID}/{participantKey}", getMessage).Methods("GET")
+	r.HandleFunc("/message/{sessionID}/{participantKey}/{hash}", deleteTssMessage).Methods("DELETE")
+	r.HandleFunc("/message/{sessionID}/{participantKey}/ack", ackTssMessages).Methods("POST")
+
+	// Stream Routes, push instead of polling
+	r.HandleFunc("/stream/{sessionID}", streamSession).Methods("GET")
+	r.HandleFunc("/stream/{sessionID}/{participantKey}", streamSession).Methods("GET")
+}
+
+// Start binds the listen address and serves in the background. Listen and
+// TLS errors are returned, serving errors are logged.
+func (s *RelayServer) Start() error {
+	if s.cfg.Store != nil {
+		if err := SetRelayStore(s.cfg.Store); err != nil {
+			s.log.Warn("failed to close previous relay store", "error", err)
+		}
+	}
+	RelayRequireAuth(!s.cfg.AllowOpenSessions)
+
+	var tlsConfig *tls.Config
+	switch {
+	case s.cfg.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	case s.cfg.SelfSignedTLS:
+		cert, fingerprint, err := selfSignedCertificate()
+		if err != nil {
+			return fmt.Errorf("failed to create self-signed certificate: %w", err)
+		}
+		s.CertFingerprint = fingerprint
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	listener, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.cfg.Addr, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	s.log.Info("relay listening", "addr", listener.Addr().String(), "tls", tlsConfig != nil,
+		"cert_fingerprint", s.CertFingerprint, "open_sessions", s.cfg.AllowOpenSessions)
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.log.Error("relay stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Addr is the bound listen address, useful with port 0.
+func (s *RelayServer) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return s.cfg.Addr
+	}
+	return s.listener.Addr().String()
+}
+
+// Shutdown stops accepting requests and waits for the ones in flight until
+// ctx is done, then closes the remaining connections.
+func (s *RelayServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		s.server.Close()
+	}
+	if s.limiter != nil {
+		s.limiter.stop()
+	}
+	s.log.Info("relay shut down")
+	return err
+}
+
+// ---- Middleware ----
+
+// statusRecorder captures the response status and size for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (s *RelayServer) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		s.metrics.begin()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.metrics.observe(routeTemplate(r), r.Method, rec.status, r.ContentLength, rec.bytes, time.Since(start))
+		s.log.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"ip", s.clientIP(r),
+		)
+	})
+}
+
+func (s *RelayServer) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter != nil && !s.limiter.allow(s.clientIP(r)) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)
+		sessionID := mux.Vars(r)["sessionID"]
+		if sessionID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// sessions count once a write on them was authorized, so requests
+		// refused by the handlers can't fill the session limit
+		write := sessionWrite(r)
+		if write && !s.sessions.admits(sessionID) {
+			http.Error(w, "too many active sessions", http.StatusServiceUnavailable)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 300 {
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/"+sessionID:
+			s.sessions.remove(sessionID)
+		case write:
+			s.sessions.touch(sessionID)
+		default:
+			s.sessions.refresh(sessionID)
+		}
+	})
+}
+
+// sessionWrite tells whether r creates session data: a join, an ACL, a
+// session creation or a message.
+func sessionWrite(r *http.Request) bool {
+	switch routeTemplate(r) {
+	case "/{sessionID}":
+		return r.Method == http.MethodPost || r.Method == http.MethodPut
+	case "/acl/{sessionID}", "/message/{sessionID}":
+		return r.Method == http.MethodPost
+	}
+	return false
+}
+
+// clientIP is the request's client address. Behind a proxy it is the
+// right-most X-Forwarded-For entry, the one the proxy appended; entries left
+// of it come from the client and can be forged.
+func (s *RelayServer) clientIP(r *http.Request) string {
+	if s.cfg.TrustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ---- Limits ----
+
+// sessionTracker counts the sessions seen within the relay TTL.
+type sessionTracker struct {
+	mu       sync.Mutex
+	max      int
+	lastSeen map[string]time.Time
+}
+
+func newSessionTracker(max int) *sessionTracker {
+	return &sessionTracker{max: max, lastSeen: make(map[string]time.Time)}
+}
+
+// admits reports whether sessionID is known or there is room for it.
+func (t *sessionTracker) admits(sessionID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, known := t.lastSeen[sessionID]; known || t.max <= 0 || len(t.lastSeen) < t.max {
+		return true
+	}
+	t.prune()
+	return len(t.lastSeen) < t.max
+}
+
+// touch records activity on sessionID, counting it if it is new.
+func (t *sessionTracker) touch(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastSeen[sessionID] = time.Now()
+}
+
+// refresh records activity on sessionID if it is counted already.
+func (t *sessionTracker) refresh(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, known := t.lastSeen[sessionID]; known {
+		t.lastSeen[sessionID] = time.Now()
+	}
+}
+
+// active is the number of sessions seen within the relay TTL.
+func (t *sessionTracker) active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune()
+	return len(t.lastSeen)
+}
+
+func (t *sessionTracker) prune() {
+	now := time.Now()
+	for id, seen := range t.lastSeen {
+		if now.Sub(seen) > defaultRelayTTL {
+			delete(t.lastSeen, id)
+		}
+	}
+}
+
+func (t *sessionTracker) remove(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.lastSeen, sessionID)
+}
+
+// ipRateLimiter is a token bucket per client IP.
+type ipRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	done    chan struct{}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPRateLimiter(rate float64, burst int) *ipRateLimiter {
+	l := &ipRateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket), done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				l.mu.Lock()
+				for ip, b := range l.buckets {
+					if time.Since(b.last) > time.Minute {
+						delete(l.buckets, ip)
+					}
+				}
+				l.mu.Unlock()
+			}
+		}
+	}()
+	return l
+}
+
+func (l *ipRateLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *ipRateLimiter) stop() {
+	close(l.done)
+}
+
+// ---- TLS ----
+
+// selfSignedCertificate creates a one year P-256 certificate for localhost
+// and the host's addresses, and its SHA-256 fingerprint.
+func selfSignedCertificate() (tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "bbmt-relay"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	fingerprint := sha256.Sum256(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, hex.EncodeToString(fingerprint[:]), nil
+}
+
+// relayHTTPClient is the HTTP client of relay requests.
+var relayHTTPClient = http.DefaultClient
+
+// PinRelayCertificate makes relay requests accept exactly the TLS
+// certificate with the SHA-256 fingerprint, as a self-signed relay logs it,
+// instead of CA-verified ones. An empty fingerprint restores CA
+// verification.
+func PinRelayCertificate(fingerprintHex string) (string, error) {
+	if fingerprintHex == "" {
+		relayHTTPClient = http.DefaultClient
+		return "", nil
+	}
+	pinned, err := hex.DecodeString(strings.ReplaceAll(fingerprintHex, ":", ""))
+	if err != nil || len(pinned) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 certificate fingerprint")
+	}
+	relayHTTPClient = &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: true, // replaced by the pin below
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 {
+					return errors.New("relay sent no certificate")
+				}
+				fingerprint := sha256.Sum256(rawCerts[0])
+				if !strings.EqualFold(hex.EncodeToString(fingerprint[:]), hex.EncodeToString(pinned)) {
+					return errors.New("relay certificate does not match the pinned fingerprint")
+				}
+				return nil
+			},
+		},
+	}}
+	return hex.EncodeToString(pinned), nil
+}
@@ -0,0 +1,388 @@
+package tss
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// RelayStore is where the relay keeps sessions, messages and completion
+// flags, as JSON values. Values expire after the store's TTL unless they are
+// set again, missing or expired keys read as nil.
+type RelayStore interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	Close() error
+}
+
+// RelayStoreLister is a RelayStore that can list its live keys, which the
+// relay's metrics and admin API need. Stores without it still work, the
+// admin API then cannot list sessions.
+type RelayStoreLister interface {
+	Keys(prefix string) ([]string, error)
+}
+
+// RelayStoreExpirer is a RelayStore that can keep a value until a given time
+// instead of its TTL, which session lifecycles use to outlive idle sessions.
+// Without it lifecycles expire with the store's TTL.
+type RelayStoreExpirer interface {
+	SetUntil(key string, value []byte, expires time.Time) error
+}
+
+// Relay store kinds for UseRelayStore.
+const (
+	RelayStoreMemory = "memory"
+	RelayStoreDisk   = "disk"
+)
+
+const (
+	defaultRelayTTL        = 5 * time.Minute
+	defaultRelayGCInterval = 10 * time.Minute
+	relayStoreExt          = ".json"
+)
+
+// Mutex for swapping the relay store
+var relayStoreMu sync.RWMutex
+
+var relayStore RelayStore = newMemoryRelayStore(defaultRelayTTL, defaultRelayGCInterval)
+
+func currentRelayStore() RelayStore {
+	relayStoreMu.RLock()
+	defer relayStoreMu.RUnlock()
+	return relayStore
+}
+
+// SetRelayStore makes the relay keep its data in store, closing the previous
+// store. Call it before RunRelay.
+func SetRelayStore(store RelayStore) error {
+	relayStoreMu.Lock()
+	defer relayStoreMu.Unlock()
+	previous := relayStore
+	relayStore = store
+	if previous != nil && previous != store {
+		return previous.Close()
+	}
+	return nil
+}
+
+// UseRelayStore selects the relay storage: "memory" (the default, lost on
+// restart) or "disk" under dir, so sessions in flight survive a relay
+// restart. ttlSeconds is how long sessions and messages live without
+// updates, gcSeconds how often expired ones are removed; 0 keeps the
+// defaults of 5 and 10 minutes.
+func UseRelayStore(kind, dir string, ttlSeconds, gcSeconds int) (result string, err error) {
+	ttl, gcInterval := defaultRelayTTL, defaultRelayGCInterval
+	if ttlSeconds > 0 {
+		ttl = time.Duration(ttlSeconds) * time.Second
+	}
+	if gcSeconds > 0 {
+		gcInterval = time.Duration(gcSeconds) * time.Second
+	}
+
+	var store RelayStore
+	switch kind {
+	case RelayStoreMemory:
+		store = newMemoryRelayStore(ttl, gcInterval)
+	case RelayStoreDisk:
+		if store, err = newDiskRelayStore(dir, ttl, gcInterval); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown relay store %q, options: %s, %s", kind, RelayStoreMemory, RelayStoreDisk)
+	}
+	if err := SetRelayStore(store); err != nil {
+		Logln("BBMTLog", "failed to close previous relay store:", err)
+	}
+	return kind, nil
+}
+
+// ---- In-Memory Store ----
+
+type memoryRelayStore struct {
+	cache *cache.Cache
+}
+
+func newMemoryRelayStore(ttl, gcInterval time.Duration) *memoryRelayStore {
+	return &memoryRelayStore{cache: cache.New(ttl, gcInterval)}
+}
+
+func (s *memoryRelayStore) Get(key string) ([]byte, error) {
+	if value, found := s.cache.Get(key); found {
+		return value.([]byte), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryRelayStore) Set(key string, value []byte) error {
+	s.cache.Set(key, value, cache.DefaultExpiration)
+	return nil
+}
+
+func (s *memoryRelayStore) SetUntil(key string, value []byte, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		s.cache.Delete(key)
+		return nil
+	}
+	s.cache.Set(key, value, ttl)
+	return nil
+}
+
+func (s *memoryRelayStore) Delete(key string) error {
+	s.cache.Delete(key)
+	return nil
+}
+
+func (s *memoryRelayStore) Keys(prefix string) ([]string, error) {
+	keys := []string{}
+	for key := range s.cache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryRelayStore) Close() error {
+	s.cache.Flush()
+	return nil
+}
+
+// ---- On-Disk Store ----
+
+// diskRelayStore keeps each key in its own file, written to a temporary file,
+// synced and renamed so a crash never leaves a torn or lost value behind. The
+// keys and their expiry are indexed in memory, loaded from the files on start.
+type diskRelayStore struct {
+	mu    sync.Mutex // guards index, serializes writes with gc
+	dir   string
+	ttl   time.Duration
+	index map[string]int64 // key -> expiry, unix seconds
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// diskRelayEntry is the file content of a key.
+type diskRelayEntry struct {
+	Key     string          `json:"key"`
+	Expires int64           `json:"expires"` // unix seconds
+	Value   json.RawMessage `json:"value"`
+}
+
+func newDiskRelayStore(dir string, ttl, gcInterval time.Duration) (*diskRelayStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("relay store directory is required")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create relay store directory: %w", err)
+	}
+	// leftovers of interrupted writes
+	if tmps, err := filepath.Glob(filepath.Join(dir, "*.tmp")); err == nil {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}
+
+	s := &diskRelayStore{dir: dir, ttl: ttl, index: map[string]int64{}, stop: make(chan struct{})}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	go func() {
+		ticker := time.NewTicker(gcInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.gc()
+			}
+		}
+	}()
+	Logln("BBMTLog", "relay store on disk at", dir)
+	return s, nil
+}
+
+// file is the path of key, hashed so session IDs never reach the file
+// system.
+func (s *diskRelayStore) file(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+relayStoreExt)
+}
+
+// load indexes the entries on disk, removing expired and corrupt ones.
+func (s *diskRelayStore) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list relay store: %w", err)
+	}
+	now := time.Now().Unix()
+	removed := 0
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), relayStoreExt) {
+			continue
+		}
+		path := filepath.Join(s.dir, f.Name())
+		entry, err := s.read(path)
+		if err != nil || now >= entry.Expires || s.file(entry.Key) != path {
+			if os.Remove(path) == nil {
+				removed++
+			}
+			continue
+		}
+		s.index[entry.Key] = entry.Expires
+	}
+	if removed > 0 {
+		Logln("BBMTLog", "relay store removed", removed, "expired or corrupt entries")
+	}
+	return nil
+}
+
+func (s *diskRelayStore) read(path string) (*diskRelayEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry diskRelayEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("corrupt relay store entry %s: %w", filepath.Base(path), err)
+	}
+	return &entry, nil
+}
+
+func (s *diskRelayStore) Get(key string) ([]byte, error) {
+	entry, err := s.read(s.file(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry.Key != key || time.Now().Unix() >= entry.Expires {
+		return nil, nil
+	}
+	return entry.Value, nil
+}
+
+func (s *diskRelayStore) Set(key string, value []byte) error {
+	return s.SetUntil(key, value, time.Now().Add(s.ttl))
+}
+
+func (s *diskRelayStore) SetUntil(key string, value []byte, expires time.Time) error {
+	data, err := json.Marshal(diskRelayEntry{
+		Key:     key,
+		Expires: expires.Unix(),
+		Value:   value,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal relay store entry: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.file(key)
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write relay store entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write relay store entry: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("failed to sync relay store directory: %w", err)
+	}
+	s.index[key] = expires.Unix()
+	return nil
+}
+
+// writeSynced writes data to path and flushes it to disk before returning.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the directory entries of dir, so a rename into it survives
+// a crash. Windows cannot sync directories and does not need to.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *diskRelayStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.file(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete relay store entry: %w", err)
+	}
+	delete(s.index, key)
+	return nil
+}
+
+func (s *diskRelayStore) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	keys := []string{}
+	for key, expires := range s.index {
+		if now < expires && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *diskRelayStore) Close() error {
+	s.once.Do(func() { close(s.stop) })
+	return nil
+}
+
+// gc removes expired entries.
+func (s *diskRelayStore) gc() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	removed := 0
+	for key, expires := range s.index {
+		if now < expires {
+			continue
+		}
+		if err := os.Remove(s.file(key)); err != nil && !os.IsNotExist(err) {
+			Logln("BBMTLog", "relay store gc failed:", err)
+			continue
+		}
+		delete(s.index, key)
+		removed++
+	}
+	if removed > 0 {
+		Logln("BBMTLog", "relay store gc removed", removed, "entries")
+	}
+}
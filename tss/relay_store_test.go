@@ -0,0 +1,96 @@
+package tss
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStoreFiles lists the entry files of a disk store.
+func testStoreFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+relayStoreExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDiskRelayStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newDiskRelayStore(dir, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"session-a": `["partyA"]`, "session-b": `["partyB"]`, "message-a": `[]`} {
+		if err := store.Set(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, err := store.Get("session-a"); err != nil || string(value) != `["partyA"]` {
+		t.Fatalf("get: %s %v", value, err)
+	}
+	if value, err := store.Get("session-c"); err != nil || value != nil {
+		t.Fatalf("get of a missing key: %s %v", value, err)
+	}
+	if err := store.Delete("session-b"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := store.Keys("session-"); err != nil || len(keys) != 1 || keys[0] != "session-a" {
+		t.Fatalf("keys: %v %v", keys, err)
+	}
+	store.Close()
+
+	// a restarted relay finds its entries, and drops torn writes and corrupt
+	// entries
+	os.WriteFile(filepath.Join(dir, "torn"+relayStoreExt+".tmp"), []byte(`{"key":`), 0600)
+	os.WriteFile(filepath.Join(dir, "corrupt"+relayStoreExt), []byte(`{"key":`), 0600)
+	store, err = newDiskRelayStore(dir, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, err := store.Get("session-a"); err != nil || string(value) != `["partyA"]` {
+		t.Fatalf("get after a restart: %s %v", value, err)
+	}
+	if value, err := store.Get("message-a"); err != nil || string(value) != `[]` {
+		t.Fatalf("get after a restart: %s %v", value, err)
+	}
+	if keys, err := store.Keys("session-"); err != nil || len(keys) != 1 || keys[0] != "session-a" {
+		t.Fatalf("keys after a restart: %v %v", keys, err)
+	}
+	if files := testStoreFiles(t, dir); len(files) != 2 {
+		t.Fatalf("entries after a restart: %v", files)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) != 0 {
+		t.Fatalf("torn writes left: %v", tmps)
+	}
+}
+
+func TestDiskRelayStoreExpiry(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newDiskRelayStore(dir, time.Second, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Set("session-a", []byte(`["partyA"]`)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	if value, err := store.Get("session-a"); err != nil || value != nil {
+		t.Fatalf("get of an expired key: %s %v", value, err)
+	}
+	if keys, err := store.Keys(""); err != nil || len(keys) != 0 {
+		t.Fatalf("keys after expiry: %v %v", keys, err)
+	}
+	// gc removes the expired entry
+	deadline := time.Now().Add(5 * time.Second)
+	for len(testStoreFiles(t, dir)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired entries left: %v", testStoreFiles(t, dir))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
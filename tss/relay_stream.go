@@ -0,0 +1,223 @@
+package tss
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-Sent Events streams of the relay. A stream pushes the session's
+// participants ("session" events) and, when it is opened for a participant,
+// the messages addressed to it ("message" events) as they arrive, so parties
+// do not have to poll. Sessions with a lifecycle also push their status
+// ("status" events) and end the stream with an "expired" event when they
+// expire, see relay_lifecycle.go. Message events carry the last message ID
+// as event ID, a stream reopened with it ("after" or Last-Event-ID) resumes
+// from there. Relays without /stream keep working with polling.
+
+const streamPingInterval = 15 * time.Second
+
+// Mutex for the session watchers
+var watchMu sync.Mutex
+
+// watchers holds, per session, a channel closed on the next change of the
+// session's participants or messages.
+var watchers = map[string]chan struct{}{}
+
+// sessionChanged returns a channel closed on the next change of sessionID.
+// Take it before reading the session so no change is missed.
+func sessionChanged(sessionID string) <-chan struct{} {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	ch, ok := watchers[sessionID]
+	if !ok {
+		ch = make(chan struct{})
+		watchers[sessionID] = ch
+	}
+	return ch
+}
+
+// notifySession wakes up the streams of sessionID.
+func notifySession(sessionID string) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if ch, ok := watchers[sessionID]; ok {
+		close(ch)
+		delete(watchers, sessionID)
+	}
+}
+
+// ---- Stream Handler ----
+func streamSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := getSessionID(r)
+	participantKey := getKeyParam(r)
+	if party, ok := authorize(w, r, sessionID); !ok {
+		return
+	} else if party != "" && participantKey != "" && party != participantKey {
+		http.Error(w, "members can only stream their own messages", http.StatusForbidden)
+		return
+	}
+
+	cursor, err := messageCursor(r)
+	if err != nil {
+		http.Error(w, "invalid message cursor", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// streams outlive the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	pf("Stream opened for session %s by %q", sessionID, participantKey)
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	lastParticipants, lastStatus := "", ""
+	for {
+		changed := sessionChanged(sessionID)
+
+		var expiry <-chan time.Time
+		if lc, found := loadLifecycle(sessionID); found {
+			status := lc.status(sessionID, time.Now())
+			data, _ := json.Marshal(status)
+			if string(data) != lastStatus {
+				lastStatus = string(data)
+				fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+			}
+			if status.State == SessionStateExpired {
+				fmt.Fprintf(w, "event: expired\ndata: %s\n\n", data)
+				flusher.Flush()
+				pf("Stream closed for expired session %s", sessionID)
+				return
+			}
+			if lc.ExpiresAt > 0 && status.State != SessionStateComplete {
+				expiry = time.After(time.Until(time.Unix(lc.ExpiresAt, 0)))
+			}
+		}
+
+		var session Session
+		if getData("session-"+sessionID, &session) {
+			participants, _ := json.Marshal(session.Participants)
+			if string(participants) != lastParticipants {
+				lastParticipants = string(participants)
+				fmt.Fprintf(w, "event: session\ndata: %s\n\n", participants)
+			}
+		}
+
+		if participantKey != "" {
+			pending := []Message{}
+			var messages []Message
+			if getData("message-"+sessionID, &messages) {
+				for _, msg := range messages {
+					if msg.ID > cursor && Contains(msg.To, participantKey) {
+						pending = append(pending, msg)
+					}
+				}
+			}
+			if len(pending) > 0 {
+				sort.SliceStable(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+				cursor = pending[len(pending)-1].ID
+				data, _ := json.Marshal(pending)
+				fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", cursor, data)
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			pf("Stream closed for session %s by %q", sessionID, participantKey)
+			return
+		case <-changed:
+		case <-expiry:
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// ---- Stream Client ----
+
+// streamEvent is one Server-Sent Event.
+type streamEvent struct {
+	Name string
+	Data string
+}
+
+// openStream connects to the relay stream of session, for participant key
+// if set, and delivers its events on the returned channel until ctx is done
+// or the connection drops, then closes it. It fails if the relay has no
+// streaming support, callers then fall back to polling.
+func openStream(ctx context.Context, server, session, key string) (<-chan streamEvent, error) {
+	url := server + "/stream/" + session
+	if key != "" {
+		url += "/" + key
+	}
+	req, err := newRelayRequest(ctx, session, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create stream request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := relayHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open stream: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("relay does not support streaming: %s", resp.Status)
+	}
+
+	events := make(chan streamEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		var event streamEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event.Data != "" {
+					select {
+					case events <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+				event = streamEvent{}
+			case strings.HasPrefix(line, ":"):
+				// comment, keep-alive
+			case strings.HasPrefix(line, "event:"):
+				event.Name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				if event.Data != "" {
+					event.Data += "\n"
+				}
+				event.Data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			Logln("BBMTLog", "stream closed:", err)
+		}
+	}()
+	return events, nil
+}
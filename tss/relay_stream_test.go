@@ -0,0 +1,115 @@
+package tss
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextStreamEvent returns the next event named name, skipping the others.
+func nextStreamEvent(t *testing.T, events <-chan streamEvent, name string) streamEvent {
+	t.Helper()
+	for event := range events {
+		if event.Name == name {
+			return event
+		}
+	}
+	t.Fatalf("stream closed before a %s event", name)
+	return streamEvent{}
+}
+
+func TestRelayStream(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{AllowOpenSessions: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events, err := openStream(ctx, server, "stream", "partyB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/stream", `["partyA"]`); status != http.StatusCreated {
+		t.Fatalf("join: %d", status)
+	}
+	var participants []string
+	if err := json.Unmarshal([]byte(nextStreamEvent(t, events, "session").Data), &participants); err != nil || len(participants) != 1 || participants[0] != "partyA" {
+		t.Fatalf("session event: %v %v", participants, err)
+	}
+
+	// every message to partyB is pushed as it is posted
+	for _, body := range []string{"1", "2"} {
+		msg, _ := json.Marshal(Message{From: "partyA", To: []string{"partyB"}, Body: body, SeqNo: body, Hash: "h" + body})
+		if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/message/stream", string(msg)); status != http.StatusOK {
+			t.Fatalf("post: %d", status)
+		}
+		var messages []Message
+		if err := json.Unmarshal([]byte(nextStreamEvent(t, events, "message").Data), &messages); err != nil || len(messages) == 0 {
+			t.Fatalf("message event: %v %v", messages, err)
+		}
+		if last := messages[len(messages)-1]; last.Body != body {
+			t.Fatalf("pushed message %q, want %q", last.Body, body)
+		}
+	}
+
+	// messages to other parties are not pushed
+	msg, _ := json.Marshal(Message{From: "partyB", To: []string{"partyA"}, Body: "3", SeqNo: "1", Hash: "h3"})
+	testRelayCall(t, nil, http.MethodPost, server+"/message/stream", string(msg))
+	select {
+	case event := <-events:
+		if event.Name == "message" && strings.Contains(event.Data, `"body":"3"`) {
+			t.Fatalf("pushed a message of partyA: %s", event.Data)
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRelayStreamFallback(t *testing.T) {
+	relay, err := url.Parse(newTestRelay(t, RelayConfig{AllowOpenSessions: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a relay whose streams drop right after they open
+	proxy := httputil.NewSingleHostReverseProxy(relay)
+	dropped := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/stream/") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			select {
+			case dropped <- struct{}{}:
+			default:
+			}
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	parties := []string{"partyA", "partyB"}
+	done := make(chan error, 1)
+	go func() { done <- awaitJoiners(parties, server.URL, "fallback") }()
+	select {
+	case <-dropped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no stream opened")
+	}
+	// the parties join after the stream dropped, only polling sees them
+	for _, party := range parties {
+		if status, _ := testRelayCall(t, nil, http.MethodPost, server.URL+"/fallback", `["`+party+`"]`); status != http.StatusCreated {
+			t.Fatalf("join of %s: %d", party, status)
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("parties not seen after the stream dropped")
+	}
+}
@@ -0,0 +1,554 @@
+package tss
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRelay serves a relay of cfg on its store, a fresh memory store by
+// default.
+func newTestRelay(t *testing.T, cfg RelayConfig) string {
+	t.Helper()
+	store := cfg.Store
+	if store == nil {
+		store = newMemoryRelayStore(defaultRelayTTL, defaultRelayGCInterval)
+	}
+	relayStoreMu.Lock()
+	previous := relayStore
+	relayStore = store
+	relayStoreMu.Unlock()
+	t.Cleanup(func() {
+		relayStoreMu.Lock()
+		relayStore = previous
+		relayStoreMu.Unlock()
+		store.Close()
+	})
+
+	if cfg.AllowOpenSessions {
+		RelayRequireAuth(false)
+		t.Cleanup(func() { RelayRequireAuth(true) })
+	}
+
+	cfg.Addr = "127.0.0.1:0"
+	s, err := NewRelayServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// testRelayAuth is the relay authentication of party in a session of
+// parties sharing sessionKey.
+func testRelayAuth(t *testing.T, party string, parties []string, sessionKey string) *relayAuth {
+	t.Helper()
+	auth, err := newRelayAuth(party, parties, sessionKey, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+// newTestRelayRequest builds a relay request, signed by auth unless it is nil.
+func newTestRelayRequest(t *testing.T, auth *relayAuth, method, url, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != nil {
+		if err := auth.sign(req, []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return req
+}
+
+// testRelayDo sends req and returns the status and body of the response.
+func testRelayDo(t *testing.T, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// testRelayCall sends a request signed by auth.
+func testRelayCall(t *testing.T, auth *relayAuth, method, url, body string) (int, string) {
+	t.Helper()
+	return testRelayDo(t, newTestRelayRequest(t, auth, method, url, body))
+}
+
+// testRelayACL registers the ACL of auth's session the way parties do.
+func testRelayACL(t *testing.T, server, session string, auth *relayAuth) {
+	t.Helper()
+	registry.setAuth(session, auth)
+	t.Cleanup(func() { registry.clear(session) })
+	if err := registerSessionACL(server, session); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testRelayClaim registers auth's ACL, or claims its slot in it.
+func testRelayClaim(t *testing.T, server, session string, auth *relayAuth) (int, string) {
+	t.Helper()
+	req, err := newACLRequest(server, session, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testRelayDo(t, req)
+}
+
+func TestRelayAuth(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{})
+	parties := []string{"partyA", "partyB"}
+	authA := testRelayAuth(t, "partyA", parties, "session key")
+	testRelayACL(t, server, "auth", authA)
+
+	if status, body := testRelayCall(t, authA, http.MethodPost, server+"/auth", `["partyA"]`); status != http.StatusCreated {
+		t.Fatalf("signed join: %d %s", status, body)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/auth", ""); status != http.StatusUnauthorized {
+		t.Fatalf("unsigned request: %d", status)
+	}
+	if status, _ := testRelayCall(t, authA, http.MethodPost, server+"/auth", `["partyB"]`); status != http.StatusForbidden {
+		t.Fatalf("join as another member: %d", status)
+	}
+
+	// the ACL can be registered again, but not changed
+	changed := testRelayAuth(t, "partyA", []string{"partyA", "partyC"}, "session key")
+	changed.privKey = authA.privKey
+	changed.acl.Members["partyA"] = authA.acl.Members["partyA"]
+	if status, _ := testRelayClaim(t, server, "auth", changed); status != http.StatusConflict {
+		t.Fatalf("changed ACL: %d", status)
+	}
+	if err := registerSessionACL(server, "auth"); err != nil {
+		t.Fatalf("same ACL: %v", err)
+	}
+
+	// parties not in the ACL, unclaimed members and members with another key
+	// are refused
+	outsider := testRelayAuth(t, "partyC", []string{"partyA", "partyB", "partyC"}, "session key")
+	if status, body := testRelayCall(t, outsider, http.MethodGet, server+"/auth", ""); status != http.StatusUnauthorized || !strings.Contains(body, "not a session member") {
+		t.Fatalf("non-member: %d %s", status, body)
+	}
+	authB := testRelayAuth(t, "partyB", parties, "session key")
+	if status, body := testRelayCall(t, authB, http.MethodGet, server+"/auth", ""); status != http.StatusUnauthorized || !strings.Contains(body, "not a session member") {
+		t.Fatalf("unclaimed member: %d %s", status, body)
+	}
+	impostor := testRelayAuth(t, "partyB", parties, "another session key")
+	if status, body := testRelayClaim(t, server, "auth", impostor); status != http.StatusConflict {
+		t.Fatalf("claim with another session key: %d %s", status, body)
+	}
+	impostor.acl.Group = authB.acl.Group
+	if status, body := testRelayClaim(t, server, "auth", impostor); status != http.StatusUnauthorized || !strings.Contains(body, "invalid group signature") {
+		t.Fatalf("claim without the group key: %d %s", status, body)
+	}
+	testRelayACL(t, server, "auth", authB)
+	if status, body := testRelayCall(t, authB, http.MethodGet, server+"/auth", ""); status != http.StatusOK {
+		t.Fatalf("claimed member: %d %s", status, body)
+	}
+	if status, body := testRelayCall(t, impostor, http.MethodGet, server+"/auth", ""); status != http.StatusUnauthorized || !strings.Contains(body, "invalid signature") {
+		t.Fatalf("wrong key: %d %s", status, body)
+	}
+}
+
+func TestRelayAuthMembers(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{})
+	// the parties of an N-party ECIES session share one key pair
+	var keyPair struct {
+		PrivateKey string `json:"privateKey"`
+		PublicKey  string `json:"publicKey"`
+	}
+	keyPairJSON, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(keyPairJSON), &keyPair)
+	parties := []string{"partyA", "partyB", "partyC"}
+	auths := make(map[string]*relayAuth)
+	for _, party := range parties {
+		auth, err := newRelayAuth(party, parties, "", keyPair.PublicKey, keyPair.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auths[party] = auth
+		if status, body := testRelayClaim(t, server, "members", auth); status != http.StatusCreated && status != http.StatusOK {
+			t.Fatalf("claim of %s: %d %s", party, status, body)
+		}
+	}
+	msg, _ := json.Marshal(Message{From: "partyA", To: []string{"partyB"}, Body: "1", SeqNo: "1", Hash: "h1"})
+	if status, body := testRelayCall(t, auths["partyA"], http.MethodPost, server+"/message/members", string(msg)); status != http.StatusOK {
+		t.Fatalf("post: %d %s", status, body)
+	}
+
+	// members know the group key, but only claim their own slot
+	again, err := newRelayAuth("partyB", parties, "", keyPair.PublicKey, keyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := testRelayClaim(t, server, "members", again); status != http.StatusConflict || !strings.Contains(body, "claimed by another key") {
+		t.Fatalf("second claim of partyB: %d %s", status, body)
+	}
+	// and can't sign as, or read for, another member
+	forged := &relayAuth{party: "partyB", privKey: auths["partyC"].privKey}
+	if status, _ := testRelayCall(t, forged, http.MethodGet, server+"/message/members/partyB", ""); status != http.StatusUnauthorized {
+		t.Fatalf("request of partyC as partyB: %d", status)
+	}
+	if status, _ := testRelayCall(t, auths["partyC"], http.MethodGet, server+"/message/members/partyB", ""); status != http.StatusForbidden {
+		t.Fatalf("read of partyB's messages by partyC: %d", status)
+	}
+	if status, _ := testRelayCall(t, auths["partyC"], http.MethodDelete, server+"/message/members/partyB/h1", ""); status != http.StatusForbidden {
+		t.Fatalf("delete of partyB's messages by partyC: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/message/members/partyB", ""); status != http.StatusUnauthorized {
+		t.Fatalf("read by a non-member: %d", status)
+	}
+	if messages := testMessages(t, auths["partyB"], server, "members", "partyB", "0"); len(messages) != 1 {
+		t.Fatalf("messages of partyB: %v", testMessageIDs(messages))
+	}
+
+	// sessions without an ACL are refused by default
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/open", `["partyA"]`); status != http.StatusUnauthorized {
+		t.Fatalf("join of a session without an ACL: %d", status)
+	}
+}
+
+func TestRelayAuthReplay(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{})
+	authA := testRelayAuth(t, "partyA", []string{"partyA", "partyB"}, "session key")
+	testRelayACL(t, server, "replay", authA)
+
+	req := newTestRelayRequest(t, authA, http.MethodPost, server+"/replay", `["partyA"]`)
+	replay := req.Clone(req.Context())
+	replay.Body = io.NopCloser(bytes.NewReader([]byte(`["partyA"]`)))
+	if status, body := testRelayDo(t, req); status != http.StatusCreated {
+		t.Fatalf("join: %d %s", status, body)
+	}
+	if status, body := testRelayDo(t, replay); status != http.StatusUnauthorized || !strings.Contains(body, "replayed") {
+		t.Fatalf("replayed join: %d %s", status, body)
+	}
+
+	// the query is signed, so cursors can't be moved
+	req = newTestRelayRequest(t, authA, http.MethodGet, server+"/message/replay/partyA?after=1", "")
+	req.URL.RawQuery = "after=0"
+	if status, body := testRelayDo(t, req); status != http.StatusUnauthorized || !strings.Contains(body, "invalid signature") {
+		t.Fatalf("tampered query: %d %s", status, body)
+	}
+
+	// a nonce refused with a bad signature is not used up
+	req = newTestRelayRequest(t, authA, http.MethodGet, server+"/replay", "")
+	header := req.Header.Get("Authorization")
+	sigAt := strings.Index(header, "sig=") + len("sig=")
+	req.Header.Set("Authorization", header[:sigAt]+"00"+header[sigAt+2:])
+	if status, _ := testRelayDo(t, req); status != http.StatusUnauthorized {
+		t.Fatalf("bad signature: %d", status)
+	}
+	req.Header.Set("Authorization", header)
+	if status, body := testRelayDo(t, req); status != http.StatusOK {
+		t.Fatalf("signed request after a refused one: %d %s", status, body)
+	}
+}
+
+func TestRelayClientIP(t *testing.T) {
+	s := &RelayServer{cfg: RelayConfig{TrustProxy: true}}
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.RemoteAddr = "10.0.0.1:4000"
+	if ip := s.clientIP(req); ip != "10.0.0.1" {
+		t.Fatalf("without a proxy header: %s", ip)
+	}
+	// the client may send a header of its own, the proxy appends to it
+	req.Header.Add("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	req.Header.Add("X-Forwarded-For", "3.3.3.3")
+	if ip := s.clientIP(req); ip != "3.3.3.3" {
+		t.Fatalf("forwarded: %s", ip)
+	}
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	if ip := s.clientIP(req); ip != "2.2.2.2" {
+		t.Fatalf("forwarded list: %s", ip)
+	}
+	s.cfg.TrustProxy = false
+	if ip := s.clientIP(req); ip != "10.0.0.1" {
+		t.Fatalf("untrusted proxy header: %s", ip)
+	}
+}
+
+func TestRelaySessionLimit(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{MaxSessions: 2})
+	parties := []string{"partyA", "partyB"}
+	auth1 := testRelayAuth(t, "partyA", parties, "key 1")
+	testRelayACL(t, server, "limit-1", auth1)
+
+	// refused writes and reads don't count
+	for _, session := range []string{"limit-x", "limit-y", "limit-z"} {
+		if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/"+session, `["partyA"]`); status != http.StatusUnauthorized {
+			t.Fatalf("unsigned join: %d", status)
+		}
+		if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/status/"+session, ""); status != http.StatusUnauthorized {
+			t.Fatalf("unsigned status: %d", status)
+		}
+	}
+	testRelayACL(t, server, "limit-2", testRelayAuth(t, "partyA", parties, "key 2"))
+
+	auth3 := testRelayAuth(t, "partyA", parties, "key 3")
+	if status, _ := testRelayClaim(t, server, "limit-3", auth3); status != http.StatusServiceUnavailable {
+		t.Fatalf("session over the limit: %d", status)
+	}
+	// counted sessions keep working
+	if status, _ := testRelayCall(t, auth1, http.MethodPost, server+"/limit-1", `["partyA"]`); status != http.StatusCreated {
+		t.Fatalf("join of a counted session: %d", status)
+	}
+
+	if status, _ := testRelayCall(t, auth1, http.MethodDelete, server+"/limit-1", ""); status != http.StatusOK {
+		t.Fatalf("delete: %d", status)
+	}
+	if status, _ := testRelayClaim(t, server, "limit-3", auth3); status != http.StatusCreated {
+		t.Fatalf("session after a delete: %d", status)
+	}
+}
+
+// testSessionStatus gets the relay's status of session.
+func testSessionStatus(t *testing.T, auth *relayAuth, server, session string) SessionStatus {
+	t.Helper()
+	status, body := testRelayCall(t, auth, http.MethodGet, server+"/status/"+session, "")
+	if status != http.StatusOK {
+		t.Fatalf("status of %s: %d %s", session, status, body)
+	}
+	var sessionStatus SessionStatus
+	if err := json.Unmarshal([]byte(body), &sessionStatus); err != nil {
+		t.Fatal(err)
+	}
+	return sessionStatus
+}
+
+func TestRelaySessionLifecycle(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{AllowOpenSessions: true})
+	if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/status/lifecycle", ""); status != http.StatusNotFound {
+		t.Fatalf("status of an unknown session: %d", status)
+	}
+	spec := `{"type":"keygen","expected":2}`
+	if status, _ := testRelayCall(t, nil, http.MethodPut, server+"/lifecycle", spec); status != http.StatusCreated {
+		t.Fatalf("create: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPut, server+"/lifecycle", spec); status != http.StatusOK {
+		t.Fatalf("create again: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPut, server+"/lifecycle", `{"type":"keysign","expected":2}`); status != http.StatusConflict {
+		t.Fatalf("create with another spec: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPut, server+"/other", `{"type":"dance","expected":2}`); status != http.StatusBadRequest {
+		t.Fatalf("create with an unknown type: %d", status)
+	}
+
+	sessionStatus := testSessionStatus(t, nil, server, "lifecycle")
+	if sessionStatus.State != SessionStateOpen || sessionStatus.ExpiresAt-sessionStatus.CreatedAt != int64(defaultSessionLifetime/time.Second) {
+		t.Fatalf("created session: %+v", sessionStatus)
+	}
+	for _, party := range []string{"partyA", "partyB", "partyA"} {
+		if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/lifecycle", `["`+party+`"]`); status != http.StatusCreated {
+			t.Fatalf("join of %s: %d", party, status)
+		}
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/lifecycle", `["partyC"]`); status != http.StatusConflict {
+		t.Fatalf("join of a full session: %d", status)
+	}
+	if sessionStatus := testSessionStatus(t, nil, server, "lifecycle"); sessionStatus.State != SessionStateReady || len(sessionStatus.Participants) != 2 {
+		t.Fatalf("joined session: %+v", sessionStatus)
+	}
+
+	// the session's end keeps the completion flags
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/complete/keygen/lifecycle", `["partyA"]`); status != http.StatusCreated {
+		t.Fatalf("completion: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodDelete, server+"/lifecycle", ""); status != http.StatusOK {
+		t.Fatalf("delete: %d", status)
+	}
+	if sessionStatus := testSessionStatus(t, nil, server, "lifecycle"); sessionStatus.State != SessionStateEnded || len(sessionStatus.Completed) != 1 {
+		t.Fatalf("ended session: %+v", sessionStatus)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/complete/keygen/lifecycle", `["partyB"]`); status != http.StatusCreated {
+		t.Fatalf("completion: %d", status)
+	}
+	if sessionStatus := testSessionStatus(t, nil, server, "lifecycle"); sessionStatus.State != SessionStateComplete || sessionStatus.Completed["partyB"].Kind != SessionTypeKeygen {
+		t.Fatalf("complete session: %+v", sessionStatus)
+	}
+}
+
+func TestRelaySessionLifecycleExpiry(t *testing.T) {
+	// relay data lives a second without updates
+	server := newTestRelay(t, RelayConfig{Store: newMemoryRelayStore(time.Second, time.Second), AllowOpenSessions: true})
+	parties := []string{"partyA", "partyB"}
+	authA := testRelayAuth(t, "partyA", parties, "session key")
+	testRelayACL(t, server, "idle", authA)
+	if status, _ := testRelayCall(t, authA, http.MethodPut, server+"/idle", `{"type":"keysign","expected":2,"ttl":600}`); status != http.StatusCreated {
+		t.Fatalf("create: %d", status)
+	}
+	if status, _ := testRelayCall(t, authA, http.MethodPost, server+"/idle", `["partyA"]`); status != http.StatusCreated {
+		t.Fatalf("join: %d", status)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPut, server+"/short", `{"type":"keysign","expected":2,"ttl":1}`); status != http.StatusCreated {
+		t.Fatalf("create: %d", status)
+	}
+	time.Sleep(2 * time.Second)
+
+	// the idle session and its ACL outlive the relay data
+	if status, _ := testRelayCall(t, authA, http.MethodGet, server+"/idle", ""); status != http.StatusNotFound {
+		t.Fatalf("participants of an idle session: %d", status)
+	}
+	if sessionStatus := testSessionStatus(t, authA, server, "idle"); sessionStatus.State != SessionStateOpen || len(sessionStatus.Participants) != 1 {
+		t.Fatalf("idle session: %+v", sessionStatus)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/status/idle", ""); status != http.StatusUnauthorized {
+		t.Fatalf("unsigned status of an idle session: %d", status)
+	}
+
+	if sessionStatus := testSessionStatus(t, nil, server, "short"); sessionStatus.State != SessionStateExpired {
+		t.Fatalf("expired session: %+v", sessionStatus)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/short", `["partyA"]`); status != http.StatusGone {
+		t.Fatalf("join of an expired session: %d", status)
+	}
+}
+
+// testPostMessage posts msg to session and returns its relay ID.
+func testPostMessage(t *testing.T, auth *relayAuth, server, session string, msg Message) uint64 {
+	t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := testRelayCall(t, auth, http.MethodPost, server+"/message/"+session, string(data))
+	if status != http.StatusOK {
+		t.Fatalf("post: %d %s", status, body)
+	}
+	var resp struct {
+		ID uint64 `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.ID
+}
+
+// testMessages gets the messages of party in session after the cursor.
+func testMessages(t *testing.T, auth *relayAuth, server, session, party, after string) []Message {
+	t.Helper()
+	status, body := testRelayCall(t, auth, http.MethodGet, server+"/message/"+session+"/"+party+"?after="+after, "")
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if status != http.StatusOK {
+		t.Fatalf("get: %d %s", status, body)
+	}
+	var messages []Message
+	if err := json.Unmarshal([]byte(body), &messages); err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func testMessageIDs(messages []Message) []uint64 {
+	ids := []uint64{}
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func TestRelayMessages(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{AllowOpenSessions: true})
+	toB := Message{From: "partyA", To: []string{"partyB"}, Body: "1", SeqNo: "1", Hash: "h1"}
+	if id := testPostMessage(t, nil, server, "messages", toB); id != 1 {
+		t.Fatalf("first ID %d", id)
+	}
+	toBoth := Message{From: "partyA", To: []string{"partyB", "partyC"}, Body: "2", SeqNo: "2", Hash: "h2"}
+	if id := testPostMessage(t, nil, server, "messages", toBoth); id != 2 {
+		t.Fatalf("second ID %d", id)
+	}
+	// a retried post keeps its ID and is delivered once
+	if id := testPostMessage(t, nil, server, "messages", toB); id != 1 {
+		t.Fatalf("retried post got ID %d", id)
+	}
+	if id := testPostMessage(t, nil, server, "messages", Message{From: "partyC", To: []string{"partyB"}, Body: "3", SeqNo: "1", Hash: "h3"}); id != 3 {
+		t.Fatalf("same sequence number of another sender got ID %d", id)
+	}
+
+	if ids := testMessageIDs(testMessages(t, nil, server, "messages", "partyB", "0")); len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Fatalf("messages of partyB: %v", ids)
+	}
+	if ids := testMessageIDs(testMessages(t, nil, server, "messages", "partyB", "1")); len(ids) != 2 || ids[0] != 2 {
+		t.Fatalf("messages of partyB after 1: %v", ids)
+	}
+	if status, _ := testRelayCall(t, nil, http.MethodGet, server+"/message/messages/partyB?after=x", ""); status != http.StatusBadRequest {
+		t.Fatalf("invalid cursor: %d", status)
+	}
+
+	// acknowledged messages are released for partyB only
+	if status, _ := testRelayCall(t, nil, http.MethodPost, server+"/message/messages/partyB/ack", `{"id":2}`); status != http.StatusOK {
+		t.Fatalf("ack: %d", status)
+	}
+	if ids := testMessageIDs(testMessages(t, nil, server, "messages", "partyB", "0")); len(ids) != 1 || ids[0] != 3 {
+		t.Fatalf("messages of partyB after the ack: %v", ids)
+	}
+	if ids := testMessageIDs(testMessages(t, nil, server, "messages", "partyC", "0")); len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("messages of partyC: %v", ids)
+	}
+	// a post retried after its ack is not delivered again
+	if id := testPostMessage(t, nil, server, "messages", toB); id != 1 {
+		t.Fatalf("post retried after the ack got ID %d", id)
+	}
+	if ids := testMessageIDs(testMessages(t, nil, server, "messages", "partyB", "0")); len(ids) != 1 {
+		t.Fatalf("retried post delivered again: %v", ids)
+	}
+
+	// the recipient releases its copy by hash
+	if status, _ := testRelayCall(t, nil, http.MethodDelete, server+"/message/messages/partyC/h2", ""); status != http.StatusOK {
+		t.Fatalf("delete: %d", status)
+	}
+	if messages := testMessages(t, nil, server, "messages", "partyC", "0"); len(messages) != 0 {
+		t.Fatalf("messages of partyC after the delete: %v", testMessageIDs(messages))
+	}
+}
+
+func TestRelayMessagesAuth(t *testing.T) {
+	server := newTestRelay(t, RelayConfig{})
+	parties := []string{"partyA", "partyB"}
+	authA := testRelayAuth(t, "partyA", parties, "session key")
+	authB := testRelayAuth(t, "partyB", parties, "session key")
+	testRelayACL(t, server, "member-messages", authA)
+	testRelayACL(t, server, "member-messages", authB)
+
+	msg := Message{From: "partyA", To: []string{"partyB"}, Body: "1", SeqNo: "1"}
+	id := testPostMessage(t, authA, server, "member-messages", msg)
+	for name, msg := range map[string]Message{
+		"as another member": {From: "partyB", To: []string{"partyA"}, Body: "2", SeqNo: "2"},
+		"to a non-member":   {From: "partyA", To: []string{"partyC"}, Body: "3", SeqNo: "3"},
+	} {
+		data, _ := json.Marshal(msg)
+		if status, _ := testRelayCall(t, authA, http.MethodPost, server+"/message/member-messages", string(data)); status != http.StatusForbidden {
+			t.Fatalf("post %s: %d", name, status)
+		}
+	}
+	if status, _ := testRelayCall(t, authA, http.MethodGet, server+"/message/member-messages/partyB", ""); status != http.StatusForbidden {
+		t.Fatalf("read of another member's messages: %d", status)
+	}
+	if status, _ := testRelayCall(t, authA, http.MethodPost, server+"/message/member-messages/partyB/ack", `{"id":1}`); status != http.StatusForbidden {
+		t.Fatalf("ack of another member's messages: %d", status)
+	}
+	if messages := testMessages(t, authB, server, "member-messages", "partyB", "0"); len(messages) != 1 || messages[0].ID != id {
+		t.Fatalf("messages of partyB: %v", testMessageIDs(messages))
+	}
+}
@@ -0,0 +1,396 @@
+package tss
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	ecdsaKeygen "github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/resharing"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+const (
+	committeeOld = "old"
+	committeeNew = "new"
+)
+
+func (r ReshareRequest) GetOldParties() []string {
+	return strings.Split(r.OldParties, ",")
+}
+
+func (r ReshareRequest) GetNewParties() []string {
+	return strings.Split(r.NewParties, ",")
+}
+
+// ResharePrefix returns the party key prefix used by the new committee of a
+// resharing session. Every party derives it from the shared session ID, so the
+// new committee agrees on its party keys without an extra round trip.
+func ResharePrefix(session string) (string, error) {
+	hash, err := Sha256("reshare/" + session)
+	if err != nil {
+		return "", err
+	}
+	return hash[:16] + "/", nil
+}
+
+// ReshareECDSA hands the shares of an existing key over to a new committee.
+// The public key and chain code stay the same, so funds don't move. A party
+// that belongs to both committees runs one tss-lib instance per committee;
+// messages between them are delivered locally.
+func (s *ServiceImpl) ReshareECDSA(req *ReshareRequest) (*ReshareResponse, error) {
+	if err := s.validateReshareRequest(req); err != nil {
+		return nil, err
+	}
+	oldParties := req.GetOldParties()
+	newParties := req.GetNewParties()
+	isOld := Contains(oldParties, req.LocalPartyKey)
+	isNew := Contains(newParties, req.LocalPartyKey)
+	if !isOld && !isNew {
+		return nil, errors.New("local party is neither in the old nor in the new committee")
+	}
+
+	var oldState LocalState
+	oldPrefix := ""
+	pubKey := req.ExpectedPubKey
+	chainCodeHex := req.ChainCodeHex
+	if isOld {
+		Logln("BBMTLog", "restoring local state...")
+		localStateStr, err := s.stateAccessor.GetLocalState(req.PubKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get local state, error: %w", err)
+		}
+		if err := json.Unmarshal([]byte(localStateStr), &oldState); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal local state, error: %w", err)
+		}
+		if oldState.ECDSALocalData.ECDSAPub == nil {
+			return nil, errors.New("nil ecdsa pub key")
+		}
+		keygenCommittee := make([]string, 0, len(oldState.KeygenCommitteeKeys))
+		for _, key := range oldState.KeygenCommitteeKeys {
+			keygenCommittee = append(keygenCommittee, normalizePartyKey(key))
+		}
+		for _, party := range oldParties {
+			if !Contains(keygenCommittee, normalizePartyKey(party)) {
+				return nil, fmt.Errorf("old party %s is not in the keygen committee", party)
+			}
+		}
+		if len(pubKey) > 0 && pubKey != oldState.PubKey {
+			return nil, fmt.Errorf("keyshare pub key %s does not match expected pub key %s", oldState.PubKey, pubKey)
+		}
+		oldPrefix = oldState.ResharePrefix
+		pubKey = oldState.PubKey
+		if chainCodeHex == "" {
+			chainCodeHex = oldState.ChainCodeHex
+		}
+	}
+	if chainCodeHex == "" {
+		return nil, errors.New("nil chain code")
+	}
+	if req.ResharePrefix == oldPrefix {
+		return nil, errors.New("reshare prefix must differ from the keyshare's current prefix")
+	}
+	if isNew && s.preParams == nil {
+		return nil, errors.New("nil pre-parameters for new committee member")
+	}
+
+	// Parties without the old keyshare don't know its prefix. They only need
+	// the old party IDs to sort the same way, which holds for any prefix.
+	oldPartyIDs, oldLocalPartyID := s.getParties(oldParties, req.LocalPartyKey, oldPrefix)
+	newPartyIDs, newLocalPartyID := s.getParties(newParties, req.LocalPartyKey, req.ResharePrefix)
+	committees := map[string]tss.SortedPartyIDs{
+		committeeOld: oldPartyIDs,
+		committeeNew: newPartyIDs,
+	}
+
+	curve := tss.S256()
+	oldCtx := tss.NewPeerContext(oldPartyIDs)
+	newCtx := tss.NewPeerContext(newPartyIDs)
+	totalPartiesCount := len(oldPartyIDs) + len(newPartyIDs)
+	outCh := make(chan tss.Message, totalPartiesCount*2)
+	errCh := make(chan struct{}, 2)
+	parties := make(map[string]tss.Party)
+	endChs := make(map[string]chan *ecdsaKeygen.LocalPartySaveData)
+	if isOld {
+		endChs[committeeOld] = make(chan *ecdsaKeygen.LocalPartySaveData, 1)
+		params := tss.NewReSharingParameters(curve, oldCtx, newCtx, oldLocalPartyID, len(oldPartyIDs), req.OldThreshold, len(newPartyIDs), req.NewThreshold)
+		parties[committeeOld] = resharing.NewLocalParty(params, oldState.ECDSALocalData, outCh, endChs[committeeOld])
+	}
+	if isNew {
+		endChs[committeeNew] = make(chan *ecdsaKeygen.LocalPartySaveData, 1)
+		params := tss.NewReSharingParameters(curve, oldCtx, newCtx, newLocalPartyID, len(oldPartyIDs), req.OldThreshold, len(newPartyIDs), req.NewThreshold)
+		save := ecdsaKeygen.NewLocalPartySaveData(len(newPartyIDs))
+		save.LocalPreParams = *s.preParams
+		parties[committeeNew] = resharing.NewLocalParty(params, save, outCh, endChs[committeeNew])
+	}
+
+	for committee, party := range parties {
+		go func(committee string, party tss.Party) {
+			tErr := party.Start()
+			if tErr != nil {
+				Logln("BBMTLog", "failed to start reshare process", "committee", committee, "error", tErr)
+				errCh <- struct{}{}
+			}
+		}(committee, party)
+	}
+	epoch := &shareEpoch{}
+	if isOld {
+		epoch.epoch, epoch.known = oldState.ShareEpoch, true
+	}
+	saveData, err := s.processReshare(parties, committees, epoch, errCh, outCh, endChs)
+	if err != nil {
+		Logln("BBMTLog", "failed to process reshare", "error", err)
+		return nil, err
+	}
+	// the old shares are gone, and with them every presignature computed from them
+	dropPresignatures(oldState.PubKey)
+	if !isNew {
+		Logln("BBMTLog", "old committee share handed over")
+		return &ReshareResponse{PubKey: pubKey}, nil
+	}
+
+	newPubKey, err := GetHexEncodedPubKey(saveData.ECDSAPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hex encoded ecdsa pub key, error: %w", err)
+	}
+	if len(pubKey) > 0 && newPubKey != pubKey {
+		return nil, fmt.Errorf("reshared pub key %s does not match %s", newPubKey, pubKey)
+	}
+	now := time.Now().UnixMilli()
+	localState := &LocalState{
+		PubKey:              newPubKey,
+		ECDSALocalData:      *saveData,
+		KeygenCommitteeKeys: newParties,
+		LocalPartyKey:       req.LocalPartyKey,
+		ChainCodeHex:        chainCodeHex,
+		CreatedAt:           now,
+		ResharePrefix:       req.ResharePrefix,
+		Threshold:           req.NewThreshold,
+		ShareEpoch:          epoch.current() + 1,
+		RefreshedAt:         now,
+	}
+	if isOld {
+		localState.CreatedAt = oldState.CreatedAt
+	}
+	if err := s.saveLocalStateData(localState); err != nil {
+		return nil, fmt.Errorf("failed to save local state data, error: %w", err)
+	}
+	return &ReshareResponse{
+		PubKey: newPubKey,
+	}, nil
+}
+
+func (s *ServiceImpl) processReshare(parties map[string]tss.Party,
+	committees map[string]tss.SortedPartyIDs,
+	epoch *shareEpoch,
+	errCh <-chan struct{},
+	outCh <-chan tss.Message,
+	endChs map[string]chan *ecdsaKeygen.LocalPartySaveData) (*ecdsaKeygen.LocalPartySaveData, error) {
+
+	var newSaveData *ecdsaKeygen.LocalPartySaveData
+	finished := make(map[string]bool)
+	errChan := make(chan error, 1)
+
+	until := time.Now().Add(time.Duration(keyGenTimeout) * time.Second)
+
+	for {
+		select {
+		case <-errCh:
+			return nil, errors.New("failed to start reshare process")
+
+		// Process outgoing messages
+		case outMsg := <-outCh:
+			go func() {
+				msgData, r, _err := outMsg.WireBytes()
+				if _err != nil {
+					errChan <- fmt.Errorf("failed to get wire bytes, error: %v", _err)
+					return
+				}
+				fromCommittee := committeeOf(r.From, committees)
+				fromEpoch := 0
+				if fromCommittee == committeeOld {
+					fromEpoch = epoch.current()
+				}
+				for _, item := range r.To {
+					// some resharing messages list the sender among the recipients
+					if item.KeyInt().Cmp(r.From.KeyInt()) == 0 {
+						continue
+					}
+					jsonBytes, _err := json.MarshalIndent(MessageFromTss{
+						WireBytes:     msgData,
+						From:          r.From.Moniker,
+						IsBroadcast:   r.IsBroadcast,
+						FromCommittee: fromCommittee,
+						ToCommittee:   committeeOf(item, committees),
+						ShareEpoch:    fromEpoch,
+					}, "", "  ")
+					if _err != nil {
+						errChan <- fmt.Errorf("failed to marshal message to json, error: %v", _err)
+						return
+					}
+					outboundPayload := base64.StdEncoding.EncodeToString(jsonBytes)
+					if item.Moniker == r.From.Moniker {
+						// our own instance in the other committee
+						if _err := s.applyReshareMessage(parties, committees, epoch, outboundPayload); _err != nil {
+							errChan <- fmt.Errorf("failed to apply local message, error: %w", _err)
+							return
+						}
+						continue
+					}
+					if _err := s.messenger.Send(r.From.Moniker, item.Moniker, outboundPayload); _err != nil {
+						errChan <- fmt.Errorf("failed to send message to peer, error: %v", _err)
+						return
+					}
+				}
+			}()
+
+		case err := <-s.abortCh:
+			return nil, err
+
+		// Process incoming messages
+		case msg := <-s.inboundMessageCh:
+			go func() {
+				if err := s.applyReshareMessage(parties, committees, epoch, msg); err != nil {
+					errChan <- fmt.Errorf("failed to apply message to tss instance, error: %w", err)
+					return
+				}
+			}()
+
+		case saveData := <-endChs[committeeOld]:
+			if saveData != nil {
+				finished[committeeOld] = true
+			}
+
+		case saveData := <-endChs[committeeNew]:
+			if saveData != nil && newSaveData == nil {
+				newSaveData = saveData
+				finished[committeeNew] = true
+			}
+
+		// Periodic or idle check
+		default:
+			time.Sleep(250 * time.Millisecond)
+			if time.Since(until) > 0 {
+				waiting := make([]tss.Party, 0, len(parties))
+				for committee, party := range parties {
+					if !finished[committee] {
+						waiting = append(waiting, party)
+					}
+				}
+				return nil, blameTimeout(fmt.Errorf("reshare timeout, didn't finish in %d seconds", keyGenTimeout), waiting...)
+			}
+			select {
+			case err := <-errChan:
+				return nil, err
+			default:
+				if len(finished) == len(parties) {
+					Logln("BBMTLog", "reshare finished")
+					time.Sleep(250 * time.Millisecond) // give space time for message sender channels
+					return newSaveData, nil
+				}
+			}
+		}
+	}
+}
+
+// applyReshareMessage routes an inbound resharing message to the local party
+// of the committee it is addressed to.
+func (s *ServiceImpl) applyReshareMessage(parties map[string]tss.Party, committees map[string]tss.SortedPartyIDs, epoch *shareEpoch, msg string) error {
+	var msgFromTss MessageFromTss
+	originalBytes, err := base64.StdEncoding.DecodeString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to decode message from base64, error: %w", err)
+	}
+	if err := json.Unmarshal(originalBytes, &msgFromTss); err != nil {
+		return fmt.Errorf("failed to unmarshal message from json, error: %w", err)
+	}
+	localParty, ok := parties[msgFromTss.ToCommittee]
+	if !ok {
+		return fmt.Errorf("no local party in committee %q", msgFromTss.ToCommittee)
+	}
+	var fromParty *tss.PartyID
+	for _, item := range committees[msgFromTss.FromCommittee] {
+		if item.Moniker == msgFromTss.From {
+			fromParty = item
+			break
+		}
+	}
+	if fromParty == nil {
+		return newBlame(BlameProtocol, fmt.Errorf("failed to find from party,from:%s,committee:%s", msgFromTss.From, msgFromTss.FromCommittee), msgFromTss.From)
+	}
+	if msgFromTss.FromCommittee == committeeOld {
+		if err := epoch.observe(msgFromTss.From, msgFromTss.ShareEpoch); err != nil {
+			return newBlame(BlameProtocol, err, msgFromTss.From)
+		}
+	}
+	if _, errUpdate := localParty.UpdateFromBytes(msgFromTss.WireBytes, fromParty, msgFromTss.IsBroadcast); errUpdate != nil {
+		return fmt.Errorf("failed to update from bytes, error: %w", blameTssError(BlameProtocol, errUpdate, msgFromTss.From))
+	}
+	return nil
+}
+
+// shareEpoch is the epoch of the keyshares the old committee reshares from.
+// Parties without a keyshare learn it from the old committee's messages.
+type shareEpoch struct {
+	mu    sync.Mutex
+	epoch int
+	known bool
+}
+
+func (e *shareEpoch) current() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.epoch
+}
+
+// observe records the epoch announced by an old committee member and rejects
+// old committee members whose keyshares come from a different epoch.
+func (e *shareEpoch) observe(from string, epoch int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.known {
+		e.epoch, e.known = epoch, true
+		return nil
+	}
+	if e.epoch != epoch {
+		return fmt.Errorf("mixed share epochs, party %s holds epoch %d, expected epoch %d", from, epoch, e.epoch)
+	}
+	return nil
+}
+
+// committeeOf tells which committee a party ID belongs to. Old and new party
+// keys never collide because the new committee uses a fresh key prefix.
+func committeeOf(partyID *tss.PartyID, committees map[string]tss.SortedPartyIDs) string {
+	for _, item := range committees[committeeOld] {
+		if item.KeyInt().Cmp(partyID.KeyInt()) == 0 {
+			return committeeOld
+		}
+	}
+	return committeeNew
+}
+
+func (*ServiceImpl) validateReshareRequest(req *ReshareRequest) error {
+	if req == nil {
+		return errors.New("nil request")
+	}
+	if req.LocalPartyKey == "" {
+		return errors.New("nil local party key")
+	}
+	if req.OldParties == "" {
+		return errors.New("nil old committee keys")
+	}
+	if req.NewParties == "" {
+		return errors.New("nil new committee keys")
+	}
+	if req.OldThreshold < 1 || len(req.GetOldParties()) < req.OldThreshold+1 {
+		return fmt.Errorf("old committee needs at least %d parties", req.OldThreshold+1)
+	}
+	if req.NewThreshold < 1 || len(req.GetNewParties()) < req.NewThreshold+1 {
+		return fmt.Errorf("invalid new threshold %d for %d parties", req.NewThreshold, len(req.GetNewParties()))
+	}
+	return nil
+}
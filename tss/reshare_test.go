@@ -0,0 +1,131 @@
+package tss
+
+import (
+	"strings"
+	"testing"
+)
+
+// testReshare reshares the key from oldParties to newParties and returns the
+// keyshares of the new committee.
+func testReshare(t *testing.T, states map[string]*testState, oldParties, newParties []string, session string) map[string]*testState {
+	t.Helper()
+	oldState := states[oldParties[0]].localState(t)
+	pubKey := oldState.PubKey
+	threshold, err := oldState.threshold()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := ResharePrefix(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := append([]string{}, oldParties...)
+	newStates := make(map[string]*testState)
+	net := newTestNet()
+	for _, party := range newParties {
+		if !Contains(all, party) {
+			all = append(all, party)
+		}
+	}
+	for _, party := range all {
+		state := &testState{}
+		if states[party] != nil {
+			state.state = states[party].state
+		}
+		if Contains(newParties, party) {
+			newStates[party] = state
+		}
+		s, err := NewService(&testMessenger{net}, state, Contains(newParties, party), testPreParams(party))
+		if err != nil {
+			t.Fatal(err)
+		}
+		net.add(party, s)
+	}
+	errs := runParties(all, func(party string) error {
+		_, err := net.service(party).ReshareECDSA(&ReshareRequest{
+			PubKey:         pubKey,
+			LocalPartyKey:  party,
+			OldParties:     strings.Join(oldParties, ","),
+			NewParties:     strings.Join(newParties, ","),
+			OldThreshold:   threshold,
+			NewThreshold:   threshold,
+			ChainCodeHex:   testChainCode,
+			ExpectedPubKey: pubKey,
+			ResharePrefix:  prefix,
+		})
+		return err
+	})
+	requireNoErrors(t, errs)
+	return newStates
+}
+
+func TestReshareReplaceParty(t *testing.T) {
+	states := testKeyshares(t)
+	before := states["partyA"].localState(t)
+
+	// partyC is lost, partyD takes its place
+	newStates := testReshare(t, states, []string{"partyA", "partyB"}, []string{"partyA", "partyB", "partyD"}, "reshare-test")
+	for party, state := range newStates {
+		localState := state.localState(t)
+		if localState.PubKey != before.PubKey || localState.ChainCodeHex != before.ChainCodeHex {
+			t.Fatalf("%s: reshare changed the key", party)
+		}
+		if localState.ShareEpoch != 1 || localState.ResharePrefix == "" {
+			t.Fatalf("%s: epoch %d, prefix %q", party, localState.ShareEpoch, localState.ResharePrefix)
+		}
+	}
+	if newStates["partyD"].localState(t).CreatedAt == before.CreatedAt {
+		t.Fatal("the new party inherited the keygen time")
+	}
+
+	derivePath := "m/44/0/0/0/0"
+	responses, errs := testKeysign(t, []string{"partyB", "partyD"}, newStates, derivePath, testMessage(1))
+	requireNoErrors(t, errs)
+	requireSignature(t, newStates, "partyD", derivePath, responses["partyD"])
+}
+
+func TestRefreshShareEpochs(t *testing.T) {
+	parties := []string{"partyA", "partyB", "partyC"}
+	states := testKeyshares(t)
+	before := states["partyA"].localState(t)
+	if before.ShareEpoch != 0 {
+		t.Fatalf("keygen epoch %d", before.ShareEpoch)
+	}
+
+	refreshed := testReshare(t, states, parties, parties, "refresh-test")
+	after := refreshed["partyA"].localState(t)
+	if after.ShareEpoch != 1 || after.RefreshedAt == 0 || after.CreatedAt != before.CreatedAt {
+		t.Fatalf("epoch %d, refreshed at %d, created at %d", after.ShareEpoch, after.RefreshedAt, after.CreatedAt)
+	}
+	if after.ECDSALocalData.Xi.Cmp(before.ECDSALocalData.Xi) == 0 {
+		t.Fatal("refresh kept the share")
+	}
+
+	derivePath := "m/44/0/0/0/0"
+	responses, errs := testKeysign(t, []string{"partyA", "partyB"}, refreshed, derivePath, testMessage(2))
+	requireNoErrors(t, errs)
+	requireSignature(t, refreshed, "partyA", derivePath, responses["partyA"])
+
+	// a refreshed share doesn't sign with one from before the refresh
+	mixed := map[string]*testState{"partyA": refreshed["partyA"], "partyC": states["partyC"]}
+	_, errs = testKeysign(t, []string{"partyA", "partyC"}, mixed, derivePath, testMessage(3))
+	if errs["partyA"] == nil || !strings.Contains(errs["partyA"].Error(), "mixed share epochs") {
+		t.Fatalf("mixed epochs: %v", errs)
+	}
+}
+
+func TestShareEpochObserve(t *testing.T) {
+	epoch := &shareEpoch{}
+	if err := epoch.observe("partyA", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := epoch.observe("partyB", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := epoch.observe("partyC", 1); err == nil {
+		t.Fatal("mixed epoch accepted")
+	}
+	if epoch.current() != 2 {
+		t.Fatalf("epoch %d", epoch.current())
+	}
+}
@@ -0,0 +1,163 @@
+package tss
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionEntry holds everything the library tracks for a single MPC session.
+type sessionEntry struct {
+	status        Status
+	log           []Status
+	encryptionKey string
+	decryptionKey string
+	localState    string
+	undecryptable map[string]string // party key -> decryption error
+	auth          *relayAuth        // relay request signing, nil for open sessions
+}
+
+// sessionRegistry owns the per-session status, logs, ECIES keys and keygen
+// results behind a single lock, so several ceremonies (multiple wallets, or
+// parallel per-input keysigns) can run in the same process.
+type sessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*sessionEntry
+}
+
+var registry = newSessionRegistry()
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[string]*sessionEntry)}
+}
+
+// entry returns the session entry, creating it if needed. Caller must hold mu.
+func (r *sessionRegistry) entry(session string) *sessionEntry {
+	e, exists := r.sessions[session]
+	if !exists {
+		e = &sessionEntry{}
+		r.sessions[session] = e
+	}
+	return e
+}
+
+// status returns a copy of the current status of the session.
+func (r *sessionRegistry) status(session string) (Status, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, exists := r.sessions[session]
+	if !exists {
+		return Status{}, false
+	}
+	return e.status, true
+}
+
+// update applies fn to the session status, stamps it and appends it to the log.
+func (r *sessionRegistry) update(session string, fn func(status *Status)) Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(session)
+	fn(&e.status)
+	e.status.Time = int(time.Now().Unix())
+	e.log = append(e.log, e.status)
+	return e.status
+}
+
+// logs returns a copy of the recorded status history of the session.
+func (r *sessionRegistry) logs(session string) ([]Status, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, exists := r.sessions[session]
+	if !exists || e.log == nil {
+		return nil, false
+	}
+	return append([]Status(nil), e.log...), true
+}
+
+func (r *sessionRegistry) clear(session string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, session)
+}
+
+func (r *sessionRegistry) setKeys(session, encKey, decKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(session)
+	e.encryptionKey = encKey
+	e.decryptionKey = decKey
+}
+
+// keys returns the ECIES encryption and decryption keys of the session.
+func (r *sessionRegistry) keys(session string) (string, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, exists := r.sessions[session]
+	if !exists {
+		return "", ""
+	}
+	return e.encryptionKey, e.decryptionKey
+}
+
+func (r *sessionRegistry) setAuth(session string, auth *relayAuth) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry(session).auth = auth
+}
+
+// auth returns the relay request signing of the session, if any.
+func (r *sessionRegistry) auth(session string) *relayAuth {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, exists := r.sessions[session]
+	if !exists {
+		return nil
+	}
+	return e.auth
+}
+
+func (r *sessionRegistry) setLocalState(session, localState string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry(session).localState = localState
+}
+
+// takeLocalState returns the keyshare saved for the session and forgets it.
+func (r *sessionRegistry) takeLocalState(session string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, exists := r.sessions[session]
+	if !exists {
+		return ""
+	}
+	localState := e.localState
+	e.localState = ""
+	return localState
+}
+
+// recordUndecryptable remembers that a message from party could not be
+// decrypted, so a later timeout can be blamed on it.
+func (r *sessionRegistry) recordUndecryptable(session, party, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(session)
+	if e.undecryptable == nil {
+		e.undecryptable = make(map[string]string)
+	}
+	e.undecryptable[party] = reason
+}
+
+// undecryptable returns a copy of the parties whose messages could not be
+// decrypted in the session.
+func (r *sessionRegistry) undecryptable(session string) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, exists := r.sessions[session]
+	if !exists {
+		return nil
+	}
+	out := make(map[string]string, len(e.undecryptable))
+	for party, reason := range e.undecryptable {
+		out[party] = reason
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package tss
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Run with -race: several ceremonies update and read their sessions at once.
+func TestSessionRegistryConcurrent(t *testing.T) {
+	const sessions, updates = 4, 50
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		session := fmt.Sprintf("registry-test-%d", i)
+		defer ClearSessionLog(session)
+		registry.setKeys(session, "enc-"+session, "dec-"+session)
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for n := 1; n <= updates; n++ {
+				setSeqNo(session, "sent", 1, n)
+				setIndex(session, "received", 2, n)
+				registry.setLocalState(session, session)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < updates; n++ {
+				var state map[string]any
+				if err := json.Unmarshal([]byte(SessionState(session)), &state); err != nil {
+					t.Errorf("SessionState: %v", err)
+					return
+				}
+				SessionLog(session)
+				registry.takeLocalState(session)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < updates; n++ {
+				registry.recordUndecryptable(session, fmt.Sprintf("party%d", n%3), "bad")
+				registry.undecryptable(session)
+				if enc, dec := registry.keys(session); enc != "enc-"+session || dec != "dec-"+session {
+					t.Errorf("keys of %s: %s %s", session, enc, dec)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < sessions; i++ {
+		session := fmt.Sprintf("registry-test-%d", i)
+		logs, exists := registry.logs(session)
+		if !exists || len(logs) != 2*updates {
+			t.Fatalf("%s: want %d log entries, got %d", session, 2*updates, len(logs))
+		}
+		status := getStatus(session)
+		if status.SeqNo != updates || status.Index != updates {
+			t.Fatalf("%s: lost updates: %+v", session, status)
+		}
+		if len(registry.undecryptable(session)) != 3 {
+			t.Fatalf("%s: want 3 undecryptable parties", session)
+		}
+	}
+}
+
+func TestSessionRegistryClear(t *testing.T) {
+	session := "registry-test-clear"
+	setStep(session, "started", 1)
+	registry.setLocalState(session, "keyshare")
+	if got := registry.takeLocalState(session); got != "keyshare" {
+		t.Fatalf("takeLocalState = %q", got)
+	}
+	if got := registry.takeLocalState(session); got != "" {
+		t.Fatalf("local state was not forgotten: %q", got)
+	}
+	ClearSessionLog(session)
+	if SessionState(session) != "{}" || SessionLog(session) != "[]" {
+		t.Fatal("session was not cleared")
+	}
+}
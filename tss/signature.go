@@ -0,0 +1,73 @@
+package tss
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	mecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// ErrInvalidSignature is returned when a keysign produced a signature that does
+// not verify against the derived public key, so it must never be used.
+type ErrInvalidSignature string
+
+func (e ErrInvalidSignature) Error() string { return "invalid signature: " + string(e) }
+
+// normalizedSignature is a verified low-S signature, with R and S padded to 32
+// bytes and the recovery ID SecP256k1Recover expects (0 or 1).
+type normalizedSignature struct {
+	R          []byte
+	S          []byte
+	RecoveryID byte
+	DER        []byte
+}
+
+// normalizeSignature enforces BIP-62 low-S, verifies the signature against
+// pubKey and msg, and recomputes the recovery ID by recovering the key.
+func normalizeSignature(pubKey *ecdsa.PublicKey, msg, rBytes, sBytes []byte) (*normalizedSignature, error) {
+	curveN := btcec.S256().N
+	r := new(big.Int).SetBytes(rBytes)
+	s := new(big.Int).SetBytes(sBytes)
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(curveN) >= 0 || s.Cmp(curveN) >= 0 {
+		return nil, ErrInvalidSignature("r or s out of range")
+	}
+	if s.Cmp(new(big.Int).Rsh(curveN, 1)) > 0 {
+		s.Sub(curveN, s)
+	}
+	if !ecdsa.Verify(pubKey, msg, r, s) {
+		return nil, ErrInvalidSignature("does not verify against the derived public key")
+	}
+
+	sig := &normalizedSignature{
+		R: r.FillBytes(make([]byte, 32)),
+		S: s.FillBytes(make([]byte, 32)),
+	}
+	expected := elliptic.MarshalCompressed(btcec.S256(), pubKey.X, pubKey.Y)
+	found := false
+	for recoveryID := byte(0); recoveryID < 4; recoveryID++ {
+		compact := make([]byte, 65)
+		compact[0] = 27 + 4 + recoveryID
+		copy(compact[1:33], sig.R)
+		copy(compact[33:], sig.S)
+		recovered, _, err := mecdsa.RecoverCompact(compact, msg)
+		if err == nil && bytes.Equal(recovered.SerializeCompressed(), expected) {
+			sig.RecoveryID = recoveryID
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrInvalidSignature("public key is not recoverable")
+	}
+
+	der, err := GetDERSignature(r, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DER signature: %w", err)
+	}
+	sig.DER = der
+	return sig, nil
+}
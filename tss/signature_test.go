@@ -0,0 +1,129 @@
+package tss
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	mecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+func TestNormalizeSignatureLowS(t *testing.T) {
+	curve := btcec.S256()
+	halfN := new(big.Int).Rsh(curve.N, 1)
+	privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := hex.EncodeToString(elliptic.MarshalCompressed(curve, privKey.X, privKey.Y))
+	for i := 0; i < 16; i++ {
+		hash := sha256.Sum256([]byte{byte(i)})
+		r, s, err := ecdsa.Sign(rand.Reader, privKey, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the high-S twin verifies too, and flips the recovery ID
+		if i%2 == 0 {
+			s.Sub(curve.N, s)
+		}
+		sig, err := normalizeSignature(&privKey.PublicKey, hash[:], r.Bytes(), s.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sig.R) != 32 || len(sig.S) != 32 || new(big.Int).SetBytes(sig.S).Cmp(halfN) > 0 {
+			t.Fatalf("not a padded low-S signature: %x %x", sig.R, sig.S)
+		}
+		if sig.RecoveryID > 1 {
+			t.Fatalf("recovery ID %d", sig.RecoveryID)
+		}
+		recovered, err := SecP256k1Recover(hex.EncodeToString(sig.R), hex.EncodeToString(sig.S), hex.EncodeToString([]byte{sig.RecoveryID}), hex.EncodeToString(hash[:]))
+		if err != nil || recovered != pubKey {
+			t.Fatalf("recovered %s: %v", recovered, err)
+		}
+		parsed, err := mecdsa.ParseDERSignature(sig.DER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := parsed.S(); s.IsOverHalfOrder() {
+			t.Fatal("DER signature is high-S")
+		}
+	}
+}
+
+func TestNormalizeSignatureInvalid(t *testing.T) {
+	curve := btcec.S256()
+	privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte("message"))
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := sha256.Sum256([]byte("other message"))
+	for name, args := range map[string][][]byte{
+		"other message": {other[:], r.Bytes(), s.Bytes()},
+		"zero r":        {hash[:], nil, s.Bytes()},
+		"s of N":        {hash[:], r.Bytes(), curve.N.Bytes()},
+	} {
+		_, err := normalizeSignature(&privKey.PublicKey, args[0], args[1], args[2])
+		var invalid ErrInvalidSignature
+		if !errors.As(err, &invalid) {
+			t.Fatalf("%s: want ErrInvalidSignature, got %v", name, err)
+		}
+	}
+}
+
+func TestKeysignLowS(t *testing.T) {
+	states := testKeyshares(t)
+	derivePath := "m/44/0/0/0/0"
+	halfN := new(big.Int).Rsh(btcec.S256().N, 1)
+	responses, errs := testKeysign(t, []string{"partyA", "partyB"}, states, derivePath, testMessage(4))
+	requireNoErrors(t, errs)
+	for party, resp := range responses {
+		s, _ := new(big.Int).SetString(resp.S, 16)
+		if s == nil || s.Cmp(halfN) > 0 {
+			t.Fatalf("%s: high S %s", party, resp.S)
+		}
+		requireSignature(t, states, party, derivePath, resp)
+	}
+	if responses["partyA"].DerSignature != responses["partyB"].DerSignature {
+		t.Fatal("parties returned different signatures")
+	}
+}
+
+func TestSigningCommitteeNostrKeys(t *testing.T) {
+	hexKeys := make([]string, 3)
+	npubs := make([]string, 3)
+	for i := range hexKeys {
+		key := make([]byte, 32)
+		key[31] = byte(i + 1)
+		hexKeys[i] = hex.EncodeToString(key)
+		npub, err := HexToNpub(hexKeys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		npubs[i] = npub
+	}
+	state := LocalState{KeygenCommitteeKeys: hexKeys, Threshold: 1}
+	committee, err := state.signingCommittee([]string{npubs[0], hexKeys[0], npubs[2]}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(committee) != 2 || committee[0] != npubs[0] || committee[1] != npubs[2] {
+		t.Fatalf("committee %v, want %v", committee, []string{npubs[0], npubs[2]})
+	}
+	other := make([]byte, 32)
+	other[0] = 1
+	outsider, _ := HexToNpub(hex.EncodeToString(other))
+	if _, err := state.signingCommittee([]string{npubs[0], outsider}, 1); err == nil {
+		t.Fatal("accepted a party outside the keygen committee")
+	}
+}
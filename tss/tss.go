@@ -1,7 +1,6 @@
 package tss
 
 import (
-	"crypto/ecdsa"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -23,10 +22,40 @@ import (
 )
 
 func (s *ServiceImpl) ApplyData(msg string) error {
+	if _, err := messageSender(msg); err != nil {
+		return err
+	}
 	s.inboundMessageCh <- msg
 	return nil
 }
 
+// messageSender is the party a protocol message claims to come from.
+func messageSender(msg string) (string, error) {
+	originalBytes, err := base64.StdEncoding.DecodeString(msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode message from base64, error: %w", err)
+	}
+	var envelope struct {
+		From string `json:"from"`
+	}
+	if err := json.Unmarshal(originalBytes, &envelope); err != nil {
+		return "", fmt.Errorf("failed to unmarshal message from json, error: %w", err)
+	}
+	if envelope.From == "" {
+		return "", errors.New("message has no sender")
+	}
+	return envelope.From, nil
+}
+
+// abort stops the running protocol with err, a failure the transport
+// detected outside of it.
+func (s *ServiceImpl) abort(err error) {
+	select {
+	case s.abortCh <- err:
+	default:
+	}
+}
+
 func LocalPreParams(ppmFile string, timeoutMinutes int) (result bool, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -66,10 +95,13 @@ func LocalPreParams(ppmFile string, timeoutMinutes int) (result bool, err error)
 	} else {
 		Logln("BBMTLog", "ppm file found...")
 		Logln("BBMTLog", "ppm loading...")
-		_, err := loadPreParamsFromFile(ppmFile)
+		preParams, err := loadPreParamsFromFile(ppmFile)
 		if err != nil {
 			return false, fmt.Errorf("failed to load pre-parameters from file: %w", err)
 		}
+		if err := checkPreParams(preParams); err != nil {
+			return false, fmt.Errorf("invalid pre-parameters in %s: %w", ppmFile, err)
+		}
 		Logln("BBMTLog", "ppm ok...")
 		return true, nil
 	}
@@ -78,6 +110,13 @@ func LocalPreParams(ppmFile string, timeoutMinutes int) (result bool, err error)
 func PreParams(ppmFile string) (*ecdsaKeygen.LocalPreParams, error) {
 	Logln("BBMTLog", "ppm generation...")
 
+	// fresh pre-parameters from the pool when it runs, so every keygen gets
+	// its own Paillier keys; the ppm file is the fallback
+	if preParams := takePoolPreParams(); preParams != nil {
+		Logln("BBMTLog", "ppm taken from pool...")
+		return preParams, nil
+	}
+
 	if _, err := os.Stat(ppmFile); err != nil {
 		if os.IsNotExist(err) {
 			Logln("BBMTLog", "ppm file not found...")
@@ -108,6 +147,9 @@ func PreParams(ppmFile string) (*ecdsaKeygen.LocalPreParams, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to load pre-parameters from file: %w", err)
 		}
+		if err := checkPreParams(preParams); err != nil {
+			return nil, fmt.Errorf("invalid pre-parameters in %s: %w", ppmFile, err)
+		}
 		Logln("BBMTLog", "ppm ok...")
 		return preParams, nil
 	}
@@ -125,6 +167,7 @@ func NewService(msg Messenger, stateAccessor LocalStateAccessor, createPreParam
 		messenger:        msg,
 		stateAccessor:    stateAccessor,
 		inboundMessageCh: make(chan string),
+		abortCh:          make(chan error, 1),
 	}
 	if createPreParam {
 		ppms, err := PreParams(ppmFile)
@@ -167,12 +210,14 @@ func (r KeysignRequest) GetKeysignCommitteeKeys() []string {
 	return strings.Split(r.KeysignCommitteeKeys, ",")
 }
 
-func (s *ServiceImpl) getParties(allPartyKeys []string, localPartyKey string) ([]*tss.PartyID, *tss.PartyID) {
+// getParties builds the sorted tss-lib party IDs. The party key is derived from
+// keyPrefix+moniker; keyPrefix is empty for keyshares that were never reshared.
+func (s *ServiceImpl) getParties(allPartyKeys []string, localPartyKey, keyPrefix string) ([]*tss.PartyID, *tss.PartyID) {
 	var localPartyID *tss.PartyID
 	var unSortedPartiesID []*tss.PartyID
 	sort.Strings(allPartyKeys)
 	for idx, item := range allPartyKeys {
-		key := new(big.Int).SetBytes([]byte(item))
+		key := new(big.Int).SetBytes([]byte(keyPrefix + item))
 		partyID := tss.NewPartyID(strconv.Itoa(idx), item, key)
 		if item == localPartyKey {
 			localPartyID = partyID
@@ -194,15 +239,20 @@ func (s *ServiceImpl) KeygenECDSA(req *KeygenRequest) (*KeygenResponse, error) {
 	if len(chaincode) != 32 {
 		return nil, fmt.Errorf("invalid chain code length")
 	}
-	partyIDs, localPartyID := s.getParties(req.GetAllParties(), req.LocalPartyID)
+	partyIDs, localPartyID := s.getParties(req.GetAllParties(), req.LocalPartyID, "")
 
 	ctx := tss.NewPeerContext(partyIDs)
 	curve := tss.S256()
 	totalPartiesCount := len(req.GetAllParties())
-	threshod, err := GetThreshold(totalPartiesCount)
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to get threshold: %w", err)
+	threshod := req.Threshold
+	if threshod == 0 {
+		threshod, err = GetThreshold(totalPartiesCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get threshold: %w", err)
+		}
+	}
+	if threshod < 1 || threshod >= totalPartiesCount {
+		return nil, fmt.Errorf("invalid threshold %d for %d parties", threshod, totalPartiesCount)
 	}
 	params := tss.NewParameters(curve, ctx, localPartyID, totalPartiesCount, threshod)
 	outCh := make(chan tss.Message, totalPartiesCount*2)                   // message channel
@@ -211,6 +261,7 @@ func (s *ServiceImpl) KeygenECDSA(req *KeygenRequest) (*KeygenResponse, error) {
 		KeygenCommitteeKeys: req.GetAllParties(),
 		LocalPartyKey:       req.LocalPartyID,
 		ChainCodeHex:        req.ChainCodeHex, // ChainCode will be used later for ECDSA key derivation
+		Threshold:           threshod,
 	}
 	errChan := make(chan struct{})
 	localPartyECDSA := ecdsaKeygen.NewLocalParty(params, outCh, endCh, *s.preParams)
@@ -232,7 +283,7 @@ func (s *ServiceImpl) KeygenECDSA(req *KeygenRequest) (*KeygenResponse, error) {
 	}, nil
 }
 
-func (s *ServiceImpl) applyMessageToTssInstance(localParty tss.Party, msg string, sortedPartyIds tss.SortedPartyIDs) (string, error) {
+func (s *ServiceImpl) applyMessageToTssInstance(localParty tss.Party, msg string, sortedPartyIds tss.SortedPartyIDs, shareEpoch int) (string, error) {
 	var msgFromTss MessageFromTss
 	originalBytes, err := base64.StdEncoding.DecodeString(msg)
 	if err != nil {
@@ -249,11 +300,14 @@ func (s *ServiceImpl) applyMessageToTssInstance(localParty tss.Party, msg string
 		}
 	}
 	if fromParty == nil {
-		return "", fmt.Errorf("failed to find from party,from:%s", msgFromTss.From)
+		return "", newBlame(BlameProtocol, fmt.Errorf("failed to find from party,from:%s", msgFromTss.From), msgFromTss.From)
+	}
+	if msgFromTss.ShareEpoch != shareEpoch {
+		return "", newBlame(BlameProtocol, fmt.Errorf("mixed share epochs, party %s holds epoch %d, local epoch is %d", msgFromTss.From, msgFromTss.ShareEpoch, shareEpoch), msgFromTss.From)
 	}
 	_, errUpdate := localParty.UpdateFromBytes(msgFromTss.WireBytes, fromParty, msgFromTss.IsBroadcast)
 	if errUpdate != nil {
-		return "", fmt.Errorf("failed to update from bytes, error: %w", errUpdate)
+		return "", fmt.Errorf("failed to update from bytes, error: %w", blameTssError(BlameProtocol, errUpdate, msgFromTss.From))
 	}
 
 	return "", nil
@@ -316,9 +370,12 @@ func (s *ServiceImpl) processKeygen(localParty tss.Party,
 			}()
 
 		// Process incoming messages
+		case err := <-s.abortCh:
+			return "", err
+
 		case msg := <-s.inboundMessageCh:
 			go func() {
-				if _, err := s.applyMessageToTssInstance(localParty, msg, sortedPartyIds); err != nil {
+				if _, err := s.applyMessageToTssInstance(localParty, msg, sortedPartyIds, 0); err != nil {
 					errChan <- fmt.Errorf("failed to apply message to tss instance, error: %w", err)
 					return
 				}
@@ -345,7 +402,7 @@ func (s *ServiceImpl) processKeygen(localParty tss.Party,
 		default:
 			time.Sleep(250 * time.Millisecond)
 			if time.Since(until) > 0 {
-				return "", fmt.Errorf("keygen timeout, didn't finish in %d seconds", keyGenTimeout)
+				return "", blameTimeout(fmt.Errorf("keygen timeout, didn't finish in %d seconds", keyGenTimeout), localParty)
 			} else {
 				select {
 				case err := <-errChan:
@@ -362,6 +419,44 @@ func (s *ServiceImpl) processKeygen(localParty tss.Party,
 	}
 }
 
+// threshold returns the tss-lib threshold of the keyshare, falling back to the
+// committee size based default for keyshares that do not record it.
+func (l *LocalState) threshold() (int, error) {
+	if l.Threshold > 0 {
+		return l.Threshold, nil
+	}
+	return GetThreshold(len(l.KeygenCommitteeKeys))
+}
+
+// signingCommittee validates a keysign committee against the keyshare: every
+// signer must belong to the keygen committee and there must be at least
+// threshold+1 of them. Blank and repeated entries are dropped, so every party
+// derives the same tss-lib party IDs from the result.
+func (l *LocalState) signingCommittee(parties []string, threshold int) ([]string, error) {
+	keygenCommittee := make([]string, 0, len(l.KeygenCommitteeKeys))
+	for _, key := range l.KeygenCommitteeKeys {
+		keygenCommittee = append(keygenCommittee, normalizePartyKey(key))
+	}
+	committee := make([]string, 0, len(parties))
+	seen := make([]string, 0, len(parties))
+	for _, party := range parties {
+		party = strings.TrimSpace(party)
+		normalized := normalizePartyKey(party)
+		if party == "" || Contains(seen, normalized) {
+			continue
+		}
+		if !Contains(keygenCommittee, normalized) {
+			return nil, fmt.Errorf("keysign party %s is not in the keygen committee", party)
+		}
+		committee = append(committee, party)
+		seen = append(seen, normalized)
+	}
+	if len(committee) < threshold+1 {
+		return nil, fmt.Errorf("keysign needs %d of %d parties, got %d", threshold+1, len(l.KeygenCommitteeKeys), len(committee))
+	}
+	return committee, nil
+}
+
 func (s *ServiceImpl) saveLocalStateData(localState *LocalState) error {
 	result, err := json.MarshalIndent(localState, "", "  ")
 	if err != nil {
@@ -402,16 +497,18 @@ func (s *ServiceImpl) KeysignECDSA(req *KeysignRequest) (*KeysignResponse, error
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode chain code hex, error: %w", err)
 	}
-	keysignCommittee := req.GetKeysignCommitteeKeys()
-	if !Contains(keysignCommittee, localState.LocalPartyKey) {
-		return nil, errors.New("local party not in keysign committee")
-	}
-	keysignPartyIDs, localPartyID := s.getParties(keysignCommittee, localState.LocalPartyKey)
-
-	threshold, err := GetThreshold(len(localState.KeygenCommitteeKeys))
+	threshold, err := localState.threshold()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get threshold: %w", err)
 	}
+	keysignCommittee, err := localState.signingCommittee(req.GetKeysignCommitteeKeys(), threshold)
+	if err != nil {
+		return nil, err
+	}
+	if !Contains(keysignCommittee, localState.LocalPartyKey) {
+		return nil, errors.New("local party not in keysign committee")
+	}
+	keysignPartyIDs, localPartyID := s.getParties(keysignCommittee, localState.LocalPartyKey, localState.ResharePrefix)
 	curve := tss.S256()
 	outCh := make(chan tss.Message, len(keysignPartyIDs)*2)
 	endCh := make(chan *common.SignatureData, len(keysignPartyIDs))
@@ -441,30 +538,28 @@ func (s *ServiceImpl) KeysignECDSA(req *KeysignRequest) (*KeysignResponse, error
 			close(errCh)
 		}
 	}()
-	sig, err := s.processKeySign(keysignParty, errCh, outCh, endCh, keysignPartyIDs)
+	sig, err := s.processKeySign(keysignParty, errCh, outCh, endCh, keysignPartyIDs, localState.ShareEpoch)
 	if err != nil {
 		Logln("BBMTLog", "failed to process keysign", "error", err)
 		return nil, err
 	}
 
-	// let's verify the signature
-	if ecdsa.Verify(localKey[0].ECDSAPub.ToECDSAPubKey(), bytesToSign, new(big.Int).SetBytes(sig.R), new(big.Int).SetBytes(sig.S)) {
-		Logln("BBMTLog", "signature is valid")
-	} else {
-		return nil, fmt.Errorf("invalid signature")
-	}
-	derSig, err := GetDERSignature(new(big.Int).SetBytes(sig.R), new(big.Int).SetBytes(sig.S))
+	// verify the signature against the derived key, and normalize it to low-S
+	// with a recovery ID SecP256k1Recover agrees with
+	normalized, err := normalizeSignature(&derivedKey.PublicKey, bytesToSign, sig.R, sig.S)
 	if err != nil {
-		Logln("BBMTLog", "fail to get DER signature", "error", err)
+		Logln("BBMTLog", "keysign produced an unusable signature", "error", err)
+		return nil, err
 	}
+	Logln("BBMTLog", "signature is valid")
 
 	return &KeysignResponse{
 		Msg:          req.MessageToSign,
 		MsgHex:       hex.EncodeToString(bytesToSign),
-		R:            hex.EncodeToString(sig.R),
-		S:            hex.EncodeToString(sig.S),
-		DerSignature: hex.EncodeToString(derSig),
-		RecoveryID:   hex.EncodeToString(sig.SignatureRecovery),
+		R:            hex.EncodeToString(normalized.R),
+		S:            hex.EncodeToString(normalized.S),
+		DerSignature: hex.EncodeToString(normalized.DER),
+		RecoveryID:   hex.EncodeToString([]byte{normalized.RecoveryID}),
 	}, nil
 }
 
@@ -472,7 +567,8 @@ func (s *ServiceImpl) processKeySign(localParty tss.Party,
 	errCh <-chan struct{},
 	outCh <-chan tss.Message,
 	endCh <-chan *common.SignatureData,
-	sortedPartyIds tss.SortedPartyIDs) (*common.SignatureData, error) {
+	sortedPartyIds tss.SortedPartyIDs,
+	shareEpoch int) (*common.SignatureData, error) {
 
 	var signature *common.SignatureData = nil
 	errChan := make(chan error, 1)
@@ -494,6 +590,7 @@ func (s *ServiceImpl) processKeySign(localParty tss.Party,
 					WireBytes:   msgData,
 					From:        r.From.Moniker,
 					IsBroadcast: r.IsBroadcast,
+					ShareEpoch:  shareEpoch,
 				}, "", "  ")
 				if err != nil {
 					errChan <- fmt.Errorf("failed to marshal message to json, error: %w", err)
@@ -520,10 +617,12 @@ func (s *ServiceImpl) processKeySign(localParty tss.Party,
 					}
 				}
 			}()
+		case err := <-s.abortCh:
+			return nil, err
 		case msg := <-s.inboundMessageCh:
 			go func() {
 				// apply the message to the tss instance
-				if _, err := s.applyMessageToTssInstance(localParty, msg, sortedPartyIds); err != nil {
+				if _, err := s.applyMessageToTssInstance(localParty, msg, sortedPartyIds, shareEpoch); err != nil {
 					errChan <- fmt.Errorf("failed to apply message to tss instance, error: %w", err)
 				}
 			}()
@@ -535,7 +634,7 @@ func (s *ServiceImpl) processKeySign(localParty tss.Party,
 			time.Sleep(250 * time.Millisecond)
 			if time.Since(until) > 0 {
 				Logln("BBMTLog", "Received timeout to end downloadMessage. Stopping...")
-				return nil, fmt.Errorf("keysign timeout, didn't finish in %d seconds", keySignTimeout)
+				return nil, blameTimeout(fmt.Errorf("keysign timeout, didn't finish in %d seconds", keySignTimeout), localParty)
 			} else {
 				select {
 				case err := <-errChan:
@@ -0,0 +1,22 @@
+package tss
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeygenExplicitThreshold(t *testing.T) {
+	parties := []string{"partyA", "partyB", "partyC"}
+	states := testKeygen(t, parties, 2) // 3-of-3
+	for party, state := range states {
+		if threshold := state.localState(t).Threshold; threshold != 2 {
+			t.Fatalf("%s: threshold %d, want 2", party, threshold)
+		}
+	}
+	_, errs := testKeysign(t, parties[:2], states, "m/44/0/0/0/0", testMessage(1))
+	for party, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "needs 3 of 3 parties") {
+			t.Fatalf("%s: keysign with 2 of 3-of-3: %v", party, err)
+		}
+	}
+}